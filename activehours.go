@@ -0,0 +1,120 @@
+package meow
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	// embed the time zone database, so that time zones can be loaded on hosts
+	// lacking it (e.g. minimal containers)
+	_ "time/tzdata"
+)
+
+// weekdayNames are the abbreviations of the weekdays, starting with Sunday.
+var weekdayNames = [7]string{"sun", "mon", "tue", "wed", "thu", "fri", "sat"}
+
+// ActiveHours is a daily period of time on certain weekdays in a time zone,
+// during which an endpoint is probed. Outside of it, the endpoint is paused.
+type ActiveHours struct {
+	// Start and End are the minutes since midnight the period starts and ends.
+	Start, End int
+	Location   *time.Location
+	Weekdays   [7]bool
+}
+
+// ActiveHoursPayload contains the same fields as ActiveHours, but only as
+// strings: start and end as "15:04" (end may be "24:00"), an IANA time zone,
+// e.g. "Europe/Zurich", and weekdays abbreviated as "mon", "tue", etc. If no
+// weekdays are given, the period applies to every day.
+type ActiveHoursPayload struct {
+	Start    string   `json:"start"`
+	End      string   `json:"end"`
+	Timezone string   `json:"timezone"`
+	Weekdays []string `json:"weekdays,omitempty"`
+}
+
+// ParseActiveHours creates ActiveHours from the given payload, or returns an
+// error, if its fields are malformed or the period is empty.
+func ParseActiveHours(payload ActiveHoursPayload) (*ActiveHours, error) {
+	start, err := parseTimeOfDay(payload.Start)
+	if err != nil {
+		return nil, fmt.Errorf("active hours start: %v", err)
+	}
+	end, err := parseTimeOfDay(payload.End)
+	if err != nil {
+		return nil, fmt.Errorf("active hours end: %v", err)
+	}
+	if start >= end {
+		return nil, fmt.Errorf("active hours start %s is not before end %s",
+			payload.Start, payload.End)
+	}
+	if payload.Timezone == "" {
+		return nil, fmt.Errorf("active hours require a timezone")
+	}
+	location, err := time.LoadLocation(payload.Timezone)
+	if err != nil {
+		return nil, fmt.Errorf(`active hours timezone "%s": %v`, payload.Timezone, err)
+	}
+	hours := ActiveHours{Start: start, End: end, Location: location}
+	if len(payload.Weekdays) == 0 {
+		hours.Weekdays = [7]bool{true, true, true, true, true, true, true}
+	}
+	for _, name := range payload.Weekdays {
+		found := false
+		for i, weekday := range weekdayNames {
+			if strings.ToLower(name) == weekday {
+				hours.Weekdays[i] = true
+				found = true
+			}
+		}
+		if !found {
+			return nil, fmt.Errorf(`"%s" is not a weekday (use %s)`,
+				name, strings.Join(weekdayNames[:], ", "))
+		}
+	}
+	return &hours, nil
+}
+
+// parseTimeOfDay returns the minutes since midnight of the given "15:04" time,
+// which may be "24:00" for the end of the day.
+func parseTimeOfDay(raw string) (int, error) {
+	if raw == "24:00" {
+		return 24 * 60, nil
+	}
+	t, err := time.Parse("15:04", raw)
+	if err != nil {
+		return 0, fmt.Errorf(`"%s" is not a time of day (HH:MM)`, raw)
+	}
+	return t.Hour()*60 + t.Minute(), nil
+}
+
+// Active indicates whether or not t lies within the active hours.
+func (h ActiveHours) Active(t time.Time) bool {
+	t = t.In(h.Location)
+	minute := t.Hour()*60 + t.Minute()
+	return h.Weekdays[t.Weekday()] && minute >= h.Start && minute < h.End
+}
+
+// Payload converts the active hours to their payload representation.
+func (h ActiveHours) Payload() ActiveHoursPayload {
+	payload := ActiveHoursPayload{
+		Start:    fmt.Sprintf("%02d:%02d", h.Start/60, h.Start%60),
+		End:      fmt.Sprintf("%02d:%02d", h.End/60, h.End%60),
+		Timezone: h.Location.String(),
+	}
+	for i, active := range h.Weekdays {
+		if active {
+			payload.Weekdays = append(payload.Weekdays, weekdayNames[i])
+		}
+	}
+	if len(payload.Weekdays) == len(weekdayNames) {
+		payload.Weekdays = nil
+	}
+	return payload
+}
+
+// ActiveAt indicates whether or not the endpoint is to be probed at t, which
+// is always the case for endpoints without active hours.
+func (e Endpoint) ActiveAt(t time.Time) bool {
+	return e.ActiveHours == nil || e.ActiveHours.Active(t)
+}
@@ -0,0 +1,57 @@
+package meow
+
+import (
+	"fmt"
+	"html"
+	"unicode/utf8"
+)
+
+// Thresholds of the uptime ratio for the colors of an uptime badge.
+const (
+	BadgeGreenThreshold  = 0.99
+	BadgeYellowThreshold = 0.95
+)
+
+const badgeTemplate = `<svg xmlns="http://www.w3.org/2000/svg" width="%d" height="20" role="img" aria-label="%s: %s">
+<title>%s: %s</title>
+<rect width="%d" height="20" fill="#555"/>
+<rect x="%d" width="%d" height="20" fill="%s"/>
+<g fill="#fff" text-anchor="middle" font-family="Verdana,Geneva,DejaVu Sans,sans-serif" font-size="11">
+<text x="%d" y="14">%s</text>
+<text x="%d" y="14">%s</text>
+</g>
+</svg>
+`
+
+// UptimeBadge renders an SVG badge showing the label and the uptime ratio,
+// colored green, yellow, or red according to the thresholds. If known is
+// false, the badge shows "unknown" in grey.
+func UptimeBadge(label string, uptime float64, known bool) []byte {
+	value, color := "unknown", "#9f9f9f"
+	if known {
+		value = fmt.Sprintf("%.2f%%", uptime*100)
+		switch {
+		case uptime >= BadgeGreenThreshold:
+			color = "#4c1"
+		case uptime >= BadgeYellowThreshold:
+			color = "#dfb317"
+		default:
+			color = "#e05d44"
+		}
+	}
+	labelWidth := badgeTextWidth(label)
+	valueWidth := badgeTextWidth(value)
+	label, value = html.EscapeString(label), html.EscapeString(value)
+	return []byte(fmt.Sprintf(badgeTemplate,
+		labelWidth+valueWidth, label, value,
+		label, value,
+		labelWidth,
+		labelWidth, valueWidth, color,
+		labelWidth/2, label,
+		labelWidth+valueWidth/2, value))
+}
+
+// badgeTextWidth estimates the width of text in pixels, including padding.
+func badgeTextWidth(text string) int {
+	return utf8.RuneCountInString(text)*7 + 10
+}
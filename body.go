@@ -0,0 +1,164 @@
+package meow
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// MaxRequestBodySize is the maximum number of bytes of a request body sent by
+// the probe, whether read from a file or generated.
+const MaxRequestBodySize = 64 << 10
+
+// MaxRandomBytes is the maximum number of random bytes generated by a single
+// {{random:N}} placeholder.
+const MaxRandomBytes = 1024
+
+// BodySource describes the request body of a probe, which is resolved at probe
+// time: either the contents of a file in the body directory on the probe's
+// host (given as "file:relative/path"), or a template whose placeholders
+// {{uuid}} (a random UUID), {{timestamp}} (the current time as RFC 3339), and
+// {{random:N}} (N random bytes, hex-encoded) are replaced with freshly
+// generated values.
+type BodySource struct {
+	raw      string
+	path     string
+	template []bodyPart
+}
+
+// bodyPart is either literal text, or a placeholder generating a value.
+type bodyPart struct {
+	literal  string
+	generate func() (string, error)
+}
+
+var placeholderPattern = regexp.MustCompile(`\{\{([a-z]+)(?::([0-9]+))?\}\}`)
+
+// ParseBodySource parses raw as BodySource, or returns an error, if it refers
+// to a file outside of the body directory, contains unknown placeholders, or
+// may generate a body exceeding MaxRequestBodySize.
+func ParseBodySource(raw string) (*BodySource, error) {
+	if path, ok := strings.CutPrefix(raw, "file:"); ok {
+		// files elsewhere on the probe's host must not be sent
+		if !filepath.IsLocal(path) {
+			return nil, fmt.Errorf(`body file "%s" is not a path within the body directory`, path)
+		}
+		return &BodySource{raw: raw, path: filepath.Clean(path)}, nil
+	}
+	source := BodySource{raw: raw}
+	size, offset := 0, 0
+	for _, match := range placeholderPattern.FindAllStringSubmatchIndex(raw, -1) {
+		literal := raw[offset:match[0]]
+		source.template = append(source.template, bodyPart{literal: literal})
+		size += len(literal)
+		name, arg := raw[match[2]:match[3]], ""
+		if match[4] >= 0 {
+			arg = raw[match[4]:match[5]]
+		}
+		generate, n, err := generator(name, arg)
+		if err != nil {
+			return nil, err
+		}
+		source.template = append(source.template, bodyPart{generate: generate})
+		size += n
+		offset = match[1]
+	}
+	source.template = append(source.template, bodyPart{literal: raw[offset:]})
+	size += len(raw) - offset
+	if size > MaxRequestBodySize {
+		return nil, fmt.Errorf("body of up to %d bytes exceeds the maximum of %d", size, MaxRequestBodySize)
+	}
+	if strings.Contains(placeholderPattern.ReplaceAllString(raw, ""), "{{") {
+		return nil, fmt.Errorf(`body "%s" contains a malformed placeholder`, raw)
+	}
+	return &source, nil
+}
+
+// generator returns the function generating the values of the placeholder
+// with the given name and argument, and the maximum size of these values.
+func generator(name, arg string) (func() (string, error), int, error) {
+	switch {
+	case name == "uuid" && arg == "":
+		return generateUUID, 36, nil
+	case name == "timestamp" && arg == "":
+		return func() (string, error) {
+			return time.Now().UTC().Format(time.RFC3339Nano), nil
+		}, len(time.RFC3339Nano), nil
+	case name == "random" && arg != "":
+		n, err := strconv.Atoi(arg)
+		if err != nil || n < 1 || n > MaxRandomBytes {
+			return nil, 0, fmt.Errorf("{{random:%s}} must generate 1 to %d bytes", arg, MaxRandomBytes)
+		}
+		return func() (string, error) {
+			data := make([]byte, n)
+			if _, err := rand.Read(data); err != nil {
+				return "", err
+			}
+			return hex.EncodeToString(data), nil
+		}, 2 * n, nil
+	}
+	return nil, 0, fmt.Errorf(`unknown placeholder "%s" in body`, name)
+}
+
+// generateUUID returns a random (version 4) UUID.
+func generateUUID() (string, error) {
+	var id [16]byte
+	if _, err := rand.Read(id[:]); err != nil {
+		return "", err
+	}
+	id[6] = id[6]&0x0f | 0x40
+	id[8] = id[8]&0x3f | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", id[0:4], id[4:6], id[6:8], id[8:10], id[10:16]), nil
+}
+
+// Render resolves the body source to the body of a request, reading files from
+// the body directory dir. An error is returned if the file cannot be read or
+// exceeds MaxRequestBodySize, or if dir is empty, i.e. file bodies are
+// disabled.
+func (b *BodySource) Render(dir string) ([]byte, error) {
+	if b.path != "" {
+		if dir == "" {
+			return nil, fmt.Errorf("body file %s: no body directory configured", b.path)
+		}
+		// neither may symbolic links escape the body directory
+		file, err := os.OpenInRoot(dir, b.path)
+		if err != nil {
+			return nil, fmt.Errorf("open body file: %v", err)
+		}
+		defer file.Close()
+		body, err := io.ReadAll(io.LimitReader(file, MaxRequestBodySize+1))
+		if err != nil {
+			return nil, fmt.Errorf("read body file %s: %v", b.path, err)
+		}
+		if len(body) > MaxRequestBodySize {
+			return nil, fmt.Errorf("body file %s exceeds %d bytes", b.path, MaxRequestBodySize)
+		}
+		return body, nil
+	}
+	var body bytes.Buffer
+	for _, part := range b.template {
+		if part.generate == nil {
+			body.WriteString(part.literal)
+			continue
+		}
+		value, err := part.generate()
+		if err != nil {
+			return nil, fmt.Errorf("generate body: %v", err)
+		}
+		body.WriteString(value)
+	}
+	return body.Bytes(), nil
+}
+
+// String returns the body source as it was parsed.
+func (b *BodySource) String() string {
+	return b.raw
+}
@@ -3,16 +3,27 @@ package main
 import (
 	"bytes"
 	"context"
+	"crypto/subtle"
 	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
 	"io"
-	"log"
+	"log/slog"
+	"mime"
+	"net"
 	"net/http"
 	"net/url"
 	"os"
-	"regexp"
+	"os/signal"
+	"path"
+	"slices"
 	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
 
 	"github.com/patrickbucher/meow"
 	"github.com/valkey-io/valkey-go"
@@ -24,206 +35,2532 @@ type Config map[string]*meow.Endpoint
 func main() {
 	addr := flag.String("addr", "0.0.0.0", "listen to address")
 	port := flag.Uint("port", 8000, "listen on port")
+	settingsFile := flag.String("settings", "", "file with runtime-tunable settings (NAME=VALUE)")
+	onInvalid := flag.String("on-invalid", invalidLog,
+		"how to treat invalid stored endpoints at startup (log, refuse, quarantine)")
+	logFormat := flag.String("log-format", logFormatText, "format of the log (text, json)")
+	logLevel := flag.String("log-level", "info", "minimum level logged (debug, info, warn, error)")
+	corsOrigin := flag.String("cors-origin", "", "origin allowed to call the API from a browser (* for any)")
+	authReads := flag.Bool("auth-reads", false, "require MEOW_API_KEY for reading, too")
 	flag.Parse()
 
-	log.SetOutput(os.Stderr)
+	logger, err := newLogger(os.Stderr, *logFormat, *logLevel)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "configure logging: %v\n", err)
+		os.Exit(1)
+	}
+	slog.SetDefault(logger)
 
 	valkeyURL := os.Getenv("VALKEY_URL")
 	if valkeyURL == "" {
-		log.Fatal("VALKEY_URL environment variable not set")
+		fatal("VALKEY_URL environment variable not set")
 	}
 
-	u, err := url.Parse(valkeyURL)
+	options, err := meow.ValkeyClientOption(valkeyURL)
+	if err != nil {
+		fatal("parse VALKEY_URL", "error", err)
+	}
+	client, err := valkey.NewClient(*options)
 	if err != nil {
-		log.Fatalf("parse VALKEY_URL: %v", err)
+		fatal("connect to Valkey", "error", err)
 	}
+	defer client.Close()
 
-	dbStr := u.Path
-	if len(dbStr) > 0 && dbStr[0] == '/' {
-		dbStr = dbStr[1:]
+	settings, err := loadSettings(context.Background(), client, *settingsFile)
+	if err != nil {
+		fatal("load settings", "error", err)
 	}
-	db, err := strconv.Atoi(dbStr)
+	slog.Info("settings loaded", "settings", fmt.Sprintf("%+v", *settings))
+
+	timeSeries, err := meow.TimeSeriesAvailable(context.Background(), client)
 	if err != nil {
-		log.Fatalf("parse DB from VALKEY_URL: %v", err)
+		fatal("detect time series", "error", err)
 	}
+	slog.Info("time series detected", "available", timeSeries)
 
-	options := valkey.ClientOption{
-		InitAddress: []string{u.Host},
-		SelectDB:    db,
+	if err := migrateEndpoints(context.Background(), client); err != nil {
+		fatal("migrate stored endpoints", "error", err)
+	}
+	if err := validateEndpoints(context.Background(), client, *onInvalid); err != nil {
+		fatal("validate stored endpoints", "error", err)
 	}
-	client, err := valkey.NewClient(options)
+	if err := countEndpoints(context.Background(), client); err != nil {
+		fatal("count stored endpoints", "error", err)
+	}
+
+	adminToken := os.Getenv("MEOW_ADMIN_TOKEN")
+	ownerTokens, err := parseOwnerTokens(os.Getenv("MEOW_OWNER_TOKENS"))
 	if err != nil {
-		log.Fatalf("connect to Valkey: %v", err)
+		fatal("parse MEOW_OWNER_TOKENS", "error", err)
 	}
-	defer client.Close()
+	auth := authenticator{adminToken: adminToken, ownerTokens: ownerTokens}
+	store := meow.NewConfigStore(client)
+	results := valkeyResults{client}
+	http.HandleFunc("POST /admin/reload", requireAdmin(adminToken, func(w http.ResponseWriter, r *http.Request) {
+		reloadSettings(w, r, client, *settingsFile)
+	}))
+	http.HandleFunc("GET /admin/scheduler", requireAdmin(adminToken, func(w http.ResponseWriter, r *http.Request) {
+		getScheduler(w, r, client)
+	}))
+	http.HandleFunc("POST /admin/scheduler", requireAdmin(adminToken, func(w http.ResponseWriter, r *http.Request) {
+		postScheduler(w, r, client)
+	}))
+	http.HandleFunc("GET /admin/readonly", requireAdmin(adminToken, func(w http.ResponseWriter, r *http.Request) {
+		getReadOnly(w, r, client)
+	}))
+	http.HandleFunc("POST /admin/readonly", requireAdmin(adminToken, func(w http.ResponseWriter, r *http.Request) {
+		postReadOnly(w, r, client)
+	}))
+	http.HandleFunc("GET /admin/stats", requireAdmin(adminToken, func(w http.ResponseWriter, r *http.Request) {
+		getProbeStats(w, r, client)
+	}))
+	http.HandleFunc("POST /admin/endpoints/{id}/build", requireAdmin(adminToken, func(w http.ResponseWriter, r *http.Request) {
+		postExpectedBuild(w, r, client, store)
+	}))
 
-	http.HandleFunc("/endpoints/", func(w http.ResponseWriter, r *http.Request) {
+	http.HandleFunc("/endpoints/", auth.identify(func(w http.ResponseWriter, r *http.Request) {
 		switch r.Method {
 		case http.MethodGet:
-			getEndpoint(w, r, client)
+			getEndpoint(w, r, store)
 		case http.MethodPost:
-			postEndpoint(w, r, client)
-		// TODO: support http.MethodDelete to delete endpoints
+			postEndpoint(w, r, store, results)
+		case http.MethodDelete:
+			deleteEndpoint(w, r, store)
 		default:
-			log.Printf("request from %s rejected: method %s not allowed",
-				r.RemoteAddr, r.Method)
-			w.WriteHeader(http.StatusMethodNotAllowed)
+			logRejection(r, "method not allowed")
+			writeError(w, http.StatusMethodNotAllowed, "method not allowed")
 		}
+	}))
+	probeClients := meow.NewClientCache(maxProbeClients)
+	http.HandleFunc("POST /endpoints/probe", auth.identify(func(w http.ResponseWriter, r *http.Request) {
+		postProbe(w, r, store, probeClients)
+	}))
+	http.HandleFunc("POST /endpoints/{id}/check", auth.identify(func(w http.ResponseWriter, r *http.Request) {
+		postEndpointCheck(w, r, store, probeClients)
+	}))
+	http.HandleFunc("GET /endpoints/{id}/schedule", auth.identify(func(w http.ResponseWriter, r *http.Request) {
+		getEndpointSchedule(w, r, client, store)
+	}))
+	http.HandleFunc("GET /endpoints/{id}/effective", auth.identify(func(w http.ResponseWriter, r *http.Request) {
+		getEndpointEffective(w, r, store)
+	}))
+	http.HandleFunc("GET /endpoints/{id}/status", auth.identify(func(w http.ResponseWriter, r *http.Request) {
+		getEndpointStatus(w, r, client, store)
+	}))
+	http.HandleFunc("GET /endpoints/{id}/incidents", auth.identify(func(w http.ResponseWriter, r *http.Request) {
+		getEndpointIncidents(w, r, client, store)
+	}))
+	http.HandleFunc("GET /endpoints/{id}/history", auth.identify(func(w http.ResponseWriter, r *http.Request) {
+		getEndpointHistory(w, r, client, store)
+	}))
+	http.HandleFunc("GET /endpoints/{id}/latency", auth.identify(func(w http.ResponseWriter, r *http.Request) {
+		getEndpointLatency(w, r, client, store, timeSeries)
+	}))
+	http.HandleFunc("GET /endpoints/{id}/reliability", auth.identify(func(w http.ResponseWriter, r *http.Request) {
+		getEndpointReliability(w, r, client, store)
+	}))
+	http.HandleFunc("GET /endpoints/{id}/uptime", auth.identify(func(w http.ResponseWriter, r *http.Request) {
+		getEndpointUptime(w, r, client, store)
+	}))
+	http.HandleFunc("GET /schema/endpoint", auth.identify(getEndpointSchema))
+	http.HandleFunc("GET /prometheus/rules.yaml", auth.identify(func(w http.ResponseWriter, r *http.Request) {
+		getPrometheusRules(w, r, store)
+	}))
+	http.HandleFunc("GET /metrics", auth.identify(func(w http.ResponseWriter, r *http.Request) {
+		getMetrics(w, r, client, store)
+	}))
+	http.HandleFunc("GET /tags/{tag}/uptime", auth.identify(func(w http.ResponseWriter, r *http.Request) {
+		getTagUptime(w, r, client, store)
+	}))
+	// badges are public, so that they can be embedded
+	http.HandleFunc("GET /endpoints/{id}/badge.svg", func(w http.ResponseWriter, r *http.Request) {
+		getEndpointBadge(w, r, client, store)
 	})
-	http.HandleFunc("/endpoints", func(w http.ResponseWriter, r *http.Request) {
-		getEndpoints(w, r, client)
+	http.HandleFunc("/endpoints", auth.identify(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+			getEndpoints(w, r, client, store)
+		case http.MethodPost:
+			postEndpoints(w, r, store)
+		default:
+			logRejection(r, "method not allowed")
+			writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		}
+	}))
+
+	// health checks are exempt from load shedding
+	http.HandleFunc("GET /healthz", getLiveness)
+	http.HandleFunc("GET /readyz", func(w http.ResponseWriter, r *http.Request) {
+		getReadiness(w, r, client)
 	})
 
 	listenTo := fmt.Sprintf("%s:%d", *addr, *port)
-	log.Printf("listen to %s", listenTo)
-	http.ListenAndServe(listenTo, nil)
+	slog.Info("listening", "address", listenTo)
+	// probing on demand does not modify the configuration
+	handler := rejectWhileReadOnly(http.DefaultServeMux, client, "/admin/readonly", "/endpoints/probe",
+		"/endpoints/*/check")
+	// administrative endpoints require the admin token instead, and badges
+	// and health checks are public
+	handler = requireAPIKey(handler, os.Getenv("MEOW_API_KEY"), *authReads, "/admin/*", "/admin/*/*/*",
+		"/endpoints/*/badge.svg", "/healthz", "/readyz")
+	signals, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+	// a second signal terminates right away
+	context.AfterFunc(signals, stop)
+	listener, err := net.Listen("tcp", listenTo)
+	if err != nil {
+		fatal("listen", "address", listenTo, "error", err)
+	}
+	if err := serve(signals, listener, allowCORS(shedLoad(handler, "/healthz", "/readyz"), *corsOrigin),
+		shutdownTimeout); err != nil {
+		slog.Error("serve", "address", listenTo, "error", err)
+	}
 }
 
-func getEndpoint(w http.ResponseWriter, r *http.Request, client valkey.Client) {
-	log.Printf("GET %s from %s", r.URL, r.RemoteAddr)
-	identifier, err := extractEndpointIdentifier(r.URL.String())
+// shutdownTimeout is how long the requests in flight may take to finish when
+// the config server is shut down, after which they are cancelled.
+const shutdownTimeout = 10 * time.Second
+
+// serve handles the requests accepted by listener with handler until ctx is
+// done. It then stops accepting connections, and waits up to timeout for the
+// requests in flight to finish, after which they are cancelled. An error is
+// returned if serving fails, or if the requests do not finish in time.
+func serve(ctx context.Context, listener net.Listener, handler http.Handler, timeout time.Duration) error {
+	// cancels the requests still in flight when shutting down takes too long
+	base, cancelRequests := context.WithCancel(context.Background())
+	defer cancelRequests()
+	server := &http.Server{
+		Handler:     handler,
+		BaseContext: func(net.Listener) context.Context { return base },
+	}
+	failed := make(chan error, 1)
+	go func() {
+		if err := server.Serve(listener); !errors.Is(err, http.ErrServerClosed) {
+			failed <- err
+		}
+	}()
+	select {
+	case err := <-failed:
+		return fmt.Errorf("serve: %v", err)
+	case <-ctx.Done():
+	}
+	slog.Info("shutting down", "timeout", timeout.String())
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	if err := server.Shutdown(shutdownCtx); err != nil {
+		cancelRequests()
+		server.Close()
+		return fmt.Errorf("shut down gracefully: %v", err)
+	}
+	return nil
+}
+
+// rejectWhileReadOnly wraps handler, so that requests other than GET and HEAD
+// are rejected with 503 Service Unavailable while the configuration is frozen.
+// Requests to paths matching the exempt patterns (see path.Match) are always
+// handled.
+func rejectWhileReadOnly(handler http.Handler, client valkey.Client, exempt ...string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		isExempt := slices.ContainsFunc(exempt, func(pattern string) bool {
+			matched, _ := path.Match(pattern, r.URL.Path)
+			return matched
+		})
+		if r.Method == http.MethodGet || r.Method == http.MethodHead || isExempt {
+			handler.ServeHTTP(w, r)
+			return
+		}
+		readOnly, err := fetchReadOnly(r.Context(), client)
+		if err != nil {
+			slog.Error("fetch read-only flag", "error", err)
+			writeError(w, http.StatusInternalServerError, "")
+			return
+		}
+		if readOnly {
+			logRejection(r, "read-only")
+			writeError(w, http.StatusServiceUnavailable, "the configuration is read-only; no changes are accepted")
+			return
+		}
+		handler.ServeHTTP(w, r)
+	})
+}
+
+// corsAllowedMethods are the methods of the API a browser may use
+// cross-origin, corsAllowedHeaders the request headers it may send, and
+// corsExposedHeaders the response headers it may read.
+const (
+	corsAllowedMethods = "GET, HEAD, POST, PATCH, DELETE"
+	corsAllowedHeaders = "Authorization, Content-Type, If-Match, If-None-Match, Idempotency-Key, X-Api-Key"
+	corsExposedHeaders = "ETag, Location, Retry-After, X-Next-Cursor"
+)
+
+// allowCORS wraps handler, so that browsers allow pages served from origin (or
+// from any origin, if it is *) to call the API. Preflight requests (OPTIONS)
+// are answered with 204 No Content without being passed on to handler. If
+// origin is empty, handler is returned as it is.
+func allowCORS(handler http.Handler, origin string) http.Handler {
+	if origin == "" {
+		return handler
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		header := w.Header()
+		header.Set("Access-Control-Allow-Origin", origin)
+		if origin != "*" {
+			header.Add("Vary", "Origin")
+		}
+		header.Set("Access-Control-Allow-Methods", corsAllowedMethods)
+		header.Set("Access-Control-Allow-Headers", corsAllowedHeaders)
+		header.Set("Access-Control-Expose-Headers", corsExposedHeaders)
+		if r.Method == http.MethodOptions {
+			header.Set("Access-Control-Max-Age", "600")
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		handler.ServeHTTP(w, r)
+	})
+}
+
+// requireAPIKey wraps handler, so that requests other than GET and HEAD (or
+// all requests, if reads is set) are rejected unless they provide the key,
+// either in the X-Api-Key header, or as the bearer token. Requests without a
+// key are rejected with 401 Unauthorized, and requests with another key with
+// 403 Forbidden. Requests to paths matching the exempt patterns (see
+// path.Match) are always handled. If key is empty, handler is returned as it
+// is.
+func requireAPIKey(handler http.Handler, key string, reads bool, exempt ...string) http.Handler {
+	if key == "" {
+		return handler
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		isExempt := slices.ContainsFunc(exempt, func(pattern string) bool {
+			matched, _ := path.Match(pattern, r.URL.Path)
+			return matched
+		})
+		isRead := r.Method == http.MethodGet || r.Method == http.MethodHead
+		if isExempt || (isRead && !reads) {
+			handler.ServeHTTP(w, r)
+			return
+		}
+		provided := r.Header.Get("X-Api-Key")
+		if provided == "" {
+			provided, _ = strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		}
+		if provided == "" {
+			logRejection(r, "no API key")
+			writeError(w, http.StatusUnauthorized, "no API key")
+			return
+		}
+		if subtle.ConstantTimeCompare([]byte(provided), []byte(key)) != 1 {
+			logRejection(r, "invalid API key")
+			writeError(w, http.StatusForbidden, "invalid API key")
+			return
+		}
+		handler.ServeHTTP(w, r)
+	})
+}
+
+// retryAfter is the number of seconds clients are asked to wait before
+// retrying a request rejected due to overload.
+const retryAfter = 1
+
+// shedLoad wraps handler, so that requests beyond the maximum number of
+// requests in flight are rejected with 503 Service Unavailable and a
+// Retry-After header rather than piling up. Requests to the exempt paths are
+// always handled.
+func shedLoad(handler http.Handler, exempt ...string) http.Handler {
+	var inFlight atomic.Int64
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if slices.Contains(exempt, r.URL.Path) {
+			handler.ServeHTTP(w, r)
+			return
+		}
+		n := inFlight.Add(1)
+		defer inFlight.Add(-1)
+		if max := meow.CurrentSettings().MaxInFlight; max > 0 && n > int64(max) {
+			logRejection(r, "overload", "in_flight", n-1)
+			w.Header().Set("Retry-After", strconv.Itoa(retryAfter))
+			writeError(w, http.StatusServiceUnavailable, "too many requests in flight")
+			return
+		}
+		handler.ServeHTTP(w, r)
+	})
+}
+
+// readinessTimeout is how long the readiness check waits for Valkey to answer.
+const readinessTimeout = 2 * time.Second
+
+// getLiveness reports that the config server is running. Health checks are
+// only logged at the debug level, because they are issued frequently.
+func getLiveness(w http.ResponseWriter, r *http.Request) {
+	slog.Debug("request", requestAttrs(r)...)
+	w.WriteHeader(http.StatusOK)
+}
+
+// getReadiness reports whether or not the config server can reach Valkey, which
+// has to answer a PING within the readiness timeout.
+func getReadiness(w http.ResponseWriter, r *http.Request, client valkey.Client) {
+	slog.Debug("request", requestAttrs(r)...)
+	ctx, cancel := context.WithTimeout(r.Context(), readinessTimeout)
+	defer cancel()
+	if err := client.Do(ctx, client.B().Ping().Build()).Error(); err != nil {
+		slog.Debug("readiness check: ping", "error", err)
+		writeError(w, http.StatusServiceUnavailable, "valkey is unreachable")
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+// migrateEndpoints upgrades all stored endpoints to the current schema version
+// by filling in the fields they lack with defaults.
+func migrateEndpoints(ctx context.Context, client valkey.Client) error {
+	keys, err := allEndpointKeys(ctx, client)
+	if err != nil {
+		return fmt.Errorf("get endpoint keys: %v", err)
+	}
+	for _, key := range keys {
+		kvs, err := client.Do(ctx, client.B().Hgetall().Key(key).Build()).AsStrMap()
+		if err != nil {
+			return fmt.Errorf("hgetall %s: %v", key, err)
+		}
+		changes, version, err := meow.MigrateEndpoint(kvs)
+		if err != nil {
+			return fmt.Errorf("migrate %s: %v", key, err)
+		}
+		if len(changes) == 0 {
+			continue
+		}
+		args := make([]string, 0, 1+2*len(changes))
+		args = append(args, key)
+		for field, value := range changes {
+			args = append(args, field, value)
+		}
+		if err := client.Do(ctx, client.B().Arbitrary("HSET").Args(args...).Build()).Error(); err != nil {
+			return fmt.Errorf("hset %s: %v", key, err)
+		}
+		slog.Info("migrated endpoint", "key", key, "from_version", version,
+			"to_version", meow.EndpointSchemaVersion, "fields_set", len(changes))
+	}
+	return nil
+}
+
+// Treatments of invalid stored endpoints found at startup.
+const (
+	invalidLog        = "log"
+	invalidRefuse     = "refuse"
+	invalidQuarantine = "quarantine"
+)
+
+// quarantinePrefix is prepended to the keys of quarantined endpoints, which
+// are thereby neither served nor probed, but kept for manual inspection.
+const quarantinePrefix = "quarantine:"
+
+// validateEndpoints parses all stored endpoints and logs those that are
+// invalid. Depending on onInvalid, an error is returned if there are any
+// (refuse), or they are moved out of the way (quarantine).
+func validateEndpoints(ctx context.Context, client valkey.Client, onInvalid string) error {
+	switch onInvalid {
+	case invalidLog, invalidRefuse, invalidQuarantine:
+	default:
+		return fmt.Errorf(`unknown treatment "%s" of invalid endpoints`, onInvalid)
+	}
+	keys, err := allEndpointKeys(ctx, client)
+	if err != nil {
+		return fmt.Errorf("get endpoint keys: %v", err)
+	}
+	var invalid []string
+	for _, key := range keys {
+		kvs, err := client.Do(ctx, client.B().Hgetall().Key(key).Build()).AsStrMap()
+		if err != nil {
+			return fmt.Errorf("hgetall %s: %v", key, err)
+		}
+		if _, err := meow.EndpointFromMap(kvs); err != nil {
+			identifier := strings.TrimPrefix(key, "endpoint:")
+			slog.Warn("invalid endpoint", "identifier", identifier, "error", err)
+			invalid = append(invalid, key)
+		}
+	}
+	if len(invalid) == 0 {
+		return nil
+	}
+	switch onInvalid {
+	case invalidRefuse:
+		return fmt.Errorf("%d of %d stored endpoints are invalid", len(invalid), len(keys))
+	case invalidQuarantine:
+		for _, key := range invalid {
+			quarantined := quarantinePrefix + key
+			err := client.Do(ctx, client.B().Rename().Key(key).Newkey(quarantined).Build()).Error()
+			if err != nil {
+				return fmt.Errorf("rename %s to %s: %v", key, quarantined, err)
+			}
+			slog.Info("quarantined endpoint", "key", key, "quarantined_as", quarantined)
+		}
+	}
+	return nil
+}
+
+// countEndpoints initializes the counter of stored endpoints.
+func countEndpoints(ctx context.Context, client valkey.Client) error {
+	keys, err := allEndpointKeys(ctx, client)
+	if err != nil {
+		return fmt.Errorf("get endpoint keys: %v", err)
+	}
+	err = client.Do(ctx, client.B().Set().Key(meow.EndpointCountKey).Value(strconv.Itoa(len(keys))).Build()).Error()
+	if err != nil {
+		return fmt.Errorf("set %s: %v", meow.EndpointCountKey, err)
+	}
+	slog.Info("endpoints stored", "count", len(keys))
+	return nil
+}
+
+// loadSettings loads the settings from the given file (if any) and the
+// environment, puts them into effect, and stores them for the probe.
+func loadSettings(ctx context.Context, client valkey.Client, settingsFile string) (*meow.Settings, error) {
+	lookup, err := meow.SettingsFileLookup(settingsFile)
+	if err != nil {
+		return nil, err
+	}
+	settings, err := meow.LoadSettings(lookup)
 	if err != nil {
-		log.Printf("extract endpoint identifier of %s: %v", r.URL, err)
-		w.WriteHeader(http.StatusBadRequest)
+		return nil, err
+	}
+	err = client.Do(ctx, client.B().Arbitrary("HSET", meow.SettingsKey,
+		"default_frequency", settings.DefaultFrequency.String(),
+		"min_frequency", settings.MinFrequency.String(),
+		"default_fail_after", strconv.Itoa(int(settings.DefaultFailAfter)),
+		"incident_retention", settings.IncidentRetention.String(),
+		"status_write_on_change", strconv.FormatBool(settings.StatusWriteOnChange),
+		"history_size", strconv.Itoa(settings.HistorySize),
+		"nxdomain_as_config_error", strconv.FormatBool(settings.NXDomainAsConfigError),
+		"max_endpoints", strconv.Itoa(settings.MaxEndpoints),
+		"redact_headers", strings.Join(settings.RedactHeaders, ","),
+		"retry_transport_errors", strconv.FormatBool(settings.RetryTransportErrors),
+		"log_safe_params", strings.Join(settings.LogSafeParams, ","),
+		"breaker_threshold", strconv.Itoa(settings.BreakerThreshold),
+		"breaker_cooldown", settings.BreakerCooldown.String(),
+		"max_in_flight", strconv.Itoa(settings.MaxInFlight),
+		"reject_stale_updates", strconv.FormatBool(settings.RejectStaleUpdates),
+		"default_headers", meow.FormatHeaderList(settings.DefaultHeaders),
+		"notify_limit", strconv.Itoa(settings.NotifyLimit),
+		"notify_window", settings.NotifyWindow.String(),
+		"stats_interval", settings.StatsInterval.String(),
+		"latency_retention", settings.LatencyRetention.String()).Build()).Error()
+	if err != nil {
+		return nil, fmt.Errorf("hset %s: %v", meow.SettingsKey, err)
+	}
+	meow.ApplySettings(*settings)
+	return settings, nil
+}
+
+func reloadSettings(w http.ResponseWriter, r *http.Request, client valkey.Client, settingsFile string) {
+	logRequest(r)
+	settings, err := loadSettings(context.WithoutCancel(r.Context()), client, settingsFile)
+	if err != nil {
+		slog.Error("reload settings", "error", err)
+		writeError(w, http.StatusInternalServerError, "")
 		return
 	}
-	ctx := context.Background()
-	key := "endpoint:" + identifier
-	kvs, err := client.Do(ctx, client.B().Hgetall().Key(key).Build()).AsStrMap()
+	slog.Info("settings reloaded", "settings", fmt.Sprintf("%+v", *settings))
+	payload, err := settings.JSON()
 	if err != nil {
-		log.Printf("hgetall %s: %v", key, err)
-		w.WriteHeader(http.StatusInternalServerError)
+		slog.Error("convert settings to JSON", "error", err)
+		writeError(w, http.StatusInternalServerError, "")
 		return
 	}
-	if len(kvs) == 0 {
-		log.Printf(`no such endpoint "%s"`, identifier)
-		w.WriteHeader(http.StatusNotFound)
+	writeJSON(w, http.StatusOK, json.RawMessage(payload))
+}
+
+func getScheduler(w http.ResponseWriter, r *http.Request, client valkey.Client) {
+	logRequest(r)
+	state, err := fetchSchedulerState(r.Context(), client)
+	if err != nil {
+		slog.Error("fetch scheduler state", "error", err)
+		writeError(w, http.StatusInternalServerError, "")
 		return
 	}
-	endpoint, err := meow.EndpointFromMap(kvs)
+	writeSchedulerState(w, state)
+}
+
+// postScheduler pauses or resumes the probe scheduler as requested by the
+// state query parameter, and returns the state now in effect.
+func postScheduler(w http.ResponseWriter, r *http.Request, client valkey.Client) {
+	logRequest(r)
+	state, err := meow.ParseSchedulerState(r.URL.Query().Get("state"))
 	if err != nil {
-		log.Printf("parse endpoint from %s: %v", key, err)
-		w.WriteHeader(http.StatusInternalServerError)
+		logRejection(r, err.Error())
+		writeError(w, http.StatusBadRequest, err.Error())
 		return
 	}
-	payload, err := endpoint.JSON()
+	err = client.Do(r.Context(), client.B().Set().Key(meow.SchedulerKey).Value(string(state)).Build()).Error()
 	if err != nil {
-		log.Printf("convert %v to JSON: %v", endpoint, err)
-		w.WriteHeader(http.StatusInternalServerError)
+		slog.Error("set", "key", meow.SchedulerKey, "error", err)
+		writeError(w, http.StatusInternalServerError, "")
 		return
 	}
-	w.Write(payload)
+	slog.Info("scheduler state changed", "state", string(state))
+	writeSchedulerState(w, state)
 }
 
-func postEndpoint(w http.ResponseWriter, r *http.Request, client valkey.Client) {
-	log.Printf("POST %s from %s", r.URL, r.RemoteAddr)
-	buf := bytes.NewBufferString("")
-	io.Copy(buf, r.Body)
-	defer r.Body.Close()
-	endpoint, err := meow.EndpointFromJSON(buf.String())
+// fetchSchedulerState returns the stored scheduler state, which is running
+// unless stored otherwise.
+func fetchSchedulerState(ctx context.Context, client valkey.Client) (meow.SchedulerState, error) {
+	raw, err := client.Do(ctx, client.B().Get().Key(meow.SchedulerKey).Build()).ToString()
+	if valkey.IsValkeyNil(err) {
+		return meow.SchedulerRunning, nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("get %s: %v", meow.SchedulerKey, err)
+	}
+	return meow.ParseSchedulerState(raw)
+}
+
+func writeSchedulerState(w http.ResponseWriter, state meow.SchedulerState) {
+	payload, err := state.JSON()
 	if err != nil {
-		log.Printf("parse JSON body: %v", err)
-		w.WriteHeader(http.StatusBadRequest)
+		slog.Error("convert scheduler state to JSON", "state", string(state), "error", err)
+		writeError(w, http.StatusInternalServerError, "")
 		return
 	}
-	ctx := context.Background()
-	key := "endpoint:" + endpoint.Identifier
-	// Check if exists
-	existing, err := client.Do(ctx, client.B().Hgetall().Key(key).Build()).AsStrMap()
+	writeJSON(w, http.StatusOK, json.RawMessage(payload))
+}
+
+func getReadOnly(w http.ResponseWriter, r *http.Request, client valkey.Client) {
+	logRequest(r)
+	readOnly, err := fetchReadOnly(r.Context(), client)
 	if err != nil {
-		log.Printf("hgetall %s: %v", key, err)
-		w.WriteHeader(http.StatusInternalServerError)
+		slog.Error("fetch read-only flag", "error", err)
+		writeError(w, http.StatusInternalServerError, "")
 		return
 	}
-	exists := len(existing) > 0
-	var status int
-	if exists {
-		// updating existing endpoint
-		identifierPathParam, err := extractEndpointIdentifier(r.URL.String())
+	writeReadOnly(w, readOnly)
+}
+
+// postReadOnly freezes or unfreezes the configuration as requested by the on
+// query parameter, and returns whether it is read-only now.
+func postReadOnly(w http.ResponseWriter, r *http.Request, client valkey.Client) {
+	logRequest(r)
+	readOnly, err := strconv.ParseBool(r.URL.Query().Get("on"))
+	if err != nil {
+		logRejection(r, "parse on: "+err.Error())
+		writeError(w, http.StatusBadRequest, "parse on: "+err.Error())
+		return
+	}
+	if readOnly {
+		err = client.Do(r.Context(), client.B().Set().Key(meow.ReadOnlyKey).Value("true").Build()).Error()
+	} else {
+		err = client.Do(r.Context(), client.B().Del().Key(meow.ReadOnlyKey).Build()).Error()
+	}
+	if err != nil {
+		slog.Error("update", "key", meow.ReadOnlyKey, "error", err)
+		writeError(w, http.StatusInternalServerError, "")
+		return
+	}
+	slog.Info("read-only mode changed", "read_only", readOnly)
+	writeReadOnly(w, readOnly)
+}
+
+// fetchReadOnly returns whether or not the configuration is frozen, which it
+// is not unless stored otherwise.
+func fetchReadOnly(ctx context.Context, client valkey.Client) (bool, error) {
+	raw, err := client.Do(ctx, client.B().Get().Key(meow.ReadOnlyKey).Build()).ToString()
+	if valkey.IsValkeyNil(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("get %s: %v", meow.ReadOnlyKey, err)
+	}
+	return strconv.ParseBool(raw)
+}
+
+func writeReadOnly(w http.ResponseWriter, readOnly bool) {
+	writeJSON(w, http.StatusOK, struct {
+		ReadOnly bool `json:"read_only"`
+	}{readOnly})
+}
+
+// getProbeStats returns the runtime statistics persisted by the probe, the most
+// recent entry first.
+func getProbeStats(w http.ResponseWriter, r *http.Request, client valkey.Client) {
+	logRequest(r)
+	raws, err := client.Do(r.Context(), client.B().Lrange().Key(meow.ProbeStatsKey).Start(0).Stop(-1).Build()).AsStrSlice()
+	if err != nil {
+		slog.Error("lrange", "key", meow.ProbeStatsKey, "error", err)
+		writeError(w, http.StatusInternalServerError, "")
+		return
+	}
+	stats := make([]meow.ProbeStats, 0, len(raws))
+	for _, raw := range raws {
+		entry, err := meow.ProbeStatsFromJSON(raw)
 		if err != nil {
-			log.Printf("extract endpoint identifier of %s: %v", r.URL, err)
-			w.WriteHeader(http.StatusBadRequest)
+			slog.Error("parse probe stats", "key", meow.ProbeStatsKey, "error", err)
+			writeError(w, http.StatusInternalServerError, "")
 			return
 		}
-		if identifierPathParam != endpoint.Identifier {
-			log.Printf("identifier mismatch: (ressource: %s, body: %s)",
-				identifierPathParam, endpoint.Identifier)
-			w.WriteHeader(http.StatusBadRequest)
+		stats = append(stats, *entry)
+	}
+	writeJSON(w, http.StatusOK, stats)
+}
+
+// postExpectedBuild sets the build the endpoint is expected to report through
+// its build header to the expected query parameter, or clears it, if empty.
+func postExpectedBuild(w http.ResponseWriter, r *http.Request, client valkey.Client, store meow.ConfigStore) {
+	logRequest(r)
+	identifier := r.PathValue("id")
+	_, err := store.Stat(r.Context(), identifier)
+	if errors.Is(err, meow.ErrNotFound) {
+		logRejection(r, "endpoint not found")
+		writeError(w, http.StatusNotFound, "endpoint not found")
+		return
+	}
+	if err != nil {
+		slog.Error("check existence of endpoint", "identifier", identifier, "error", err)
+		writeError(w, http.StatusInternalServerError, "")
+		return
+	}
+	key := meow.ExpectedBuildKey(identifier)
+	expected := r.URL.Query().Get("expected")
+	if expected == "" {
+		err = client.Do(r.Context(), client.B().Del().Key(key).Build()).Error()
+	} else {
+		err = client.Do(r.Context(), client.B().Set().Key(key).Value(expected).Build()).Error()
+	}
+	if err != nil {
+		slog.Error("update", "key", key, "error", err)
+		writeError(w, http.StatusInternalServerError, "")
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// logURL returns the URL u sanitized for logging, i.e. with the values of
+// query parameters other than the safe ones redacted.
+func logURL(u *url.URL) string {
+	return meow.SanitizeURL(u, meow.CurrentSettings().LogSafeParams)
+}
+
+// Formats of the log: human-readable key=value pairs, or one JSON object per
+// line.
+const (
+	logFormatText = "text"
+	logFormatJSON = "json"
+)
+
+// newLogger creates a logger writing to w in the given format, which logs
+// events of level or above.
+func newLogger(w io.Writer, format, level string) (*slog.Logger, error) {
+	var minLevel slog.Level
+	if err := minLevel.UnmarshalText([]byte(level)); err != nil {
+		return nil, fmt.Errorf(`log level "%s" is not debug, info, warn, or error`, level)
+	}
+	options := slog.HandlerOptions{Level: minLevel}
+	switch format {
+	case logFormatText:
+		return slog.New(slog.NewTextHandler(w, &options)), nil
+	case logFormatJSON:
+		return slog.New(slog.NewJSONHandler(w, &options)), nil
+	default:
+		return nil, fmt.Errorf(`log format "%s" is neither %s nor %s`, format, logFormatText, logFormatJSON)
+	}
+}
+
+// fatal logs msg with the attributes args as an error and exits.
+func fatal(msg string, args ...any) {
+	slog.Error(msg, args...)
+	os.Exit(1)
+}
+
+// requestAttrs returns the attributes describing the request r: its method,
+// URL (as sanitized by logURL), the client's address, and the identifier of the
+// endpoint requested, if any.
+func requestAttrs(r *http.Request) []any {
+	attrs := []any{"method", r.Method, "url", logURL(r.URL), "remote_addr", r.RemoteAddr}
+	identifier := r.PathValue("id")
+	if rest, ok := strings.CutPrefix(r.URL.Path, "/endpoints/"); ok && identifier == "" {
+		identifier, _, _ = strings.Cut(rest, "/")
+	}
+	if identifier != "" {
+		attrs = append(attrs, "identifier", identifier)
+	}
+	return attrs
+}
+
+// logRequest logs the request r as it is handled.
+func logRequest(r *http.Request) {
+	slog.Info("request", requestAttrs(r)...)
+}
+
+// logRejection logs that the request r was rejected for the given reason, along
+// with the further attributes args.
+func logRejection(r *http.Request, reason string, args ...any) {
+	attrs := append(requestAttrs(r), "reason", reason)
+	slog.Warn("request rejected", append(attrs, args...)...)
+}
+
+// requireAdmin wraps handler, so that it is only called for requests providing
+// token as a bearer token in the Authorization header. If token is empty,
+// administrative requests are rejected altogether.
+func requireAdmin(token string, handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		provided, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok {
+			logRejection(r, "no bearer token")
+			writeError(w, http.StatusUnauthorized, "no bearer token")
 			return
 		}
-		status = http.StatusNoContent
-	} else {
-		status = http.StatusCreated
+		if token == "" || subtle.ConstantTimeCompare([]byte(provided), []byte(token)) != 1 {
+			logRejection(r, "invalid admin token")
+			writeError(w, http.StatusForbidden, "invalid admin token")
+			return
+		}
+		handler(w, r)
+	}
+}
+
+// caller is the identity of the client performing a request.
+type caller struct {
+	// owner is the owner the caller acts on behalf of.
+	owner string
+
+	// admin indicates that the caller may access all endpoints.
+	admin bool
+
+	// anonymous indicates that the caller did not identify itself, because
+	// no tokens are in use.
+	anonymous bool
+}
+
+// mayAccess indicates whether or not the caller may see and modify endpoints
+// owned by owner.
+func (c caller) mayAccess(owner string) bool {
+	return c.admin || c.owner == owner
+}
+
+// name identifies the caller performing r in the metadata of the endpoints it
+// writes: by its owner, as admin, or by its address if it is anonymous.
+func (c caller) name(r *http.Request) string {
+	switch {
+	case c.owner != "":
+		return c.owner
+	case c.anonymous:
+		if host, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+			return host
+		}
+		return r.RemoteAddr
+	}
+	return "admin"
+}
+
+type callerKey struct{}
+
+// callerFrom returns the caller identified for r. Requests not passing through
+// authenticator.identify (e.g. for public badges) are not restricted.
+func callerFrom(r *http.Request) caller {
+	if c, ok := r.Context().Value(callerKey{}).(caller); ok {
+		return c
+	}
+	return caller{admin: true, anonymous: true}
+}
+
+// authenticator identifies callers by their bearer token. Unless owner tokens
+// are configured, the API is not scoped by owners, and every caller may access
+// all endpoints.
+type authenticator struct {
+	adminToken string
+
+	// ownerTokens maps owners to their tokens.
+	ownerTokens map[string]string
+}
+
+// parseOwnerTokens parses raw, which consists of owner:token pairs separated by
+// commas.
+func parseOwnerTokens(raw string) (map[string]string, error) {
+	tokens := make(map[string]string)
+	for _, pair := range strings.Split(raw, ",") {
+		if pair = strings.TrimSpace(pair); pair == "" {
+			continue
+		}
+		owner, token, ok := strings.Cut(pair, ":")
+		if !ok || owner == "" || token == "" {
+			return nil, fmt.Errorf(`"%s" is not of the form owner:token`, pair)
+		}
+		tokens[owner] = token
+	}
+	return tokens, nil
+}
+
+// identify wraps handler, so that it is called with the caller identified by
+// the bearer token in the Authorization header, which can be retrieved using
+// callerFrom. If the API is scoped by owners, requests with a missing or
+// unknown token are rejected.
+func (a authenticator) identify(handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		c := caller{admin: true, anonymous: true}
+		if len(a.ownerTokens) > 0 {
+			provided, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+			if !ok {
+				logRejection(r, "no bearer token")
+				writeError(w, http.StatusUnauthorized, "no bearer token")
+				return
+			}
+			c, ok = a.callerFor(provided)
+			if !ok {
+				logRejection(r, "invalid token")
+				writeError(w, http.StatusForbidden, "invalid token")
+				return
+			}
+		}
+		handler(w, r.WithContext(context.WithValue(r.Context(), callerKey{}, c)))
 	}
-	// HSET the endpoint
-	err = client.Do(ctx, client.B().Arbitrary("HSET", key,
-		"identifier", endpoint.Identifier,
-		"url", endpoint.URL.String(),
-		"method", endpoint.Method,
-		"status_online", strconv.Itoa(int(endpoint.StatusOnline)),
-		"frequency", endpoint.Frequency.String(),
-		"fail_after", strconv.Itoa(int(endpoint.FailAfter))).Build()).Error()
+}
+
+// callerFor returns the caller identified by the token, which is either the
+// admin token or one of the owner tokens.
+func (a authenticator) callerFor(token string) (caller, bool) {
+	if a.adminToken != "" && subtle.ConstantTimeCompare([]byte(token), []byte(a.adminToken)) == 1 {
+		return caller{admin: true}, true
+	}
+	for owner, ownerToken := range a.ownerTokens {
+		if subtle.ConstantTimeCompare([]byte(token), []byte(ownerToken)) == 1 {
+			return caller{owner: owner}, true
+		}
+	}
+	return caller{}, false
+}
+
+func getEndpoint(w http.ResponseWriter, r *http.Request, store meow.ConfigStore) {
+	logRequest(r)
+	identifier, err := extractEndpointIdentifier(r.URL.String())
 	if err != nil {
-		log.Printf("hset %s: %v", key, err)
-		w.WriteHeader(http.StatusInternalServerError)
+		logRejection(r, err.Error())
+		writeError(w, http.StatusBadRequest, err.Error())
 		return
 	}
-	w.WriteHeader(status)
+	endpoint, err := fetchEndpointFor(r.Context(), store, callerFrom(r), identifier)
+	if err != nil {
+		slog.Error("fetch endpoint", "error", err)
+		writeError(w, statusForError(err), "")
+		return
+	}
+	payload, err := endpoint.JSON()
+	if err != nil {
+		slog.Error("convert endpoint to JSON", "endpoint", endpoint.String(), "error", err)
+		writeError(w, http.StatusInternalServerError, "")
+		return
+	}
+	w.Header().Set("ETag", endpointETag(endpoint.Version))
+	writeJSON(w, http.StatusOK, json.RawMessage(payload))
 }
 
-func getEndpoints(w http.ResponseWriter, r *http.Request, client valkey.Client) {
-	if r.Method != http.MethodGet {
-		log.Printf("request from %s rejected: method %s not allowed",
-			r.RemoteAddr, r.Method)
-		w.WriteHeader(http.StatusMethodNotAllowed)
+func postEndpoint(w http.ResponseWriter, r *http.Request, store meow.ConfigStore, results resultStore) {
+	logRequest(r)
+	if !requireJSON(w, r) {
 		return
 	}
-	log.Printf("GET %s from %s", r.URL, r.RemoteAddr)
-	ctx := context.Background()
-	keys, err := client.Do(ctx, client.B().Keys().Pattern("endpoint:*").Build()).AsStrSlice()
+	buf := bytes.NewBufferString("")
+	io.Copy(buf, r.Body)
+	defer r.Body.Close()
+	endpoint, err := meow.EndpointFromJSON(buf.String())
 	if err != nil {
-		log.Printf("get keys for endpoint:*: %v", err)
-		w.WriteHeader(http.StatusInternalServerError)
+		logRejection(r, "parse JSON body: "+err.Error())
+		writeInvalidEndpoint(w, err)
 		return
 	}
-	payloads := make([]meow.EndpointPayload, 0, len(keys))
-	for _, key := range keys {
-		kvs, err := client.Do(ctx, client.B().Hgetall().Key(key).Build()).AsStrMap()
+	c := callerFrom(r)
+	if !c.admin && endpoint.Owner == "" {
+		endpoint.Owner = c.owner
+	}
+	if !c.mayAccess(endpoint.Owner) {
+		logRejection(r, "cannot assign owner", "owner", c.owner, "assigned_owner", endpoint.Owner)
+		writeError(w, http.StatusForbidden, "cannot assign owner")
+		return
+	}
+	// not cancelled if the client goes away, so that the writes are not left
+	// half done
+	ctx := context.WithoutCancel(r.Context())
+	idempotencyKey := r.Header.Get("Idempotency-Key")
+	if idempotencyKey != "" {
+		replayed, err := replayIdempotentResult(ctx, w, results, idempotencyKey, endpoint.Identifier)
 		if err != nil {
-			log.Printf("hgetall %s: %v", key, err)
-			w.WriteHeader(http.StatusInternalServerError)
+			slog.Error("replay result of idempotency key", "idempotency_key", idempotencyKey, "error", err)
+			writeError(w, http.StatusInternalServerError, "")
 			return
 		}
-		statusOnline, _ := strconv.Atoi(kvs["status_online"])
-		failAfter, _ := strconv.Atoi(kvs["fail_after"])
-		payload := meow.EndpointPayload{
-			Identifier:   kvs["identifier"],
-			URL:          kvs["url"],
-			Method:       kvs["method"],
-			StatusOnline: uint16(statusOnline),
-			Frequency:    kvs["frequency"],
-			FailAfter:    uint8(failAfter),
+		if replayed {
+			return
 		}
-		payloads = append(payloads, payload)
 	}
-	data, err := json.Marshal(payloads)
-	if err != nil {
-		log.Printf("serialize payloads: %v", err)
-		w.WriteHeader(http.StatusInternalServerError)
-		return
+	ifMatch := strings.TrimSpace(r.Header.Get("If-Match"))
+	var matchVersions []uint64
+	if ifMatch != "*" {
+		if matchVersions, err = parseIfMatch(ifMatch); err != nil {
+			logRejection(r, err.Error())
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
 	}
-	w.Write(data)
-}
+	// posted with If-None-Match * in order to create the endpoint, but not to
+	// overwrite it
+	var createOnly bool
+	if ifNoneMatch := strings.TrimSpace(r.Header.Get("If-None-Match")); ifNoneMatch != "" {
+		if ifNoneMatch != "*" {
+			logRejection(r, fmt.Sprintf(`If-None-Match "%s" is not *`, ifNoneMatch))
+			writeError(w, http.StatusBadRequest, fmt.Sprintf(`If-None-Match "%s" is not *`, ifNoneMatch))
+			return
+		}
+		createOnly = true
+	}
+	if createOnly && ifMatch != "" {
+		logRejection(r, "If-Match conflicts with creating the endpoint")
+		writeError(w, http.StatusBadRequest, "If-Match conflicts with creating the endpoint")
+		return
+	}
+	stat, err := store.Stat(ctx, endpoint.Identifier)
+	exists := err == nil
+	if err != nil && !errors.Is(err, meow.ErrNotFound) {
+		slog.Error("check existence of endpoint", "identifier", endpoint.Identifier, "error", err)
+		writeError(w, statusForError(err), "")
+		return
+	}
+	var info meow.EndpointInfo
+	if exists {
+		info = *stat
+	}
+	if ifMatch == "*" && !exists {
+		logRejection(r, "If-Match * requires the endpoint to exist")
+		writeError(w, http.StatusPreconditionFailed, "If-Match * requires the endpoint to exist")
+		return
+	}
+	// the version the update is based on, if the client provided it
+	observedVersion := endpoint.Version
+	updatedBy := c.name(r)
+	if exists {
+		// updating existing endpoint
+		if createOnly {
+			err := fmt.Errorf("create endpoint %s: %w", endpoint.Identifier, meow.ErrConflict)
+			logRejection(r, err.Error())
+			writeError(w, statusForError(err), err.Error())
+			return
+		}
+		identifierPathParam, err := extractEndpointIdentifier(r.URL.String())
+		if err != nil {
+			logRejection(r, err.Error())
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		if identifierPathParam != endpoint.Identifier {
+			logRejection(r, "identifier mismatch", "body_identifier", endpoint.Identifier)
+			writeError(w, http.StatusBadRequest, "identifier mismatch")
+			return
+		}
+		if !c.mayAccess(info.Owner) {
+			logRejection(r, "endpoint of another owner", "owner", info.Owner)
+			writeError(w, http.StatusForbidden, "endpoint of another owner")
+			return
+		}
+		if observedVersion > 0 && observedVersion < info.Version {
+			// the client did not see the updates since observedVersion
+			slog.Warn("stale update", "identifier", endpoint.Identifier, "updated_by", updatedBy,
+				"observed_version", observedVersion, "stored_version", info.Version,
+				"previous_updated_by", info.UpdatedBy)
+			if meow.CurrentSettings().RejectStaleUpdates {
+				writeError(w, http.StatusConflict, "the endpoint was updated in the meantime")
+				return
+			}
+		}
+		stored, err := store.Get(ctx, endpoint.Identifier)
+		matched := matchVersions == nil || slices.Contains(matchVersions, info.Version)
+		if err == nil && matched && stored.Equal(endpoint) {
+			// a failed precondition is reported by the write instead
+			slog.Info("endpoint unchanged", "endpoint", endpoint.String(), "updated_by", updatedBy)
+			w.Header().Set("ETag", endpointETag(info.Version))
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+	}
+	endpoint.UpdatedBy = updatedBy
+	created, err := store.PutIf(ctx, endpoint, meow.Precondition{CreateOnly: createOnly, Versions: matchVersions})
+	if errors.Is(err, meow.ErrConflict) || errors.Is(err, meow.ErrPreconditionFailed) {
+		// e.g. created or written concurrently since the existence was checked
+		logRejection(r, err.Error())
+		writeError(w, statusForError(err), err.Error())
+		return
+	}
+	if errors.Is(err, meow.ErrLimitReached) {
+		slog.Warn("create endpoint", "identifier", endpoint.Identifier, "error", err)
+		writeError(w, statusForError(err), "")
+		return
+	}
+	if err != nil {
+		slog.Error("write endpoint", "identifier", endpoint.Identifier, "error", err)
+		writeError(w, http.StatusInternalServerError, "")
+		return
+	}
+	status := http.StatusCreated
+	if !created {
+		status = http.StatusNoContent
+		if exists && endpoint.Version != info.Version+1 {
+			slog.Warn("concurrent update", "identifier", endpoint.Identifier, "updated_by", updatedBy, "version", endpoint.Version-1)
+		}
+		// observed version 0: not provided by the client
+		slog.Info("stored endpoint", "endpoint", endpoint.String(), "version", endpoint.Version,
+			"updated_by", updatedBy, "observed_version", observedVersion,
+			"replaced_version", info.Version, "previous_updated_by", info.UpdatedBy)
+	} else {
+		slog.Info("stored endpoint", "endpoint", endpoint.String(), "version", endpoint.Version, "updated_by", updatedBy)
+	}
+	result := idempotentResult{Identifier: endpoint.Identifier, Status: status, ETag: endpointETag(endpoint.Version)}
+	if status == http.StatusCreated {
+		// return the stored representation, including the defaults applied
+		result.Body, err = endpoint.JSON()
+		if err != nil {
+			slog.Error("convert endpoint to JSON", "endpoint", endpoint.String(), "error", err)
+			writeError(w, http.StatusInternalServerError, "")
+			return
+		}
+	}
+	if idempotencyKey != "" {
+		if err := results.save(ctx, idempotencyKey, result); err != nil {
+			slog.Error("store result of idempotency key", "idempotency_key", idempotencyKey, "error", err)
+		}
+	}
+	result.write(w)
+}
+
+// endpointETag returns the weak entity tag of the endpoint stored with the
+// given version, which changes with every write.
+func endpointETag(version uint64) string {
+	return fmt.Sprintf(`W/"%d"`, version)
+}
+
+// parseIfMatch parses the entity tags of the If-Match header raw, and returns
+// the versions of the endpoint they match, which are nil if raw is empty. The
+// tags are compared regardless of whether they are weak or strong. An error is
+// returned for tags not issued by endpointETag (including "*", which must be
+// handled by the caller).
+func parseIfMatch(raw string) ([]uint64, error) {
+	if strings.TrimSpace(raw) == "" {
+		return nil, nil
+	}
+	var versions []uint64
+	for _, tag := range strings.Split(raw, ",") {
+		tag = strings.TrimPrefix(strings.TrimSpace(tag), "W/")
+		unquoted, ok := strings.CutPrefix(tag, `"`)
+		if ok {
+			unquoted, ok = strings.CutSuffix(unquoted, `"`)
+		}
+		version, err := strconv.ParseUint(unquoted, 10, 64)
+		if !ok || err != nil {
+			return nil, fmt.Errorf(`If-Match "%s" is not a list of entity tags of endpoints`, raw)
+		}
+		versions = append(versions, version)
+	}
+	return versions, nil
+}
+
+// maxImportSize is the maximum number of endpoints imported at once.
+const maxImportSize = 1000
+
+// importResult reports the outcome of importing an endpoint: created,
+// updated, error (with the reason), or skipped (valid, but not imported,
+// because other endpoints of the batch are invalid).
+type importResult struct {
+	Identifier string `json:"identifier"`
+	Result     string `json:"result"`
+	Field      string `json:"field,omitempty"`
+	Error      string `json:"error,omitempty"`
+}
+
+// postEndpoints imports the endpoints of the JSON array in the request body,
+// each of which is created or updated. The endpoints are stored within a single
+// transaction, so that either all or none of them are stored. The outcome of
+// each endpoint is reported with 207 Multi-Status, or with 400 Bad Request, if
+// none were stored due to invalid ones.
+func postEndpoints(w http.ResponseWriter, r *http.Request, store meow.ConfigStore) {
+	logRequest(r)
+	if !requireJSON(w, r) {
+		return
+	}
+	defer r.Body.Close()
+	var raws []json.RawMessage
+	if err := json.NewDecoder(r.Body).Decode(&raws); err != nil {
+		logRejection(r, "parse JSON body: "+err.Error())
+		writeInvalidEndpoint(w, fmt.Errorf("body is not a JSON array of endpoints: %v", err))
+		return
+	}
+	if len(raws) > maxImportSize {
+		logRejection(r, "import too large", "endpoints", len(raws), "max", maxImportSize)
+		writeInvalidEndpoint(w, fmt.Errorf("%d endpoints exceed the maximum of %d", len(raws), maxImportSize))
+		return
+	}
+	results := make([]importResult, len(raws))
+	seen := make(map[string]bool)
+	for i, raw := range raws {
+		var identified struct {
+			Identifier string `json:"identifier"`
+		}
+		json.Unmarshal(raw, &identified)
+		if identified.Identifier != "" && seen[identified.Identifier] {
+			logRejection(r, "endpoint posted repeatedly", "identifier", identified.Identifier)
+			writeInvalidEndpoint(w, fmt.Errorf("endpoint %s is posted repeatedly", identified.Identifier))
+			return
+		}
+		seen[identified.Identifier] = true
+		results[i].Identifier = identified.Identifier
+	}
+	c := callerFrom(r)
+	updatedBy := c.name(r)
+	// not cancelled if the client goes away, so that the writes are not left
+	// half done
+	ctx := context.WithoutCancel(r.Context())
+	endpoints := make([]*meow.Endpoint, len(raws))
+	// the endpoints must be stored as they are now, so that an owner changed
+	// meanwhile cannot be overridden
+	conds := make([]meow.Precondition, len(raws))
+	valid := true
+	for i, raw := range raws {
+		endpoint, err := meow.EndpointFromJSON(string(raw))
+		if err != nil {
+			results[i].Result, results[i].Error = "error", err.Error()
+			var fieldErr *meow.FieldError
+			if errors.As(err, &fieldErr) {
+				results[i].Field, results[i].Error = fieldErr.Field, fieldErr.Err.Error()
+			}
+			valid = false
+			continue
+		}
+		if !c.admin && endpoint.Owner == "" {
+			endpoint.Owner = c.owner
+		}
+		info, err := store.Stat(ctx, endpoint.Identifier)
+		exists := err == nil
+		if err != nil && !errors.Is(err, meow.ErrNotFound) {
+			slog.Error("import endpoints", "identifier", endpoint.Identifier, "error", err)
+			writeError(w, http.StatusInternalServerError, "")
+			return
+		}
+		if !c.mayAccess(endpoint.Owner) || (exists && !c.mayAccess(info.Owner)) {
+			slog.Warn("import of endpoint rejected", "identifier", endpoint.Identifier, "remote_addr", r.RemoteAddr, "owner", c.owner)
+			results[i].Result, results[i].Error = "error", meow.ErrForbidden.Error()
+			valid = false
+			continue
+		}
+		conds[i] = meow.Precondition{CreateOnly: true}
+		if exists {
+			conds[i] = meow.Precondition{Versions: []uint64{info.Version}}
+		}
+		endpoint.UpdatedBy = updatedBy
+		endpoints[i] = endpoint
+	}
+	if !valid {
+		for i := range results {
+			if results[i].Result != "error" {
+				results[i].Result = "skipped"
+			}
+		}
+		writeJSON(w, http.StatusBadRequest, results)
+		return
+	}
+	created, err := store.PutAll(ctx, endpoints, conds)
+	if errors.Is(err, meow.ErrPreconditionFailed) || errors.Is(err, meow.ErrConflict) {
+		err = fmt.Errorf("import endpoints: changed concurrently: %w", meow.ErrConflict)
+	}
+	if err != nil {
+		slog.Error("import endpoints", "error", err)
+		writeError(w, statusForError(err), "")
+		return
+	}
+	var n int
+	for i := range results {
+		results[i].Result = "updated"
+		if created[i] {
+			results[i].Result = "created"
+			n++
+		}
+	}
+	slog.Info("imported endpoints", "count", len(results), "created", n, "updated_by", updatedBy)
+	writeJSON(w, http.StatusMultiStatus, results)
+}
+
+// invalidEndpoint is the body of a response rejecting an invalid endpoint:
+// the error, and the field it concerns, if it can be attributed to one.
+type invalidEndpoint struct {
+	Field string `json:"field,omitempty"`
+	Error string `json:"error"`
+}
+
+// errorBody is the body of a response rejecting a request or reporting its
+// failure.
+type errorBody struct {
+	Error string `json:"error"`
+}
+
+// writeError responds with status and a body giving the reason, or the status
+// text if reason is empty, e.g. for internal errors not to be disclosed.
+func writeError(w http.ResponseWriter, status int, reason string) {
+	if reason == "" {
+		reason = http.StatusText(status)
+	}
+	writeJSON(w, status, errorBody{Error: reason})
+}
+
+// requireJSON indicates whether or not the body of r is declared as JSON by its
+// Content-Type header. If not, r is rejected with 415 Unsupported Media Type.
+func requireJSON(w http.ResponseWriter, r *http.Request) bool {
+	contentType := r.Header.Get("Content-Type")
+	if mediaType, _, err := mime.ParseMediaType(contentType); err != nil || mediaType != "application/json" {
+		logRejection(r, "body is not JSON", "content_type", contentType)
+		writeError(w, http.StatusUnsupportedMediaType, "the body must be of type application/json")
+		return false
+	}
+	return true
+}
+
+// writeJSON responds with status and v serialized as JSON as the body, or with
+// 500 Internal Server Error if v cannot be serialized. A body serialized
+// already is passed as json.RawMessage.
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		slog.Error("convert response to JSON", "error", err)
+		writeError(w, http.StatusInternalServerError, "")
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write(data)
+}
+
+// writeInvalidEndpoint rejects an endpoint that could not be parsed due to err
+// with 400 Bad Request, describing the error in the body.
+func writeInvalidEndpoint(w http.ResponseWriter, err error) {
+	body := invalidEndpoint{Error: err.Error()}
+	var fieldErr *meow.FieldError
+	if errors.As(err, &fieldErr) {
+		body.Field, body.Error = fieldErr.Field, fieldErr.Err.Error()
+	}
+	writeJSON(w, http.StatusBadRequest, body)
+}
+
+func deleteEndpoint(w http.ResponseWriter, r *http.Request, store meow.ConfigStore) {
+	logRequest(r)
+	identifier, err := extractEndpointIdentifier(r.URL.String())
+	if err != nil {
+		logRejection(r, err.Error())
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	// not cancelled if the client goes away, so that the writes are not left
+	// half done
+	ctx := context.WithoutCancel(r.Context())
+	// not parsed, so that endpoints stored malformed can be deleted, too
+	info, err := store.Stat(ctx, identifier)
+	if errors.Is(err, meow.ErrNotFound) {
+		logRejection(r, "endpoint not found")
+		writeError(w, http.StatusNotFound, "endpoint not found")
+		return
+	}
+	if err != nil {
+		slog.Error("check existence of endpoint", "identifier", identifier, "error", err)
+		writeError(w, http.StatusInternalServerError, "")
+		return
+	}
+	if !callerFrom(r).mayAccess(info.Owner) {
+		logRejection(r, "endpoint of another owner", "owner", info.Owner)
+		writeError(w, http.StatusForbidden, "endpoint of another owner")
+		return
+	}
+	err = store.Delete(ctx, identifier)
+	if errors.Is(err, meow.ErrNotFound) {
+		// deleted concurrently
+		logRejection(r, "endpoint not found")
+		writeError(w, http.StatusNotFound, "endpoint not found")
+		return
+	}
+	if err != nil {
+		// the endpoint is gone already, but not all of its data
+		slog.Error("delete endpoint", "identifier", identifier, "error", err)
+	}
+	slog.Info("deleted endpoint", "identifier", identifier)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// idempotencyWindow is how long the result of a request with an
+// Idempotency-Key header is retained for replay.
+const idempotencyWindow = 24 * time.Hour
+
+// idempotentResult is the result of a write request, which is replayed to
+// clients retrying the request with the same Idempotency-Key header.
+type idempotentResult struct {
+	Identifier string `json:"identifier"`
+	Status     int    `json:"status"`
+	Body       []byte `json:"body,omitempty"`
+	ETag       string `json:"etag,omitempty"`
+}
+
+func (i idempotentResult) write(w http.ResponseWriter) {
+	if i.Status == http.StatusCreated {
+		w.Header().Set("Location", "/endpoints/"+i.Identifier)
+	}
+	if i.ETag != "" {
+		w.Header().Set("ETag", i.ETag)
+	}
+	if len(i.Body) > 0 {
+		w.Header().Set("Content-Type", "application/json")
+	}
+	w.WriteHeader(i.Status)
+	w.Write(i.Body)
+}
+
+// resultStore retains the results of write requests for replay.
+type resultStore interface {
+	// load returns the result stored for idempotencyKey, or nil, if there is
+	// none.
+	load(ctx context.Context, idempotencyKey string) (*idempotentResult, error)
+
+	// save stores the result for idempotencyKey unless there is already one,
+	// which expires after the idempotencyWindow.
+	save(ctx context.Context, idempotencyKey string, result idempotentResult) error
+}
+
+// valkeyResults keeps the results in Valkey, each under the key
+// idempotency:{key}.
+type valkeyResults struct {
+	client valkey.Client
+}
+
+func (v valkeyResults) load(ctx context.Context, idempotencyKey string) (*idempotentResult, error) {
+	client := v.client
+	key := "idempotency:" + idempotencyKey
+	raw, err := client.Do(ctx, client.B().Get().Key(key).Build()).ToString()
+	if valkey.IsValkeyNil(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("get %s: %v", key, err)
+	}
+	var result idempotentResult
+	if err := json.Unmarshal([]byte(raw), &result); err != nil {
+		return nil, fmt.Errorf("parse result from %s: %v", key, err)
+	}
+	return &result, nil
+}
+
+func (v valkeyResults) save(ctx context.Context, idempotencyKey string, result idempotentResult) error {
+	client := v.client
+	key := "idempotency:" + idempotencyKey
+	data, err := json.Marshal(result)
+	if err != nil {
+		return fmt.Errorf("serialize result: %v", err)
+	}
+	err = client.Do(ctx, client.B().Set().Key(key).Value(string(data)).Nx().
+		Ex(idempotencyWindow).Build()).Error()
+	if err != nil && !valkey.IsValkeyNil(err) {
+		return fmt.Errorf("set %s: %v", key, err)
+	}
+	return nil
+}
+
+// replayIdempotentResult writes the result stored for idempotencyKey to w and
+// indicates whether or not there was such a result. If the stored result
+// belongs to another endpoint than identifier, 422 is written instead.
+func replayIdempotentResult(ctx context.Context, w http.ResponseWriter, results resultStore,
+	idempotencyKey, identifier string) (bool, error) {
+	result, err := results.load(ctx, idempotencyKey)
+	if err != nil || result == nil {
+		return false, err
+	}
+	if result.Identifier != identifier {
+		slog.Warn("idempotency key used for another endpoint", "idempotency_key", idempotencyKey,
+			"used_for", result.Identifier, "identifier", identifier)
+		writeError(w, http.StatusUnprocessableEntity, "the idempotency key was used for another endpoint")
+		return true, nil
+	}
+	slog.Info("replay result of idempotency key", "idempotency_key", idempotencyKey)
+	result.write(w)
+	return true, nil
+}
+
+func getEndpointSchedule(w http.ResponseWriter, r *http.Request, client valkey.Client, store meow.ConfigStore) {
+	logRequest(r)
+	endpoint := endpointForSubresource(w, r, store, "/schedule")
+	if endpoint == nil {
+		return
+	}
+	ctx := r.Context()
+	statusKey := meow.StatusKey(endpoint.Identifier)
+	state, err := client.Do(ctx, client.B().Hgetall().Key(statusKey).Build()).AsStrMap()
+	if err != nil {
+		slog.Error("hgetall", "key", statusKey, "error", err)
+		writeError(w, http.StatusInternalServerError, "")
+		return
+	}
+	schedule, err := meow.ScheduleFromMap(state)
+	if err != nil {
+		slog.Error("parse schedule", "key", statusKey, "error", err)
+		writeError(w, http.StatusInternalServerError, "")
+		return
+	}
+	if schedule.EffectiveInterval == 0 {
+		// not scheduled yet: the configured frequency will be applied
+		schedule.EffectiveInterval = endpoint.Frequency
+	}
+	payload, err := schedule.JSON()
+	if err != nil {
+		slog.Error("convert schedule to JSON", "error", err)
+		writeError(w, http.StatusInternalServerError, "")
+		return
+	}
+	writeJSON(w, http.StatusOK, json.RawMessage(payload))
+}
+
+// maxProbeBatch is the maximum number of endpoints probed on demand at once,
+// of which up to probeBatchParallelism are probed concurrently.
+const (
+	maxProbeBatch         = 50
+	probeBatchParallelism = 8
+)
+
+// maxProbeClients is the maximum number of HTTP clients shared between the
+// endpoints probed on demand.
+const maxProbeClients = 8
+
+// postProbe probes the endpoints selected by either the tag or the
+// comma-separated ids query parameter once, and writes their results ordered
+// by identifier. The results are neither persisted nor alerted on. Endpoints of
+// the tag the caller cannot access are left out, while the ids must refer to
+// accessible endpoints.
+func postProbe(w http.ResponseWriter, r *http.Request, store meow.ConfigStore, clients *meow.ClientCache) {
+	logRequest(r)
+	tag, rawIDs := r.URL.Query().Get("tag"), r.URL.Query().Get("ids")
+	if (tag == "") == (rawIDs == "") {
+		logRejection(r, "either tag or ids required")
+		writeError(w, http.StatusBadRequest, "either tag or ids required")
+		return
+	}
+	ctx := r.Context()
+	c := callerFrom(r)
+	var identifiers []string
+	if tag != "" {
+		tagged, err := store.Tagged(ctx, tag)
+		if err != nil {
+			slog.Error("look up endpoints by tag", "tag", tag, "error", err)
+			writeError(w, http.StatusInternalServerError, "")
+			return
+		}
+		identifiers = tagged
+	} else {
+		identifiers = strings.Split(rawIDs, ",")
+	}
+	slices.Sort(identifiers)
+	identifiers = slices.Compact(identifiers)
+	if len(identifiers) > maxProbeBatch {
+		logRejection(r, "too many endpoints", "endpoints", len(identifiers), "max", maxProbeBatch)
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("more than %d endpoints", maxProbeBatch))
+		return
+	}
+	endpoints := make([]*meow.Endpoint, 0, len(identifiers))
+	for _, identifier := range identifiers {
+		endpoint, err := fetchEndpointFor(ctx, store, c, identifier)
+		if tag != "" && (errors.Is(err, meow.ErrNotFound) || errors.Is(err, meow.ErrForbidden)) {
+			// stale index entry, or another owner's endpoint
+			continue
+		}
+		if err != nil {
+			slog.Error("fetch endpoint", "identifier", identifier, "error", err)
+			writeError(w, statusForError(err), "")
+			return
+		}
+		endpoints = append(endpoints, endpoint)
+	}
+	results := make([]meow.ProbeResult, len(endpoints))
+	slots := make(chan struct{}, probeBatchParallelism)
+	var wg sync.WaitGroup
+	for i, endpoint := range endpoints {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			slots <- struct{}{}
+			defer func() { <-slots }()
+			result, err := meow.ProbeEndpoint(meow.WithProbeInput(ctx, meow.ProbeInput{Clients: clients}), endpoint)
+			if err != nil {
+				// e.g. the client went away
+				slog.Warn("probe endpoint", "identifier", endpoint.Identifier, "error", err)
+			}
+			results[i] = meow.NewProbeResult(endpoint.Identifier, result)
+		}()
+	}
+	wg.Wait()
+	writeJSON(w, http.StatusOK, results)
+}
+
+// postEndpointCheck probes an endpoint once right away, e.g. to verify its
+// configuration, without storing the result or alerting on it.
+func postEndpointCheck(w http.ResponseWriter, r *http.Request, store meow.ConfigStore, clients *meow.ClientCache) {
+	logRequest(r)
+	endpoint := endpointForSubresource(w, r, store, "/check")
+	if endpoint == nil {
+		return
+	}
+	result, err := meow.ProbeEndpoint(meow.WithProbeInput(r.Context(), meow.ProbeInput{Clients: clients}), endpoint)
+	if err != nil {
+		// e.g. the client went away
+		slog.Warn("probe endpoint", "identifier", endpoint.Identifier, "error", err)
+		writeError(w, http.StatusServiceUnavailable, "")
+		return
+	}
+	writeJSON(w, http.StatusOK, meow.NewProbeResult(endpoint.Identifier, result))
+}
+
+// getEndpointEffective writes the configuration the endpoint is probed with,
+// i.e. with the settings in effect applied and with secrets redacted, as
+// opposed to the stored configuration written by getEndpoint.
+func getEndpointEffective(w http.ResponseWriter, r *http.Request, store meow.ConfigStore) {
+	logRequest(r)
+	endpoint := endpointForSubresource(w, r, store, "/effective")
+	if endpoint == nil {
+		return
+	}
+	payload, err := endpoint.Effective(meow.CurrentSettings()).JSON()
+	if err != nil {
+		slog.Error("convert effective configuration to JSON", "endpoint", endpoint.String(), "error", err)
+		writeError(w, http.StatusInternalServerError, "")
+		return
+	}
+	writeJSON(w, http.StatusOK, json.RawMessage(payload))
+}
+
+// getEndpointSchema writes a description of the fields of an endpoint, with the
+// defaults of the settings in effect, e.g. for building forms.
+func getEndpointSchema(w http.ResponseWriter, r *http.Request) {
+	logRequest(r)
+	payload, err := meow.EndpointSchema(meow.CurrentSettings()).JSON()
+	if err != nil {
+		slog.Error("convert endpoint schema to JSON", "error", err)
+		writeError(w, http.StatusInternalServerError, "")
+		return
+	}
+	writeJSON(w, http.StatusOK, json.RawMessage(payload))
+}
+
+func getEndpointStatus(w http.ResponseWriter, r *http.Request, client valkey.Client, store meow.ConfigStore) {
+	logRequest(r)
+	endpoint := endpointForSubresource(w, r, store, "/status")
+	if endpoint == nil {
+		return
+	}
+	ctx := r.Context()
+	statusKey := meow.StatusKey(endpoint.Identifier)
+	kvs, err := client.Do(ctx, client.B().Hgetall().Key(statusKey).Build()).AsStrMap()
+	if err != nil {
+		slog.Error("hgetall", "key", statusKey, "error", err)
+		writeError(w, http.StatusInternalServerError, "")
+		return
+	}
+	status, err := meow.CheckResultFromMap(kvs)
+	if err != nil {
+		slog.Error("parse status", "key", statusKey, "error", err)
+		writeError(w, http.StatusInternalServerError, "")
+		return
+	}
+	status.DeriveUp(endpoint.FailAfter)
+	payload, err := status.JSON()
+	if err != nil {
+		slog.Error("convert status to JSON", "error", err)
+		writeError(w, http.StatusInternalServerError, "")
+		return
+	}
+	writeJSON(w, http.StatusOK, json.RawMessage(payload))
+}
+
+func getEndpointIncidents(w http.ResponseWriter, r *http.Request, client valkey.Client, store meow.ConfigStore) {
+	logRequest(r)
+	endpoint := endpointForSubresource(w, r, store, "/incidents")
+	if endpoint == nil {
+		return
+	}
+	ctx := r.Context()
+	incidents, err := fetchIncidents(ctx, client, endpoint.Identifier)
+	if err != nil {
+		slog.Error("fetch incidents", "identifier", endpoint.Identifier, "error", err)
+		writeError(w, http.StatusInternalServerError, "")
+		return
+	}
+	payloads := make([]meow.IncidentPayload, 0, len(incidents))
+	for _, incident := range incidents {
+		payloads = append(payloads, incident.Payload())
+	}
+	writeJSON(w, http.StatusOK, payloads)
+}
+
+// defaultHistoryLimit is the number of history entries returned by default.
+const defaultHistoryLimit = 50
+
+// getEndpointHistory returns the latest probe results of an endpoint, the most
+// recent first; at most as many as requested by limit, and as retained.
+func getEndpointHistory(w http.ResponseWriter, r *http.Request, client valkey.Client, store meow.ConfigStore) {
+	logRequest(r)
+	endpoint := endpointForSubresource(w, r, store, "/history")
+	if endpoint == nil {
+		return
+	}
+	limit := defaultHistoryLimit
+	if raw := r.URL.Query().Get("limit"); raw != "" {
+		n, err := strconv.Atoi(raw)
+		if err != nil || n < 1 {
+			logRejection(r, "limit is not a positive number")
+			writeError(w, http.StatusBadRequest, "limit is not a positive number")
+			return
+		}
+		limit = n
+	}
+	ctx := r.Context()
+	entries, err := fetchHistory(ctx, client, endpoint.Identifier, limit)
+	if err != nil {
+		slog.Error("fetch history", "identifier", endpoint.Identifier, "error", err)
+		writeError(w, http.StatusInternalServerError, "")
+		return
+	}
+	writeJSON(w, http.StatusOK, entries)
+}
+
+// maxLatencyPoints is the maximum number of buckets the latencies of an
+// endpoint are aggregated into at once.
+const maxLatencyPoints = 1000
+
+// getEndpointLatency returns the latencies of an endpoint within the window
+// aggregated into buckets, which are queried from its time series if Valkey
+// provides time series and the probe stores latencies in them, and computed
+// from its history otherwise.
+func getEndpointLatency(w http.ResponseWriter, r *http.Request, client valkey.Client, store meow.ConfigStore,
+	timeSeries bool) {
+	logRequest(r)
+	endpoint := endpointForSubresource(w, r, store, "/latency")
+	if endpoint == nil {
+		return
+	}
+	rawWindow, rawBucket := r.URL.Query().Get("window"), r.URL.Query().Get("bucket")
+	if rawWindow == "" {
+		rawWindow = "1d"
+	}
+	if rawBucket == "" {
+		rawBucket = "1h"
+	}
+	window, err := meow.ParseWindow(rawWindow)
+	if err != nil {
+		logRejection(r, "parse window: "+err.Error())
+		writeError(w, http.StatusBadRequest, "parse window: "+err.Error())
+		return
+	}
+	bucket, err := meow.ParseWindow(rawBucket)
+	if err == nil && bucket < time.Second {
+		err = fmt.Errorf("bucket %v is shorter than 1s", bucket)
+	} else if err == nil && window/bucket > maxLatencyPoints {
+		err = fmt.Errorf("window %v exceeds %d buckets of %v", window, maxLatencyPoints, bucket)
+	}
+	if err != nil {
+		logRejection(r, "parse bucket: "+err.Error())
+		writeError(w, http.StatusBadRequest, "parse bucket: "+err.Error())
+		return
+	}
+	ctx := r.Context()
+	end := time.Now()
+	var latencies *meow.LatencyRange
+	if timeSeries && meow.CurrentSettings().LatencyRetention > 0 {
+		latencies, err = fetchLatency(ctx, client, endpoint.Identifier, window, bucket, end)
+	}
+	if err == nil && latencies == nil {
+		// no time series, e.g. if the latencies were not stored until recently
+		var entries []meow.HistoryEntry
+		entries, err = fetchHistory(ctx, client, endpoint.Identifier, 0)
+		aggregated := meow.AggregateLatency(entries, window, bucket, end)
+		latencies = &aggregated
+	}
+	if err != nil {
+		slog.Error("fetch latency", "identifier", endpoint.Identifier, "error", err)
+		writeError(w, http.StatusInternalServerError, "")
+		return
+	}
+	payload, err := latencies.JSON()
+	if err != nil {
+		slog.Error("convert latency to JSON", "error", err)
+		writeError(w, http.StatusInternalServerError, "")
+		return
+	}
+	writeJSON(w, http.StatusOK, json.RawMessage(payload))
+}
+
+// fetchLatency queries the time series of the endpoint identified by
+// identifier for the average and maximum latency, and the number of probes,
+// within the buckets of the window ending at end. Nil is returned if there is
+// no such time series.
+func fetchLatency(ctx context.Context, client valkey.Client, identifier string, window, bucket time.Duration,
+	end time.Time) (*meow.LatencyRange, error) {
+	latencies := meow.LatencyRange{Window: window, Bucket: bucket, Source: meow.LatencyFromTimeSeries}
+	key := meow.LatencyKey(identifier)
+	from := strconv.FormatInt(end.Add(-window).UnixMilli(), 10)
+	to := strconv.FormatInt(end.UnixMilli(), 10)
+	ms := bucket.Milliseconds()
+	results := client.DoMulti(ctx,
+		client.B().Exists().Key(key).Build(),
+		client.B().TsRange().Key(key).Fromtimestamp(from).Totimestamp(to).AggregationAvg().Bucketduration(ms).Build(),
+		client.B().TsRange().Key(key).Fromtimestamp(from).Totimestamp(to).AggregationMax().Bucketduration(ms).Build(),
+		client.B().TsRange().Key(key).Fromtimestamp(from).Totimestamp(to).AggregationCount().Bucketduration(ms).Build())
+	exists, err := results[0].AsInt64()
+	if err != nil {
+		return nil, fmt.Errorf("exists %s: %v", key, err)
+	}
+	if exists == 0 {
+		return nil, nil
+	}
+	points := make(map[int64]*meow.LatencyPoint)
+	for i, aggregation := range []string{"avg", "max", "count"} {
+		samples, err := results[i+1].ToArray()
+		if err != nil {
+			return nil, fmt.Errorf("ts.range %s %s: %v", key, aggregation, err)
+		}
+		for _, sample := range samples {
+			pair, err := sample.ToArray()
+			if err != nil || len(pair) != 2 {
+				return nil, fmt.Errorf("ts.range %s %s: malformed sample", key, aggregation)
+			}
+			timestamp, err := pair[0].AsInt64()
+			if err != nil {
+				return nil, fmt.Errorf("parse timestamp of %s: %v", key, err)
+			}
+			value, err := pair[1].AsFloat64()
+			if err != nil {
+				return nil, fmt.Errorf("parse %s of %s: %v", aggregation, key, err)
+			}
+			point, ok := points[timestamp]
+			if !ok {
+				point = &meow.LatencyPoint{Timestamp: time.UnixMilli(timestamp).UTC()}
+				points[timestamp] = point
+			}
+			switch aggregation {
+			case "avg":
+				point.AverageMS = value
+			case "max":
+				point.MaxMS = value
+			case "count":
+				point.Probes = int(value)
+			}
+		}
+	}
+	latencies.Points = make([]meow.LatencyPoint, 0, len(points))
+	for _, point := range points {
+		latencies.Points = append(latencies.Points, *point)
+	}
+	slices.SortFunc(latencies.Points, func(a, b meow.LatencyPoint) int {
+		return a.Timestamp.Compare(b.Timestamp)
+	})
+	return &latencies, nil
+}
+
+func getEndpointReliability(w http.ResponseWriter, r *http.Request, client valkey.Client, store meow.ConfigStore) {
+	logRequest(r)
+	endpoint := endpointForSubresource(w, r, store, "/reliability")
+	if endpoint == nil {
+		return
+	}
+	rawWindow := r.URL.Query().Get("window")
+	if rawWindow == "" {
+		rawWindow = "30d"
+	}
+	window, err := meow.ParseWindow(rawWindow)
+	if err != nil {
+		logRejection(r, "parse window: "+err.Error())
+		writeError(w, http.StatusBadRequest, "parse window: "+err.Error())
+		return
+	}
+	ctx := r.Context()
+	incidents, err := fetchIncidents(ctx, client, endpoint.Identifier)
+	if err != nil {
+		slog.Error("fetch incidents", "identifier", endpoint.Identifier, "error", err)
+		writeError(w, http.StatusInternalServerError, "")
+		return
+	}
+	reliability := meow.ComputeReliability(incidents, window, time.Now())
+	payload, err := reliability.JSON()
+	if err != nil {
+		slog.Error("convert reliability to JSON", "error", err)
+		writeError(w, http.StatusInternalServerError, "")
+		return
+	}
+	writeJSON(w, http.StatusOK, json.RawMessage(payload))
+}
+
+func getEndpointUptime(w http.ResponseWriter, r *http.Request, client valkey.Client, store meow.ConfigStore) {
+	logRequest(r)
+	endpoint := endpointForSubresource(w, r, store, "/uptime")
+	if endpoint == nil {
+		return
+	}
+	rawWindow := r.URL.Query().Get("window")
+	if rawWindow == "" {
+		rawWindow = "7d"
+	}
+	window, err := meow.ParseWindow(rawWindow)
+	if err != nil {
+		logRejection(r, "parse window: "+err.Error())
+		writeError(w, http.StatusBadRequest, "parse window: "+err.Error())
+		return
+	}
+	ctx := r.Context()
+	entries, err := fetchHistory(ctx, client, endpoint.Identifier, 0)
+	if err != nil {
+		slog.Error("fetch history", "identifier", endpoint.Identifier, "error", err)
+		writeError(w, http.StatusInternalServerError, "")
+		return
+	}
+	uptime := meow.ComputeUptime(entries, window, time.Now())
+	payload, err := uptime.JSON()
+	if err != nil {
+		slog.Error("convert uptime to JSON", "error", err)
+		writeError(w, http.StatusInternalServerError, "")
+		return
+	}
+	writeJSON(w, http.StatusOK, json.RawMessage(payload))
+}
+
+// tagUptime is the uptime aggregated across the endpoints with a tag, along
+// with the uptime of each of them.
+type tagUptime struct {
+	Tag         string           `json:"tag"`
+	Window      string           `json:"window"`
+	Aggregation meow.Aggregation `json:"aggregation"`
+	Raw         *float64         `json:"raw"`
+	Adjusted    *float64         `json:"adjusted"`
+	Members     []memberUptime   `json:"members"`
+}
+
+// memberUptime is the uptime of an endpoint with a tag.
+type memberUptime struct {
+	Identifier string `json:"identifier"`
+	meow.UptimePayload
+}
+
+// getTagUptime reports the uptime aggregated (mean by default, or min) across
+// the endpoints with a tag the caller may access within the window.
+func getTagUptime(w http.ResponseWriter, r *http.Request, client valkey.Client, store meow.ConfigStore) {
+	logRequest(r)
+	tag := r.PathValue("tag")
+	rawWindow := r.URL.Query().Get("window")
+	if rawWindow == "" {
+		rawWindow = "7d"
+	}
+	window, err := meow.ParseWindow(rawWindow)
+	if err != nil {
+		logRejection(r, "parse window: "+err.Error())
+		writeError(w, http.StatusBadRequest, "parse window: "+err.Error())
+		return
+	}
+	rawAggregation := r.URL.Query().Get("aggregation")
+	if rawAggregation == "" {
+		rawAggregation = string(meow.AggregateMean)
+	}
+	aggregation, err := meow.ParseAggregation(rawAggregation)
+	if err != nil {
+		logRejection(r, "parse aggregation: "+err.Error())
+		writeError(w, http.StatusBadRequest, "parse aggregation: "+err.Error())
+		return
+	}
+	ctx := r.Context()
+	identifiers, err := store.Tagged(ctx, tag)
+	if err != nil {
+		slog.Error("look up endpoints by tag", "tag", tag, "error", err)
+		writeError(w, http.StatusInternalServerError, "")
+		return
+	}
+	c := callerFrom(r)
+	end := time.Now()
+	var uptimes []meow.Uptime
+	members := make([]memberUptime, 0, len(identifiers))
+	for _, identifier := range identifiers {
+		endpoint, err := fetchEndpointFor(ctx, store, c, identifier)
+		if errors.Is(err, meow.ErrNotFound) || errors.Is(err, meow.ErrForbidden) {
+			// stale index entry, or another owner's endpoint
+			continue
+		}
+		if err != nil {
+			slog.Error("fetch endpoint", "identifier", identifier, "error", err)
+			writeError(w, statusForError(err), "")
+			return
+		}
+		entries, err := fetchHistory(ctx, client, endpoint.Identifier, 0)
+		if err != nil {
+			slog.Error("fetch history", "identifier", endpoint.Identifier, "error", err)
+			writeError(w, http.StatusInternalServerError, "")
+			return
+		}
+		uptime := meow.ComputeUptime(entries, window, end)
+		uptimes = append(uptimes, uptime)
+		members = append(members, memberUptime{endpoint.Identifier, uptime.Payload()})
+	}
+	result := tagUptime{Tag: tag, Window: window.String(), Aggregation: aggregation, Members: members}
+	result.Raw, result.Adjusted = meow.AggregateUptime(uptimes, aggregation)
+	writeJSON(w, http.StatusOK, result)
+}
+
+func getEndpointBadge(w http.ResponseWriter, r *http.Request, client valkey.Client, store meow.ConfigStore) {
+	logRequest(r)
+	endpoint := endpointForSubresource(w, r, store, "/badge.svg")
+	if endpoint == nil {
+		return
+	}
+	rawWindow := r.URL.Query().Get("window")
+	if rawWindow == "" {
+		rawWindow = "7d"
+	}
+	window, err := meow.ParseWindow(rawWindow)
+	if err != nil {
+		logRejection(r, "parse window: "+err.Error())
+		writeError(w, http.StatusBadRequest, "parse window: "+err.Error())
+		return
+	}
+	ctx := r.Context()
+	entries, err := fetchHistory(ctx, client, endpoint.Identifier, 0)
+	if err != nil {
+		slog.Error("fetch history", "identifier", endpoint.Identifier, "error", err)
+		writeError(w, http.StatusInternalServerError, "")
+		return
+	}
+	uptime := meow.ComputeUptime(entries, window, time.Now())
+	w.Header().Set("Content-Type", "image/svg+xml")
+	w.Header().Set("Cache-Control", fmt.Sprintf("public, max-age=%d", badgeMaxAge))
+	w.Write(meow.UptimeBadge(endpoint.Identifier, uptime.Adjusted, uptime.AdjustedProbes > 0))
+}
+
+// badgeMaxAge is the number of seconds badges may be cached.
+const badgeMaxAge = 300
+
+// fetchHistory returns up to n (or all, if n is 0) retained history entries of
+// the endpoint identified by identifier, the most recent entry first.
+func fetchHistory(ctx context.Context, client valkey.Client, identifier string, n int) ([]meow.HistoryEntry, error) {
+	key := meow.HistoryKey(identifier)
+	raws, err := client.Do(ctx, client.B().Lrange().Key(key).Start(0).Stop(int64(n-1)).Build()).AsStrSlice()
+	if err != nil {
+		return nil, fmt.Errorf("lrange %s: %v", key, err)
+	}
+	entries := make([]meow.HistoryEntry, 0, len(raws))
+	for _, raw := range raws {
+		entry, err := meow.HistoryEntryFromJSON(raw)
+		if err != nil {
+			return nil, fmt.Errorf("parse history entry from %s: %v", key, err)
+		}
+		entries = append(entries, *entry)
+	}
+	return entries, nil
+}
+
+// fetchIncidents returns the retained incidents of the endpoint identified by
+// identifier, ordered by their start.
+func fetchIncidents(ctx context.Context, client valkey.Client, identifier string) ([]meow.Incident, error) {
+	indexKey := meow.IncidentIndexKey(identifier)
+	keys, err := client.Do(ctx, client.B().Zrange().Key(indexKey).Min("0").Max("-1").Build()).AsStrSlice()
+	if err != nil {
+		return nil, fmt.Errorf("zrange %s: %v", indexKey, err)
+	}
+	incidents := make([]meow.Incident, 0, len(keys))
+	for _, key := range keys {
+		kvs, err := client.Do(ctx, client.B().Hgetall().Key(key).Build()).AsStrMap()
+		if err != nil {
+			return nil, fmt.Errorf("hgetall %s: %v", key, err)
+		}
+		if len(kvs) == 0 {
+			// expired, but not yet removed from the index
+			continue
+		}
+		incident, err := meow.IncidentFromMap(kvs)
+		if err != nil {
+			return nil, fmt.Errorf("parse incident from %s: %v", key, err)
+		}
+		incidents = append(incidents, *incident)
+	}
+	return incidents, nil
+}
+
+// endpointForSubresource extracts the endpoint identifier from the path of r,
+// which ends in suffix (e.g. /endpoints/libvirt/schedule), and loads the
+// according endpoint. If this fails, an according status is written to w and
+// nil is returned.
+func endpointForSubresource(w http.ResponseWriter, r *http.Request, store meow.ConfigStore,
+	suffix string) *meow.Endpoint {
+	identifier, err := extractEndpointIdentifier(strings.TrimSuffix(r.URL.Path, suffix))
+	if err != nil {
+		logRejection(r, err.Error())
+		writeError(w, http.StatusBadRequest, err.Error())
+		return nil
+	}
+	endpoint, err := fetchEndpointFor(r.Context(), store, callerFrom(r), identifier)
+	if err != nil {
+		slog.Error("fetch endpoint", "error", err)
+		writeError(w, statusForError(err), "")
+		return nil
+	}
+	return endpoint
+}
+
+// fetchEndpointFor loads the endpoint identified by identifier from the store,
+// but returns an error wrapping meow.ErrForbidden if the caller c may not
+// access it.
+func fetchEndpointFor(ctx context.Context, store meow.ConfigStore, c caller, identifier string) (*meow.Endpoint, error) {
+	endpoint, err := store.Get(ctx, identifier)
+	if err != nil {
+		return nil, err
+	}
+	if !c.mayAccess(endpoint.Owner) {
+		return nil, fmt.Errorf(`endpoint "%s" of owner "%s": %w`, identifier, endpoint.Owner, meow.ErrForbidden)
+	}
+	return endpoint, nil
+}
+
+// statusForError maps the errors returned by the store helpers to HTTP status
+// codes: meow.ErrNotFound to 404, meow.ErrConflict to 409,
+// meow.ErrPreconditionFailed to 412, meow.ErrInvalidCursor to 400,
+// meow.ErrLimitReached and meow.ErrForbidden to 403, and others to 500.
+func statusForError(err error) int {
+	switch {
+	case errors.Is(err, meow.ErrPreconditionFailed):
+		return http.StatusPreconditionFailed
+	case errors.Is(err, meow.ErrInvalidCursor):
+		return http.StatusBadRequest
+	case errors.Is(err, meow.ErrLimitReached), errors.Is(err, meow.ErrForbidden):
+		return http.StatusForbidden
+	case errors.Is(err, meow.ErrNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, meow.ErrConflict):
+		return http.StatusConflict
+	}
+	return http.StatusInternalServerError
+}
+
+func getEndpoints(w http.ResponseWriter, r *http.Request, client valkey.Client, store meow.ConfigStore) {
+	logRequest(r)
+	method := strings.ToUpper(r.URL.Query().Get("method"))
+	if method != "" && !meow.IsStandardMethod(method) {
+		logRejection(r, "not a standard method", "filter_method", method)
+		writeError(w, http.StatusBadRequest, "not a standard method")
+		return
+	}
+	include := r.URL.Query().Get("include")
+	if include != "" && include != "status" {
+		logRejection(r, `only "status" can be included`)
+		writeError(w, http.StatusBadRequest, `only "status" can be included`)
+		return
+	}
+	var fields []string
+	if raw := r.URL.Query().Get("fields"); raw != "" {
+		var err error
+		if fields, err = meow.ParseEndpointFields(raw); err != nil {
+			logRejection(r, "select fields: "+err.Error())
+			writeError(w, http.StatusBadRequest, "select fields: "+err.Error())
+			return
+		}
+		if include == "status" {
+			fields = append(fields, "status")
+		}
+	}
+	format := r.URL.Query().Get("format")
+	if format != "" && format != "json" && format != "ndjson" {
+		logRejection(r, `only "json" and "ndjson" are supported formats`)
+		writeError(w, http.StatusBadRequest, `only "json" and "ndjson" are supported formats`)
+		return
+	}
+	var envelope bool
+	if raw := r.URL.Query().Get("envelope"); raw != "" {
+		var err error
+		if envelope, err = strconv.ParseBool(raw); err != nil {
+			logRejection(r, "envelope is not a boolean")
+			writeError(w, http.StatusBadRequest, "envelope is not a boolean")
+			return
+		}
+		if envelope && format == "ndjson" {
+			logRejection(r, "envelope not supported by format ndjson")
+			writeError(w, http.StatusBadRequest, "envelope not supported by format ndjson")
+			return
+		}
+	}
+	limit := defaultPageLimit
+	if raw := r.URL.Query().Get("limit"); raw != "" {
+		n, err := strconv.Atoi(raw)
+		if err != nil || n < 1 {
+			logRejection(r, "limit is not a positive number")
+			writeError(w, http.StatusBadRequest, "limit is not a positive number")
+			return
+		}
+		limit = min(n, maxPageLimit)
+	}
+	query := meow.ListQuery{
+		Owner:            callerFrom(r).owner,
+		Method:           method,
+		IdentifierPrefix: r.URL.Query().Get("identifier_prefix"),
+		Cursor:           r.URL.Query().Get("cursor"),
+		Limit:            limit,
+	}
+	var stream elementStream = &arrayStream{w: w, fields: fields}
+	contentType := "application/json"
+	if format == "ndjson" {
+		contentType = "application/x-ndjson"
+		stream = &lineStream{w: w, fields: fields}
+	}
+	var wrapped *envelopeStream
+	if envelope {
+		wrapped = &envelopeStream{w: w, array: arrayStream{w: w, fields: fields}}
+		stream = wrapped
+	}
+	// the elements are written as they are read, so that the page is not held
+	// in memory, whose cursor is known before its first element
+	var started bool
+	write := func(next string, element any) error {
+		if !started {
+			w.Header().Set("Content-Type", contentType)
+			if next != "" {
+				w.Header().Set("X-Next-Cursor", next)
+			}
+			started = true
+		}
+		return stream.write(element)
+	}
+	// stop scanning if the client goes away
+	ctx := r.Context()
+	next, err := store.ListPage(ctx, query, func(next string, endpoints []*meow.Endpoint) error {
+		payloads := make([]meow.EndpointPayload, len(endpoints))
+		for i, endpoint := range endpoints {
+			payloads[i] = endpoint.ToPayload()
+		}
+		if include != "status" {
+			for _, payload := range payloads {
+				if err := write(next, payload); err != nil {
+					return err
+				}
+			}
+			return nil
+		}
+		combined, err := withStatus(ctx, client, payloads)
+		if err != nil {
+			return fmt.Errorf("include status: %v", err)
+		}
+		for _, element := range combined {
+			if err := write(next, element); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil && started {
+		// abort the connection, so that the truncated page is not mistaken
+		// for a complete one
+		slog.Error("list endpoints", "error", err)
+		panic(http.ErrAbortHandler)
+	}
+	if errors.Is(err, meow.ErrInvalidCursor) {
+		logRejection(r, err.Error())
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if err != nil {
+		slog.Error("list endpoints", "error", err)
+		writeError(w, http.StatusInternalServerError, "")
+		return
+	}
+	if !started {
+		w.Header().Set("Content-Type", contentType)
+	}
+	if wrapped != nil {
+		wrapped.next = next
+	}
+	if err := stream.close(); err != nil {
+		slog.Error("list endpoints", "error", err)
+	}
+}
+
+// defaultPageLimit is the number of endpoints listed per page, unless the
+// client asks for another limit, which is capped at maxPageLimit.
+const (
+	defaultPageLimit = 100
+	maxPageLimit     = 1000
+)
 
-const endpointIdentifierPatternRaw = "^/endpoints/([a-z][-a-z0-9]+)$"
+// getPrometheusRules generates Prometheus alerting rules for the endpoints the
+// caller may access from their current configuration.
+func getPrometheusRules(w http.ResponseWriter, r *http.Request, store meow.ConfigStore) {
+	logRequest(r)
+	endpoints, err := fetchValidEndpoints(r.Context(), store, callerFrom(r))
+	if err != nil {
+		slog.Error("list endpoints", "error", err)
+		writeError(w, http.StatusInternalServerError, "")
+		return
+	}
+	w.Header().Set("Content-Type", "application/yaml")
+	w.Write(meow.PrometheusRules(endpoints))
+}
+
+// getMetrics exposes the check outcomes of the endpoints the caller may access
+// as Prometheus metrics, which are derived from their statuses.
+func getMetrics(w http.ResponseWriter, r *http.Request, client valkey.Client, store meow.ConfigStore) {
+	logRequest(r)
+	ctx := r.Context()
+	endpoints, err := fetchValidEndpoints(ctx, store, callerFrom(r))
+	if err != nil {
+		slog.Error("list endpoints", "error", err)
+		writeError(w, http.StatusInternalServerError, "")
+		return
+	}
+	statuses := make(map[string]meow.CheckResult, len(endpoints))
+	if len(endpoints) > 0 {
+		cmds := make(valkey.Commands, 0, len(endpoints))
+		for _, e := range endpoints {
+			cmds = append(cmds, client.B().Hgetall().Key(meow.StatusKey(e.Identifier)).Build())
+		}
+		for i, result := range client.DoMulti(ctx, cmds...) {
+			key := meow.StatusKey(endpoints[i].Identifier)
+			kvs, err := result.AsStrMap()
+			if err != nil {
+				slog.Error("hgetall", "key", key, "error", err)
+				writeError(w, http.StatusInternalServerError, "")
+				return
+			}
+			status, err := meow.CheckResultFromMap(kvs)
+			if err != nil {
+				slog.Warn("skip status", "identifier", endpoints[i].Identifier, "error", err)
+				continue
+			}
+			statuses[endpoints[i].Identifier] = *status
+		}
+	}
+	w.Header().Set("Content-Type", meow.PrometheusMetricsContentType)
+	w.Write(meow.PrometheusMetrics(endpoints, statuses))
+}
+
+// fetchValidEndpoints returns the endpoints the caller c may access ordered by
+// their identifiers, skipping invalid ones.
+func fetchValidEndpoints(ctx context.Context, store meow.ConfigStore, c caller) ([]meow.Endpoint, error) {
+	stored, err := store.List(ctx)
+	if err != nil {
+		return nil, err
+	}
+	endpoints := make([]meow.Endpoint, 0, len(stored))
+	for _, endpoint := range stored {
+		if c.mayAccess(endpoint.Owner) {
+			endpoints = append(endpoints, *endpoint)
+		}
+	}
+	return endpoints, nil
+}
+
+// allEndpointKeys returns the keys of all stored endpoints, which are scanned
+// so that Valkey is not blocked (unlike with KEYS).
+func allEndpointKeys(ctx context.Context, client valkey.Client) ([]string, error) {
+	var keys []string
+	err := meow.ScanEndpointKeys(ctx, client, "", func(batch []string) error {
+		keys = append(keys, batch...)
+		return nil
+	})
+	return keys, err
+}
+
+// elementStream writes a listing element by element.
+type elementStream interface {
+	write(v any) error
+	close() error
+}
 
-var endpointIdentifierPattern = regexp.MustCompile(endpointIdentifierPatternRaw)
+// arrayStream writes a JSON array element by element to w, so that the
+// elements need not be held in memory all at once.
+type arrayStream struct {
+	w       io.Writer
+	encoder *json.Encoder
+	started bool
+
+	// fields are the fields each element is reduced to, or nil for all.
+	fields []string
+}
+
+// write appends the element v to the array.
+func (s *arrayStream) write(v any) error {
+	if s.fields != nil {
+		selected, err := selectFields(v, s.fields)
+		if err != nil {
+			return err
+		}
+		v = selected
+	}
+	separator := ","
+	if !s.started {
+		separator = "["
+		s.encoder = json.NewEncoder(s.w)
+		s.started = true
+	}
+	if _, err := io.WriteString(s.w, separator); err != nil {
+		return fmt.Errorf("write array: %v", err)
+	}
+	if err := s.encoder.Encode(v); err != nil {
+		return fmt.Errorf("write array element: %v", err)
+	}
+	return nil
+}
+
+// close terminates the array, which is empty if no elements were written.
+func (s *arrayStream) close() error {
+	end := "]"
+	if !s.started {
+		end = "[]"
+	}
+	if _, err := io.WriteString(s.w, end); err != nil {
+		return fmt.Errorf("write array: %v", err)
+	}
+	return nil
+}
+
+// envelopeStream writes the elements element by element as the array endpoints
+// of a JSON object, which also holds their count and the cursor of the next
+// page, if there is one.
+type envelopeStream struct {
+	w       io.Writer
+	array   arrayStream
+	started bool
+	count   int
+	next    string
+}
+
+// write appends the element v to the array of the envelope.
+func (s *envelopeStream) write(v any) error {
+	if err := s.open(); err != nil {
+		return err
+	}
+	if err := s.array.write(v); err != nil {
+		return err
+	}
+	s.count++
+	return nil
+}
+
+// close terminates the array, and adds the count and the cursor of the next
+// page to the envelope.
+func (s *envelopeStream) close() error {
+	if err := s.open(); err != nil {
+		return err
+	}
+	if err := s.array.close(); err != nil {
+		return err
+	}
+	end := fmt.Sprintf(`,"count":%d`, s.count)
+	if s.next != "" {
+		end += fmt.Sprintf(`,"next_cursor":"%s"`, s.next)
+	}
+	if _, err := io.WriteString(s.w, end+"}"); err != nil {
+		return fmt.Errorf("write envelope: %v", err)
+	}
+	return nil
+}
+
+// open starts the envelope, unless it has been started already.
+func (s *envelopeStream) open() error {
+	if s.started {
+		return nil
+	}
+	s.started = true
+	if _, err := io.WriteString(s.w, `{"endpoints":`); err != nil {
+		return fmt.Errorf("write envelope: %v", err)
+	}
+	return nil
+}
+
+// lineStream writes newline-delimited JSON (one object per line) to w, which
+// is flushed after every line, so that clients can process the elements as
+// they arrive.
+type lineStream struct {
+	w       io.Writer
+	encoder *json.Encoder
+
+	// fields are the fields each element is reduced to, or nil for all.
+	fields []string
+}
+
+// write appends the element v as a line.
+func (s *lineStream) write(v any) error {
+	if s.fields != nil {
+		selected, err := selectFields(v, s.fields)
+		if err != nil {
+			return err
+		}
+		v = selected
+	}
+	if s.encoder == nil {
+		s.encoder = json.NewEncoder(s.w)
+	}
+	if err := s.encoder.Encode(v); err != nil {
+		return fmt.Errorf("write line: %v", err)
+	}
+	if flusher, ok := s.w.(http.Flusher); ok {
+		flusher.Flush()
+	}
+	return nil
+}
+
+// close does nothing, since the lines need no termination.
+func (s *lineStream) close() error {
+	return nil
+}
+
+// selectFields reduces v, which must be serialized as a JSON object, to the
+// given fields. Fields omitted in the serialization of v are omitted as well.
+func selectFields(v any, fields []string) (map[string]json.RawMessage, error) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil, fmt.Errorf("marshal %v as JSON: %v", v, err)
+	}
+	var all map[string]json.RawMessage
+	if err := json.Unmarshal(data, &all); err != nil {
+		return nil, fmt.Errorf("unmarshal %s as JSON object: %v", data, err)
+	}
+	selected := make(map[string]json.RawMessage, len(fields))
+	for _, field := range fields {
+		if value, ok := all[field]; ok {
+			selected[field] = value
+		}
+	}
+	return selected, nil
+}
+
+// endpointWithStatus is an endpoint's configuration combined with its live
+// status.
+type endpointWithStatus struct {
+	meow.EndpointPayload
+	Status meow.CheckResultPayload `json:"status"`
+}
+
+// withStatus combines the given endpoints with their status, which is read
+// within a single round-trip.
+func withStatus(ctx context.Context, client valkey.Client, payloads []meow.EndpointPayload) ([]endpointWithStatus, error) {
+	combined := make([]endpointWithStatus, 0, len(payloads))
+	if len(payloads) == 0 {
+		return combined, nil
+	}
+	cmds := make(valkey.Commands, 0, len(payloads))
+	for _, payload := range payloads {
+		cmds = append(cmds, client.B().Hgetall().Key(meow.StatusKey(payload.Identifier)).Build())
+	}
+	for i, result := range client.DoMulti(ctx, cmds...) {
+		key := meow.StatusKey(payloads[i].Identifier)
+		kvs, err := result.AsStrMap()
+		if err != nil {
+			return nil, fmt.Errorf("hgetall %s: %v", key, err)
+		}
+		status, err := meow.CheckResultFromMap(kvs)
+		if err != nil {
+			return nil, fmt.Errorf("parse status from %s: %v", key, err)
+		}
+		combined = append(combined, endpointWithStatus{payloads[i], status.Payload()})
+	}
+	return combined, nil
+}
 
+// extractEndpointIdentifier returns the identifier of the endpoint resource
+// at /endpoints/{identifier}, which is checked like the identifiers of the
+// endpoints written, so that every endpoint stored can be addressed.
 func extractEndpointIdentifier(endpoint string) (string, error) {
-	matches := endpointIdentifierPattern.FindStringSubmatch(endpoint)
-	if len(matches) == 0 {
-		return "", fmt.Errorf(`endpoint "%s" does not match pattern "%s"`,
-			endpoint, endpointIdentifierPatternRaw)
+	identifier, ok := strings.CutPrefix(endpoint, "/endpoints/")
+	if !ok || !meow.ValidIdentifier(identifier) {
+		return "", fmt.Errorf(`endpoint "%s" is not /endpoints/ followed by an identifier matching "%s"`,
+			endpoint, meow.IdentifierPattern)
 	}
-	return matches[1], nil
+	return identifier, nil
 }
@@ -0,0 +1,703 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/patrickbucher/meow"
+	"github.com/patrickbucher/meow/internal/valkeytest"
+)
+
+func TestMain(m *testing.M) {
+	// the rejections logged by the handlers are expected
+	slog.SetDefault(slog.New(slog.NewTextHandler(io.Discard, nil)))
+	os.Exit(m.Run())
+}
+
+// okHandler responds with 200 OK to every request.
+var okHandler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+	w.WriteHeader(http.StatusOK)
+})
+
+// errorOf returns the reason given by the JSON error body of the response
+// recorded by rec, or fails t, if there is none.
+func errorOf(t *testing.T, rec *httptest.ResponseRecorder) string {
+	t.Helper()
+	if contentType := rec.Header().Get("Content-Type"); contentType != "application/json" {
+		t.Fatalf(`expected Content-Type "application/json", got "%s"`, contentType)
+	}
+	var body errorBody
+	if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil || body.Error == "" {
+		t.Fatalf(`expected body {"error": "..."}, got %q (%v)`, rec.Body.String(), err)
+	}
+	return body.Error
+}
+
+func TestRequireAPIKey(t *testing.T) {
+	const key = "s3cr3t"
+	tests := []struct {
+		name     string
+		key      string
+		reads    bool
+		method   string
+		path     string
+		header   string
+		value    string
+		expected int
+	}{
+		{"unset allows write", "", false, http.MethodPost, "/endpoints/libvirt", "", "", http.StatusOK},
+		{"unset allows read", "", true, http.MethodGet, "/endpoints", "", "", http.StatusOK},
+		{"read without key", key, false, http.MethodGet, "/endpoints", "", "", http.StatusOK},
+		{"head without key", key, false, http.MethodHead, "/endpoints", "", "", http.StatusOK},
+		{"post without key", key, false, http.MethodPost, "/endpoints/libvirt", "", "", http.StatusUnauthorized},
+		{"patch without key", key, false, http.MethodPatch, "/endpoints/libvirt", "", "", http.StatusUnauthorized},
+		{"delete without key", key, false, http.MethodDelete, "/endpoints/libvirt", "", "", http.StatusUnauthorized},
+		{"post with X-Api-Key", key, false, http.MethodPost, "/endpoints/libvirt", "X-Api-Key", key, http.StatusOK},
+		{"post with bearer token", key, false, http.MethodPost, "/endpoints/libvirt", "Authorization", "Bearer " + key, http.StatusOK},
+		{"delete with bearer token", key, false, http.MethodDelete, "/endpoints/libvirt", "Authorization", "Bearer " + key, http.StatusOK},
+		{"post with wrong X-Api-Key", key, false, http.MethodPost, "/endpoints/libvirt", "X-Api-Key", "guess", http.StatusForbidden},
+		{"patch with wrong bearer token", key, false, http.MethodPatch, "/endpoints/libvirt", "Authorization", "Bearer guess", http.StatusForbidden},
+		{"post with other scheme", key, false, http.MethodPost, "/endpoints/libvirt", "Authorization", "Basic " + key, http.StatusForbidden},
+		{"authenticated read without key", key, true, http.MethodGet, "/endpoints", "", "", http.StatusUnauthorized},
+		{"authenticated read with wrong key", key, true, http.MethodGet, "/endpoints", "X-Api-Key", "guess", http.StatusForbidden},
+		{"authenticated read with key", key, true, http.MethodGet, "/endpoints", "X-Api-Key", key, http.StatusOK},
+		{"exempt write without key", key, true, http.MethodPost, "/admin/reload", "", "", http.StatusOK},
+		{"exempt read without key", key, true, http.MethodGet, "/endpoints/libvirt/badge.svg", "", "", http.StatusOK},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			handler := requireAPIKey(okHandler, test.key, test.reads, "/admin/*", "/endpoints/*/badge.svg")
+			r := httptest.NewRequest(test.method, test.path, nil)
+			if test.header != "" {
+				r.Header.Set(test.header, test.value)
+			}
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, r)
+			if rec.Code != test.expected {
+				t.Fatalf("expected status %d, got %d", test.expected, rec.Code)
+			}
+			if rec.Code != http.StatusOK {
+				errorOf(t, rec)
+			}
+		})
+	}
+}
+
+func TestExtractEndpointIdentifier(t *testing.T) {
+	tests := []struct {
+		path     string
+		expected string
+		valid    bool
+	}{
+		{"/endpoints/libvirt", "libvirt", true},
+		{"/endpoints/api-gateway", "api-gateway", true},
+		{"/endpoints/LibVirt", "", false},
+		{"/endpoints/2fa", "", false},
+		{"/endpoints/weird_id", "", false},
+		{"/endpoints/weird-id!", "", false},
+		{"/endpoints/", "", false},
+		{"/endpoints/libvirt/status", "", false},
+		{"/other/libvirt", "", false},
+	}
+	for _, test := range tests {
+		identifier, err := extractEndpointIdentifier(test.path)
+		if test.valid && (err != nil || identifier != test.expected) {
+			t.Errorf(`expected identifier "%s" from %s, got "%s" (%v)`, test.expected, test.path, identifier, err)
+		} else if !test.valid && err == nil {
+			t.Errorf(`expected %s to be rejected, got "%s"`, test.path, identifier)
+		}
+	}
+}
+
+func TestWriteInvalidEndpoint(t *testing.T) {
+	_, err := meow.EndpointFromJSON(`{"identifier":"libvirt","url":"https://libvirt.org","method":"BANANA","status_online":200}`)
+	if err == nil {
+		t.Fatal("expected method BANANA to be rejected")
+	}
+	rec := httptest.NewRecorder()
+	writeInvalidEndpoint(rec, err)
+	if rec.Code != http.StatusBadRequest {
+		t.Fatalf("expected status %d, got %d", http.StatusBadRequest, rec.Code)
+	}
+	var body invalidEndpoint
+	if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+		t.Fatalf("parse body %q: %v", rec.Body.String(), err)
+	}
+	if body.Field != "method" || !strings.Contains(body.Error, "BANANA") {
+		t.Errorf(`expected error for field method mentioning "BANANA", got %+v`, body)
+	}
+}
+
+func TestStatusForError(t *testing.T) {
+	tests := []struct {
+		err      error
+		expected int
+	}{
+		{fmt.Errorf(`endpoint "libvirt": %w`, meow.ErrNotFound), http.StatusNotFound},
+		{fmt.Errorf("create endpoint libvirt: %w", meow.ErrConflict), http.StatusConflict},
+		{fmt.Errorf("maximum of 10 endpoints: %w", meow.ErrLimitReached), http.StatusForbidden},
+		{fmt.Errorf("endpoint of another owner: %w", meow.ErrForbidden), http.StatusForbidden},
+		{&meow.FieldError{Field: "url", Err: meow.ErrNotFound}, http.StatusNotFound},
+		{errors.New("connection refused"), http.StatusInternalServerError},
+		{fmt.Errorf("hgetall endpoint:libvirt: %v", meow.ErrNotFound), http.StatusInternalServerError},
+	}
+	for _, test := range tests {
+		if status := statusForError(test.err); status != test.expected {
+			t.Errorf(`expected status %d for "%v", got %d`, test.expected, test.err, status)
+		}
+	}
+}
+
+// asCaller returns r as performed by c, as if identified by authenticator.
+func asCaller(r *http.Request, c caller) *http.Request {
+	return r.WithContext(context.WithValue(r.Context(), callerKey{}, c))
+}
+
+// seededStore returns a store holding the endpoint libvirt owned by ops.
+func seededStore(t *testing.T) meow.ConfigStore {
+	t.Helper()
+	endpoint, err := meow.EndpointFromJSON(`{"identifier":"libvirt","url":"https://libvirt.org","method":"GET","status_online":200,"frequency":"1m","owner":"ops"}`)
+	if err != nil {
+		t.Fatalf("parse endpoint: %v", err)
+	}
+	store := meow.NewMemConfigStore()
+	if _, err := store.Put(context.Background(), endpoint); err != nil {
+		t.Fatalf("put endpoint: %v", err)
+	}
+	return store
+}
+
+func TestGetEndpoint(t *testing.T) {
+	tests := []struct {
+		name     string
+		path     string
+		caller   caller
+		expected int
+	}{
+		{"owner", "/endpoints/libvirt", caller{owner: "ops"}, http.StatusOK},
+		{"admin", "/endpoints/libvirt", caller{admin: true}, http.StatusOK},
+		{"other owner", "/endpoints/libvirt", caller{owner: "dev"}, http.StatusForbidden},
+		{"missing", "/endpoints/go-dev", caller{admin: true}, http.StatusNotFound},
+		{"invalid identifier", "/endpoints/Go_Dev", caller{admin: true}, http.StatusBadRequest},
+	}
+	store := seededStore(t)
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			getEndpoint(rec, asCaller(httptest.NewRequest(http.MethodGet, test.path, nil), test.caller), store)
+			if rec.Code != test.expected {
+				t.Fatalf("expected status %d, got %d", test.expected, rec.Code)
+			}
+			if rec.Code != http.StatusOK {
+				errorOf(t, rec)
+				return
+			}
+			endpoint, err := meow.EndpointFromJSON(rec.Body.String())
+			if err != nil || endpoint.Identifier != "libvirt" {
+				t.Errorf("expected endpoint libvirt, got %q (%v)", rec.Body.String(), err)
+			}
+			if etag := rec.Header().Get("ETag"); etag != endpointETag(1) {
+				t.Errorf("expected ETag %s, got %s", endpointETag(1), etag)
+			}
+		})
+	}
+}
+
+func TestDeleteEndpoint(t *testing.T) {
+	store := seededStore(t)
+	del := func(c caller) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		deleteEndpoint(rec, asCaller(httptest.NewRequest(http.MethodDelete, "/endpoints/libvirt", nil), c), store)
+		return rec
+	}
+	if rec := del(caller{owner: "dev"}); rec.Code != http.StatusForbidden {
+		t.Fatalf("expected deletion by other owner to be forbidden, got %d", rec.Code)
+	}
+	if rec := del(caller{owner: "ops"}); rec.Code != http.StatusNoContent {
+		t.Fatalf("expected deletion by owner to succeed, got %d", rec.Code)
+	}
+	if _, err := store.Get(context.Background(), "libvirt"); !errors.Is(err, meow.ErrNotFound) {
+		t.Errorf("expected endpoint to be deleted, got %v", err)
+	}
+	if rec := del(caller{owner: "ops"}); rec.Code != http.StatusNotFound {
+		t.Errorf("expected second deletion to fail with 404, got %d", rec.Code)
+	}
+}
+
+func TestGetEndpointsMalformed(t *testing.T) {
+	store := meow.NewMemConfigStore(map[string]string{
+		"identifier": "libvirt", "url": "https://libvirt.org", "method": "GET",
+		"status_online": "two hundred", "frequency": "1m0s",
+	})
+	rec := httptest.NewRecorder()
+	getEndpoints(rec, httptest.NewRequest(http.MethodGet, "/endpoints", nil), nil, store)
+	if rec.Code != http.StatusInternalServerError {
+		t.Fatalf("expected malformed endpoint to fail the listing with 500, got %d: %s", rec.Code, rec.Body.String())
+	}
+	errorOf(t, rec)
+}
+
+// manyEndpoints returns a store holding n endpoints identified by number.
+func manyEndpoints(t testing.TB, n int) meow.ConfigStore {
+	t.Helper()
+	store := meow.NewMemConfigStore()
+	for i := range n {
+		endpoint, err := meow.EndpointFromJSON(fmt.Sprintf(
+			`{"identifier":"endpoint-%04d","url":"https://example.com/%d","method":"GET","status_online":200}`, i, i))
+		if err != nil {
+			t.Fatalf("parse endpoint: %v", err)
+		}
+		if _, err := store.Put(context.Background(), endpoint); err != nil {
+			t.Fatalf("put endpoint: %v", err)
+		}
+	}
+	return store
+}
+
+// observedStore records how much of the response has been written whenever
+// ListPage passes a batch to the handler.
+type observedStore struct {
+	meow.ConfigStore
+	rec     *httptest.ResponseRecorder
+	written []int
+}
+
+func (s *observedStore) ListPage(ctx context.Context, query meow.ListQuery, fn func(string, []*meow.Endpoint) error) (string, error) {
+	return s.ConfigStore.ListPage(ctx, query, func(next string, endpoints []*meow.Endpoint) error {
+		s.written = append(s.written, s.rec.Body.Len())
+		return fn(next, endpoints)
+	})
+}
+
+func TestGetEndpointsStreamed(t *testing.T) {
+	rec := httptest.NewRecorder()
+	store := &observedStore{ConfigStore: manyEndpoints(t, 600), rec: rec}
+	getEndpoints(rec, httptest.NewRequest(http.MethodGet, "/endpoints?limit=500", nil), nil, store)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d", rec.Code)
+	}
+	if len(store.written) < 2 {
+		t.Fatalf("expected page to be read in batches, got %d", len(store.written))
+	}
+	for i := 1; i < len(store.written); i++ {
+		if store.written[i] <= store.written[i-1] {
+			t.Errorf("expected batch %d to be written before batch %d is read, got %v", i-1, i, store.written)
+		}
+	}
+	var payloads []meow.EndpointPayload
+	if err := json.Unmarshal(rec.Body.Bytes(), &payloads); err != nil {
+		t.Fatalf("expected JSON array, got %v", err)
+	}
+	if len(payloads) != 500 || payloads[499].Identifier != "endpoint-0499" {
+		t.Errorf("expected endpoints 0 to 499, got %d", len(payloads))
+	}
+	if cursor := rec.Result().Header.Get("X-Next-Cursor"); cursor != "endpoint-0499" {
+		t.Errorf("expected cursor endpoint-0499 as header, got %q", cursor)
+	}
+}
+
+func TestGetEndpointsEmptyPage(t *testing.T) {
+	rec := httptest.NewRecorder()
+	getEndpoints(rec, httptest.NewRequest(http.MethodGet, "/endpoints?identifier_prefix=nope-&envelope=true", nil),
+		nil, manyEndpoints(t, 3))
+	if rec.Code != http.StatusOK || rec.Header().Get("Content-Type") != "application/json" {
+		t.Fatalf("expected JSON with status 200, got %d and %s", rec.Code, rec.Header().Get("Content-Type"))
+	}
+	if body := rec.Body.String(); body != `{"endpoints":[],"count":0}` {
+		t.Errorf("expected empty envelope, got %s", body)
+	}
+}
+
+// discardWriter is a ResponseWriter discarding the body.
+type discardWriter struct {
+	header http.Header
+}
+
+func (d discardWriter) Header() http.Header         { return d.header }
+func (d discardWriter) Write(p []byte) (int, error) { return len(p), nil }
+func (d discardWriter) WriteHeader(int)             {}
+
+func BenchmarkGetEndpoints(b *testing.B) {
+	store := manyEndpoints(b, 1000)
+	for _, limit := range []int{100, 1000} {
+		b.Run(fmt.Sprintf("limit=%d", limit), func(b *testing.B) {
+			b.ReportAllocs()
+			for b.Loop() {
+				r := httptest.NewRequest(http.MethodGet, fmt.Sprintf("/endpoints?limit=%d", limit), nil)
+				getEndpoints(discardWriter{make(http.Header)}, r, nil, store)
+			}
+		})
+	}
+}
+
+func TestGetEndpointsFields(t *testing.T) {
+	store := manyEndpoints(t, 2)
+	tests := []struct {
+		name   string
+		query  string
+		status int
+		fields []string
+	}{
+		{"all fields", "", http.StatusOK, []string{"identifier", "url", "method", "status_online"}},
+		{"selected fields", "?fields=identifier,url", http.StatusOK, []string{"identifier", "url"}},
+		{"invalid field", "?fields=identifier,password", http.StatusBadRequest, nil},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			getEndpoints(rec, httptest.NewRequest(http.MethodGet, "/endpoints"+test.query, nil), nil, store)
+			if rec.Code != test.status {
+				t.Fatalf("expected status %d, got %d: %s", test.status, rec.Code, rec.Body.String())
+			}
+			if test.status != http.StatusOK {
+				if message := errorOf(t, rec); !strings.Contains(message, "password") {
+					t.Errorf("expected invalid field to be named, got %s", message)
+				}
+				return
+			}
+			var elements []map[string]any
+			if err := json.Unmarshal(rec.Body.Bytes(), &elements); err != nil || len(elements) != 2 {
+				t.Fatalf("expected two endpoints, got %s (%v)", rec.Body.String(), err)
+			}
+			for _, field := range test.fields {
+				if _, ok := elements[0][field]; !ok {
+					t.Errorf("expected field %s, got %v", field, elements[0])
+				}
+			}
+			if test.query != "" && len(elements[0]) != len(test.fields) {
+				t.Errorf("expected only fields %v, got %v", test.fields, elements[0])
+			}
+		})
+	}
+}
+
+// postJSON performs a POST of body to path with postEndpoint, which is given
+// the headers as name, value pairs.
+func postJSON(store meow.ConfigStore, path, body string, headers ...string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(http.MethodPost, path, strings.NewReader(body))
+	r.Header.Set("Content-Type", "application/json")
+	for i := 0; i+1 < len(headers); i += 2 {
+		r.Header.Set(headers[i], headers[i+1])
+	}
+	rec := httptest.NewRecorder()
+	postEndpoint(rec, r, store, nil)
+	return rec
+}
+
+func TestPostEndpointCreateOnly(t *testing.T) {
+	const libvirt = `{"identifier":"libvirt","url":"https://libvirt.org","method":"GET","status_online":200,"frequency":"1m","owner":"ops"}`
+	const updated = `{"identifier":"libvirt","url":"https://libvirt.org","method":"HEAD","status_online":200,"frequency":"1m","owner":"ops"}`
+	tests := []struct {
+		name     string
+		seeded   bool
+		path     string
+		body     string
+		headers  []string
+		expected int
+	}{
+		{"create", false, "/endpoints/libvirt", libvirt, []string{"If-None-Match", "*"}, http.StatusCreated},
+		{"create through collection", false, "/endpoints/", libvirt, []string{"If-None-Match", "*"}, http.StatusCreated},
+		{"create without header", false, "/endpoints/", libvirt, nil, http.StatusCreated},
+		{"conflicting creation", true, "/endpoints/libvirt", updated, []string{"If-None-Match", "*"}, http.StatusConflict},
+		{"conflicting creation of same", true, "/endpoints/libvirt", libvirt, []string{"If-None-Match", "*"}, http.StatusConflict},
+		{"update", true, "/endpoints/libvirt", updated, nil, http.StatusNoContent},
+		{"unchanged", true, "/endpoints/libvirt", libvirt, nil, http.StatusNotModified},
+		{"update through collection", true, "/endpoints/", updated, nil, http.StatusBadRequest},
+		{"other entity tag", true, "/endpoints/libvirt", updated, []string{"If-None-Match", `W/"1"`}, http.StatusBadRequest},
+		{"with If-Match", false, "/endpoints/libvirt", libvirt, []string{"If-None-Match", "*", "If-Match", "*"}, http.StatusBadRequest},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			store := meow.NewMemConfigStore()
+			if test.seeded {
+				store = seededStore(t)
+			}
+			rec := postJSON(store, test.path, test.body, test.headers...)
+			if rec.Code != test.expected {
+				t.Fatalf("expected status %d, got %d: %s", test.expected, rec.Code, rec.Body.String())
+			}
+			if rec.Code == http.StatusBadRequest {
+				errorOf(t, rec)
+				return
+			}
+			stored, err := store.Get(context.Background(), "libvirt")
+			if err != nil {
+				t.Fatalf("expected endpoint to be stored, got %v", err)
+			}
+			switch rec.Code {
+			case http.StatusCreated:
+				if location := rec.Header().Get("Location"); location != "/endpoints/libvirt" {
+					t.Errorf("expected Location /endpoints/libvirt, got %s", location)
+				}
+			case http.StatusNoContent:
+				if stored.Method != "HEAD" || stored.Version != 2 {
+					t.Errorf("expected update to version 2, got %+v", *stored)
+				}
+			case http.StatusConflict:
+				errorOf(t, rec)
+				if stored.Method != "GET" || stored.Version != 1 {
+					t.Errorf("expected endpoint not to be overwritten, got %+v", *stored)
+				}
+			}
+		})
+	}
+}
+
+func TestGetEndpointStatus(t *testing.T) {
+	client := valkeytest.NewClient(t)
+	store := seededStore(t)
+	get := func() map[string]any {
+		t.Helper()
+		rec := httptest.NewRecorder()
+		getEndpointStatus(rec, httptest.NewRequest(http.MethodGet, "/endpoints/libvirt/status", nil), client, store)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("expected status 200, got %d: %s", rec.Code, rec.Body.String())
+		}
+		var fields map[string]any
+		if err := json.Unmarshal(rec.Body.Bytes(), &fields); err != nil {
+			t.Fatalf("parse %s: %v", rec.Body.String(), err)
+		}
+		return fields
+	}
+	// not probed yet
+	if fields := get(); fields["state"] != "unknown" || fields["up"] != nil {
+		t.Errorf(`expected "state": "unknown" without up, got %v`, fields)
+	}
+	ctx := context.Background()
+	key := meow.StatusKey("libvirt")
+	err := client.Do(ctx, client.B().Hset().Key(key).FieldValue().
+		FieldValue("state", "offline").FieldValue("status_code", "503").
+		FieldValue("consecutive_failures", "1").Build()).Error()
+	if err != nil {
+		t.Fatalf("hset %s: %v", key, err)
+	}
+	// seededStore's endpoint fails after 3 probes by default
+	if fields := get(); fields["state"] != "offline" || fields["status_code"] != 503.0 || fields["up"] != true {
+		t.Errorf("expected endpoint offline, but still up, got %v", fields)
+	}
+	err = client.Do(ctx, client.B().Hset().Key(key).FieldValue().FieldValue("consecutive_failures", "5").Build()).Error()
+	if err != nil {
+		t.Fatalf("hset %s: %v", key, err)
+	}
+	if fields := get(); fields["up"] != false {
+		t.Errorf("expected endpoint down, got %v", fields)
+	}
+
+	rec := httptest.NewRecorder()
+	getEndpointStatus(rec, httptest.NewRequest(http.MethodGet, "/endpoints/go-dev/status", nil), client, store)
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("expected status 404 for missing endpoint, got %d", rec.Code)
+	}
+}
+
+func TestGetMetrics(t *testing.T) {
+	client := valkeytest.NewClient(t)
+	store := seededStore(t)
+	key := meow.StatusKey("libvirt")
+	err := client.Do(context.Background(), client.B().Hset().Key(key).FieldValue().
+		FieldValue("state", "online").FieldValue("checks", "7").FieldValue("failures", "2").Build()).Error()
+	if err != nil {
+		t.Fatalf("hset %s: %v", key, err)
+	}
+	tests := []struct {
+		name     string
+		caller   caller
+		expected []string
+	}{
+		{"owner", caller{owner: "ops"}, []string{
+			`meow_endpoint_up{identifier="libvirt"} 1`,
+			`meow_endpoint_checks_total{identifier="libvirt"} 7`,
+			`meow_endpoint_failures_total{identifier="libvirt"} 2`,
+		}},
+		{"other owner", caller{owner: "dev"}, nil},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			getMetrics(rec, asCaller(httptest.NewRequest(http.MethodGet, "/metrics", nil), test.caller), client, store)
+			if rec.Code != http.StatusOK || rec.Header().Get("Content-Type") != meow.PrometheusMetricsContentType {
+				t.Fatalf("expected metrics with status 200, got %d and %s", rec.Code, rec.Header().Get("Content-Type"))
+			}
+			var samples []string
+			for _, line := range strings.Split(strings.TrimSpace(rec.Body.String()), "\n") {
+				if !strings.HasPrefix(line, "#") {
+					samples = append(samples, line)
+				}
+			}
+			if !slices.Equal(samples, test.expected) {
+				t.Errorf("expected samples %v, got %v", test.expected, samples)
+			}
+		})
+	}
+}
+
+func TestServeShutdown(t *testing.T) {
+	tests := []struct {
+		name     string
+		duration time.Duration
+		timeout  time.Duration
+		finished bool
+	}{
+		{"in flight", 100 * time.Millisecond, time.Second, true},
+		{"too slow", time.Minute, 100 * time.Millisecond, false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			listener, err := net.Listen("tcp", "127.0.0.1:0")
+			if err != nil {
+				t.Fatalf("listen: %v", err)
+			}
+			started := make(chan struct{})
+			handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				close(started)
+				select {
+				case <-time.After(test.duration):
+					w.Write([]byte("finished"))
+				case <-r.Context().Done():
+				}
+			})
+			ctx, shutDown := context.WithCancel(context.Background())
+			served := make(chan error, 1)
+			go func() { served <- serve(ctx, listener, handler, test.timeout) }()
+			type response struct {
+				body string
+				err  error
+			}
+			responses := make(chan response, 1)
+			go func() {
+				res, err := http.Get("http://" + listener.Addr().String())
+				if err != nil {
+					responses <- response{err: err}
+					return
+				}
+				defer res.Body.Close()
+				body, err := io.ReadAll(res.Body)
+				responses <- response{string(body), err}
+			}()
+			<-started
+			shutDown()
+			err = <-served
+			if (err == nil) != test.finished {
+				t.Errorf("expected shutdown to succeed only when finished in time, got %v", err)
+			}
+			res := <-responses
+			if finished := res.err == nil && res.body == "finished"; finished != test.finished {
+				t.Errorf("expected request finished: %t, got %q (%v)", test.finished, res.body, res.err)
+			}
+			if _, err := net.Dial("tcp", listener.Addr().String()); err == nil {
+				t.Errorf("expected no connections to be accepted after shutdown")
+			}
+		})
+	}
+}
+
+func TestErrorResponses(t *testing.T) {
+	client := valkeytest.NewClient(t)
+	store := seededStore(t)
+	endpoint := `{"identifier":"go-dev","url":"https://go.dev","method":"GET","status_online":200}`
+	tests := []struct {
+		name    string
+		method  string
+		path    string
+		body    string
+		handler func(http.ResponseWriter, *http.Request)
+		status  int
+	}{
+		{"missing endpoint", http.MethodGet, "/endpoints/go-dev", "",
+			func(w http.ResponseWriter, r *http.Request) { getEndpoint(w, r, store) }, http.StatusNotFound},
+		{"deleting missing endpoint", http.MethodDelete, "/endpoints/go-dev", "",
+			func(w http.ResponseWriter, r *http.Request) { deleteEndpoint(w, r, store) }, http.StatusNotFound},
+		{"status of missing endpoint", http.MethodGet, "/endpoints/go-dev/status", "",
+			func(w http.ResponseWriter, r *http.Request) { getEndpointStatus(w, r, client, store) }, http.StatusNotFound},
+		{"invalid method filter", http.MethodGet, "/endpoints?method=BANANA", "",
+			func(w http.ResponseWriter, r *http.Request) { getEndpoints(w, r, client, store) }, http.StatusBadRequest},
+		{"probe without endpoints", http.MethodPost, "/endpoints/probe", "",
+			func(w http.ResponseWriter, r *http.Request) { postProbe(w, r, store, nil) }, http.StatusBadRequest},
+		{"invalid scheduler state", http.MethodPost, "/admin/scheduler?state=asleep", "",
+			func(w http.ResponseWriter, r *http.Request) { postScheduler(w, r, client) }, http.StatusBadRequest},
+		{"invalid read-only flag", http.MethodPost, "/admin/readonly?on=maybe", "",
+			func(w http.ResponseWriter, r *http.Request) { postReadOnly(w, r, client) }, http.StatusBadRequest},
+		{"invalid endpoint", http.MethodPost, "/endpoints/go-dev", `{"identifier":"go-dev"}`,
+			func(w http.ResponseWriter, r *http.Request) { postEndpoint(w, r, store, nil) }, http.StatusBadRequest},
+		{"endpoint without Content-Type", http.MethodPost, "/endpoints/go-dev", endpoint,
+			func(w http.ResponseWriter, r *http.Request) {
+				r.Header.Del("Content-Type")
+				postEndpoint(w, r, store, nil)
+			}, http.StatusUnsupportedMediaType},
+		{"endpoint as text", http.MethodPost, "/endpoints/go-dev", endpoint,
+			func(w http.ResponseWriter, r *http.Request) {
+				r.Header.Set("Content-Type", "text/plain")
+				postEndpoint(w, r, store, nil)
+			}, http.StatusUnsupportedMediaType},
+		{"endpoints without Content-Type", http.MethodPost, "/endpoints", "[" + endpoint + "]",
+			func(w http.ResponseWriter, r *http.Request) {
+				r.Header.Del("Content-Type")
+				postEndpoints(w, r, store)
+			}, http.StatusUnsupportedMediaType},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			r := httptest.NewRequest(test.method, test.path, strings.NewReader(test.body))
+			r.Header.Set("Content-Type", "application/json")
+			r.SetPathValue("id", "go-dev")
+			rec := httptest.NewRecorder()
+			test.handler(rec, r)
+			if rec.Code != test.status {
+				t.Fatalf("expected status %d, got %d: %s", test.status, rec.Code, rec.Body.String())
+			}
+			errorOf(t, rec)
+		})
+	}
+}
+
+func TestJSONResponses(t *testing.T) {
+	store := seededStore(t)
+	get := httptest.NewRequest(http.MethodGet, "/endpoints/libvirt", nil)
+	rec := httptest.NewRecorder()
+	getEndpoint(rec, asCaller(get, caller{owner: "ops"}), store)
+	if rec.Code != http.StatusOK || rec.Header().Get("Content-Type") != "application/json" {
+		t.Errorf("expected endpoint as JSON, got %d and %s", rec.Code, rec.Header().Get("Content-Type"))
+	}
+	// parameters of the media type are accepted
+	rec = postJSON(store, "/endpoints/go-dev",
+		`{"identifier":"go-dev","url":"https://go.dev","method":"GET","status_online":200}`,
+		"Content-Type", "application/json; charset=utf-8")
+	if rec.Code/100 != 2 || rec.Header().Get("Content-Type") != "application/json" {
+		t.Errorf("expected endpoint to be stored, got %d and %s: %s", rec.Code, rec.Header().Get("Content-Type"), rec.Body.String())
+	}
+}
+
+func TestWriteJSON(t *testing.T) {
+	rec := httptest.NewRecorder()
+	writeJSON(rec, http.StatusCreated, struct {
+		Identifier string `json:"identifier"`
+	}{"libvirt"})
+	if rec.Code != http.StatusCreated || rec.Body.String() != `{"identifier":"libvirt"}` {
+		t.Errorf("expected 201 with the value as JSON, got %d with %s", rec.Code, rec.Body)
+	}
+	rec = httptest.NewRecorder()
+	writeJSON(rec, http.StatusOK, json.RawMessage(`{"identifier":"libvirt"}`))
+	if rec.Body.String() != `{"identifier":"libvirt"}` {
+		t.Errorf("expected raw JSON to be written as is, got %s", rec.Body)
+	}
+	rec = httptest.NewRecorder()
+	writeJSON(rec, http.StatusOK, make(chan int))
+	if rec.Code != http.StatusInternalServerError {
+		t.Errorf("expected 500 for a value not serializable, got %d", rec.Code)
+	}
+	if reason := errorOf(t, rec); reason != http.StatusText(http.StatusInternalServerError) {
+		t.Errorf(`expected reason "%s", got "%s"`, http.StatusText(http.StatusInternalServerError), reason)
+	}
+}
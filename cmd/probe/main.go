@@ -2,18 +2,29 @@ package main
 
 import (
 	"bytes"
+	"cmp"
+	"container/heap"
+	"context"
 	"encoding/json"
 	"fmt"
 	"io"
-	"log"
+	"math"
 	"net/http"
+	"net/url"
 	"os"
 	"os/signal"
+	"runtime"
+	"slices"
+	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"syscall"
+	"text/template"
 	"time"
 
 	"github.com/patrickbucher/meow"
+	"github.com/valkey-io/valkey-go"
 )
 
 func main() {
@@ -22,7 +33,52 @@ func main() {
 		fmt.Fprintln(os.Stderr, "environment variable CONFIG_URL must be set")
 		os.Exit(1)
 	}
-	endpoints := mustFetchEndpoints(configURL)
+	valkeyURL, ok := os.LookupEnv("VALKEY_URL")
+	if !ok {
+		fmt.Fprintln(os.Stderr, "environment variable VALKEY_URL must be set")
+		os.Exit(1)
+	}
+	options, err := meow.ValkeyClientOption(valkeyURL)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "parse VALKEY_URL: %v\n", err)
+		os.Exit(1)
+	}
+	client, err := valkey.NewClient(*options)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "connect to Valkey: %v\n", err)
+		os.Exit(1)
+	}
+	defer client.Close()
+
+	if err := refreshSettings(client); err != nil {
+		fmt.Fprintf(os.Stderr, "refresh settings: %v\n", err)
+		os.Exit(1)
+	}
+	go func() {
+		for range time.Tick(settingsRefreshInterval) {
+			if err := refreshSettings(client); err != nil {
+				fmt.Fprintf(os.Stderr, "refresh settings: %v\n", err)
+			}
+		}
+	}()
+
+	if err := refreshSchedulerState(client); err != nil {
+		fmt.Fprintf(os.Stderr, "refresh scheduler state: %v\n", err)
+		os.Exit(1)
+	}
+	go func() {
+		for range time.Tick(schedulerRefreshInterval) {
+			if err := refreshSchedulerState(client); err != nil {
+				fmt.Fprintf(os.Stderr, "refresh scheduler state: %v\n", err)
+			}
+		}
+	}()
+
+	endpoints, err := fetchEndpoints(configURL)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "fetch endpoints: %v\n", err)
+		os.Exit(1)
+	}
 
 	logFileName := fmt.Sprintf("meow-%v.log", time.Now().Format("2006-01-02T15-04-05"))
 	logFilePath := strings.Join([]string{os.TempDir(), logFileName}, string(os.PathSeparator))
@@ -33,7 +89,60 @@ func main() {
 	}
 	fmt.Fprintf(os.Stderr, "started logging to %s\n", logFilePath)
 
-	go monitor(endpoints, logFile)
+	var exporter *meow.OTLPExporter
+	if otlpEndpoint := os.Getenv("MEOW_OTLP_ENDPOINT"); otlpEndpoint != "" {
+		tracing := false
+		if raw, ok := os.LookupEnv("MEOW_OTLP_TRACING"); ok {
+			if tracing, err = strconv.ParseBool(raw); err != nil {
+				fmt.Fprintf(os.Stderr, `MEOW_OTLP_TRACING "%s" is not a boolean`+"\n", raw)
+				os.Exit(1)
+			}
+		}
+		exporter = meow.NewOTLPExporter(otlpEndpoint, tracing, otlpBatchSize, otlpExportInterval, func(err error) {
+			fmt.Fprintf(os.Stderr, "export metrics: %v\n", err)
+		})
+		go exporter.Run()
+		fmt.Fprintf(os.Stderr, "exporting metrics to %s\n", otlpEndpoint)
+	}
+
+	var notifier *meow.Notifier
+	if webhook := os.Getenv("MEOW_NOTIFY_WEBHOOK"); webhook != "" {
+		timeout := defaultNotifyTimeout
+		if raw, ok := os.LookupEnv("MEOW_NOTIFY_TIMEOUT"); ok {
+			if timeout, err = time.ParseDuration(raw); err != nil || timeout <= 0 {
+				fmt.Fprintf(os.Stderr, `MEOW_NOTIFY_TIMEOUT "%s" is not a valid duration`+"\n", raw)
+				os.Exit(1)
+			}
+		}
+		var tmpl *template.Template
+		if raw, ok := os.LookupEnv("MEOW_NOTIFY_WEBHOOK_TEMPLATE"); ok {
+			if tmpl, err = meow.ParseNotifyTemplate(raw); err != nil {
+				fmt.Fprintf(os.Stderr, "MEOW_NOTIFY_WEBHOOK_TEMPLATE: %v\n", err)
+				os.Exit(1)
+			}
+		}
+		notifier = meow.NewNotifier(webhook, timeout, tmpl, func(err error) {
+			fmt.Fprintf(os.Stderr, "notify: %v\n", err)
+		})
+		go notifier.Run()
+		fmt.Fprintln(os.Stderr, "delivering notifications to webhook")
+	}
+
+	timeSeries, err := meow.TimeSeriesAvailable(context.Background(), client)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "detect time series: %v\n", err)
+		os.Exit(1)
+	}
+	if !timeSeries {
+		fmt.Fprintln(os.Stderr, "time series unavailable, not storing latencies")
+	}
+
+	go persistStatsPeriodically(client)
+
+	changes := make(chan endpointChanges)
+	go monitor(changes, logFile, client, exporter, notifier, timeSeries)
+	changes <- endpointChanges{updated: endpoints, full: true}
+	go watchEndpoints(client, configURL, changes)
 
 	done := make(chan struct{})
 	signals := make(chan os.Signal, 1)
@@ -49,26 +158,355 @@ func main() {
 	<-done
 }
 
-func monitor(endpoints []meow.Endpoint, logger *meow.LogFile) {
-	probe := func(e meow.Endpoint, messages chan string) {
+// otlpBatchSize and otlpExportInterval define how many probe metrics are
+// exported at once, and how long they are held back at most.
+const (
+	otlpBatchSize      = 100
+	otlpExportInterval = 10 * time.Second
+)
+
+// defaultNotifyTimeout is how long the delivery of a notification may take,
+// unless configured otherwise.
+const defaultNotifyTimeout = 5 * time.Second
+
+// settingsRefreshInterval is how often the settings stored by the config server
+// are reloaded.
+const settingsRefreshInterval = 30 * time.Second
+
+// refreshSettings puts the settings stored by the config server into effect.
+func refreshSettings(client valkey.Client) error {
+	ctx := context.Background()
+	kvs, err := client.Do(ctx, client.B().Hgetall().Key(meow.SettingsKey).Build()).AsStrMap()
+	if err != nil {
+		return fmt.Errorf("hgetall %s: %v", meow.SettingsKey, err)
+	}
+	settings, err := meow.SettingsFromMap(kvs)
+	if err != nil {
+		return fmt.Errorf("parse settings from %s: %v", meow.SettingsKey, err)
+	}
+	meow.ApplySettings(*settings)
+	return nil
+}
+
+// schedulerRefreshInterval is how often the scheduler state stored by the
+// config server is reloaded.
+const schedulerRefreshInterval = 5 * time.Second
+
+// schedulerPaused indicates that the scheduler has been paused, so that no
+// probes are issued.
+var schedulerPaused atomic.Bool
+
+// refreshSchedulerState puts the scheduler state stored by the config server
+// into effect.
+func refreshSchedulerState(client valkey.Client) error {
+	ctx := context.Background()
+	raw, err := client.Do(ctx, client.B().Get().Key(meow.SchedulerKey).Build()).ToString()
+	if valkey.IsValkeyNil(err) {
+		raw, err = string(meow.SchedulerRunning), nil
+	}
+	if err != nil {
+		return fmt.Errorf("get %s: %v", meow.SchedulerKey, err)
+	}
+	state, err := meow.ParseSchedulerState(raw)
+	if err != nil {
+		return fmt.Errorf("parse %s: %v", meow.SchedulerKey, err)
+	}
+	schedulerPaused.Store(state == meow.SchedulerPaused)
+	return nil
+}
+
+// probeCounters count the probes issued within the current stats interval, and
+// the endpoints being probed.
+type probeCounters struct {
+	probes    atomic.Int64
+	failures  atomic.Int64
+	duration  atomic.Int64
+	endpoints atomic.Int64
+}
+
+var counters probeCounters
+
+// record counts a probe that took duration, and whether or not it failed.
+func (c *probeCounters) record(duration time.Duration, failed bool) {
+	c.probes.Add(1)
+	if failed {
+		c.failures.Add(1)
+	}
+	c.duration.Add(int64(duration))
+}
+
+// statsDisabledInterval is how often the stats interval is looked up again if
+// persisting the runtime statistics is disabled.
+const statsDisabledInterval = 30 * time.Second
+
+// persistStatsPeriodically persists the runtime statistics of the probe once
+// per stats interval, as configured at the start of the interval.
+func persistStatsPeriodically(client valkey.Client) {
+	for {
+		interval := meow.CurrentSettings().StatsInterval
+		if interval <= 0 {
+			time.Sleep(statsDisabledInterval)
+			continue
+		}
+		start := time.Now()
+		time.Sleep(interval)
+		if err := persistStats(client, time.Since(start)); err != nil {
+			fmt.Fprintf(os.Stderr, "persist stats: %v\n", err)
+		}
+	}
+}
+
+// persistStats prepends the runtime statistics of the interval just elapsed to
+// the list of probe stats, and resets the counters for the next interval.
+func persistStats(client valkey.Client, interval time.Duration) error {
+	probes, failures := counters.probes.Swap(0), counters.failures.Swap(0)
+	duration := counters.duration.Swap(0)
+	var memStats runtime.MemStats
+	runtime.ReadMemStats(&memStats)
+	stats := meow.ProbeStats{
+		Timestamp:  time.Now(),
+		IntervalMS: interval.Milliseconds(),
+		Probes:     probes,
+		Failures:   failures,
+
+		Endpoints:      int(counters.endpoints.Load()),
+		Goroutines:     runtime.NumGoroutine(),
+		HeapAllocBytes: memStats.HeapAlloc,
+		SysBytes:       memStats.Sys,
+		GCCycles:       memStats.NumGC,
+	}
+	if probes > 0 {
+		stats.ErrorRate = float64(failures) / float64(probes)
+		stats.AverageDurationMS = float64(duration) / float64(probes) / float64(time.Millisecond)
+	}
+	data, err := stats.JSON()
+	if err != nil {
+		return err
+	}
+	ctx := context.Background()
+	results := client.DoMulti(ctx,
+		client.B().Lpush().Key(meow.ProbeStatsKey).Element(string(data)).Build(),
+		client.B().Ltrim().Key(meow.ProbeStatsKey).Start(0).Stop(meow.ProbeStatsRetained-1).Build())
+	for _, result := range results {
+		if err := result.Error(); err != nil {
+			return fmt.Errorf("append to %s: %v", meow.ProbeStatsKey, err)
+		}
+	}
+	return nil
+}
+
+// monitor probes the endpoints announced by changes, and restarts or stops the
+// probes of endpoints updated or deleted later on.
+func monitor(changes <-chan endpointChanges, logger *meow.LogFile, client valkey.Client,
+	exporter *meow.OTLPExporter, notifier *meow.Notifier, timeSeries bool) {
+	clients := meow.NewClientCache(maxCachedClients)
+	breakers := newHostBreakers()
+	schedule := newScheduler()
+	go schedule.run()
+	probe := func(e meow.Endpoint, stop <-chan struct{}, messages chan string) {
 		messages <- fmt.Sprintf("started probing %s every %v", e.Identifier, e.Frequency)
-		freq := time.NewTicker(e.Frequency)
+		scheduled := schedule.add(e.Identifier, e.Frequency, time.Now())
+		defer schedule.remove(scheduled)
+		// wait for the next probe, unless the endpoint was updated or deleted
+		wait := func() bool {
+			select {
+			case <-scheduled.ready:
+				return true
+			case <-stop:
+				messages <- fmt.Sprintf("stopped probing %s", e.Identifier)
+				return false
+			}
+		}
+		// whether the next probe must not reuse a pooled connection
+		freshConnections := false
 		errorCount := 0
 		lastStateOK := false
 		firstTry := true
 		alerted := false
+		// the endpoint was considered offline since its last success
+		wasOffline := false
+		// the state as of the previous probe
+		lastState := meow.StateUnknown
+		// outcomes of the last e.StabilityWindow probes, the latest last
+		var recent []bool
+		var failingSince time.Time
+		var written *statusSnapshot
+		// value captured by e.ExtractRegex from the previous response
+		var extracted string
+		paused := false
+		held := false
+		// notify within the limit spanning all channels
+		notify := func(n meow.Notification) {
+			if notifier == nil {
+				return
+			}
+			allowed, err := allowNotification(client, e.Identifier, n.Time)
+			if err != nil {
+				// rather notify too often than not at all
+				messages <- fmt.Sprintf("%c count notification: %v", meow.CrossMark, err)
+			} else if !allowed {
+				settings := meow.CurrentSettings()
+				messages <- fmt.Sprintf("%s notification suppressed: limit of %d per %v reached",
+					e.Identifier, settings.NotifyLimit, settings.NotifyWindow)
+				return
+			}
+			notifier.Notify(n)
+		}
+		// the first probe is due right away
+		if !wait() {
+			return
+		}
 		for {
 			start := time.Now()
-			status, err := requestForStatus(e)
+			if schedulerPaused.Load() {
+				if !held {
+					messages <- fmt.Sprintf("%s is not probed while the scheduler is paused", e.Identifier)
+					held = true
+				}
+				// keep the status as it is
+				if !wait() {
+					return
+				}
+				continue
+			}
+			held = false
+			if !e.ActiveAt(start) {
+				if !paused {
+					// TODO: adjust log format
+					messages <- fmt.Sprintf("%s is paused outside of its active hours", e.Identifier)
+					paused = true
+				}
+				// not probed: keep last_probed
+				err := persistStatus(client, e.Identifier,
+					"state", string(meow.StatePaused),
+					"next_due", start.Add(e.Frequency).Format(time.RFC3339Nano),
+					"effective_interval", e.Frequency.String())
+				if err != nil {
+					messages <- fmt.Sprintf("%c persist status: %v", meow.CrossMark, err)
+				}
+				written = nil
+				if !wait() {
+					return
+				}
+				continue
+			}
+			paused = false
+			host := e.URL.Host
+			breaker, allowed := breakers.allow(host, start)
+			if !allowed {
+				// not probed: keep the state and last_probed
+				err := persistStatus(client, e.Identifier,
+					"breaker", string(breaker),
+					"next_due", start.Add(e.Frequency).Format(time.RFC3339Nano),
+					"effective_interval", e.Frequency.String())
+				if err != nil {
+					messages <- fmt.Sprintf("%c persist status: %v", meow.CrossMark, err)
+				}
+				written = nil
+				if !wait() {
+					return
+				}
+				continue
+			}
+			inMaintenance := e.InMaintenance(start)
+			var span meow.ProbeSpan
+			input := meow.ProbeInput{Clients: clients, Extracted: extracted, FreshConnections: freshConnections}
+			if exporter.Tracing() {
+				span = meow.NewProbeSpan(e, start)
+				input.Traceparent = span.Traceparent()
+			}
+			if e.ExpectBuildHeader != "" {
+				var err error
+				if input.ExpectedBuild, err = fetchExpectedBuild(client, e.Identifier); err != nil {
+					messages <- fmt.Sprintf("%c fetch expected build: %v", meow.CrossMark, err)
+				}
+			}
+			result, err := meow.ProbeEndpoint(meow.WithProbeInput(context.Background(), input), &e)
 			if err != nil {
-				// TODO: adjust log format
-				messages <- fmt.Sprintf("%c request failed: %v", meow.CrossMark, err)
+				messages <- fmt.Sprintf("%c %v", meow.CrossMark, err)
+				if !wait() {
+					return
+				}
+				continue
+			}
+			if e.ExtractRegex != nil && result.StatusCode != 0 {
+				// kept if no response was received
+				extracted = result.Extracted
 			}
+			var captured []byte
+			if len(e.CaptureHeaderNames) > 0 {
+				if captured, err = json.Marshal(result.Headers); err != nil {
+					messages <- fmt.Sprintf("%c serialize captured headers: %v", meow.CrossMark, err)
+				}
+			}
+			status, ttfb := result.StatusCode, result.TTFB
+			redirected := result.State == meow.StateRedirect
 			end := time.Now()
-			duration := end.Sub(start)
-			stateOK := status == int(e.StatusOnline)
-			if stateOK {
-				if lastStateOK || firstTry {
+			duration := result.Latency
+			stateOK := result.FailureKind == ""
+			// don't let the next probe reuse a possibly broken connection
+			freshConnections = !stateOK && !e.KeepConnectionsOnFailure
+			counters.record(duration, !stateOK)
+			// a host responding with an unexpected status is not down
+			reached := stateOK || result.FailureKind == meow.FailureStatus || result.FailureKind == meow.FailureAssertion
+			if previous, current := breakers.record(host, reached, end); current != previous {
+				// TODO: adjust log format
+				messages <- fmt.Sprintf("circuit breaker of %s is %s (was %s)", host, current, previous)
+				breaker = current
+			}
+			failureKind, failureMessage := string(result.FailureKind), result.Error
+			if !stateOK {
+				// TODO: adjust log format
+				messages <- fmt.Sprintf("%c %s probe failed: %s: %s", meow.CrossMark, e.Identifier, failureKind, failureMessage)
+			}
+			var stability string
+			stable := true
+			if e.StabilityWindow > 0 {
+				recent = append(recent, stateOK)
+				if len(recent) > int(e.StabilityWindow) {
+					recent = recent[1:]
+				}
+				successes := 0
+				for _, ok := range recent {
+					if ok {
+						successes++
+					}
+				}
+				// probes not performed yet do not count as failures
+				failures := len(recent) - successes
+				stable = failures <= int(e.StabilityWindow-e.StabilityThreshold)
+				stability = strconv.FormatFloat(float64(successes)/float64(len(recent)), 'f', 2, 64)
+			}
+			var concurrency string
+			if result.Concurrency != nil {
+				concurrency = strconv.FormatFloat(*result.Concurrency, 'f', 2, 64)
+			}
+			state := meow.StateOnline
+			if stateOK && !stable {
+				// not recovered until succeeding often enough again
+				state = meow.StateOffline
+				// TODO: adjust log format
+				messages <- fmt.Sprintf("%c %s responded, but is not stable (stability %s)",
+					meow.CatUnavailable, e.Identifier, stability)
+			} else if stateOK {
+				if e.MaxTTFB > 0 && ttfb > e.MaxTTFB {
+					state = meow.StateDegraded
+					// TODO: adjust log format
+					messages <- fmt.Sprintf("%c %s is degraded (time to first byte %v exceeds %v)",
+						meow.CatUnavailable, e.Identifier, ttfb, e.MaxTTFB)
+				} else if redirected && e.RedirectCountsAs == meow.StateDegraded {
+					state = meow.StateDegraded
+					// TODO: adjust log format
+					messages <- fmt.Sprintf("%c %s is degraded (redirected with status %d to %s)",
+						meow.CatUnavailable, e.Identifier, status, result.RedirectLocation)
+				} else if result.State == meow.StateDegraded {
+					// some of the concurrent probes failed
+					state = meow.StateDegraded
+					failed := int(e.ConcurrentProbes) - int(math.Round(*result.Concurrency*float64(e.ConcurrentProbes)))
+					// TODO: adjust log format
+					messages <- fmt.Sprintf("%c %s is degraded (%d of %d concurrent requests failed)",
+						meow.CatUnavailable, e.Identifier, failed, e.ConcurrentProbes)
+				} else if lastStateOK || firstTry {
 					// TODO: adjust log format
 					messages <- fmt.Sprintf("%c %s is online (took %v)",
 						meow.CatAvailable, e.Identifier, duration)
@@ -77,71 +515,805 @@ func monitor(endpoints []meow.Endpoint, logger *meow.LogFile) {
 					messages <- fmt.Sprintf("%c %s is online again (took %v)",
 						meow.CatAvailableAgain, e.Identifier, duration)
 				}
+				if wasOffline {
+					incident := meow.Incident{
+						Start:       failingSince,
+						End:         start,
+						MaxFailures: errorCount,
+					}
+					if err := recordIncident(client, e.Identifier, incident,
+						meow.CurrentSettings().IncidentRetention); err != nil {
+						messages <- fmt.Sprintf("%c record incident: %v", meow.CrossMark, err)
+					}
+				}
+				if alerted {
+					notify(meow.Notification{Identifier: e.Identifier, State: state, From: lastState, Time: start})
+				}
 				lastStateOK = true
 				errorCount = 0
 				alerted = false
+				wasOffline = false
 			} else {
 				errorCount++
+				if errorCount == 1 {
+					failingSince = start
+				}
 				// TODO: adjust log format
 				messages <- fmt.Sprintf("%c %s is not online (%d times)",
 					meow.CatUnavailable, e.Identifier, errorCount)
-				if errorCount >= int(e.FailAfter) && !alerted {
-					// TODO: adjust log format
-					messages <- fmt.Sprintf("%c ALERT: %s is offline (%d failed attempts)",
-						meow.CatAlert, e.Identifier, e.FailAfter)
+				misconfigured := result.FailureKind == meow.FailureNXDomain &&
+					meow.CurrentSettings().NXDomainAsConfigError
+				refused := result.FailureKind == meow.FailureRefused
+				failAfter := int(e.FailAfter)
+				if refused && e.FastFailOnRefused {
+					failAfter = 1
+				}
+				offline := errorCount >= failAfter
+				if e.StabilityWindow > 0 && !(refused && e.FastFailOnRefused) {
+					offline = !stable
+				}
+				if offline {
+					wasOffline = true
+					state = meow.StateOffline
+					if misconfigured {
+						state = meow.StateMisconfigured
+					} else if refused {
+						state = meow.StateRefused
+					}
+				}
+				if offline && !alerted && !inMaintenance {
+					if misconfigured {
+						// TODO: adjust log format
+						messages <- fmt.Sprintf("%c CONFIG ERROR: host of %s does not exist (%s)",
+							meow.CatAlert, e.Identifier, e.URL.Hostname())
+					} else {
+						// TODO: adjust log format
+						messages <- fmt.Sprintf("%c ALERT: %s is offline (%d failed attempts, %s)",
+							meow.CatAlert, e.Identifier, errorCount, failureKind)
+					}
+					notify(meow.Notification{
+						Identifier:  e.Identifier,
+						State:       state,
+						From:        lastState,
+						FailureKind: result.FailureKind,
+						Error:       failureMessage,
+						Failures:    errorCount,
+						Time:        start,
+					})
 					alerted = true
 				}
 				lastStateOK = false
 			}
+			if redirected {
+				state = meow.StateRedirect
+			}
+			if inMaintenance {
+				state = meow.StateMaintenance
+			}
+			lastState = state
 			firstTry = false
-			<-freq.C
+			schedule := []string{
+				"last_probed", start.Format(time.RFC3339Nano),
+				"next_due", start.Add(e.Frequency).Format(time.RFC3339Nano),
+				"effective_interval", e.Frequency.String(),
+			}
+			snapshot := statusSnapshot{state, status, failureKind, errorCount, latencyBucket(duration), result.Build}
+			written, err = writeStatus(client, e.Identifier, written, snapshot, schedule, []string{
+				"state", string(state),
+				"status_code", strconv.Itoa(status),
+				"consecutive_failures", strconv.Itoa(errorCount),
+				"failure_kind", failureKind,
+				"error", failureMessage,
+				"latency", duration.String(),
+				"ttfb", ttfb.String(),
+				"body_hash", result.BodyHash,
+				"build", result.Build,
+				"status_text", result.StatusText,
+				"cert_sha256", result.CertSHA256,
+				"redirect_location", result.RedirectLocation,
+				"set_cookie", result.SetCookie,
+				"content_encoding", result.ContentEncoding,
+				"headers", string(captured),
+				"stability", stability,
+				"concurrency", concurrency,
+				"breaker", string(breaker),
+			})
+			if err != nil {
+				messages <- fmt.Sprintf("%c persist status: %v", meow.CrossMark, err)
+			}
+			if err := countCheck(client, e.Identifier, !stateOK); err != nil {
+				messages <- fmt.Sprintf("%c count check: %v", meow.CrossMark, err)
+			}
+			entry := meow.HistoryEntry{
+				Timestamp:   start,
+				Status:      status,
+				LatencyMS:   duration.Milliseconds(),
+				OK:          stateOK,
+				State:       state,
+				FailureKind: meow.FailureKind(failureKind),
+			}
+			if err := appendHistory(client, e.Identifier, entry); err != nil {
+				messages <- fmt.Sprintf("%c append history: %v", meow.CrossMark, err)
+			}
+			if retention := meow.CurrentSettings().LatencyRetention; timeSeries && retention > 0 {
+				if err := appendLatency(client, e.Identifier, entry, retention); err != nil {
+					messages <- fmt.Sprintf("%c append latency: %v", meow.CrossMark, err)
+				}
+			}
+			if exporter.Tracing() {
+				span.End, span.Status, span.Error = end, status, failureMessage
+				exporter.RecordSpan(span)
+			}
+			exporter.Record(meow.ProbeMetrics{
+				Identifier:          e.Identifier,
+				Time:                start,
+				Up:                  stateOK,
+				Latency:             duration,
+				ConsecutiveFailures: errorCount,
+				FailureKind:         meow.FailureKind(failureKind),
+			})
+			if !wait() {
+				return
+			}
 		}
 	}
+	// probes running, by the endpoints' identifiers
+	probes := make(map[string]runningProbe)
 	messages := make(chan string)
-	for _, endpoint := range endpoints {
-		go probe(endpoint, messages)
+	for {
+		select {
+		case logMessage := <-messages:
+			fmt.Fprintln(os.Stderr, logMessage)
+			logger.WriteLine(logMessage)
+		case change := <-changes:
+			updated := make(map[string]bool)
+			for _, e := range change.updated {
+				updated[e.Identifier] = true
+				// writes that only changed the metadata require no restart
+				config := e
+				config.Version, config.UpdatedBy = 0, ""
+				data, err := config.JSON()
+				if err != nil {
+					fmt.Fprintf(os.Stderr, "convert %v to JSON: %v\n", e, err)
+					continue
+				}
+				running, ok := probes[e.Identifier]
+				if ok && bytes.Equal(running.config, data) {
+					continue
+				}
+				if ok {
+					// restart with the new configuration
+					close(running.stop)
+				}
+				stop := make(chan struct{})
+				probes[e.Identifier] = runningProbe{config: data, stop: stop}
+				go probe(e, stop, messages)
+			}
+			for identifier, running := range probes {
+				if (change.full && !updated[identifier]) || slices.Contains(change.deleted, identifier) {
+					close(running.stop)
+					delete(probes, identifier)
+				}
+			}
+			counters.endpoints.Store(int64(len(probes)))
+		}
+	}
+}
+
+// runningProbe is the probe of an endpoint with the given configuration (as
+// JSON), which is stopped by closing stop.
+type runningProbe struct {
+	config []byte
+	stop   chan struct{}
+}
+
+// scheduler releases the probes of the endpoints when they are due, those due
+// at the same time in the order of their identifiers, so that the order of the
+// probes does not depend on when their goroutines happen to run.
+type scheduler struct {
+	mu    sync.Mutex
+	queue probeQueue
+	// wake interrupts waiting for the next due probe, e.g. when one was added
+	wake chan struct{}
+}
+
+// scheduledProbe is the probe of the endpoint identified by identifier, which
+// is due next at due, and then every frequency. It is released through ready.
+type scheduledProbe struct {
+	identifier string
+	frequency  time.Duration
+	due        time.Time
+	ready      chan struct{}
+	// position in the queue, or -1 once removed
+	index int
+}
+
+func newScheduler() *scheduler {
+	return &scheduler{wake: make(chan struct{}, 1)}
+}
+
+// add schedules the probe of the endpoint identified by identifier, which is
+// due at now, and then every frequency.
+func (s *scheduler) add(identifier string, frequency time.Duration, now time.Time) *scheduledProbe {
+	p := &scheduledProbe{
+		identifier: identifier,
+		frequency:  frequency,
+		due:        now,
+		ready:      make(chan struct{}, 1),
+	}
+	s.mu.Lock()
+	heap.Push(&s.queue, p)
+	s.mu.Unlock()
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+	return p
+}
+
+// remove stops scheduling p.
+func (s *scheduler) remove(p *scheduledProbe) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if p.index >= 0 {
+		heap.Remove(&s.queue, p.index)
+	}
+}
+
+// due returns the probes due at now in the order they are released, and
+// schedules each of them again a frequency later. A probe overdue several times
+// (e.g. after the process was suspended) is returned once.
+func (s *scheduler) due(now time.Time) []*scheduledProbe {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var due []*scheduledProbe
+	for len(s.queue) > 0 && !s.queue[0].due.After(now) {
+		p := s.queue[0]
+		due = append(due, p)
+		for !p.due.After(now) {
+			p.due = p.due.Add(p.frequency)
+		}
+		heap.Fix(&s.queue, 0)
+	}
+	return due
+}
+
+// next returns when the next probe is due, or false if none is scheduled.
+func (s *scheduler) next() (time.Time, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.queue) == 0 {
+		return time.Time{}, false
+	}
+	return s.queue[0].due, true
+}
+
+// run releases the due probes for as long as the process runs. Like the ticks
+// of a time.Ticker, releases are dropped for probes still busy with the
+// previous one.
+func (s *scheduler) run() {
+	timer := time.NewTimer(0)
+	for {
+		for _, p := range s.due(time.Now()) {
+			select {
+			case p.ready <- struct{}{}:
+			default:
+			}
+		}
+		wait := time.Hour
+		if next, ok := s.next(); ok {
+			wait = time.Until(next)
+		}
+		timer.Reset(wait)
+		select {
+		case <-timer.C:
+		case <-s.wake:
+		}
+	}
+}
+
+// probeOrder orders probes by when they are due next, and those due at the
+// same time by the identifiers of their endpoints.
+func probeOrder(a, b *scheduledProbe) int {
+	if c := a.due.Compare(b.due); c != 0 {
+		return c
+	}
+	return cmp.Compare(a.identifier, b.identifier)
+}
+
+// probeQueue is a heap of the scheduled probes, ordered by probeOrder.
+type probeQueue []*scheduledProbe
+
+func (q probeQueue) Len() int           { return len(q) }
+func (q probeQueue) Less(i, j int) bool { return probeOrder(q[i], q[j]) < 0 }
+
+func (q probeQueue) Swap(i, j int) {
+	q[i], q[j] = q[j], q[i]
+	q[i].index, q[j].index = i, j
+}
+
+func (q *probeQueue) Push(x any) {
+	p := x.(*scheduledProbe)
+	p.index = len(*q)
+	*q = append(*q, p)
+}
+
+func (q *probeQueue) Pop() any {
+	old := *q
+	p := old[len(old)-1]
+	old[len(old)-1] = nil
+	p.index = -1
+	*q = old[:len(old)-1]
+	return p
+}
+
+// breakerState is the state of the circuit breaker of a host.
+type breakerState string
+
+// States of a circuit breaker: A closed breaker lets all probes of its host
+// pass, an open one none, and a half-open one a single probe testing whether
+// the host recovered.
+const (
+	breakerClosed   breakerState = "closed"
+	breakerOpen     breakerState = "open"
+	breakerHalfOpen breakerState = "half_open"
+)
+
+// hostBreaker is the circuit breaker of a single host.
+type hostBreaker struct {
+	state    breakerState
+	failures int
+	openedAt time.Time
+	// testing indicates that the probe of a half-open breaker is in flight
+	testing bool
+}
+
+// hostBreakers are the circuit breakers of the hosts probed, which suspend
+// probing a host after meow.Settings.BreakerThreshold consecutive failed
+// probes across all its endpoints.
+type hostBreakers struct {
+	mu       sync.Mutex
+	breakers map[string]*hostBreaker
+}
+
+func newHostBreakers() *hostBreakers {
+	return &hostBreakers{breakers: make(map[string]*hostBreaker)}
+}
+
+// allow indicates whether or not an endpoint of host may be probed at now, and
+// returns the state of the host's breaker. Once the cooldown of an open breaker
+// has passed, it becomes half-open and allows a single probe.
+func (h *hostBreakers) allow(host string, now time.Time) (breakerState, bool) {
+	settings := meow.CurrentSettings()
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	b, ok := h.breakers[host]
+	if !ok || settings.BreakerThreshold == 0 {
+		return breakerClosed, true
 	}
-	for logMessage := range messages {
-		fmt.Fprintln(os.Stderr, logMessage)
-		logger.WriteLine(logMessage)
+	switch b.state {
+	case breakerOpen:
+		if now.Sub(b.openedAt) < settings.BreakerCooldown {
+			return b.state, false
+		}
+		b.state, b.testing = breakerHalfOpen, true
+		return b.state, true
+	case breakerHalfOpen:
+		if b.testing {
+			return b.state, false
+		}
+		b.testing = true
+		return b.state, true
 	}
+	return b.state, true
 }
 
-func requestForStatus(e meow.Endpoint) (int, error) {
-	req, err := http.NewRequest(e.Method, e.URL.String(), nil)
+// record records whether or not a probe of an endpoint of host reached it at
+// now, and returns the state of the host's breaker before and after.
+func (h *hostBreakers) record(host string, reached bool, now time.Time) (breakerState, breakerState) {
+	threshold := meow.CurrentSettings().BreakerThreshold
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	b, ok := h.breakers[host]
+	if !ok {
+		b = &hostBreaker{state: breakerClosed}
+		h.breakers[host] = b
+	}
+	previous := b.state
+	b.testing = false
+	switch {
+	case threshold == 0 || reached:
+		b.state, b.failures = breakerClosed, 0
+	case b.state == breakerHalfOpen:
+		b.state, b.openedAt = breakerOpen, now
+	default:
+		b.failures++
+		if b.failures >= threshold {
+			b.state, b.openedAt = breakerOpen, now
+		}
+	}
+	return previous, b.state
+}
+
+// maxCachedClients is the maximum number of HTTP clients shared between
+// endpoints. Endpoints not fitting into the cache get a dedicated client.
+const maxCachedClients = 32
+
+// fetchExpectedBuild returns the build expected for the endpoint identified by
+// identifier, or an empty string, if none is expected.
+func fetchExpectedBuild(client valkey.Client, identifier string) (string, error) {
+	ctx := context.Background()
+	key := meow.ExpectedBuildKey(identifier)
+	build, err := client.Do(ctx, client.B().Get().Key(key).Build()).ToString()
+	if valkey.IsValkeyNil(err) {
+		return "", nil
+	}
 	if err != nil {
-		return 0, fmt.Errorf("prepare request: %s %s %s: %v", e.Identifier, e.Method, e.URL, err)
+		return "", fmt.Errorf("get %s: %v", key, err)
 	}
-	res, err := http.DefaultClient.Do(req)
+	return build, nil
+}
+
+// statusSnapshot holds the parts of an endpoint's status that are considered
+// meaningful changes when writing the status on change only.
+type statusSnapshot struct {
+	state         meow.State
+	status        int
+	failureKind   string
+	failures      int
+	latencyBucket int
+	build         string
+}
+
+// latencyBuckets are the upper bounds of the latency buckets.
+var latencyBuckets = []time.Duration{
+	100 * time.Millisecond,
+	250 * time.Millisecond,
+	500 * time.Millisecond,
+	time.Second,
+	2500 * time.Millisecond,
+	5 * time.Second,
+}
+
+// latencyBucket returns the index of the bucket latency falls into.
+func latencyBucket(latency time.Duration) int {
+	for i, bound := range latencyBuckets {
+		if latency < bound {
+			return i
+		}
+	}
+	return len(latencyBuckets)
+}
+
+// writeStatus persists the status fields and the schedule (field/value pairs)
+// of the endpoint identified by identifier, or only its schedule, if the status
+// is written on change only and snapshot equals the one written before. It
+// returns the snapshot written, or nil, if persisting failed.
+func writeStatus(client valkey.Client, identifier string, written *statusSnapshot, snapshot statusSnapshot,
+	schedule, status []string) (*statusSnapshot, error) {
+	if meow.CurrentSettings().StatusWriteOnChange && written != nil && *written == snapshot {
+		// nothing meaningful changed: only update the schedule
+		if err := persistStatus(client, identifier, schedule...); err != nil {
+			return nil, err
+		}
+		return written, nil
+	}
+	if err := persistStatus(client, identifier, append(status, schedule...)...); err != nil {
+		return nil, err
+	}
+	return &snapshot, nil
+}
+
+// persistStatus stores the given field/value pairs in the status hash of the
+// endpoint identified by identifier, which is used to expose the probe's state
+// (e.g. its schedule) through the config server.
+func persistStatus(client valkey.Client, identifier string, fieldValues ...string) error {
+	ctx := context.Background()
+	key := meow.StatusKey(identifier)
+	args := append([]string{key}, fieldValues...)
+	err := client.Do(ctx, client.B().Arbitrary("HSET").Args(args...).Build()).Error()
 	if err != nil {
-		return 0, fmt.Errorf("perform request %s %s %s: %v", e.Identifier, e.Method, e.URL, err)
+		return fmt.Errorf("hset %s: %v", key, err)
 	}
-	defer res.Body.Close()
-	return res.StatusCode, nil
+	return nil
 }
 
-func mustFetchEndpoints(configURL string) []meow.Endpoint {
+// countCheck increments the number of checks (and failures, if failed) in the
+// status of the endpoint identified by identifier, which are exported as
+// Prometheus counters by the config server.
+func countCheck(client valkey.Client, identifier string, failed bool) error {
+	ctx := context.Background()
+	key := meow.StatusKey(identifier)
+	cmds := valkey.Commands{client.B().Hincrby().Key(key).Field("checks").Increment(1).Build()}
+	if failed {
+		cmds = append(cmds, client.B().Hincrby().Key(key).Field("failures").Increment(1).Build())
+	}
+	for _, result := range client.DoMulti(ctx, cmds...) {
+		if err := result.Error(); err != nil {
+			return fmt.Errorf("hincrby %s: %v", key, err)
+		}
+	}
+	return nil
+}
+
+// allowNotification counts a notification about the endpoint identified by
+// identifier at now in its status, and indicates whether or not the notify
+// limit of the current window allows sending it. Suppressed notifications are
+// not counted, and a new window starts with the first notification after the
+// previous window has passed.
+func allowNotification(client valkey.Client, identifier string, now time.Time) (bool, error) {
+	settings := meow.CurrentSettings()
+	if settings.NotifyLimit <= 0 {
+		return true, nil
+	}
+	ctx := context.Background()
+	key := meow.StatusKey(identifier)
+	values, err := client.Do(ctx, client.B().Hmget().Key(key).
+		Field("notifications", "notifications_since").Build()).ToArray()
+	if err != nil {
+		return false, fmt.Errorf("hmget %s notifications notifications_since: %v", key, err)
+	}
+	rawCount, _ := values[0].ToString()
+	rawSince, _ := values[1].ToString()
+	count, _ := strconv.Atoi(rawCount)
+	since, err := time.Parse(time.RFC3339Nano, rawSince)
+	if err != nil || now.Sub(since) >= settings.NotifyWindow {
+		count, since = 0, now
+	}
+	if count >= settings.NotifyLimit {
+		return false, nil
+	}
+	err = persistStatus(client, identifier,
+		"notifications", strconv.Itoa(count+1),
+		"notifications_since", since.Format(time.RFC3339Nano))
+	return err == nil, err
+}
+
+// appendHistory prepends the entry to the history of the endpoint identified by
+// identifier, which is trimmed to the configured history size.
+func appendHistory(client valkey.Client, identifier string, entry meow.HistoryEntry) error {
+	ctx := context.Background()
+	key := meow.HistoryKey(identifier)
+	data, err := entry.JSON()
+	if err != nil {
+		return err
+	}
+	size := meow.CurrentSettings().HistorySize
+	results := client.DoMulti(ctx,
+		client.B().Lpush().Key(key).Element(string(data)).Build(),
+		client.B().Ltrim().Key(key).Start(0).Stop(int64(size-1)).Build())
+	for _, result := range results {
+		if err := result.Error(); err != nil {
+			return fmt.Errorf("append to %s: %v", key, err)
+		}
+	}
+	return nil
+}
+
+// appendLatency adds the latency of the entry to the time series of the
+// endpoint identified by identifier, which is created retaining the latencies
+// for retention.
+func appendLatency(client valkey.Client, identifier string, entry meow.HistoryEntry,
+	retention time.Duration) error {
+	ctx := context.Background()
+	key := meow.LatencyKey(identifier)
+	timestamp := strconv.FormatInt(entry.Timestamp.UnixMilli(), 10)
+	err := client.Do(ctx, client.B().TsAdd().Key(key).Timestamp(timestamp).Value(float64(entry.LatencyMS)).
+		Retention(retention.Milliseconds()).OnDuplicateLast().Build()).Error()
+	if err != nil {
+		return fmt.Errorf("ts.add %s: %v", key, err)
+	}
+	return nil
+}
+
+// recordIncident stores the incident of the endpoint identified by identifier,
+// and removes incidents that started longer than retention ago.
+func recordIncident(client valkey.Client, identifier string, incident meow.Incident,
+	retention time.Duration) error {
+	ctx := context.Background()
+	key := meow.IncidentKey(identifier, incident.Start)
+	indexKey := meow.IncidentIndexKey(identifier)
+	expired := time.Now().Add(-retention).UnixMilli()
+	results := client.DoMulti(ctx,
+		client.B().Arbitrary("HSET", key,
+			"start", incident.Start.Format(time.RFC3339Nano),
+			"end", incident.End.Format(time.RFC3339Nano),
+			"max_failures", strconv.Itoa(incident.MaxFailures)).Build(),
+		client.B().Arbitrary("PEXPIREAT", key).
+			Args(strconv.FormatInt(incident.Start.Add(retention).UnixMilli(), 10)).Build(),
+		client.B().Arbitrary("ZADD", indexKey).
+			Args(strconv.FormatInt(incident.Start.UnixMilli(), 10), key).Build(),
+		client.B().Arbitrary("ZREMRANGEBYSCORE", indexKey).
+			Args("-inf", "("+strconv.FormatInt(expired, 10)).Build())
+	for _, result := range results {
+		if err := result.Error(); err != nil {
+			return fmt.Errorf("store incident %s: %v", key, err)
+		}
+	}
+	return nil
+}
+
+// endpointPageLimit is the number of endpoints fetched per page, which is the
+// most the config server lists at once.
+const endpointPageLimit = 1000
+
+// fetchEndpoints fetches the endpoints currently configured from the config
+// server, page by page.
+func fetchEndpoints(configURL string) ([]meow.Endpoint, error) {
 	endpoints := make([]meow.Endpoint, 0)
-	configEndpoint := fmt.Sprintf("%s/endpoints", configURL)
-	res, err := http.Get(configEndpoint)
+	seen := make(map[string]bool)
+	cursor := ""
+	for {
+		query := url.Values{"limit": {strconv.Itoa(endpointPageLimit)}}
+		if cursor != "" {
+			query.Set("cursor", cursor)
+		}
+		configEndpoint := fmt.Sprintf("%s/endpoints?%s", configURL, query.Encode())
+		data, header, status, err := fetchConfig(configEndpoint)
+		if err != nil {
+			return nil, err
+		}
+		if status != http.StatusOK {
+			return nil, fmt.Errorf("fetch endpoints from %s: status %d", configEndpoint, status)
+		}
+		payloads := make([]meow.EndpointPayload, 0)
+		if err := json.Unmarshal(data, &payloads); err != nil {
+			return nil, fmt.Errorf("unmarshal JSON payload: %v", err)
+		}
+		for _, payload := range payloads {
+			if seen[payload.Identifier] {
+				// listed on an earlier page already
+				continue
+			}
+			seen[payload.Identifier] = true
+			endpoint, err := meow.EndpointFromPayload(payload)
+			if err != nil {
+				return nil, fmt.Errorf("convert payload of %s to endpoint: %v", payload.Identifier, err)
+			}
+			endpoints = append(endpoints, *endpoint)
+		}
+		if cursor = header.Get("X-Next-Cursor"); cursor == "" {
+			return endpoints, nil
+		}
+	}
+}
+
+// fetchEndpoint fetches the endpoint identified by identifier from the config
+// server, or returns nil, if there is no such endpoint to be probed.
+func fetchEndpoint(configURL, identifier string) (*meow.Endpoint, error) {
+	configEndpoint := fmt.Sprintf("%s/endpoints/%s", configURL, url.PathEscape(identifier))
+	data, _, status, err := fetchConfig(configEndpoint)
+	if err != nil {
+		return nil, err
+	}
+	switch status {
+	case http.StatusOK:
+	case http.StatusNotFound, http.StatusForbidden:
+		// deleted, or owned by someone else
+		return nil, nil
+	default:
+		return nil, fmt.Errorf("fetch endpoint from %s: status %d", configEndpoint, status)
+	}
+	endpoint, err := meow.EndpointFromJSON(string(data))
+	if err != nil {
+		return nil, fmt.Errorf("parse endpoint %s: %v", identifier, err)
+	}
+	return endpoint, nil
+}
+
+// fetchConfig performs a GET request against configEndpoint, and returns the
+// response body, headers, and status.
+func fetchConfig(configEndpoint string) ([]byte, http.Header, int, error) {
+	req, err := http.NewRequest(http.MethodGet, configEndpoint, nil)
 	if err != nil {
-		log.Fatalf("fetch endpoints from %s: %v", configEndpoint, err)
+		return nil, nil, 0, fmt.Errorf("prepare request to %s: %v", configEndpoint, err)
+	}
+	if token := os.Getenv("CONFIG_TOKEN"); token != "" {
+		// required if the config server scopes endpoints by owners
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	if key := os.Getenv("CONFIG_API_KEY"); key != "" {
+		// required if the config server requires an API key for reading
+		req.Header.Set("X-Api-Key", key)
+	}
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, nil, 0, fmt.Errorf("fetch %s: %v", configEndpoint, err)
 	}
 	defer res.Body.Close()
-	payloads := make([]meow.EndpointPayload, 0)
 	buf := bytes.NewBufferString("")
 	if _, err := io.Copy(buf, res.Body); err != nil {
-		log.Fatalf("copy body from result of %s: %v", configEndpoint, err)
+		return nil, nil, 0, fmt.Errorf("copy body from result of %s: %v", configEndpoint, err)
 	}
-	if err := json.Unmarshal(buf.Bytes(), &payloads); err != nil {
-		log.Fatalf("unmarshal JSON payload: %v", err)
-	}
-	for _, payload := range payloads {
-		endpoint, err := meow.EndpointFromPayload(payload)
+	return buf.Bytes(), res.Header, res.StatusCode, nil
+}
+
+// endpointChanges are the endpoints created or updated, and the identifiers of
+// the endpoints deleted. A full reload contains all the endpoints, so that the
+// probes of endpoints not contained are stopped.
+type endpointChanges struct {
+	updated []meow.Endpoint
+	deleted []string
+	full    bool
+}
+
+// endpointKeyspacePattern matches the channels of the keyspace notifications
+// concerning endpoints, whose key follows the last "__:".
+const endpointKeyspacePattern = "__keyspace@*__:endpoint:*"
+
+// endpointReloadInterval is how often all the endpoints are reloaded if
+// keyspace notifications are not enabled, and resubscribeDelay how long to wait
+// before subscribing again after losing the connection.
+const (
+	endpointReloadInterval = time.Minute
+	resubscribeDelay       = 5 * time.Second
+)
+
+// watchEndpoints announces the endpoints changed on changes: incrementally, as
+// keyspace notifications of endpoints arrive, or by reloading all endpoints
+// periodically, if Valkey does not notify about changes of endpoints.
+func watchEndpoints(client valkey.Client, configURL string, changes chan<- endpointChanges) {
+	reload := func() {
+		endpoints, err := fetchEndpoints(configURL)
 		if err != nil {
-			log.Fatalf("convert payload %v to endpoint: %v", payload, err)
+			fmt.Fprintf(os.Stderr, "reload endpoints: %v\n", err)
+			return
+		}
+		changes <- endpointChanges{updated: endpoints, full: true}
+	}
+	if err := checkKeyspaceNotifications(client); err != nil {
+		fmt.Fprintf(os.Stderr, "%v: reloading endpoints every %v\n", err, endpointReloadInterval)
+		for range time.Tick(endpointReloadInterval) {
+			reload()
+		}
+	}
+	// the notifications are handled one by one, without blocking the receipt
+	identifiers := make(chan string, notificationBufferSize)
+	go func() {
+		for identifier := range identifiers {
+			endpoint, err := fetchEndpoint(configURL, identifier)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "fetch changed endpoint: %v\n", err)
+				continue
+			}
+			if endpoint == nil {
+				changes <- endpointChanges{deleted: []string{identifier}}
+			} else {
+				changes <- endpointChanges{updated: []meow.Endpoint{*endpoint}}
+			}
 		}
-		endpoints = append(endpoints, *endpoint)
+	}()
+	ctx := context.Background()
+	subscribe := client.B().Psubscribe().Pattern(endpointKeyspacePattern).Build()
+	for {
+		err := client.Receive(ctx, subscribe, func(msg valkey.PubSubMessage) {
+			key := msg.Channel[strings.LastIndex(msg.Channel, "__:")+len("__:"):]
+			identifiers <- strings.TrimPrefix(key, "endpoint:")
+		})
+		fmt.Fprintf(os.Stderr, "receive keyspace notifications: %v\n", err)
+		time.Sleep(resubscribeDelay)
+		// changes may have been missed while disconnected
+		reload()
+	}
+}
+
+// notificationBufferSize is the number of keyspace notifications buffered
+// while the endpoints concerned are being fetched.
+const notificationBufferSize = 100
+
+// checkKeyspaceNotifications returns an error, unless Valkey is configured to
+// notify about changes of hashes (h) and removals of keys (g) on the keyspace
+// channels (K).
+func checkKeyspaceNotifications(client valkey.Client) error {
+	ctx := context.Background()
+	config, err := client.Do(ctx, client.B().ConfigGet().Parameter("notify-keyspace-events").Build()).AsStrMap()
+	if err != nil {
+		return fmt.Errorf("get notify-keyspace-events: %v", err)
+	}
+	flags := config["notify-keyspace-events"]
+	all := strings.Contains(flags, "A")
+	if !strings.Contains(flags, "K") || !(all || strings.Contains(flags, "g") && strings.Contains(flags, "h")) {
+		return fmt.Errorf(`notify-keyspace-events "%s" lacks the flags "Kgh"`, flags)
 	}
-	return endpoints
+	return nil
 }
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/patrickbucher/meow"
+	"github.com/patrickbucher/meow/internal/valkeytest"
+)
+
+func TestWriteStatusOnChange(t *testing.T) {
+	defer meow.ApplySettings(meow.CurrentSettings())
+	tests := []struct {
+		name          string
+		writeOnChange bool
+		latencies     []time.Duration
+		expected      string
+	}{
+		{"unchanged", true, []time.Duration{10 * time.Millisecond, 20 * time.Millisecond}, "10ms"},
+		{"other latency bucket", true, []time.Duration{10 * time.Millisecond, 300 * time.Millisecond}, "300ms"},
+		{"always written", false, []time.Duration{10 * time.Millisecond, 20 * time.Millisecond}, "20ms"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			settings := meow.DefaultSettings()
+			settings.StatusWriteOnChange = test.writeOnChange
+			meow.ApplySettings(settings)
+			client := valkeytest.NewClient(t)
+			var written *statusSnapshot
+			var lastProbed string
+			for i, latency := range test.latencies {
+				lastProbed = time.Date(2026, 10, 14, 12, i, 0, 0, time.UTC).Format(time.RFC3339Nano)
+				snapshot := statusSnapshot{meow.StateOnline, 200, "", 0, latencyBucket(latency), ""}
+				var err error
+				written, err = writeStatus(client, "libvirt", written, snapshot,
+					[]string{"last_probed", lastProbed},
+					[]string{"state", string(meow.StateOnline), "latency", latency.String()})
+				if err != nil || written == nil || *written != snapshot {
+					t.Fatalf("expected snapshot %+v to be written, got %+v (%v)", snapshot, written, err)
+				}
+			}
+			status, err := client.Do(context.Background(),
+				client.B().Hgetall().Key(meow.StatusKey("libvirt")).Build()).AsStrMap()
+			if err != nil {
+				t.Fatalf("get status: %v", err)
+			}
+			if status["latency"] != test.expected || status["last_probed"] != lastProbed {
+				t.Errorf("expected latency %s last probed at %s, got %s at %s",
+					test.expected, lastProbed, status["latency"], status["last_probed"])
+			}
+		})
+	}
+}
+
+func TestFetchEndpointsPages(t *testing.T) {
+	const n = 2*endpointPageLimit + 500
+	var pages int
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		pages++
+		limit, err := strconv.Atoi(r.URL.Query().Get("limit"))
+		if err != nil {
+			t.Errorf("parse limit: %v", err)
+		}
+		start := 0
+		if cursor := r.URL.Query().Get("cursor"); cursor != "" {
+			// the first endpoint of the next page is listed again, as may
+			// happen while scanning
+			if start, err = strconv.Atoi(cursor); err != nil {
+				t.Errorf("parse cursor: %v", err)
+			}
+		}
+		end := min(start+limit, n)
+		if end < n {
+			w.Header().Set("X-Next-Cursor", strconv.Itoa(end-1))
+		}
+		payloads := make([]meow.EndpointPayload, 0, end-start)
+		for i := start; i < end; i++ {
+			payloads = append(payloads, meow.EndpointPayload{Identifier: fmt.Sprintf("endpoint-%04d", i),
+				URL: "https://example.com", Method: "GET", StatusOnline: 200, Frequency: "1m"})
+		}
+		data, err := json.Marshal(payloads)
+		if err != nil {
+			t.Errorf("marshal endpoints: %v", err)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(data)
+	}))
+	defer server.Close()
+	endpoints, err := fetchEndpoints(server.URL)
+	if err != nil {
+		t.Fatalf("fetch endpoints: %v", err)
+	}
+	if pages != 3 {
+		t.Errorf("expected 3 pages to be fetched, got %d", pages)
+	}
+	if len(endpoints) != n || endpoints[n-1].Identifier != fmt.Sprintf("endpoint-%04d", n-1) {
+		t.Errorf("expected %d endpoints, each once, got %d", n, len(endpoints))
+	}
+}
+
+func TestSchedulerDue(t *testing.T) {
+	start := time.Date(2026, 10, 14, 12, 0, 0, 0, time.UTC)
+	s := newScheduler()
+	s.add("beta", 10*time.Second, start)
+	s.add("gamma", 5*time.Second, start)
+	s.add("alpha", 10*time.Second, start)
+	removed := s.add("delta", 5*time.Second, start)
+	s.remove(removed)
+	tests := []struct {
+		at       time.Duration
+		expected []string
+	}{
+		{0, []string{"alpha", "beta", "gamma"}},
+		{4 * time.Second, nil},
+		{5 * time.Second, []string{"gamma"}},
+		{10 * time.Second, []string{"alpha", "beta", "gamma"}},
+		// overdue several times, but released once
+		{32 * time.Second, []string{"gamma", "alpha", "beta"}},
+		{35 * time.Second, []string{"gamma"}},
+		{40 * time.Second, []string{"alpha", "beta", "gamma"}},
+	}
+	for _, test := range tests {
+		var identifiers []string
+		for _, p := range s.due(start.Add(test.at)) {
+			identifiers = append(identifiers, p.identifier)
+		}
+		if !slices.Equal(identifiers, test.expected) {
+			t.Errorf("due after %v: expected %v, got %v", test.at, test.expected, identifiers)
+		}
+	}
+}
+
+func TestSchedulerRun(t *testing.T) {
+	s := newScheduler()
+	go s.run()
+	p := s.add("frickelbude", 10*time.Millisecond, time.Now())
+	for i := range 3 {
+		select {
+		case <-p.ready:
+		case <-time.After(time.Second):
+			t.Fatalf("probe %d not released", i+1)
+		}
+	}
+}
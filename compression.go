@@ -0,0 +1,55 @@
+package meow
+
+import (
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// AcceptedEncodings are the content encodings accepted by the probes of
+// endpoints expecting valid compression, i.e. the ones that can be decoded
+// using the standard library (which lacks br).
+const AcceptedEncodings = "gzip, deflate"
+
+// maxDecodedSize is the maximum number of decompressed bytes verified, so that
+// a compression bomb cannot keep the probe busy.
+const maxDecodedSize = 64 * MaxBodySize
+
+// DecodeBody decompresses body according to its content encoding, and returns
+// up to MaxBodySize bytes of the decoded body, and whether it was longer. An
+// error is returned if the body does not decompress, or if the encoding is not
+// supported. If truncated is set, body is only the beginning of the response
+// body, which therefore may end prematurely.
+func DecodeBody(encoding string, body []byte, truncated bool) ([]byte, bool, error) {
+	var reader io.Reader
+	var err error
+	switch strings.ToLower(strings.TrimSpace(encoding)) {
+	case "", "identity":
+		return body, truncated, nil
+	case "gzip", "x-gzip":
+		reader, err = gzip.NewReader(bytes.NewReader(body))
+	case "deflate":
+		reader, err = zlib.NewReader(bytes.NewReader(body))
+	default:
+		return nil, false, fmt.Errorf(`content encoding "%s" is not supported`, encoding)
+	}
+	if err != nil {
+		return nil, false, fmt.Errorf("decompress %s body: %v", encoding, err)
+	}
+	decoded, err := io.ReadAll(io.LimitReader(reader, MaxBodySize+1))
+	if err == nil && len(decoded) > MaxBodySize {
+		// verify the rest without retaining it
+		_, err = io.Copy(io.Discard, io.LimitReader(reader, maxDecodedSize))
+	}
+	if err != nil && !(truncated && errors.Is(err, io.ErrUnexpectedEOF)) {
+		return nil, false, fmt.Errorf("decompress %s body: %v", encoding, err)
+	}
+	if len(decoded) > MaxBodySize {
+		return decoded[:MaxBodySize], true, nil
+	}
+	return decoded, truncated, nil
+}
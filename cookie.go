@@ -0,0 +1,66 @@
+package meow
+
+import (
+	"fmt"
+	"net/http"
+)
+
+// CookieExpectation describes a cookie an endpoint must set in its response
+// using the Set-Cookie header. Attributes left at their zero value are not
+// checked.
+type CookieExpectation struct {
+	// Name is the name of the cookie.
+	Name string `json:"name"`
+
+	// Secure and HTTPOnly require the according attributes to be set.
+	Secure   bool `json:"secure,omitempty"`
+	HTTPOnly bool `json:"http_only,omitempty"`
+
+	// SameSite is the required value of the SameSite attribute: "lax",
+	// "strict", or "none".
+	SameSite string `json:"same_site,omitempty"`
+}
+
+// sameSiteModes maps the values of the SameSite attribute to their mode.
+var sameSiteModes = map[string]http.SameSite{
+	"lax":    http.SameSiteLaxMode,
+	"strict": http.SameSiteStrictMode,
+	"none":   http.SameSiteNoneMode,
+}
+
+// validate returns an error, if the expectation has no valid cookie name or an
+// unknown SameSite value.
+func (c CookieExpectation) validate() error {
+	if !headerNamePattern.MatchString(c.Name) {
+		return fmt.Errorf(`"%s" is not a valid cookie name`, c.Name)
+	}
+	if _, ok := sameSiteModes[c.SameSite]; c.SameSite != "" && !ok {
+		return fmt.Errorf(`same_site "%s" must be lax, strict, or none`, c.SameSite)
+	}
+	return nil
+}
+
+// Check looks up the expected cookie among the cookies set by the response
+// headers header, and returns an error, if it is missing or lacks one of the
+// required attributes. The cookie found is returned as a Set-Cookie value with
+// its value redacted, or an empty string, if it is missing.
+func (c CookieExpectation) Check(header http.Header) (string, error) {
+	for _, line := range header.Values("Set-Cookie") {
+		cookie, err := http.ParseSetCookie(line)
+		if err != nil || cookie.Name != c.Name {
+			continue
+		}
+		cookie.Value = Redacted
+		observed := cookie.String()
+		switch {
+		case c.Secure && !cookie.Secure:
+			return observed, fmt.Errorf("cookie %s is not Secure", c.Name)
+		case c.HTTPOnly && !cookie.HttpOnly:
+			return observed, fmt.Errorf("cookie %s is not HttpOnly", c.Name)
+		case c.SameSite != "" && cookie.SameSite != sameSiteModes[c.SameSite]:
+			return observed, fmt.Errorf("cookie %s is not SameSite=%s", c.Name, c.SameSite)
+		}
+		return observed, nil
+	}
+	return "", fmt.Errorf("cookie %s is not set", c.Name)
+}
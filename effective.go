@@ -0,0 +1,90 @@
+package meow
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+)
+
+// EffectivePayload is the configuration an endpoint is actually probed with:
+// its payload with the settings in effect applied, and with secrets redacted.
+// Its fields take precedence over the ones of the EndpointPayload embedded.
+type EffectivePayload struct {
+	EndpointPayload
+
+	// URL and Proxy have their passwords redacted. Proxy is empty if the
+	// proxy configured in the probe's environment is used.
+	URL                  string `json:"url"`
+	Proxy                string `json:"proxy,omitempty"`
+	ProxyFromEnvironment bool   `json:"proxy_from_environment"`
+
+	// FailAfter, Timeout, ConcurrentProbes, and Protocol are the values
+	// applied if the endpoint does not configure them.
+	FailAfter        uint8  `json:"fail_after"`
+	Timeout          string `json:"timeout"`
+	ConcurrentProbes uint8  `json:"concurrent_probes"`
+	Protocol         string `json:"protocol"`
+
+	// Headers are the default headers merged with those of the endpoint,
+	// with the values of the redacted headers replaced.
+	Headers map[string]string `json:"headers,omitempty"`
+
+	// RetryTransportErrors, NXDomainAsConfigError, BreakerThreshold, and
+	// BreakerCooldown are the settings applied to the probes of all
+	// endpoints.
+	RetryTransportErrors  bool   `json:"retry_transport_errors"`
+	NXDomainAsConfigError bool   `json:"nxdomain_as_config_error"`
+	BreakerThreshold      int    `json:"breaker_threshold"`
+	BreakerCooldown       string `json:"breaker_cooldown"`
+}
+
+// Effective resolves the configuration the endpoint is probed with according
+// to the settings given.
+func (e Endpoint) Effective(settings Settings) EffectivePayload {
+	effective := EffectivePayload{
+		EndpointPayload:      e.ToPayload(),
+		URL:                  e.URL.Redacted(),
+		ProxyFromEnvironment: e.Proxy == nil,
+		FailAfter:            max(e.FailAfter, 1),
+		Timeout:              e.ProbeTimeout().String(),
+		ConcurrentProbes:     max(e.ConcurrentProbes, 1),
+		Protocol:             e.Protocol,
+
+		RetryTransportErrors:  settings.RetryTransportErrors,
+		NXDomainAsConfigError: settings.NXDomainAsConfigError,
+		BreakerThreshold:      settings.BreakerThreshold,
+		BreakerCooldown:       settings.BreakerCooldown.String(),
+	}
+	if e.Proxy != nil {
+		effective.Proxy = e.Proxy.Redacted()
+	}
+	if effective.Protocol == "" {
+		effective.Protocol = ProtocolHTTP
+	}
+	headers := make(map[string]string)
+	for _, source := range []map[string]string{settings.DefaultHeaders, e.Headers} {
+		for name, value := range source {
+			if slices.ContainsFunc(settings.RedactHeaders, func(r string) bool { return strings.EqualFold(r, name) }) {
+				value = Redacted
+			}
+			// as set on the request, where the endpoint's headers take precedence
+			headers[http.CanonicalHeaderKey(name)] = value
+		}
+	}
+	if len(headers) > 0 {
+		effective.Headers = headers
+	}
+	return effective
+}
+
+// JSON returns the EffectivePayload as JSON data, or an error, if it cannot be
+// serialized.
+func (p EffectivePayload) JSON() ([]byte, error) {
+	data, err := json.Marshal(p)
+	if err != nil {
+		return nil, fmt.Errorf("marshal effective configuration of %s as JSON: %v", p.Identifier, err)
+	}
+	return data, nil
+}
@@ -1,13 +1,19 @@
 package meow
 
 import (
+	"bytes"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"net"
 	"net/http"
 	"net/url"
+	"reflect"
 	"regexp"
 	"strconv"
+	"strings"
 	"time"
+	"unicode"
 )
 
 // Endpoint is something to monitor with according rules.
@@ -30,6 +36,178 @@ type Endpoint struct {
 	// FailAfter is the number of failed requests after which the endpoint is
 	// considered to be offline.
 	FailAfter uint8
+
+	// MaintenanceWindows are the recurring periods during which the endpoint
+	// is under maintenance, i.e. probed without alerting.
+	MaintenanceWindows []MaintenanceWindow
+
+	// KeepConnectionsOnFailure indicates that the probe following a failed
+	// one reuses pooled connections, rather than starting with fresh
+	// connections of its own.
+	KeepConnectionsOnFailure bool
+
+	// MaxTTFB is the maximum time to the first byte of the response, after
+	// which the endpoint is considered degraded, even if the status is as
+	// expected. It is not checked if zero.
+	MaxTTFB time.Duration
+
+	// ResponseSchema is the JSON Schema the response body must conform to. It
+	// is not checked if nil.
+	ResponseSchema *Schema
+
+	// ExpectTrailer is the name of an HTTP trailer the response must provide.
+	// It is not checked if empty.
+	ExpectTrailer string
+
+	// ExpectTrailerValue is the value the trailer ExpectTrailer must have. If
+	// empty, the trailer only needs to be present.
+	ExpectTrailerValue string
+
+	// ExtractRegex captures a value (its first capture group) from the
+	// response body, which is sent in the header ExtractHeader of the next
+	// probe's request. It is not applied if nil.
+	ExtractRegex *regexp.Regexp
+
+	// ExtractHeader is the name of the request header carrying the value
+	// captured by ExtractRegex from the previous response.
+	ExtractHeader string
+
+	// HostHeader overrides the Host header of the probe request (e.g. to probe
+	// a virtual host on a server addressed by its IP), unless it is empty.
+	HostHeader string
+
+	// ActiveHours restricts probing and alerting to certain hours, unless it
+	// is nil.
+	ActiveHours *ActiveHours
+
+	// ExpectBodyHash is the hex-encoded SHA-256 hash the response body must
+	// have, unless it is empty.
+	ExpectBodyHash string
+
+	// CaptureHeaderNames are the names of the response headers whose values
+	// are captured into the endpoint's status for debugging.
+	CaptureHeaderNames []string
+
+	// FastFailOnRefused considers the endpoint offline after the first
+	// refused connection, regardless of FailAfter, since a closed port is
+	// unambiguous.
+	FastFailOnRefused bool
+
+	// Timeout bounds the whole probe, including its check paths, and is below
+	// the Frequency. If it is 0, the probe is bounded by DefaultTimeout, or
+	// nine tenths of the Frequency, if shorter.
+	Timeout time.Duration
+
+	// CheckPaths are further paths (relative to the URL) that are requested
+	// concurrently with the URL, and must respond with StatusOnline, too.
+	CheckPaths []string
+
+	// Owner is the user or team owning the endpoint, who is allowed to see
+	// and modify it if the API is scoped by owners. It may be empty.
+	Owner string
+
+	// StabilityWindow and StabilityThreshold require the endpoint to succeed
+	// in at least StabilityThreshold of its last StabilityWindow probes in
+	// order to be considered online, rather than failing FailAfter times in a
+	// row to be considered offline. Both are 0 unless configured.
+	StabilityWindow    uint8
+	StabilityThreshold uint8
+
+	// InsecureSkipVerify disables the verification of the endpoint's TLS
+	// certificate (e.g. for self-signed certificates).
+	InsecureSkipVerify bool
+
+	// Proxy is the URL of the proxy the endpoint is requested through, or nil
+	// for the proxy configured in the environment (if any).
+	Proxy *url.URL
+
+	// ExpectBuildHeader is the name of the response header reporting the
+	// build (or version) deployed, which must match the build expected for
+	// the endpoint, if any (e.g. during a deploy).
+	ExpectBuildHeader string
+
+	// ExpectSetCookie is a cookie the response must set, unless it is nil.
+	ExpectSetCookie *CookieExpectation
+
+	// Tags group the endpoint with others (e.g. by service), so that their
+	// uptime can be reported together.
+	Tags []string
+
+	// BodySource is the source of the request body resolved with every
+	// probe, or nil, if no body is sent.
+	BodySource *BodySource
+
+	// ExpectJSONPath is a comparison of a numeric field of the JSON response
+	// body with a threshold, which must be satisfied, unless it is nil.
+	ExpectJSONPath *JSONPathAssertion
+
+	// Version is incremented with every write of the endpoint, and UpdatedBy
+	// is the caller who wrote it last. Both are maintained by the config
+	// server, so that concurrent updates overwriting each other can be
+	// detected.
+	Version   uint64
+	UpdatedBy string
+
+	// Headers are set on the requests of the probes, taking precedence over
+	// the default headers of the settings.
+	Headers map[string]string
+
+	// ExpectValidCompression requires the response body to decompress
+	// without errors according to its Content-Encoding (gzip or deflate).
+	ExpectValidCompression bool
+
+	// Protocol is the protocol the endpoint is probed with: plain HTTP (if
+	// empty), or grpc-web, in which case an empty (or the body source's)
+	// message is sent, and the grpc-status must be OK.
+	Protocol string
+
+	// ConcurrentProbes is the number of requests issued at once with every
+	// probe, all of which must succeed, so that an endpoint accepting only
+	// a single connection is considered degraded. Up to one request is
+	// issued if it is 0.
+	ConcurrentProbes uint8
+
+	// ExpectStatusText is a substring the reason phrase of the response's
+	// status line (e.g. "OK" of "200 OK") must contain, in order to detect
+	// responses injected by intermediaries with the expected status code.
+	ExpectStatusText string
+
+	// PinnedCertSHA256 is the hex-encoded SHA-256 fingerprint the endpoint's
+	// TLS certificate must have, which is verified during the handshake in
+	// order to detect unexpected certificate changes.
+	PinnedCertSHA256 string
+
+	// BodyContains is a substring the response body must contain, so that an
+	// endpoint responding with the expected status while broken is detected.
+	// Only the first MaxBodySize bytes of the body are searched.
+	BodyContains string
+
+	// BodyNotContains is a substring the response body must not contain, so
+	// that error pages served with the expected status are detected. Only the
+	// first MaxBodySize bytes of the body are searched.
+	BodyNotContains string
+
+	// RedirectCountsAs is the state a redirect (a 3xx status other than
+	// StatusOnline) counts as: StateOnline, StateDegraded, or StateOffline.
+	// Redirects are not followed then, but reported as StateRedirect along
+	// with their target. If empty, redirects are followed.
+	RedirectCountsAs State
+
+	// Resolver is the address (host:port) of the DNS server the endpoint's
+	// host is resolved with instead of the system's resolver, e.g. for hosts
+	// only resolved correctly by an internal server (split-horizon DNS).
+	Resolver string
+
+	// ConnectTarget is the address (host:port) the endpoint, a forward proxy or
+	// a tunnel, is asked to open a tunnel to by a CONNECT request, which is
+	// the method the endpoint must be probed with then. The tunnel is closed
+	// unused once the endpoint responded.
+	ConnectTarget string
+
+	// DialTimeout bounds resolving the endpoint's host and connecting to it,
+	// so that a slow resolver cannot use up the whole Timeout of a probe. If
+	// it is 0, dialing is only bounded by the probe's timeout.
+	DialTimeout time.Duration
 }
 
 // EndpointPayload contains the same fields as Endpoint, but only as
@@ -41,11 +219,93 @@ type EndpointPayload struct {
 	StatusOnline uint16 `json:"status_online"`
 	Frequency    string `json:"frequency"`
 	FailAfter    uint8  `json:"fail_after"`
+
+	MaintenanceWindows       []string `json:"maintenance_windows,omitempty"`
+	KeepConnectionsOnFailure bool     `json:"keep_connections_on_failure,omitempty"`
+	MaxTTFB                  string   `json:"max_ttfb,omitempty"`
+
+	ResponseSchema     json.RawMessage     `json:"response_schema,omitempty"`
+	ExpectTrailer      string              `json:"expect_trailer,omitempty"`
+	ExpectTrailerValue string              `json:"expect_trailer_value,omitempty"`
+	ExtractRegex       string              `json:"extract_regex,omitempty"`
+	ExtractHeader      string              `json:"extract_header,omitempty"`
+	HostHeader         string              `json:"host_header,omitempty"`
+	ActiveHours        *ActiveHoursPayload `json:"active_hours,omitempty"`
+	ExpectBodyHash     string              `json:"expect_body_hash,omitempty"`
+	CaptureHeaders     []string            `json:"capture_headers,omitempty"`
+	FastFailOnRefused  bool                `json:"fast_fail_on_refused,omitempty"`
+	Timeout            string              `json:"timeout,omitempty"`
+	CheckPaths         []string            `json:"check_paths,omitempty"`
+	Owner              string              `json:"owner,omitempty"`
+	StabilityWindow    uint8               `json:"stability_window,omitempty"`
+	StabilityThreshold uint8               `json:"stability_threshold,omitempty"`
+	InsecureSkipVerify bool                `json:"insecure_skip_verify,omitempty"`
+	Proxy              string              `json:"proxy,omitempty"`
+	ExpectBuildHeader  string              `json:"expect_build_header,omitempty"`
+	ExpectSetCookie    *CookieExpectation  `json:"expect_set_cookie,omitempty"`
+	Tags               []string            `json:"tags,omitempty"`
+	BodySource         string              `json:"body_source,omitempty"`
+	ExpectJSONPath     string              `json:"expect_json_path,omitempty"`
+	Version            uint64              `json:"version,omitempty"`
+	UpdatedBy          string              `json:"updated_by,omitempty"`
+	Headers            map[string]string   `json:"headers,omitempty"`
+
+	ExpectValidCompression bool   `json:"expect_valid_compression,omitempty"`
+	Protocol               string `json:"protocol,omitempty"`
+	ConcurrentProbes       uint8  `json:"concurrent_probes,omitempty"`
+	ExpectStatusText       string `json:"expect_status_text,omitempty"`
+	PinnedCertSHA256       string `json:"pinned_cert_sha256,omitempty"`
+	BodyContains           string `json:"body_contains,omitempty"`
+	BodyNotContains        string `json:"body_not_contains,omitempty"`
+	RedirectCountsAs       string `json:"redirect_counts_as,omitempty"`
+	Resolver               string `json:"resolver,omitempty"`
+	ConnectTarget          string `json:"connect_target,omitempty"`
+	DialTimeout            string `json:"dial_timeout,omitempty"`
+}
+
+// IdentifierPattern is the pattern the identifiers of endpoints, as well as
+// owners and tags, must match.
+const IdentifierPattern = "^[a-z][-a-z0-9]+$"
+
+var idPattern = regexp.MustCompile(IdentifierPattern)
+
+// ValidIdentifier indicates whether or not s matches IdentifierPattern. Both
+// the endpoints written and the paths of the config server's endpoint
+// resources are checked with it.
+func ValidIdentifier(s string) bool {
+	return idPattern.MatchString(s)
 }
 
-const idPatternRaw = "^[a-z][-a-z0-9]+$"
+// headerNamePattern matches valid HTTP header field names (tokens).
+var headerNamePattern = regexp.MustCompile("^[-!#$%&'*+.^_`|~0-9A-Za-z]+$")
 
-var idPattern = regexp.MustCompile(idPatternRaw)
+// MaxCheckPaths is the maximum number of check paths per endpoint.
+const MaxCheckPaths = 10
+
+// MaxTags is the maximum number of tags per endpoint.
+const MaxTags = 10
+
+// DefaultTimeout bounds the probes of endpoints without a timeout, unless
+// nine tenths of their frequency are shorter.
+const DefaultTimeout = 10 * time.Second
+
+// MaxConcurrentProbes is the maximum number of requests issued at once by a
+// probe of an endpoint.
+const MaxConcurrentProbes = 10
+
+// MaxStabilityWindow is the maximum number of recent probes considered for the
+// stability of an endpoint.
+const MaxStabilityWindow = 100
+
+// bodyHashPattern matches hex-encoded SHA-256 hashes.
+var bodyHashPattern = regexp.MustCompile("^[0-9a-f]{64}$")
+
+// validHost indicates whether or not host is a valid value of the Host header,
+// i.e. a host name or IP address, optionally followed by a port.
+func validHost(host string) bool {
+	u, err := url.Parse("//" + host)
+	return err == nil && u.Host == host && u.Hostname() != "" && u.User == nil
+}
 
 // NewDefaultEndpoint creates a new Endpoint from rawURL, which is parsed. An
 // endpoint is returned, if the rawURL is valid, and an error (indicating the
@@ -60,76 +320,506 @@ func NewDefaultEndpoint(id string, rawURL string) (*Endpoint, error) {
 		URL:          parsedURL,
 		Method:       http.MethodGet,
 		StatusOnline: http.StatusOK,
-		Frequency:    5 * time.Minute,
-		FailAfter:    3,
+		Frequency:    CurrentSettings().DefaultFrequency,
+		FailAfter:    CurrentSettings().DefaultFailAfter,
 	}, nil
 }
 
-// String returns the Endpoint's fields separated by a space.
+// String returns a concise representation of the Endpoint for logging: its
+// identifier, method, URL (sanitized by SanitizeURL), and frequency. Other
+// fields are left out, so that they cannot leak into logs.
 func (e Endpoint) String() string {
-	return fmt.Sprintf("%s %s %s %d %v %d", e.Identifier,
-		e.URL, e.Method, e.StatusOnline, e.Frequency, e.FailAfter)
+	return fmt.Sprintf("%s %s %s every %v", e.Identifier, e.Method,
+		SanitizeURL(e.URL, CurrentSettings().LogSafeParams), e.Frequency)
+}
+
+// ToPayload converts the endpoint to its payload representation, from which
+// it can be created again using ToEndpoint.
+func (e *Endpoint) ToPayload() EndpointPayload {
+	payload := EndpointPayload{
+		Identifier:   e.Identifier,
+		URL:          e.URL.String(),
+		Method:       e.Method,
+		StatusOnline: e.StatusOnline,
+		Frequency:    e.Frequency.String(),
+		FailAfter:    e.FailAfter,
+
+		KeepConnectionsOnFailure: e.KeepConnectionsOnFailure,
+	}
+	if e.MaxTTFB > 0 {
+		payload.MaxTTFB = e.MaxTTFB.String()
+	}
+	if e.ResponseSchema != nil {
+		payload.ResponseSchema = json.RawMessage(e.ResponseSchema.String())
+	}
+	for _, w := range e.MaintenanceWindows {
+		payload.MaintenanceWindows = append(payload.MaintenanceWindows, w.String())
+	}
+	payload.ExpectTrailer = e.ExpectTrailer
+	payload.ExpectTrailerValue = e.ExpectTrailerValue
+	if e.ExtractRegex != nil {
+		payload.ExtractRegex = e.ExtractRegex.String()
+	}
+	payload.ExtractHeader = e.ExtractHeader
+	payload.HostHeader = e.HostHeader
+	if e.ActiveHours != nil {
+		activeHours := e.ActiveHours.Payload()
+		payload.ActiveHours = &activeHours
+	}
+	payload.ExpectBodyHash = e.ExpectBodyHash
+	payload.CaptureHeaders = e.CaptureHeaderNames
+	payload.FastFailOnRefused = e.FastFailOnRefused
+	if e.Timeout > 0 {
+		payload.Timeout = e.Timeout.String()
+	}
+	payload.CheckPaths = e.CheckPaths
+	payload.Owner = e.Owner
+	payload.StabilityWindow = e.StabilityWindow
+	payload.StabilityThreshold = e.StabilityThreshold
+	payload.InsecureSkipVerify = e.InsecureSkipVerify
+	if e.Proxy != nil {
+		payload.Proxy = e.Proxy.String()
+	}
+	payload.ExpectBuildHeader = e.ExpectBuildHeader
+	payload.ExpectSetCookie = e.ExpectSetCookie
+	payload.Tags = e.Tags
+	if e.BodySource != nil {
+		payload.BodySource = e.BodySource.String()
+	}
+	if e.ExpectJSONPath != nil {
+		payload.ExpectJSONPath = e.ExpectJSONPath.String()
+	}
+	payload.Version = e.Version
+	payload.UpdatedBy = e.UpdatedBy
+	payload.Headers = e.Headers
+	payload.ExpectValidCompression = e.ExpectValidCompression
+	payload.Protocol = e.Protocol
+	payload.ConcurrentProbes = e.ConcurrentProbes
+	payload.ExpectStatusText = e.ExpectStatusText
+	payload.PinnedCertSHA256 = e.PinnedCertSHA256
+	payload.BodyContains = e.BodyContains
+	payload.BodyNotContains = e.BodyNotContains
+	payload.RedirectCountsAs = string(e.RedirectCountsAs)
+	payload.Resolver = e.Resolver
+	payload.ConnectTarget = e.ConnectTarget
+	if e.DialTimeout > 0 {
+		payload.DialTimeout = e.DialTimeout.String()
+	}
+	return payload
 }
 
 // JSON returns the Endpoint's fields as a JSON data, or an error, if it cannot
 // be serialized.
 func (e Endpoint) JSON() ([]byte, error) {
-	payload := EndpointPayload{
-		e.Identifier,
-		e.URL.String(),
-		e.Method,
-		e.StatusOnline,
-		e.Frequency.String(),
-		e.FailAfter,
-	}
-	data, err := json.Marshal(payload)
+	data, err := json.Marshal(e.ToPayload())
 	if err != nil {
 		return nil, fmt.Errorf("marshal endpoint %v as JSON: %v", e, err)
 	}
 	return data, nil
 }
 
+var standardMethods = map[string]bool{
+	http.MethodGet:     true,
+	http.MethodHead:    true,
+	http.MethodPost:    true,
+	http.MethodPut:     true,
+	http.MethodPatch:   true,
+	http.MethodDelete:  true,
+	http.MethodConnect: true,
+	http.MethodOptions: true,
+	http.MethodTrace:   true,
+}
+
+// IsStandardMethod indicates whether or not method is one of the HTTP methods
+// defined in RFC 9110 and RFC 5789 (PATCH).
+func IsStandardMethod(method string) bool {
+	return standardMethods[method]
+}
+
+// methodsAllowed are the methods endpoints can be probed with: the standard
+// methods, except for CONNECT, which does not request a resource, and is only
+// allowed for endpoints with a ConnectTarget.
 var methodsAllowed = map[string]bool{
-	http.MethodGet:  true,
-	http.MethodHead: true,
+	http.MethodGet:     true,
+	http.MethodHead:    true,
+	http.MethodPost:    true,
+	http.MethodPut:     true,
+	http.MethodPatch:   true,
+	http.MethodDelete:  true,
+	http.MethodOptions: true,
+	http.MethodTrace:   true,
+}
+
+// validateURL checks that u is an absolute http or https URL with a host.
+func validateURL(u *url.URL) error {
+	if !u.IsAbs() || u.Host == "" {
+		return fmt.Errorf(`"%s" is not an absolute URL`, u.Redacted())
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return fmt.Errorf(`scheme "%s" is neither http nor https`, u.Scheme)
+	}
+	return nil
+}
+
+// validateAddress checks that address is a host and a port, as expected by
+// net.Dial, e.g. "10.0.0.53:53" or "[fd00::53]:53".
+func validateAddress(address string) error {
+	host, port, err := net.SplitHostPort(address)
+	if err != nil {
+		return fmt.Errorf(`"%s" is not a host:port address: %v`, address, err)
+	}
+	if host == "" {
+		return fmt.Errorf(`"%s" has no host`, address)
+	}
+	if n, err := strconv.ParseUint(port, 10, 16); err != nil || n == 0 {
+		return fmt.Errorf(`port "%s" is not between 1 and 65535`, port)
+	}
+	return nil
 }
 
-// EndpointFromJSON creates a new endpoint from a given JSON structure.
+// validateConnect checks that an endpoint probed with the method CONNECT has a
+// valid connect_target, and vice versa, and that it does not configure what is
+// not sent along with a CONNECT request: a body, a Host header (which is the
+// target), or check paths.
+func validateConnect(payload EndpointPayload) error {
+	if payload.Method != http.MethodConnect {
+		return &FieldError{"connect_target", fmt.Errorf("a tunnel requires method %s, not %s",
+			http.MethodConnect, payload.Method)}
+	}
+	if payload.ConnectTarget == "" {
+		return &FieldError{"connect_target", fmt.Errorf("method %s requires a target", payload.Method)}
+	}
+	if err := validateAddress(payload.ConnectTarget); err != nil {
+		return &FieldError{"connect_target", err}
+	}
+	switch {
+	case payload.BodySource != "":
+		return &FieldError{"body_source", fmt.Errorf("not supported by method %s", payload.Method)}
+	case payload.HostHeader != "":
+		return &FieldError{"host_header", fmt.Errorf("not supported by method %s", payload.Method)}
+	case len(payload.CheckPaths) > 0:
+		return &FieldError{"check_paths", fmt.Errorf("not supported by method %s", payload.Method)}
+	}
+	return nil
+}
+
+// EndpointFromJSON creates a new endpoint from a given JSON structure. The
+// fields frequency and fail_after are optional; the DefaultFrequency and
+// DefaultFailAfter of the current settings are applied if they are omitted.
+// The frequency may also be given as a number of seconds (see ParseFrequency).
 func EndpointFromJSON(rawJSON string) (*Endpoint, error) {
-	var payload EndpointPayload
+	settings := CurrentSettings()
+	payload := EndpointPayload{
+		Frequency: settings.DefaultFrequency.String(),
+		FailAfter: settings.DefaultFailAfter,
+	}
 	if err := json.Unmarshal([]byte(rawJSON), &payload); err != nil {
+		var typeErr *json.UnmarshalTypeError
+		if errors.As(err, &typeErr) && typeErr.Field != "" {
+			// e.g. a status_online exceeding uint16
+			return nil, &FieldError{typeErr.Field, fmt.Errorf("%s is not a valid %s", typeErr.Value, typeErr.Type)}
+		}
 		return nil, fmt.Errorf(`unmarshal raw json "%s": %v`, rawJSON, err)
 	}
 	return EndpointFromPayload(payload)
 }
 
-// EndpointFromPayload creates an endpoint from the given payload.
+// ParseFrequency parses raw as a duration (e.g. "1m30s"), or as a plain number
+// of seconds (e.g. "30").
+func ParseFrequency(raw string) (time.Duration, error) {
+	if seconds, err := strconv.ParseUint(raw, 10, 32); err == nil {
+		return time.Duration(seconds) * time.Second, nil
+	}
+	frequency, err := time.ParseDuration(raw)
+	if err != nil {
+		return 0, fmt.Errorf(`"%s" is not a valid duration`, raw)
+	}
+	return frequency, nil
+}
+
+// ToEndpoint creates an endpoint from the payload like EndpointFromPayload.
+func (p EndpointPayload) ToEndpoint() (*Endpoint, error) {
+	return EndpointFromPayload(p)
+}
+
+// EndpointFromPayload creates an endpoint from the given payload. If the
+// identifier, URL, method, status_online, frequency, or protocol is invalid,
+// the error returned is a *FieldError naming the field.
 func EndpointFromPayload(payload EndpointPayload) (*Endpoint, error) {
-	if !idPattern.MatchString(payload.Identifier) {
-		return nil, fmt.Errorf(`identifier "%s" does not match pattern "%s"`,
-			payload.Identifier, idPatternRaw)
+	if !ValidIdentifier(payload.Identifier) {
+		return nil, &FieldError{"identifier", fmt.Errorf(`"%s" does not match pattern "%s"`,
+			payload.Identifier, IdentifierPattern)}
 	}
 	parsedURL, err := url.Parse(payload.URL)
 	if err != nil {
-		return nil, fmt.Errorf(`parse URL "%s": %v`, payload.URL, err)
+		return nil, &FieldError{"url", err}
+	}
+	if err := validateURL(parsedURL); err != nil {
+		return nil, &FieldError{"url", err}
+	}
+	if !methodsAllowed[payload.Method] && payload.Method != http.MethodConnect {
+		return nil, &FieldError{"method", fmt.Errorf(`"%s" is not an allowed method`, payload.Method)}
+	}
+	if payload.StatusOnline < 100 || payload.StatusOnline > 599 {
+		return nil, &FieldError{"status_online", fmt.Errorf(`%d is not a status code from 100 to 599`,
+			payload.StatusOnline)}
+	}
+	frequency, err := ParseFrequency(payload.Frequency)
+	if err != nil {
+		return nil, &FieldError{"frequency", err}
+	}
+	if minFrequency := CurrentSettings().MinFrequency; frequency < minFrequency {
+		return nil, &FieldError{"frequency", fmt.Errorf("%v is below the minimum frequency %v",
+			frequency, minFrequency)}
+	}
+	var maxTTFB time.Duration
+	if payload.MaxTTFB != "" {
+		maxTTFB, err = time.ParseDuration(payload.MaxTTFB)
+		if err != nil || maxTTFB < 0 {
+			return nil, fmt.Errorf(`"%s" is not a valid duration`, payload.MaxTTFB)
+		}
+	}
+	var schema *Schema
+	if len(payload.ResponseSchema) > 0 {
+		if schema, err = CompileSchema(payload.ResponseSchema); err != nil {
+			return nil, fmt.Errorf("invalid response schema: %v", err)
+		}
+	}
+	windows := make([]MaintenanceWindow, 0, len(payload.MaintenanceWindows))
+	for _, spec := range payload.MaintenanceWindows {
+		window, err := ParseMaintenanceWindow(spec)
+		if err != nil {
+			return nil, err
+		}
+		windows = append(windows, *window)
+	}
+	if payload.ExpectTrailer != "" && !headerNamePattern.MatchString(payload.ExpectTrailer) {
+		return nil, fmt.Errorf(`"%s" is not a valid trailer name`, payload.ExpectTrailer)
+	}
+	if payload.ExpectTrailerValue != "" && payload.ExpectTrailer == "" {
+		return nil, fmt.Errorf("expect_trailer_value requires expect_trailer")
+	}
+	var extractRegex *regexp.Regexp
+	if payload.ExtractRegex != "" {
+		if extractRegex, err = regexp.Compile(payload.ExtractRegex); err != nil {
+			return nil, fmt.Errorf(`extract_regex "%s": %v`, payload.ExtractRegex, err)
+		}
+		if extractRegex.NumSubexp() < 1 {
+			return nil, fmt.Errorf(`extract_regex "%s" has no capture group`, payload.ExtractRegex)
+		}
+		if !headerNamePattern.MatchString(payload.ExtractHeader) {
+			return nil, fmt.Errorf(`"%s" is not a valid header name`, payload.ExtractHeader)
+		}
+	} else if payload.ExtractHeader != "" {
+		return nil, fmt.Errorf("extract_header requires extract_regex")
+	}
+	if payload.HostHeader != "" && !validHost(payload.HostHeader) {
+		return nil, fmt.Errorf(`"%s" is not a valid host`, payload.HostHeader)
+	}
+	var activeHours *ActiveHours
+	if payload.ActiveHours != nil {
+		if activeHours, err = ParseActiveHours(*payload.ActiveHours); err != nil {
+			return nil, err
+		}
+	}
+	expectBodyHash := strings.ToLower(payload.ExpectBodyHash)
+	if expectBodyHash != "" && !bodyHashPattern.MatchString(expectBodyHash) {
+		return nil, fmt.Errorf(`"%s" is not a hex-encoded SHA-256 hash`, payload.ExpectBodyHash)
+	}
+	captureHeaders, err := validateCaptureHeaders(payload.CaptureHeaders)
+	if err != nil {
+		return nil, err
+	}
+	var timeout time.Duration
+	if payload.Timeout != "" {
+		timeout, err = time.ParseDuration(payload.Timeout)
+		if err != nil {
+			return nil, &FieldError{"timeout", fmt.Errorf(`"%s" is not a valid duration`, payload.Timeout)}
+		}
+		if timeout <= 0 {
+			return nil, &FieldError{"timeout", fmt.Errorf("%v is not positive", timeout)}
+		}
+		// a probe must not overrun the next one
+		if timeout >= frequency {
+			return nil, &FieldError{"timeout", fmt.Errorf("timeout %v is not below frequency %v", timeout, frequency)}
+		}
+	}
+	if len(payload.CheckPaths) > MaxCheckPaths {
+		return nil, fmt.Errorf("%d check paths exceed the maximum of %d",
+			len(payload.CheckPaths), MaxCheckPaths)
+	}
+	for _, path := range payload.CheckPaths {
+		ref, err := url.Parse(path)
+		if err != nil || ref.IsAbs() || ref.Host != "" || path == "" {
+			return nil, fmt.Errorf(`check path "%s" is not a path relative to the URL`, path)
+		}
+	}
+	if payload.Owner != "" && !idPattern.MatchString(payload.Owner) {
+		return nil, fmt.Errorf(`owner "%s" does not match pattern "%s"`, payload.Owner, IdentifierPattern)
+	}
+	if payload.StabilityWindow > MaxStabilityWindow {
+		return nil, fmt.Errorf("stability window %d exceeds the maximum of %d",
+			payload.StabilityWindow, MaxStabilityWindow)
+	}
+	if (payload.StabilityWindow == 0) != (payload.StabilityThreshold == 0) {
+		return nil, fmt.Errorf("stability_window and stability_threshold require each other")
+	}
+	if payload.StabilityThreshold > payload.StabilityWindow {
+		return nil, fmt.Errorf("stability threshold %d exceeds stability window %d",
+			payload.StabilityThreshold, payload.StabilityWindow)
 	}
-	if allowed, ok := methodsAllowed[payload.Method]; !allowed || !ok {
-		return nil, fmt.Errorf(`"%s" is not an allowed method`, payload.Method)
+	var proxy *url.URL
+	if payload.Proxy != "" {
+		proxy, err = url.Parse(payload.Proxy)
+		if err != nil || proxy.Host == "" ||
+			(proxy.Scheme != "http" && proxy.Scheme != "https" && proxy.Scheme != "socks5") {
+			return nil, fmt.Errorf(`proxy "%s" is not an http, https, or socks5 URL`, payload.Proxy)
+		}
 	}
-	if payload.StatusOnline < 100 || payload.StatusOnline > 999 {
-		return nil, fmt.Errorf(`"%d" is not a valid status code`, payload.StatusOnline)
+	if payload.ExpectBuildHeader != "" && !headerNamePattern.MatchString(payload.ExpectBuildHeader) {
+		return nil, fmt.Errorf(`"%s" is not a valid header name`, payload.ExpectBuildHeader)
 	}
-	frequency, err := time.ParseDuration(payload.Frequency)
+	if payload.ExpectSetCookie != nil {
+		if err := payload.ExpectSetCookie.validate(); err != nil {
+			return nil, fmt.Errorf("expect_set_cookie: %v", err)
+		}
+	}
+	if len(payload.Tags) > MaxTags {
+		return nil, fmt.Errorf("%d tags exceed the maximum of %d", len(payload.Tags), MaxTags)
+	}
+	for _, tag := range payload.Tags {
+		if !idPattern.MatchString(tag) {
+			return nil, fmt.Errorf(`tag "%s" does not match pattern "%s"`, tag, IdentifierPattern)
+		}
+	}
+	var bodySource *BodySource
+	if payload.BodySource != "" {
+		if bodySource, err = ParseBodySource(payload.BodySource); err != nil {
+			return nil, fmt.Errorf("body_source: %v", err)
+		}
+	}
+	var expectJSONPath *JSONPathAssertion
+	if payload.ExpectJSONPath != "" {
+		if expectJSONPath, err = ParseJSONPathAssertion(payload.ExpectJSONPath); err != nil {
+			return nil, fmt.Errorf("expect_json_path: %v", err)
+		}
+	}
+	headers, err := validateRequestHeaders(payload.Headers)
 	if err != nil {
-		return nil, fmt.Errorf(`"%s" is not a valid duration`, payload.Frequency)
+		return nil, fmt.Errorf("headers: %v", err)
+	}
+	if err := validateProtocol(payload.Protocol, payload.Method, parsedURL); err != nil {
+		return nil, &FieldError{"protocol", err}
+	}
+	if payload.Protocol == ProtocolGRPCWeb && len(payload.CheckPaths) > 0 {
+		return nil, &FieldError{"check_paths", fmt.Errorf("check paths are not supported by protocol %s", payload.Protocol)}
+	}
+	if payload.ConcurrentProbes > MaxConcurrentProbes {
+		return nil, fmt.Errorf("%d concurrent probes exceed the maximum of %d",
+			payload.ConcurrentProbes, MaxConcurrentProbes)
+	}
+	if strings.ContainsFunc(payload.ExpectStatusText, unicode.IsControl) {
+		return nil, &FieldError{"expect_status_text", fmt.Errorf("%q contains control characters", payload.ExpectStatusText)}
+	}
+	pinnedCertSHA256 := normalizeFingerprint(payload.PinnedCertSHA256)
+	if pinnedCertSHA256 != "" {
+		if !bodyHashPattern.MatchString(pinnedCertSHA256) {
+			return nil, &FieldError{"pinned_cert_sha256",
+				fmt.Errorf(`"%s" is not a hex-encoded SHA-256 fingerprint`, payload.PinnedCertSHA256)}
+		}
+		if parsedURL.Scheme != "https" {
+			return nil, &FieldError{"pinned_cert_sha256", fmt.Errorf("pinning requires an https URL")}
+		}
+	}
+	if payload.BodyContains != "" && (payload.Method == http.MethodHead || payload.Method == http.MethodConnect) {
+		return nil, &FieldError{"body_contains", fmt.Errorf("responses to %s have no body", payload.Method)}
+	}
+	if len(payload.BodyContains) > MaxBodySize {
+		return nil, &FieldError{"body_contains", fmt.Errorf("%d bytes exceed the maximum of %d",
+			len(payload.BodyContains), MaxBodySize)}
+	}
+	if payload.BodyNotContains != "" && (payload.Method == http.MethodHead || payload.Method == http.MethodConnect) {
+		return nil, &FieldError{"body_not_contains", fmt.Errorf("responses to %s have no body", payload.Method)}
+	}
+	if len(payload.BodyNotContains) > MaxBodySize {
+		return nil, &FieldError{"body_not_contains", fmt.Errorf("%d bytes exceed the maximum of %d",
+			len(payload.BodyNotContains), MaxBodySize)}
+	}
+	switch State(payload.RedirectCountsAs) {
+	case "", StateOnline, StateDegraded, StateOffline:
+	default:
+		return nil, &FieldError{"redirect_counts_as", fmt.Errorf(`"%s" is neither %s, %s, nor %s`,
+			payload.RedirectCountsAs, StateOnline, StateDegraded, StateOffline)}
+	}
+	if payload.Resolver != "" {
+		if err := validateAddress(payload.Resolver); err != nil {
+			return nil, &FieldError{"resolver", err}
+		}
+	}
+	if payload.Method == http.MethodConnect || payload.ConnectTarget != "" {
+		if err := validateConnect(payload); err != nil {
+			return nil, err
+		}
+	}
+	var dialTimeout time.Duration
+	if payload.DialTimeout != "" {
+		dialTimeout, err = time.ParseDuration(payload.DialTimeout)
+		if err != nil || dialTimeout < 0 {
+			return nil, &FieldError{"dial_timeout", fmt.Errorf(`"%s" is not a valid duration`, payload.DialTimeout)}
+		}
+		probeTimeout := (&Endpoint{Frequency: frequency, Timeout: timeout}).ProbeTimeout()
+		if dialTimeout > probeTimeout {
+			return nil, &FieldError{"dial_timeout", fmt.Errorf("dial timeout %v exceeds timeout %v",
+				dialTimeout, probeTimeout)}
+		}
 	}
 	return &Endpoint{
-		payload.Identifier,
-		parsedURL,
-		payload.Method,
-		payload.StatusOnline,
-		frequency,
-		payload.FailAfter,
+		Identifier:         payload.Identifier,
+		URL:                parsedURL,
+		Method:             payload.Method,
+		StatusOnline:       payload.StatusOnline,
+		Frequency:          frequency,
+		FailAfter:          payload.FailAfter,
+		MaintenanceWindows: windows,
+
+		KeepConnectionsOnFailure: payload.KeepConnectionsOnFailure,
+		MaxTTFB:                  maxTTFB,
+		ResponseSchema:           schema,
+		ExpectTrailer:            payload.ExpectTrailer,
+		ExpectTrailerValue:       payload.ExpectTrailerValue,
+		ExtractRegex:             extractRegex,
+		ExtractHeader:            payload.ExtractHeader,
+		HostHeader:               payload.HostHeader,
+		ActiveHours:              activeHours,
+		ExpectBodyHash:           expectBodyHash,
+		CaptureHeaderNames:       captureHeaders,
+		FastFailOnRefused:        payload.FastFailOnRefused,
+		Timeout:                  timeout,
+		CheckPaths:               payload.CheckPaths,
+		Owner:                    payload.Owner,
+		StabilityWindow:          payload.StabilityWindow,
+		StabilityThreshold:       payload.StabilityThreshold,
+		InsecureSkipVerify:       payload.InsecureSkipVerify,
+		Proxy:                    proxy,
+		ExpectBuildHeader:        payload.ExpectBuildHeader,
+		ExpectSetCookie:          payload.ExpectSetCookie,
+		Tags:                     payload.Tags,
+		BodySource:               bodySource,
+		ExpectJSONPath:           expectJSONPath,
+		Version:                  payload.Version,
+		UpdatedBy:                payload.UpdatedBy,
+		Headers:                  headers,
+		ExpectValidCompression:   payload.ExpectValidCompression,
+		Protocol:                 payload.Protocol,
+		ConcurrentProbes:         payload.ConcurrentProbes,
+		ExpectStatusText:         payload.ExpectStatusText,
+		PinnedCertSHA256:         pinnedCertSHA256,
+		BodyContains:             payload.BodyContains,
+		BodyNotContains:          payload.BodyNotContains,
+		RedirectCountsAs:         State(payload.RedirectCountsAs),
+		Resolver:                 payload.Resolver,
+		ConnectTarget:            payload.ConnectTarget,
+		DialTimeout:              dialTimeout,
 	}, nil
 }
 
@@ -142,24 +832,27 @@ func EndpointFromRecord(record []string) (*Endpoint, error) {
 		return nil, fmt.Errorf(`malformed record "%s" (needs %d fields)`, record, nFields)
 	}
 	id := record[0]
-	if !idPattern.MatchString(id) {
-		return nil, fmt.Errorf(`id "%s" does not match pattern %s`, id, idPatternRaw)
+	if !ValidIdentifier(id) {
+		return nil, fmt.Errorf(`id "%s" does not match pattern %s`, id, IdentifierPattern)
 	}
 	parsedURL, err := url.Parse(record[1])
 	if err != nil {
 		return nil, fmt.Errorf(`parse URL "%s": %v`, record[1], err)
 	}
+	if err := validateURL(parsedURL); err != nil {
+		return nil, err
+	}
 	method := record[2]
 	if allowed, ok := methodsAllowed[method]; !allowed || !ok {
 		return nil, fmt.Errorf(`"%s" is not an allowed method`, method)
 	}
 	statusOnline, err := strconv.Atoi(record[3])
-	if err != nil || statusOnline < 100 || statusOnline > 999 {
+	if err != nil || statusOnline < 100 || statusOnline > 599 {
 		return nil, fmt.Errorf(`"%s" is not a valid status code`, record[3])
 	}
-	frequency, err := time.ParseDuration(record[4])
+	frequency, err := ParseFrequency(record[4])
 	if err != nil {
-		return nil, fmt.Errorf(`"%s" is not a valid duration`, record[4])
+		return nil, err
 	}
 	failAfter, err := strconv.Atoi(record[5])
 	if err != nil {
@@ -177,6 +870,10 @@ func EndpointFromRecord(record []string) (*Endpoint, error) {
 
 // EndpointFromMap creates a new Endpoint from the given map, which must
 // provide the fields: identifier, url, method, status_online, frequency, fail_after
+//
+// The optional fields are named like the JSON fields of EndpointPayload. Lists
+// and objects (e.g. maintenance_windows) are stored as JSON, booleans as
+// "true" or "false", and durations as understood by time.ParseDuration.
 func EndpointFromMap(m map[string]string) (*Endpoint, error) {
 	statusOnline, err := strconv.Atoi(m["status_online"])
 	if err != nil {
@@ -194,5 +891,193 @@ func EndpointFromMap(m map[string]string) (*Endpoint, error) {
 		Frequency:    m["frequency"],
 		FailAfter:    uint8(failAfter),
 	}
+	if raw := m["maintenance_windows"]; raw != "" {
+		if err := json.Unmarshal([]byte(raw), &payload.MaintenanceWindows); err != nil {
+			return nil, fmt.Errorf("parse maintenance_windows: %v", err)
+		}
+	}
+	if raw := m["keep_connections_on_failure"]; raw != "" {
+		if payload.KeepConnectionsOnFailure, err = strconv.ParseBool(raw); err != nil {
+			return nil, fmt.Errorf("parse keep_connections_on_failure: %v", err)
+		}
+	}
+	payload.MaxTTFB = m["max_ttfb"]
+	if raw := m["response_schema"]; raw != "" {
+		payload.ResponseSchema = json.RawMessage(raw)
+	}
+	payload.ExpectTrailer = m["expect_trailer"]
+	payload.ExpectTrailerValue = m["expect_trailer_value"]
+	payload.ExtractRegex = m["extract_regex"]
+	payload.ExtractHeader = m["extract_header"]
+	payload.HostHeader = m["host_header"]
+	if raw := m["active_hours"]; raw != "" {
+		if err := json.Unmarshal([]byte(raw), &payload.ActiveHours); err != nil {
+			return nil, fmt.Errorf("parse active_hours: %v", err)
+		}
+	}
+	payload.ExpectBodyHash = m["expect_body_hash"]
+	if raw := m["capture_headers"]; raw != "" {
+		if err := json.Unmarshal([]byte(raw), &payload.CaptureHeaders); err != nil {
+			return nil, fmt.Errorf("parse capture_headers: %v", err)
+		}
+	}
+	if raw := m["fast_fail_on_refused"]; raw != "" {
+		if payload.FastFailOnRefused, err = strconv.ParseBool(raw); err != nil {
+			return nil, fmt.Errorf("parse fast_fail_on_refused: %v", err)
+		}
+	}
+	// stored as 0s if not set
+	if raw := m["timeout"]; raw != "0s" {
+		payload.Timeout = raw
+	}
+	if raw := m["check_paths"]; raw != "" {
+		if err := json.Unmarshal([]byte(raw), &payload.CheckPaths); err != nil {
+			return nil, fmt.Errorf("parse check_paths: %v", err)
+		}
+	}
+	payload.Owner = m["owner"]
+	for field, value := range map[string]*uint8{
+		"stability_window":    &payload.StabilityWindow,
+		"stability_threshold": &payload.StabilityThreshold,
+	} {
+		if raw := m[field]; raw != "" {
+			n, err := strconv.ParseUint(raw, 10, 8)
+			if err != nil {
+				return nil, fmt.Errorf("parse %s: %v", field, err)
+			}
+			*value = uint8(n)
+		}
+	}
+	if raw := m["insecure_skip_verify"]; raw != "" {
+		if payload.InsecureSkipVerify, err = strconv.ParseBool(raw); err != nil {
+			return nil, fmt.Errorf("parse insecure_skip_verify: %v", err)
+		}
+	}
+	payload.Proxy = m["proxy"]
+	payload.ExpectBuildHeader = m["expect_build_header"]
+	if raw := m["expect_set_cookie"]; raw != "" {
+		if err := json.Unmarshal([]byte(raw), &payload.ExpectSetCookie); err != nil {
+			return nil, fmt.Errorf("parse expect_set_cookie: %v", err)
+		}
+	}
+	if raw := m["tags"]; raw != "" {
+		if err := json.Unmarshal([]byte(raw), &payload.Tags); err != nil {
+			return nil, fmt.Errorf("parse tags: %v", err)
+		}
+	}
+	payload.BodySource = m["body_source"]
+	payload.ExpectJSONPath = m["expect_json_path"]
+	if raw := m["version"]; raw != "" {
+		if payload.Version, err = strconv.ParseUint(raw, 10, 64); err != nil {
+			return nil, fmt.Errorf("parse version: %v", err)
+		}
+	}
+	payload.UpdatedBy = m["updated_by"]
+	if raw := m["headers"]; raw != "" {
+		if err := json.Unmarshal([]byte(raw), &payload.Headers); err != nil {
+			return nil, fmt.Errorf("parse headers: %v", err)
+		}
+	}
+	if raw := m["expect_valid_compression"]; raw != "" {
+		if payload.ExpectValidCompression, err = strconv.ParseBool(raw); err != nil {
+			return nil, fmt.Errorf("parse expect_valid_compression: %v", err)
+		}
+	}
+	payload.Protocol = m["protocol"]
+	if raw := m["concurrent_probes"]; raw != "" {
+		n, err := strconv.ParseUint(raw, 10, 8)
+		if err != nil {
+			return nil, fmt.Errorf("parse concurrent_probes: %v", err)
+		}
+		payload.ConcurrentProbes = uint8(n)
+	}
+	payload.ExpectStatusText = m["expect_status_text"]
+	payload.PinnedCertSHA256 = m["pinned_cert_sha256"]
+	payload.BodyContains = m["body_contains"]
+	payload.BodyNotContains = m["body_not_contains"]
+	payload.RedirectCountsAs = m["redirect_counts_as"]
+	payload.Resolver = m["resolver"]
+	payload.ConnectTarget = m["connect_target"]
+	payload.DialTimeout = m["dial_timeout"]
 	return EndpointFromPayload(payload)
 }
+
+// Equal indicates whether or not the endpoints e and other are configured the
+// same, i.e. all their fields are equal, except for the write metadata Version
+// and UpdatedBy. URLs are compared as normalized by normalizeURL, so that e.g.
+// https://example.com equals https://example.com:443/.
+func (e *Endpoint) Equal(other *Endpoint) bool {
+	if e == nil || other == nil {
+		return e == other
+	}
+	a, b := *e, *other
+	a.Version, a.UpdatedBy = 0, ""
+	b.Version, b.UpdatedBy = 0, ""
+	a.URL, b.URL = normalizeURL(a.URL), normalizeURL(b.URL)
+	dataA, errA := a.JSON()
+	dataB, errB := b.JSON()
+	return errA == nil && errB == nil && bytes.Equal(dataA, dataB)
+}
+
+// normalizeURL returns a copy of u with its scheme and host in lower case,
+// without the default port of its scheme, and with the root path instead of an
+// empty one. Trailing slashes of other paths are kept, since servers may treat
+// them differently.
+func normalizeURL(u *url.URL) *url.URL {
+	if u == nil {
+		return nil
+	}
+	normalized := *u
+	normalized.Scheme = strings.ToLower(u.Scheme)
+	host, port := strings.ToLower(u.Hostname()), u.Port()
+	if (normalized.Scheme == "http" && port == "80") || (normalized.Scheme == "https" && port == "443") {
+		port = ""
+	}
+	if port != "" {
+		normalized.Host = net.JoinHostPort(host, port)
+	} else if strings.Contains(host, ":") {
+		// IPv6 address
+		normalized.Host = "[" + host + "]"
+	} else {
+		normalized.Host = host
+	}
+	if normalized.Path == "" && normalized.Opaque == "" {
+		normalized.Path = "/"
+	}
+	return &normalized
+}
+
+// ProbeTimeout returns the duration a probe of the endpoint may take at most,
+// which is below its frequency, so that a probe never overruns the next one.
+func (e Endpoint) ProbeTimeout() time.Duration {
+	if e.Timeout > 0 {
+		return e.Timeout
+	}
+	return min(DefaultTimeout, e.Frequency-e.Frequency/10)
+}
+
+// endpointFields are the JSON field names of EndpointPayload.
+var endpointFields = func() map[string]bool {
+	fields := make(map[string]bool)
+	t := reflect.TypeOf(EndpointPayload{})
+	for i := range t.NumField() {
+		name, _, _ := strings.Cut(t.Field(i).Tag.Get("json"), ",")
+		if name != "" && name != "-" {
+			fields[name] = true
+		}
+	}
+	return fields
+}()
+
+// ParseEndpointFields parses raw as a comma-separated list of JSON field names
+// of EndpointPayload (e.g. "identifier,url,method"), or returns an error, if
+// one of them is not such a field.
+func ParseEndpointFields(raw string) ([]string, error) {
+	fields := splitList(raw)
+	for _, field := range fields {
+		if !endpointFields[field] {
+			return nil, fmt.Errorf(`"%s" is not a field of an endpoint`, field)
+		}
+	}
+	return fields, nil
+}
@@ -0,0 +1,478 @@
+package meow
+
+import (
+	"encoding/json"
+	"errors"
+	"maps"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// validPayload returns the payload of a valid endpoint probed every minute,
+// which the tests modify.
+func validPayload() EndpointPayload {
+	return EndpointPayload{
+		Identifier:   "libvirt",
+		URL:          "https://libvirt.org",
+		Method:       "GET",
+		StatusOnline: 200,
+		Frequency:    "1m",
+		FailAfter:    3,
+	}
+}
+
+// fieldOf returns the field the error err is attributed to, or an empty
+// string, if it is not a *FieldError.
+func fieldOf(err error) string {
+	var fieldErr *FieldError
+	if errors.As(err, &fieldErr) {
+		return fieldErr.Field
+	}
+	return ""
+}
+
+func TestProbeTimeout(t *testing.T) {
+	tests := []struct {
+		name      string
+		frequency time.Duration
+		timeout   time.Duration
+		expected  time.Duration
+	}{
+		{"default", time.Minute, 0, DefaultTimeout},
+		{"default for a frequency of 5s", 5 * time.Second, 0, 4500 * time.Millisecond},
+		{"default for a frequency of 10s", 10 * time.Second, 0, 9 * time.Second},
+		{"default for a frequency of 11s", 11 * time.Second, 0, 9900 * time.Millisecond},
+		{"configured", time.Minute, 30 * time.Second, 30 * time.Second},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			endpoint := Endpoint{Frequency: test.frequency, Timeout: test.timeout}
+			if actual := endpoint.ProbeTimeout(); actual != test.expected {
+				t.Errorf("expected probe timeout %v, got %v", test.expected, actual)
+			}
+			if actual := endpoint.ProbeTimeout(); actual >= test.frequency {
+				t.Errorf("expected probe timeout %v below frequency %v", actual, test.frequency)
+			}
+		})
+	}
+	if DefaultTimeout != 10*time.Second {
+		t.Errorf("expected default timeout of 10s, got %v", DefaultTimeout)
+	}
+}
+
+func TestEndpointTimeout(t *testing.T) {
+	tests := []struct {
+		name      string
+		frequency string
+		timeout   string
+		expected  time.Duration
+		invalid   bool
+	}{
+		{"absent", "1m", "", 0, false},
+		{"below frequency", "1m", "59s", 59 * time.Second, false},
+		{"equal to frequency", "1m", "1m", 0, true},
+		{"above frequency", "1m", "2m", 0, true},
+		{"zero", "1m", "0s", 0, true},
+		{"negative", "1m", "-1s", 0, true},
+		{"malformed", "1m", "soon", 0, true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			payload := validPayload()
+			payload.Frequency, payload.Timeout = test.frequency, test.timeout
+			endpoint, err := EndpointFromPayload(payload)
+			if test.invalid {
+				if field := fieldOf(err); field != "timeout" {
+					t.Fatalf("expected error for field timeout, got %v", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("parse endpoint: %v", err)
+			}
+			if endpoint.Timeout != test.expected {
+				t.Errorf("expected timeout %v, got %v", test.expected, endpoint.Timeout)
+			}
+		})
+	}
+}
+
+func TestEndpointTimeoutStoredAsZero(t *testing.T) {
+	endpoint, err := EndpointFromPayload(validPayload())
+	if err != nil {
+		t.Fatalf("parse endpoint: %v", err)
+	}
+	m := storedFields(t, endpoint)
+	if m["timeout"] != "0s" {
+		t.Fatalf(`expected timeout stored as "0s", got "%s"`, m["timeout"])
+	}
+	stored, err := EndpointFromMap(m)
+	if err != nil {
+		t.Fatalf("parse stored endpoint: %v", err)
+	}
+	if stored.Timeout != 0 || stored.ProbeTimeout() != DefaultTimeout {
+		t.Errorf("expected no timeout, got %v (probe timeout %v)", stored.Timeout, stored.ProbeTimeout())
+	}
+}
+
+func TestValidIdentifier(t *testing.T) {
+	tests := []struct {
+		identifier string
+		valid      bool
+	}{
+		{"libvirt", true},
+		{"api-gateway", true},
+		{"web2", true},
+		{"LibVirt", false},
+		{"API", false},
+		{"2fa", false},
+		{"-api", false},
+		{"a", false},
+		{"", false},
+		{"weird_id", false},
+		{"weird-id!", false},
+		{"api/v2", false},
+		{"api gateway", false},
+		{"libvirt\n", false},
+	}
+	for _, test := range tests {
+		if valid := ValidIdentifier(test.identifier); valid != test.valid {
+			t.Errorf("expected ValidIdentifier(%q) to be %t", test.identifier, test.valid)
+		}
+		payload := validPayload()
+		payload.Identifier = test.identifier
+		data, err := json.Marshal(payload)
+		if err != nil {
+			t.Fatalf("marshal payload: %v", err)
+		}
+		_, err = EndpointFromJSON(string(data))
+		if test.valid && err != nil {
+			t.Errorf("expected identifier %q to be accepted, got %v", test.identifier, err)
+		} else if !test.valid && fieldOf(err) != "identifier" {
+			t.Errorf("expected identifier %q to be rejected, got %v", test.identifier, err)
+		}
+	}
+}
+
+func TestEndpointFromJSONValidation(t *testing.T) {
+	tests := []struct {
+		name  string
+		json  string
+		field string
+	}{
+		{"valid", `{"identifier":"libvirt","url":"https://libvirt.org","method":"GET","status_online":200}`, ""},
+		{"valid http", `{"identifier":"libvirt","url":"http://libvirt.org:8080/health","method":"HEAD","status_online":204}`, ""},
+		{"non-standard method", `{"identifier":"libvirt","url":"https://libvirt.org","method":"BANANA","status_online":200}`, "method"},
+		{"lowercase method", `{"identifier":"libvirt","url":"https://libvirt.org","method":"get","status_online":200}`, "method"},
+		{"no method", `{"identifier":"libvirt","url":"https://libvirt.org","status_online":200}`, "method"},
+		{"CONNECT without target", `{"identifier":"libvirt","url":"https://libvirt.org","method":"CONNECT","status_online":200}`, "connect_target"},
+		{"relative URL", `{"identifier":"libvirt","url":"/health","method":"GET","status_online":200}`, "url"},
+		{"URL without host", `{"identifier":"libvirt","url":"https://","method":"GET","status_online":200}`, "url"},
+		{"ftp URL", `{"identifier":"libvirt","url":"ftp://libvirt.org","method":"GET","status_online":200}`, "url"},
+		{"malformed URL", `{"identifier":"libvirt","url":"https://libvirt.org:port","method":"GET","status_online":200}`, "url"},
+		{"status below 100", `{"identifier":"libvirt","url":"https://libvirt.org","method":"GET","status_online":99}`, "status_online"},
+		{"status above 599", `{"identifier":"libvirt","url":"https://libvirt.org","method":"GET","status_online":600}`, "status_online"},
+		{"status beyond uint16", `{"identifier":"libvirt","url":"https://libvirt.org","method":"GET","status_online":99999}`, "status_online"},
+		{"no status", `{"identifier":"libvirt","url":"https://libvirt.org","method":"GET"}`, "status_online"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := EndpointFromJSON(test.json)
+			if test.field == "" {
+				if err != nil {
+					t.Fatalf("expected endpoint to be valid, got %v", err)
+				}
+				return
+			}
+			if field := fieldOf(err); field != test.field {
+				t.Fatalf("expected error for field %s, got %v", test.field, err)
+			}
+		})
+	}
+}
+
+// mustEndpoint parses payload, or fails t, if it is invalid.
+func mustEndpoint(t *testing.T, payload EndpointPayload) *Endpoint {
+	t.Helper()
+	endpoint, err := EndpointFromPayload(payload)
+	if err != nil {
+		t.Fatalf("parse endpoint: %v", err)
+	}
+	return endpoint
+}
+
+func TestEndpointEqual(t *testing.T) {
+	tests := []struct {
+		name   string
+		modify func(p *EndpointPayload)
+		equal  bool
+	}{
+		{"identical", func(p *EndpointPayload) {}, true},
+		{"trailing slash of root", func(p *EndpointPayload) { p.URL = "https://libvirt.org/" }, true},
+		{"default port", func(p *EndpointPayload) { p.URL = "https://libvirt.org:443" }, true},
+		{"default port and trailing slash", func(p *EndpointPayload) { p.URL = "https://libvirt.org:443/" }, true},
+		{"upper case host", func(p *EndpointPayload) { p.URL = "https://LibVirt.org" }, true},
+		{"upper case scheme", func(p *EndpointPayload) { p.URL = "HTTPS://libvirt.org" }, true},
+		{"other port", func(p *EndpointPayload) { p.URL = "https://libvirt.org:8443" }, false},
+		{"port of other scheme", func(p *EndpointPayload) { p.URL = "https://libvirt.org:80" }, false},
+		{"other path", func(p *EndpointPayload) { p.URL = "https://libvirt.org/docs" }, false},
+		{"same frequency in seconds", func(p *EndpointPayload) { p.Frequency = "60" }, true},
+		{"same frequency otherwise written", func(p *EndpointPayload) { p.Frequency = "60s" }, true},
+		{"other frequency", func(p *EndpointPayload) { p.Frequency = "2m" }, false},
+		{"other method", func(p *EndpointPayload) { p.Method = "HEAD" }, false},
+		{"other status", func(p *EndpointPayload) { p.StatusOnline = 204 }, false},
+		{"other fail after", func(p *EndpointPayload) { p.FailAfter = 5 }, false},
+		{"other timeout", func(p *EndpointPayload) { p.Timeout = "5s" }, false},
+		{"write metadata", func(p *EndpointPayload) { p.Version, p.UpdatedBy = 7, "admin" }, true},
+	}
+	stored := mustEndpoint(t, validPayload())
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			payload := validPayload()
+			test.modify(&payload)
+			posted := mustEndpoint(t, payload)
+			if equal := stored.Equal(posted); equal != test.equal {
+				t.Errorf("expected Equal to be %t, got %t", test.equal, equal)
+			}
+			if equal := posted.Equal(stored); equal != test.equal {
+				t.Errorf("expected Equal to be symmetric (%t), got %t", test.equal, equal)
+			}
+		})
+	}
+}
+
+func TestEndpointEqualNil(t *testing.T) {
+	var none *Endpoint
+	endpoint := mustEndpoint(t, validPayload())
+	if !none.Equal(nil) {
+		t.Error("expected nil to equal nil")
+	}
+	if none.Equal(endpoint) || endpoint.Equal(nil) {
+		t.Error("expected nil not to equal an endpoint")
+	}
+}
+
+func TestParseFrequency(t *testing.T) {
+	tests := []struct {
+		raw      string
+		expected time.Duration
+		invalid  bool
+	}{
+		{"30", 30 * time.Second, false},
+		{"5", 5 * time.Second, false},
+		{"30s", 30 * time.Second, false},
+		{"1m30s", 90 * time.Second, false},
+		{"1h", time.Hour, false},
+		{"30.5", 0, true},
+		{"-30", 0, true},
+		{"thirty", 0, true},
+		{"", 0, true},
+	}
+	for _, test := range tests {
+		frequency, err := ParseFrequency(test.raw)
+		if test.invalid {
+			if err == nil {
+				t.Errorf("expected %q to be rejected, got %v", test.raw, frequency)
+			}
+			continue
+		}
+		if err != nil || frequency != test.expected {
+			t.Errorf("expected %q to be parsed as %v, got %v (%v)", test.raw, test.expected, frequency, err)
+		}
+	}
+}
+
+func TestEndpointFrequency(t *testing.T) {
+	tests := []struct {
+		name      string
+		min       time.Duration
+		frequency string
+		expected  string
+	}{
+		{"integer seconds", 0, "30", "30s"},
+		{"duration", 0, "1m30s", "1m30s"},
+		{"default minimum", 0, "5s", "5s"},
+		{"integer seconds at default minimum", 0, "5", "5s"},
+		{"below default minimum", 0, "10ms", ""},
+		{"integer seconds below default minimum", 0, "4", ""},
+		{"zero", 0, "0", ""},
+		{"configured minimum", time.Minute, "1m", "1m0s"},
+		{"below configured minimum", time.Minute, "30", ""},
+	}
+	defer ApplySettings(CurrentSettings())
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			settings := DefaultSettings()
+			if test.min > 0 {
+				settings.MinFrequency = test.min
+			}
+			ApplySettings(settings)
+			payload := validPayload()
+			payload.Frequency = test.frequency
+			endpoint, err := EndpointFromPayload(payload)
+			if test.expected == "" {
+				if field := fieldOf(err); field != "frequency" {
+					t.Fatalf("expected error for field frequency, got %v", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("parse endpoint: %v", err)
+			}
+			// normalized before storage
+			if frequency := endpoint.ToPayload().Frequency; frequency != test.expected {
+				t.Errorf(`expected frequency "%s", got "%s"`, test.expected, frequency)
+			}
+		})
+	}
+}
+
+func TestMinFrequencySetting(t *testing.T) {
+	if min := DefaultSettings().MinFrequency; min != 5*time.Second {
+		t.Errorf("expected default minimum frequency of 5s, got %v", min)
+	}
+	tests := []struct {
+		raw      string
+		expected time.Duration
+		invalid  bool
+	}{
+		{"30s", 30 * time.Second, false},
+		{"0s", 0, true},
+		{"soon", 0, true},
+		// above the default frequency
+		{"1h", 0, true},
+	}
+	for _, test := range tests {
+		settings, err := LoadSettings(func(name string) (string, bool) {
+			if name == "MEOW_MIN_FREQUENCY" {
+				return test.raw, true
+			}
+			return "", false
+		})
+		if test.invalid {
+			if err == nil {
+				t.Errorf("expected minimum frequency %q to be rejected", test.raw)
+			}
+			continue
+		}
+		if err != nil || settings.MinFrequency != test.expected {
+			t.Errorf("expected minimum frequency %v from %q, got %v", test.expected, test.raw, err)
+		}
+	}
+}
+
+func TestEndpointPayloadRoundTrip(t *testing.T) {
+	payload := validPayload()
+	payload.Timeout = "5s"
+	payload.Owner = "ops"
+	payload.Tags = []string{"infra", "docs"}
+	payload.CheckPaths = []string{"/healthz"}
+	payload.HostHeader = "libvirt.example.com"
+	endpoint, err := payload.ToEndpoint()
+	if err != nil {
+		t.Fatalf("convert payload: %v", err)
+	}
+	converted := endpoint.ToPayload()
+	again, err := converted.ToEndpoint()
+	if err != nil {
+		t.Fatalf("convert payload %+v again: %v", converted, err)
+	}
+	if !again.Equal(endpoint) || !reflect.DeepEqual(again.ToPayload(), converted) {
+		t.Errorf("expected %+v after round trip, got %+v", converted, again.ToPayload())
+	}
+	if converted.Frequency != "1m0s" || converted.Timeout != "5s" || converted.Owner != "ops" {
+		t.Errorf("expected normalized payload, got %+v", converted)
+	}
+	payload.URL = "ftp://libvirt.org"
+	if _, err := payload.ToEndpoint(); fieldOf(err) != "url" {
+		t.Errorf("expected invalid URL to be rejected, got %v", err)
+	}
+}
+
+// storedFields returns the fields endpoint is stored with.
+func storedFields(t *testing.T, endpoint *Endpoint) map[string]string {
+	t.Helper()
+	fields, err := EndpointFields(endpoint, "")
+	if err != nil {
+		t.Fatalf("serialize endpoint: %v", err)
+	}
+	m := make(map[string]string)
+	for i := 0; i+1 < len(fields); i += 2 {
+		m[fields[i]] = fields[i+1]
+	}
+	return m
+}
+
+func TestEndpointHeadersRoundTrip(t *testing.T) {
+	payload := validPayload()
+	payload.Headers = map[string]string{"authorization": "Bearer secret", "X-Api-Key": "key"}
+	endpoint, err := payload.ToEndpoint()
+	if err != nil {
+		t.Fatalf("convert payload: %v", err)
+	}
+	expected := map[string]string{"Authorization": "Bearer secret", "X-Api-Key": "key"}
+	if !reflect.DeepEqual(endpoint.Headers, expected) {
+		t.Fatalf("expected headers %v, got %v", expected, endpoint.Headers)
+	}
+	data, err := endpoint.JSON()
+	if err != nil {
+		t.Fatalf("marshal endpoint: %v", err)
+	}
+	fromJSON, err := EndpointFromJSON(string(data))
+	if err != nil {
+		t.Fatalf("parse %s: %v", data, err)
+	}
+	if !reflect.DeepEqual(fromJSON.Headers, expected) || !reflect.DeepEqual(fromJSON.ToPayload().Headers, expected) {
+		t.Errorf("expected headers %v from JSON, got %v", expected, fromJSON.Headers)
+	}
+	m := storedFields(t, endpoint)
+	var stored map[string]string
+	if err := json.Unmarshal([]byte(m["headers"]), &stored); err != nil || !reflect.DeepEqual(stored, expected) {
+		t.Fatalf(`expected headers stored JSON-encoded, got "%s"`, m["headers"])
+	}
+	fromMap, err := EndpointFromMap(m)
+	if err != nil {
+		t.Fatalf("parse stored endpoint: %v", err)
+	}
+	if !reflect.DeepEqual(fromMap.Headers, expected) {
+		t.Errorf("expected stored headers %v, got %v", expected, fromMap.Headers)
+	}
+}
+
+func TestEndpointHeadersStoredBefore(t *testing.T) {
+	endpoint, err := EndpointFromPayload(validPayload())
+	if err != nil {
+		t.Fatalf("parse endpoint: %v", err)
+	}
+	tests := map[string]struct {
+		change   func(map[string]string)
+		expected map[string]string
+	}{
+		"without headers": {
+			change:   func(m map[string]string) {},
+			expected: nil,
+		},
+		"missing": {
+			change:   func(m map[string]string) { delete(m, "headers") },
+			expected: nil,
+		},
+		"empty": {
+			change:   func(m map[string]string) { m["headers"] = "" },
+			expected: nil,
+		},
+	}
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			m := storedFields(t, endpoint)
+			test.change(m)
+			stored, err := EndpointFromMap(m)
+			if err != nil {
+				t.Fatalf("parse stored endpoint: %v", err)
+			}
+			if !maps.Equal(stored.Headers, test.expected) {
+				t.Errorf("expected headers %v, got %v", test.expected, stored.Headers)
+			}
+		})
+	}
+}
@@ -0,0 +1,44 @@
+package meow
+
+import (
+	"errors"
+	"fmt"
+)
+
+// ErrNotFound indicates that a stored entity (e.g. an endpoint) does not exist.
+var ErrNotFound = errors.New("not found")
+
+// ErrConflict indicates that an entity cannot be stored, because it conflicts
+// with an existing one.
+var ErrConflict = errors.New("conflict")
+
+// ErrPreconditionFailed indicates that an entity was not stored, because it
+// was not stored with a version demanded (e.g. by If-Match).
+var ErrPreconditionFailed = errors.New("precondition failed")
+
+// ErrInvalidCursor indicates that a listing cannot be continued at a cursor,
+// because it was not issued by the listing.
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+// ErrLimitReached indicates that an entity cannot be created, because a
+// configured limit (e.g. the maximum number of endpoints) has been reached.
+var ErrLimitReached = errors.New("limit reached")
+
+// ErrForbidden indicates that the caller is not allowed to access an entity
+// (e.g. an endpoint owned by someone else).
+var ErrForbidden = errors.New("forbidden")
+
+// FieldError indicates that the field of an endpoint with the given name (as
+// in its JSON representation) is invalid.
+type FieldError struct {
+	Field string
+	Err   error
+}
+
+func (e *FieldError) Error() string {
+	return fmt.Sprintf("%s: %v", e.Field, e.Err)
+}
+
+func (e *FieldError) Unwrap() error {
+	return e.Err
+}
@@ -0,0 +1,165 @@
+package meow
+
+import (
+	"encoding/json"
+	"fmt"
+	"maps"
+	"net/http"
+	"reflect"
+	"slices"
+	"strings"
+	"time"
+)
+
+// FieldSchema describes a field of the JSON representation of an endpoint, so
+// that clients can build forms and hint at the validation of the config
+// server.
+type FieldSchema struct {
+	Name string `json:"name"`
+
+	// Type is string, integer, boolean, duration (a string understood by
+	// time.ParseDuration), array (of Items), or object.
+	Type  string `json:"type"`
+	Items string `json:"items,omitempty"`
+
+	// Required fields have no default. ReadOnly fields are maintained by the
+	// config server.
+	Required bool `json:"required"`
+	ReadOnly bool `json:"read_only,omitempty"`
+
+	// Default is the value applied if the field is omitted, and Enum,
+	// Maximum, and Rule restrict the values accepted.
+	Default any      `json:"default,omitempty"`
+	Enum    []string `json:"enum,omitempty"`
+	Maximum uint64   `json:"maximum,omitempty"`
+	Rule    string   `json:"rule,omitempty"`
+}
+
+// EndpointSchemaPayload describes the fields of an endpoint in the order of
+// EndpointPayload.
+type EndpointSchemaPayload struct {
+	Fields []FieldSchema `json:"fields"`
+}
+
+// fieldRules are the validation rules of the fields of EndpointPayload (see
+// EndpointFromPayload) by their JSON names.
+var fieldRules = map[string]string{
+	"identifier":           fmt.Sprintf(`matches "%s"`, IdentifierPattern),
+	"url":                  "an absolute http or https URL",
+	"status_online":        "a status code from 100 to 599",
+	"frequency":            "at least the minimum frequency; also accepted as a number of seconds",
+	"max_ttfb":             "not negative",
+	"response_schema":      "a JSON schema the response body must conform to",
+	"expect_trailer":       "a header name",
+	"expect_trailer_value": "requires expect_trailer",
+	"extract_regex":        "a regular expression with a capture group; requires extract_header",
+	"extract_header":       "a header name; requires extract_regex",
+	"host_header":          "a host, optionally followed by a port",
+	"expect_body_hash":     "a hex-encoded SHA-256 hash",
+	"capture_headers":      fmt.Sprintf("up to %d header names", MaxCaptureHeaders),
+	"timeout":              fmt.Sprintf("positive, and below the frequency; defaults to %v, or nine tenths of the frequency, if shorter", DefaultTimeout),
+	"check_paths":          fmt.Sprintf("up to %d paths relative to the URL", MaxCheckPaths),
+	"owner":                fmt.Sprintf(`matches "%s"`, IdentifierPattern),
+	"stability_window":     "requires stability_threshold",
+	"stability_threshold":  "at most stability_window; requires stability_window",
+	"proxy":                "an http, https, or socks5 URL",
+	"expect_build_header":  "a header name",
+	"tags":                 fmt.Sprintf(`up to %d tags matching "%s"`, MaxTags, IdentifierPattern),
+	"headers":              fmt.Sprintf("up to %d header names with their values", MaxRequestHeaders),
+	"protocol":             fmt.Sprintf(`%s requires method POST and a path of the form "/package.Service/Method"`, ProtocolGRPCWeb),
+	"expect_status_text":   "no control characters",
+	"pinned_cert_sha256":   "a hex-encoded SHA-256 fingerprint; requires an https URL",
+	"body_contains":        fmt.Sprintf("up to %d bytes; not supported by methods HEAD and CONNECT", MaxBodySize),
+	"body_not_contains":    fmt.Sprintf("up to %d bytes; not supported by methods HEAD and CONNECT", MaxBodySize),
+	"resolver":             "an address (host:port)",
+	"connect_target":       "an address (host:port); requires method CONNECT",
+	"dial_timeout":         "not negative, and at most the timeout",
+}
+
+// fieldMaximums are the maximums of the integer fields below the maximum of
+// their type.
+var fieldMaximums = map[string]uint64{
+	"status_online":     599,
+	"stability_window":  MaxStabilityWindow,
+	"concurrent_probes": MaxConcurrentProbes,
+}
+
+// EndpointSchema describes the fields of EndpointPayload, with the defaults
+// of the given settings. The fields and their types are derived from the
+// payload (and the Endpoint fields of the same name), so that they always
+// match the fields accepted.
+func EndpointSchema(settings Settings) EndpointSchemaPayload {
+	defaults := map[string]any{
+		"frequency":  settings.DefaultFrequency.String(),
+		"fail_after": settings.DefaultFailAfter,
+		"protocol":   ProtocolHTTP,
+	}
+	enums := map[string][]string{
+		"method":             append(slices.Sorted(maps.Keys(methodsAllowed)), http.MethodConnect),
+		"protocol":           {ProtocolHTTP, ProtocolGRPCWeb},
+		"redirect_counts_as": {string(StateOnline), string(StateDegraded), string(StateOffline)},
+	}
+	payloadType := reflect.TypeFor[EndpointPayload]()
+	endpointType := reflect.TypeFor[Endpoint]()
+	fields := make([]FieldSchema, 0, payloadType.NumField())
+	for i := range payloadType.NumField() {
+		field := payloadType.Field(i)
+		name, options, _ := strings.Cut(field.Tag.Get("json"), ",")
+		if name == "" || name == "-" {
+			continue
+		}
+		schema := FieldSchema{
+			Name:     name,
+			Default:  defaults[name],
+			Enum:     enums[name],
+			Maximum:  fieldMaximums[name],
+			Rule:     fieldRules[name],
+			ReadOnly: name == "version" || name == "updated_by",
+		}
+		schema.Required = options != "omitempty" && schema.Default == nil
+		if endpointField, ok := endpointType.FieldByName(field.Name); ok &&
+			endpointField.Type == reflect.TypeFor[time.Duration]() {
+			schema.Type = "duration"
+		} else {
+			schema.Type = schemaType(field.Type)
+		}
+		if field.Type.Kind() == reflect.Slice && field.Type != reflect.TypeFor[json.RawMessage]() {
+			schema.Items = schemaType(field.Type.Elem())
+		}
+		if schema.Type == "integer" && schema.Maximum == 0 {
+			schema.Maximum = 1<<field.Type.Bits() - 1
+		}
+		fields = append(fields, schema)
+	}
+	return EndpointSchemaPayload{Fields: fields}
+}
+
+// schemaType returns the type of FieldSchema the values of type t are given
+// as in JSON.
+func schemaType(t reflect.Type) string {
+	if t == reflect.TypeFor[json.RawMessage]() {
+		return "object"
+	}
+	switch t.Kind() {
+	case reflect.String:
+		return "string"
+	case reflect.Bool:
+		return "boolean"
+	case reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return "integer"
+	case reflect.Slice:
+		return "array"
+	default:
+		return "object"
+	}
+}
+
+// JSON returns the EndpointSchemaPayload as JSON data, or an error, if it
+// cannot be serialized.
+func (p EndpointSchemaPayload) JSON() ([]byte, error) {
+	data, err := json.Marshal(p)
+	if err != nil {
+		return nil, fmt.Errorf("marshal endpoint schema as JSON: %v", err)
+	}
+	return data, nil
+}
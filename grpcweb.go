@@ -0,0 +1,109 @@
+package meow
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"net/http"
+	"net/textproto"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Protocols endpoints can be probed with. Endpoints without a protocol are
+// probed using plain HTTP requests.
+const (
+	ProtocolHTTP    = "http"
+	ProtocolGRPCWeb = "grpc-web"
+)
+
+// GRPCWebContentType is the content type of gRPC-web requests, whose messages
+// are serialized as protocol buffers.
+const GRPCWebContentType = "application/grpc-web+proto"
+
+// grpcWebTrailerFlag marks a gRPC-web frame as holding the trailers rather than
+// a message.
+const grpcWebTrailerFlag = 0x80
+
+// grpcWebPathPattern matches the paths of gRPC methods, i.e. /package.Service/Method.
+var grpcWebPathPattern = regexp.MustCompile(`^/[A-Za-z_][A-Za-z0-9_.]*/[A-Za-z_][A-Za-z0-9_]*$`)
+
+// validateProtocol checks that protocol is supported, and that the URL u (to
+// be requested with method) suits it.
+func validateProtocol(protocol, method string, u *url.URL) error {
+	switch protocol {
+	case "", ProtocolHTTP:
+		return nil
+	case ProtocolGRPCWeb:
+		if method != http.MethodPost {
+			return fmt.Errorf("protocol %s requires method POST, not %s", protocol, method)
+		}
+		if !grpcWebPathPattern.MatchString(u.Path) {
+			return fmt.Errorf(`path "%s" is not of the form "/package.Service/Method"`, u.Path)
+		}
+		return nil
+	default:
+		return fmt.Errorf(`protocol "%s" is neither %s nor %s`, protocol, ProtocolHTTP, ProtocolGRPCWeb)
+	}
+}
+
+// GRPCWebFrame frames the serialized message as a gRPC-web request body: a
+// flag byte (uncompressed), the message length (big-endian), and the message.
+func GRPCWebFrame(message []byte) []byte {
+	frame := make([]byte, 5, 5+len(message))
+	binary.BigEndian.PutUint32(frame[1:], uint32(len(message)))
+	return append(frame, message...)
+}
+
+// GRPCWebStatus evaluates the gRPC status of a gRPC-web response, which is
+// looked up in the headers (for trailers-only responses), the HTTP trailers,
+// and the trailer frame of the body, in that order. An error is returned
+// unless the status is OK (0), or if no status is found. If truncated is set,
+// body is only the beginning of the response body.
+func GRPCWebStatus(header, trailer http.Header, body []byte, truncated bool) error {
+	for _, h := range []http.Header{header, trailer, grpcWebTrailers(body)} {
+		if raw := h.Get("Grpc-Status"); raw != "" {
+			code, err := strconv.Atoi(raw)
+			if err != nil {
+				return fmt.Errorf(`grpc-status "%s" is not a number`, raw)
+			}
+			if code != 0 {
+				return fmt.Errorf("grpc-status %d: %s", code, h.Get("Grpc-Message"))
+			}
+			return nil
+		}
+	}
+	if truncated {
+		return fmt.Errorf("body exceeds %d bytes, cannot find grpc-status", MaxBodySize)
+	}
+	return fmt.Errorf("response lacks grpc-status")
+}
+
+// grpcWebTrailers returns the trailers held by the trailer frame of the
+// gRPC-web response body, or nil, if there is none (or if it is incomplete).
+func grpcWebTrailers(body []byte) http.Header {
+	for len(body) >= 5 {
+		flag, length := body[0], binary.BigEndian.Uint32(body[1:5])
+		body = body[5:]
+		if uint64(length) > uint64(len(body)) {
+			return nil
+		}
+		frame := body[:length]
+		body = body[length:]
+		if flag&grpcWebTrailerFlag == 0 {
+			continue
+		}
+		// the trailers are formatted like HTTP/1 header fields
+		raw := strings.TrimRight(string(frame), "\r\n") + "\r\n\r\n"
+		reader := textproto.NewReader(bufio.NewReader(bytes.NewReader([]byte(raw))))
+		header, err := reader.ReadMIMEHeader()
+		if err != nil {
+			return nil
+		}
+		return http.Header(header)
+	}
+	return nil
+}
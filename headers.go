@@ -0,0 +1,124 @@
+package meow
+
+import (
+	"fmt"
+	"maps"
+	"net/http"
+	"slices"
+	"strings"
+)
+
+// MaxCaptureHeaders is the maximum number of response headers captured per
+// endpoint.
+const MaxCaptureHeaders = 10
+
+// MaxCapturedValueSize is the maximum number of bytes of a captured header
+// value; longer values are truncated.
+const MaxCapturedValueSize = 256
+
+// Redacted replaces the value of captured headers that are sensitive.
+const Redacted = "[redacted]"
+
+// validateCaptureHeaders checks that names are at most MaxCaptureHeaders valid
+// header names, and returns them in their canonical form.
+func validateCaptureHeaders(names []string) ([]string, error) {
+	if len(names) > MaxCaptureHeaders {
+		return nil, fmt.Errorf("%d headers to capture exceed the maximum of %d",
+			len(names), MaxCaptureHeaders)
+	}
+	canonical := make([]string, 0, len(names))
+	for _, name := range names {
+		if !headerNamePattern.MatchString(name) {
+			return nil, fmt.Errorf(`"%s" is not a valid header name`, name)
+		}
+		canonical = append(canonical, http.CanonicalHeaderKey(name))
+	}
+	return canonical, nil
+}
+
+// CaptureHeaders returns the values of the endpoint's CaptureHeaders found in
+// header (multiple values joined by ", "), truncated to MaxCapturedValueSize.
+// The values of the headers listed in redact are replaced by Redacted.
+func (e Endpoint) CaptureHeaders(header http.Header, redact []string) map[string]string {
+	captured := make(map[string]string)
+	for _, name := range e.CaptureHeaderNames {
+		values, ok := header[name]
+		if !ok {
+			continue
+		}
+		if slices.ContainsFunc(redact, func(r string) bool { return strings.EqualFold(r, name) }) {
+			captured[name] = Redacted
+			continue
+		}
+		value := strings.Join(values, ", ")
+		if len(value) > MaxCapturedValueSize {
+			value = value[:MaxCapturedValueSize]
+		}
+		captured[name] = value
+	}
+	return captured
+}
+
+// MaxRequestHeaders is the maximum number of request headers per endpoint, and
+// of default headers.
+const MaxRequestHeaders = 20
+
+// validateRequestHeaders checks that headers are at most MaxRequestHeaders
+// headers with valid names and values, and returns them with their names in
+// canonical form. The Host header must be set as the host_header instead.
+func validateRequestHeaders(headers map[string]string) (map[string]string, error) {
+	if len(headers) > MaxRequestHeaders {
+		return nil, fmt.Errorf("%d request headers exceed the maximum of %d", len(headers), MaxRequestHeaders)
+	}
+	canonical := make(map[string]string, len(headers))
+	for name, value := range headers {
+		if !headerNamePattern.MatchString(name) {
+			return nil, fmt.Errorf(`"%s" is not a valid header name`, name)
+		}
+		if strings.ContainsAny(value, "\r\n\x00") {
+			return nil, fmt.Errorf(`value of header "%s" contains control characters`, name)
+		}
+		name = http.CanonicalHeaderKey(name)
+		if name == "Host" || name == "Content-Length" {
+			return nil, fmt.Errorf(`header "%s" cannot be set as a request header`, name)
+		}
+		canonical[name] = value
+	}
+	return canonical, nil
+}
+
+// ParseHeaderList parses raw as a list of headers of the form "Name:value"
+// separated by commas, e.g. "X-Monitor:meow,X-Team:sre", whose values thus
+// cannot contain commas.
+func ParseHeaderList(raw string) (map[string]string, error) {
+	headers := make(map[string]string)
+	for _, element := range splitList(raw) {
+		name, value, ok := strings.Cut(element, ":")
+		if !ok {
+			return nil, fmt.Errorf(`header "%s" is not of the form "Name:value"`, element)
+		}
+		headers[strings.TrimSpace(name)] = strings.TrimSpace(value)
+	}
+	return validateRequestHeaders(headers)
+}
+
+// FormatHeaderList formats headers as a list to be parsed by ParseHeaderList.
+func FormatHeaderList(headers map[string]string) string {
+	elements := make([]string, 0, len(headers))
+	for _, name := range slices.Sorted(maps.Keys(headers)) {
+		elements = append(elements, name+":"+headers[name])
+	}
+	return strings.Join(elements, ",")
+}
+
+// SetRequestHeaders sets the DefaultHeaders of the settings in effect on
+// header, and then the endpoint's Headers, which thereby take
+// precedence over default headers of the same name.
+func (e Endpoint) SetRequestHeaders(header http.Header) {
+	for name, value := range CurrentSettings().DefaultHeaders {
+		header.Set(name, value)
+	}
+	for name, value := range e.Headers {
+		header.Set(name, value)
+	}
+}
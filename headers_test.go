@@ -0,0 +1,66 @@
+package meow
+
+import (
+	"context"
+	"maps"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestParseHeaderList(t *testing.T) {
+	tests := []struct {
+		raw      string
+		expected map[string]string
+		ok       bool
+	}{
+		{"", map[string]string{}, true},
+		{"X-Monitor:meow", map[string]string{"X-Monitor": "meow"}, true},
+		{" x-monitor : meow , X-Team:sre", map[string]string{"X-Monitor": "meow", "X-Team": "sre"}, true},
+		{"Traceparent:00-abc:def", map[string]string{"Traceparent": "00-abc:def"}, true},
+		{"X-Monitor", nil, false},
+		{"Host:example.com", nil, false},
+		{"X Monitor:meow", nil, false},
+	}
+	for _, test := range tests {
+		headers, err := ParseHeaderList(test.raw)
+		if (err == nil) != test.ok || !maps.Equal(headers, test.expected) {
+			t.Errorf(`expected "%s" to be parsed as %v (ok: %t), got %v (%v)`, test.raw, test.expected, test.ok, headers, err)
+		}
+		if err == nil {
+			if again, err := ParseHeaderList(FormatHeaderList(headers)); err != nil || !maps.Equal(again, headers) {
+				t.Errorf("expected %v after formatting and parsing, got %v (%v)", headers, again, err)
+			}
+		}
+	}
+}
+
+func TestSetRequestHeaders(t *testing.T) {
+	defer ApplySettings(CurrentSettings())
+	settings := DefaultSettings()
+	settings.DefaultHeaders = map[string]string{"X-Monitor": "meow", "User-Agent": "meow/1"}
+	ApplySettings(settings)
+	received := make(chan http.Header, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received <- r.Header
+	}))
+	defer server.Close()
+	e := probedEndpoint(t, server, func(p *EndpointPayload) {
+		// overrides the default header regardless of the case of its name
+		p.Headers = map[string]string{"user-agent": "libvirt-check", "X-Api-Key": "key"}
+	})
+	if _, err := ProbeEndpoint(context.Background(), e); err != nil {
+		t.Fatalf("probe endpoint: %v", err)
+	}
+	header := <-received
+	expected := map[string]string{"X-Monitor": "meow", "User-Agent": "libvirt-check", "X-Api-Key": "key"}
+	for name, value := range expected {
+		if values := header.Values(name); len(values) != 1 || values[0] != value {
+			t.Errorf(`expected header %s: %s, got %v`, name, value, values)
+		}
+	}
+	effective := e.Effective(CurrentSettings())
+	if !maps.Equal(effective.Headers, expected) {
+		t.Errorf("expected effective headers %v, got %v", expected, effective.Headers)
+	}
+}
@@ -0,0 +1,180 @@
+package meow
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// HistoryEntry is the outcome of a single probe of an endpoint.
+type HistoryEntry struct {
+	Timestamp   time.Time   `json:"timestamp"`
+	Status      int         `json:"status"`
+	LatencyMS   int64       `json:"latency_ms"`
+	OK          bool        `json:"ok"`
+	State       State       `json:"state"`
+	FailureKind FailureKind `json:"failure_kind,omitempty"`
+}
+
+// HistoryKey returns the key of the list holding the history entries of the
+// endpoint identified by identifier, the most recent entry first.
+func HistoryKey(identifier string) string {
+	return "history:" + identifier
+}
+
+// JSON returns the HistoryEntry as JSON data, or an error, if it cannot be
+// serialized.
+func (h HistoryEntry) JSON() ([]byte, error) {
+	data, err := json.Marshal(h)
+	if err != nil {
+		return nil, fmt.Errorf("marshal history entry %v as JSON: %v", h, err)
+	}
+	return data, nil
+}
+
+// HistoryEntryFromJSON creates a HistoryEntry from the given JSON data.
+func HistoryEntryFromJSON(rawJSON string) (*HistoryEntry, error) {
+	var entry HistoryEntry
+	if err := json.Unmarshal([]byte(rawJSON), &entry); err != nil {
+		return nil, fmt.Errorf(`unmarshal history entry "%s": %v`, rawJSON, err)
+	}
+	return &entry, nil
+}
+
+// Uptime describes the share of successful probes of an endpoint within a time
+// window.
+type Uptime struct {
+	// Window is the time window considered.
+	Window time.Duration
+
+	// Raw is the ratio of successful probes among all Probes.
+	Raw    float64
+	Probes int
+
+	// Adjusted is the ratio of successful probes among the AdjustedProbes,
+	// which exclude the probes performed during maintenance, so that planned
+	// work does not count against the availability.
+	Adjusted       float64
+	AdjustedProbes int
+}
+
+// UptimePayload contains the same fields as Uptime, but as serializable
+// primitives with JSON tags. The ratios are null if there were no probes.
+type UptimePayload struct {
+	Window         string   `json:"window"`
+	Raw            *float64 `json:"raw"`
+	Probes         int      `json:"probes"`
+	Adjusted       *float64 `json:"adjusted"`
+	AdjustedProbes int      `json:"adjusted_probes"`
+}
+
+// ComputeUptime computes the uptime within the window ending at end from the
+// given history entries. Entries outside the window are ignored.
+func ComputeUptime(entries []HistoryEntry, window time.Duration, end time.Time) Uptime {
+	start := end.Add(-window)
+	uptime := Uptime{Window: window}
+	var ok, adjustedOK int
+	for _, entry := range entries {
+		if entry.Timestamp.Before(start) || entry.Timestamp.After(end) {
+			continue
+		}
+		uptime.Probes++
+		if entry.OK {
+			ok++
+		}
+		if entry.State == StateMaintenance {
+			continue
+		}
+		uptime.AdjustedProbes++
+		if entry.OK {
+			adjustedOK++
+		}
+	}
+	if uptime.Probes > 0 {
+		uptime.Raw = float64(ok) / float64(uptime.Probes)
+	}
+	if uptime.AdjustedProbes > 0 {
+		uptime.Adjusted = float64(adjustedOK) / float64(uptime.AdjustedProbes)
+	}
+	return uptime
+}
+
+// Payload converts the uptime to its payload representation.
+func (u Uptime) Payload() UptimePayload {
+	payload := UptimePayload{
+		Window:         u.Window.String(),
+		Probes:         u.Probes,
+		AdjustedProbes: u.AdjustedProbes,
+	}
+	if u.Probes > 0 {
+		payload.Raw = &u.Raw
+	}
+	if u.AdjustedProbes > 0 {
+		payload.Adjusted = &u.Adjusted
+	}
+	return payload
+}
+
+// JSON returns the Uptime's fields as JSON data, or an error, if it cannot be
+// serialized.
+func (u Uptime) JSON() ([]byte, error) {
+	data, err := json.Marshal(u.Payload())
+	if err != nil {
+		return nil, fmt.Errorf("marshal uptime %v as JSON: %v", u, err)
+	}
+	return data, nil
+}
+
+// Aggregation describes how the uptimes of multiple endpoints are combined.
+type Aggregation string
+
+// Aggregations of uptimes: the mean, or the worst (minimal) uptime.
+const (
+	AggregateMean Aggregation = "mean"
+	AggregateMin  Aggregation = "min"
+)
+
+// ParseAggregation parses raw as an Aggregation, or returns an error, if it is
+// neither mean nor min.
+func ParseAggregation(raw string) (Aggregation, error) {
+	switch aggregation := Aggregation(raw); aggregation {
+	case AggregateMean, AggregateMin:
+		return aggregation, nil
+	default:
+		return "", fmt.Errorf(`aggregation "%s" must be %s or %s`, raw, AggregateMean, AggregateMin)
+	}
+}
+
+// AggregateUptime combines the raw and the adjusted ratios of the given uptimes
+// using the aggregation. Uptimes without (adjusted) probes are left out, and
+// nil is returned for a ratio if there are none.
+func AggregateUptime(uptimes []Uptime, aggregation Aggregation) (raw, adjusted *float64) {
+	var raws, adjusteds []float64
+	for _, uptime := range uptimes {
+		if uptime.Probes > 0 {
+			raws = append(raws, uptime.Raw)
+		}
+		if uptime.AdjustedProbes > 0 {
+			adjusteds = append(adjusteds, uptime.Adjusted)
+		}
+	}
+	return aggregate(raws, aggregation), aggregate(adjusteds, aggregation)
+}
+
+func aggregate(ratios []float64, aggregation Aggregation) *float64 {
+	if len(ratios) == 0 {
+		return nil
+	}
+	result := ratios[0]
+	if aggregation == AggregateMin {
+		for _, ratio := range ratios[1:] {
+			result = min(result, ratio)
+		}
+		return &result
+	}
+	for _, ratio := range ratios[1:] {
+		result += ratio
+	}
+	result /= float64(len(ratios))
+	return &result
+}
@@ -0,0 +1,80 @@
+package meow
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"time"
+)
+
+// Incident is a period of time during which an endpoint was offline.
+type Incident struct {
+	// Start is the time of the first failed probe.
+	Start time.Time
+
+	// End is the time of the first successful probe after Start.
+	End time.Time
+
+	// MaxFailures is the number of consecutive failed probes.
+	MaxFailures int
+}
+
+// IncidentPayload contains the same fields as Incident, but as serializable
+// primitives with JSON tags, and additionally the incident's duration.
+type IncidentPayload struct {
+	Start       time.Time `json:"start"`
+	End         time.Time `json:"end"`
+	Duration    string    `json:"duration"`
+	MaxFailures int       `json:"max_failures"`
+}
+
+// Duration returns how long the incident lasted.
+func (i Incident) Duration() time.Duration {
+	return i.End.Sub(i.Start)
+}
+
+// Payload converts the incident to its serializable form.
+func (i Incident) Payload() IncidentPayload {
+	return IncidentPayload{i.Start, i.End, i.Duration().String(), i.MaxFailures}
+}
+
+// JSON returns the Incident's fields as JSON data, or an error, if it cannot be
+// serialized.
+func (i Incident) JSON() ([]byte, error) {
+	data, err := json.Marshal(i.Payload())
+	if err != nil {
+		return nil, fmt.Errorf("marshal incident %v as JSON: %v", i, err)
+	}
+	return data, nil
+}
+
+// IncidentFromMap creates a new Incident from the given map, which must provide
+// the fields start, end (both RFC 3339), and max_failures.
+func IncidentFromMap(m map[string]string) (*Incident, error) {
+	start, err := time.Parse(time.RFC3339Nano, m["start"])
+	if err != nil {
+		return nil, fmt.Errorf("parse start: %v", err)
+	}
+	end, err := time.Parse(time.RFC3339Nano, m["end"])
+	if err != nil {
+		return nil, fmt.Errorf("parse end: %v", err)
+	}
+	maxFailures, err := strconv.Atoi(m["max_failures"])
+	if err != nil {
+		return nil, fmt.Errorf("parse max_failures: %v", err)
+	}
+	return &Incident{start, end, maxFailures}, nil
+}
+
+// IncidentKey returns the key under which the incident of the endpoint
+// identified by identifier that started at start is stored.
+func IncidentKey(identifier string, start time.Time) string {
+	return fmt.Sprintf("incident:%s:%d", identifier, start.UnixMilli())
+}
+
+// IncidentIndexKey returns the key of the sorted set referring to the incident
+// keys of the endpoint identified by identifier, scored by their start (in
+// milliseconds since the epoch).
+func IncidentIndexKey(identifier string) string {
+	return "incidents:" + identifier
+}
@@ -0,0 +1,485 @@
+// Package valkeytest provides a Valkey server for tests, which keeps its data
+// in memory and speaks enough of RESP3 for the commands used by meow.
+package valkeytest
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"net"
+	"path"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/valkey-io/valkey-go"
+)
+
+// Server is a Valkey server holding strings, hashes, sets, and lists. Scripts,
+// transactions, sorted sets, expiry, and pub/sub are not supported.
+type Server struct {
+	listener net.Listener
+
+	mu      sync.Mutex
+	strings map[string]string
+	hashes  map[string]map[string]string
+	sets    map[string]map[string]bool
+	lists   map[string][]string
+}
+
+// NewServer starts a server listening on a local port, which is stopped when
+// the test t finishes.
+func NewServer(t testing.TB) *Server {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	s := &Server{
+		listener: listener,
+		strings:  make(map[string]string),
+		hashes:   make(map[string]map[string]string),
+		sets:     make(map[string]map[string]bool),
+		lists:    make(map[string][]string),
+	}
+	go s.serve()
+	t.Cleanup(func() { listener.Close() })
+	return s
+}
+
+// NewClient starts a server like NewServer, and returns a client connected to
+// it, which is closed when the test t finishes.
+func NewClient(t testing.TB) valkey.Client {
+	t.Helper()
+	return NewServer(t).Client(t)
+}
+
+// Client returns a client connected to the server, which is closed when the
+// test t finishes.
+func (s *Server) Client(t testing.TB) valkey.Client {
+	t.Helper()
+	client, err := valkey.NewClient(valkey.ClientOption{
+		InitAddress:       []string{s.listener.Addr().String()},
+		ForceSingleClient: true,
+		DisableCache:      true,
+	})
+	if err != nil {
+		t.Fatalf("connect to %s: %v", s.listener.Addr(), err)
+	}
+	t.Cleanup(client.Close)
+	return client
+}
+
+func (s *Server) serve() {
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+		go s.handle(conn)
+	}
+}
+
+func (s *Server) handle(conn net.Conn) {
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	w := bufio.NewWriter(conn)
+	for {
+		args, err := readCommand(r)
+		if err != nil {
+			return
+		}
+		s.mu.Lock()
+		reply := s.execute(strings.ToUpper(args[0]), args[1:])
+		s.mu.Unlock()
+		reply.write(w)
+		// flush once the pipelined commands are answered
+		if r.Buffered() == 0 {
+			if err := w.Flush(); err != nil {
+				return
+			}
+		}
+	}
+}
+
+// readCommand reads a command sent as an array of bulk strings.
+func readCommand(r *bufio.Reader) ([]string, error) {
+	line, err := readLine(r)
+	if err != nil {
+		return nil, err
+	}
+	if !strings.HasPrefix(line, "*") {
+		return nil, fmt.Errorf("expected array, got %q", line)
+	}
+	n, err := strconv.Atoi(line[1:])
+	if err != nil || n < 1 {
+		return nil, fmt.Errorf("invalid array length %q", line)
+	}
+	args := make([]string, n)
+	for i := range args {
+		line, err := readLine(r)
+		if err != nil {
+			return nil, err
+		}
+		if !strings.HasPrefix(line, "$") {
+			return nil, fmt.Errorf("expected bulk string, got %q", line)
+		}
+		size, err := strconv.Atoi(line[1:])
+		if err != nil || size < 0 {
+			return nil, fmt.Errorf("invalid bulk string length %q", line)
+		}
+		buf := make([]byte, size+2)
+		if _, err := io.ReadFull(r, buf); err != nil {
+			return nil, err
+		}
+		args[i] = string(buf[:size])
+	}
+	return args, nil
+}
+
+func readLine(r *bufio.Reader) (string, error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSuffix(line, "\r\n"), nil
+}
+
+// reply is a RESP3 value: nil, a simple string (status), an error, an
+// integer, a bulk string, an array of replies, or a map of bulk strings.
+type reply struct {
+	kind    byte
+	str     string
+	integer int64
+	array   []reply
+	hash    map[string]string
+}
+
+var (
+	okReply   = reply{kind: '+', str: "OK"}
+	nullReply = reply{kind: '_'}
+)
+
+func errorReply(format string, args ...any) reply {
+	return reply{kind: '-', str: "ERR " + fmt.Sprintf(format, args...)}
+}
+
+func intReply(n int64) reply {
+	return reply{kind: ':', integer: n}
+}
+
+func bulkReply(s string) reply {
+	return reply{kind: '$', str: s}
+}
+
+func arrayReply(items []string) reply {
+	array := make([]reply, len(items))
+	for i, item := range items {
+		array[i] = bulkReply(item)
+	}
+	return reply{kind: '*', array: array}
+}
+
+func scanReply(cursor int, items []string) reply {
+	return reply{kind: '*', array: []reply{bulkReply(strconv.Itoa(cursor)), arrayReply(items)}}
+}
+
+func (r reply) write(w *bufio.Writer) {
+	switch r.kind {
+	case '+', '-':
+		fmt.Fprintf(w, "%c%s\r\n", r.kind, r.str)
+	case ':':
+		fmt.Fprintf(w, ":%d\r\n", r.integer)
+	case '$':
+		fmt.Fprintf(w, "$%d\r\n%s\r\n", len(r.str), r.str)
+	case '*':
+		fmt.Fprintf(w, "*%d\r\n", len(r.array))
+		for _, item := range r.array {
+			item.write(w)
+		}
+	case '%':
+		fmt.Fprintf(w, "%%%d\r\n", len(r.hash))
+		for _, field := range slices.Sorted(maps.Keys(r.hash)) {
+			bulkReply(field).write(w)
+			bulkReply(r.hash[field]).write(w)
+		}
+	default:
+		w.WriteString("_\r\n")
+	}
+}
+
+var errWrongArgs = errors.New("wrong number of arguments")
+
+// execute performs the command cmd with the arguments args, which is called
+// with mu held.
+func (s *Server) execute(cmd string, args []string) reply {
+	switch cmd {
+	case "HELLO":
+		return reply{kind: '%', hash: map[string]string{"server": "valkeytest", "proto": "3"}}
+	case "CLIENT", "SELECT":
+		return okReply
+	case "PING":
+		return reply{kind: '+', str: "PONG"}
+	}
+	handler, ok := commands[cmd]
+	if !ok {
+		return errorReply("unknown command '%s'", cmd)
+	}
+	if len(args) < handler.minArgs {
+		return errorReply("%v for '%s' command", errWrongArgs, strings.ToLower(cmd))
+	}
+	return handler.fn(s, args)
+}
+
+type command struct {
+	minArgs int
+	fn      func(s *Server, args []string) reply
+}
+
+var commands = map[string]command{
+	"GET": {1, func(s *Server, args []string) reply {
+		if value, ok := s.strings[args[0]]; ok {
+			return bulkReply(value)
+		}
+		return nullReply
+	}},
+	"SET": {2, func(s *Server, args []string) reply {
+		// options such as EX are accepted, but ignored, except for NX
+		if slices.ContainsFunc(args[2:], func(arg string) bool { return strings.EqualFold(arg, "NX") }) && s.exists(args[0]) {
+			return nullReply
+		}
+		s.delete(args[0])
+		s.strings[args[0]] = args[1]
+		return okReply
+	}},
+	"INCRBY": {2, func(s *Server, args []string) reply { return s.incrBy(args[0], args[1], 1) }},
+	"DECRBY": {2, func(s *Server, args []string) reply { return s.incrBy(args[0], args[1], -1) }},
+	"DEL": {1, func(s *Server, args []string) reply {
+		var n int64
+		for _, key := range args {
+			if s.exists(key) {
+				s.delete(key)
+				n++
+			}
+		}
+		return intReply(n)
+	}},
+	"EXISTS": {1, func(s *Server, args []string) reply {
+		var n int64
+		for _, key := range args {
+			if s.exists(key) {
+				n++
+			}
+		}
+		return intReply(n)
+	}},
+	"HGETALL": {1, func(s *Server, args []string) reply {
+		return reply{kind: '%', hash: maps.Clone(s.hashes[args[0]])}
+	}},
+	"HMGET": {2, func(s *Server, args []string) reply {
+		values := make([]reply, len(args)-1)
+		for i, field := range args[1:] {
+			values[i] = nullReply
+			if value, ok := s.hashes[args[0]][field]; ok {
+				values[i] = bulkReply(value)
+			}
+		}
+		return reply{kind: '*', array: values}
+	}},
+	"HSET": {3, func(s *Server, args []string) reply {
+		if len(args)%2 != 1 {
+			return errorReply("%v for 'hset' command", errWrongArgs)
+		}
+		hash := s.hashes[args[0]]
+		if hash == nil {
+			hash = make(map[string]string)
+			s.hashes[args[0]] = hash
+		}
+		var n int64
+		for i := 1; i < len(args); i += 2 {
+			if _, ok := hash[args[i]]; !ok {
+				n++
+			}
+			hash[args[i]] = args[i+1]
+		}
+		return intReply(n)
+	}},
+	"HINCRBY": {3, func(s *Server, args []string) reply {
+		by, err := strconv.ParseInt(args[2], 10, 64)
+		if err != nil {
+			return errorReply("value is not an integer or out of range")
+		}
+		hash := s.hashes[args[0]]
+		if hash == nil {
+			hash = make(map[string]string)
+			s.hashes[args[0]] = hash
+		}
+		var n int64
+		if raw, ok := hash[args[1]]; ok {
+			if n, err = strconv.ParseInt(raw, 10, 64); err != nil {
+				return errorReply("hash value is not an integer")
+			}
+		}
+		n += by
+		hash[args[1]] = strconv.FormatInt(n, 10)
+		return intReply(n)
+	}},
+	"SADD": {2, func(s *Server, args []string) reply {
+		set := s.sets[args[0]]
+		if set == nil {
+			set = make(map[string]bool)
+			s.sets[args[0]] = set
+		}
+		var n int64
+		for _, member := range args[1:] {
+			if !set[member] {
+				set[member] = true
+				n++
+			}
+		}
+		return intReply(n)
+	}},
+	"SREM": {2, func(s *Server, args []string) reply {
+		var n int64
+		for _, member := range args[1:] {
+			if s.sets[args[0]][member] {
+				delete(s.sets[args[0]], member)
+				n++
+			}
+		}
+		if len(s.sets[args[0]]) == 0 {
+			delete(s.sets, args[0])
+		}
+		return intReply(n)
+	}},
+	"SMEMBERS": {1, func(s *Server, args []string) reply {
+		return arrayReply(slices.Sorted(maps.Keys(s.sets[args[0]])))
+	}},
+	"SSCAN": {2, func(s *Server, args []string) reply {
+		return scan(slices.Sorted(maps.Keys(s.sets[args[0]])), args[1], args[2:])
+	}},
+	"SCAN": {1, func(s *Server, args []string) reply {
+		keys := slices.Concat(slices.Collect(maps.Keys(s.strings)), slices.Collect(maps.Keys(s.hashes)),
+			slices.Collect(maps.Keys(s.sets)), slices.Collect(maps.Keys(s.lists)))
+		slices.Sort(keys)
+		return scan(keys, args[0], args[1:])
+	}},
+	"LPUSH": {2, func(s *Server, args []string) reply {
+		for _, value := range args[1:] {
+			s.lists[args[0]] = append([]string{value}, s.lists[args[0]]...)
+		}
+		return intReply(int64(len(s.lists[args[0]])))
+	}},
+	"LRANGE": {3, func(s *Server, args []string) reply {
+		list := s.lists[args[0]]
+		start, stop, err := listRange(len(list), args[1], args[2])
+		if err != nil {
+			return errorReply("%v", err)
+		}
+		return arrayReply(list[start:stop])
+	}},
+	"LTRIM": {3, func(s *Server, args []string) reply {
+		list := s.lists[args[0]]
+		start, stop, err := listRange(len(list), args[1], args[2])
+		if err != nil {
+			return errorReply("%v", err)
+		}
+		if list = list[start:stop]; len(list) == 0 {
+			delete(s.lists, args[0])
+		} else {
+			s.lists[args[0]] = list
+		}
+		return okReply
+	}},
+}
+
+func (s *Server) exists(key string) bool {
+	_, isString := s.strings[key]
+	_, isHash := s.hashes[key]
+	_, isSet := s.sets[key]
+	_, isList := s.lists[key]
+	return isString || isHash || isSet || isList
+}
+
+func (s *Server) delete(key string) {
+	delete(s.strings, key)
+	delete(s.hashes, key)
+	delete(s.sets, key)
+	delete(s.lists, key)
+}
+
+func (s *Server) incrBy(key, raw string, sign int64) reply {
+	by, err := strconv.ParseInt(raw, 10, 64)
+	if err != nil {
+		return errorReply("value is not an integer or out of range")
+	}
+	var n int64
+	if current, ok := s.strings[key]; ok {
+		if n, err = strconv.ParseInt(current, 10, 64); err != nil {
+			return errorReply("value is not an integer or out of range")
+		}
+	}
+	n += sign * by
+	s.strings[key] = strconv.FormatInt(n, 10)
+	return intReply(n)
+}
+
+// scan returns the batch of the sorted items continuing at the cursor, which
+// is the offset into the items, according to the options MATCH and COUNT.
+func scan(items []string, rawCursor string, options []string) reply {
+	cursor, err := strconv.Atoi(rawCursor)
+	if err != nil || cursor < 0 {
+		return errorReply("invalid cursor")
+	}
+	pattern, count := "*", 10
+	for i := 0; i+1 < len(options); i += 2 {
+		switch strings.ToUpper(options[i]) {
+		case "MATCH":
+			pattern = options[i+1]
+		case "COUNT":
+			if count, err = strconv.Atoi(options[i+1]); err != nil || count < 1 {
+				return errorReply("syntax error")
+			}
+		}
+	}
+	cursor = min(cursor, len(items))
+	end := min(cursor+count, len(items))
+	var batch []string
+	for _, item := range items[cursor:end] {
+		if matched, _ := path.Match(pattern, item); matched {
+			batch = append(batch, item)
+		}
+	}
+	if end == len(items) {
+		end = 0
+	}
+	return scanReply(end, batch)
+}
+
+// listRange converts the inclusive range from rawStart to rawStop, which may
+// count from the end if negative, to a slice range of a list of length n.
+func listRange(n int, rawStart, rawStop string) (int, int, error) {
+	start, err := strconv.Atoi(rawStart)
+	if err != nil {
+		return 0, 0, errors.New("value is not an integer or out of range")
+	}
+	stop, err := strconv.Atoi(rawStop)
+	if err != nil {
+		return 0, 0, errors.New("value is not an integer or out of range")
+	}
+	if start < 0 {
+		start = max(n+start, 0)
+	}
+	if stop < 0 {
+		stop += n
+	}
+	start, stop = min(start, n), min(stop+1, n)
+	if start >= stop {
+		return 0, 0, nil
+	}
+	return start, stop, nil
+}
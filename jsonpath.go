@@ -0,0 +1,129 @@
+package meow
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// JSONPathAssertion compares a numeric field of a JSON response body, selected
+// by a JSONPath, with a threshold, e.g. "$.queue_depth < 1000". Only paths of
+// member names (".name" or "['name']") and array indices ("[0]") are
+// supported.
+type JSONPathAssertion struct {
+	raw       string
+	path      []jsonPathSegment
+	operator  string
+	threshold float64
+}
+
+// jsonPathSegment selects either a member of an object by its name, or an
+// element of an array by its index.
+type jsonPathSegment struct {
+	name  string
+	index int
+	isKey bool
+}
+
+var (
+	jsonPathAssertionPattern = regexp.MustCompile(`^\s*(\$.*?)\s*(<=|>=|==|<|>)\s*(\S+)\s*$`)
+	jsonPathSegmentPattern   = regexp.MustCompile(`^(?:\.([A-Za-z_][A-Za-z0-9_-]*)|\['([^']*)'\]|\[([0-9]+)\])`)
+)
+
+// ParseJSONPathAssertion parses raw as a JSONPathAssertion consisting of a
+// path, a comparison operator (<, <=, >, >=, or ==), and a numeric threshold,
+// or returns an error, if one of them is malformed.
+func ParseJSONPathAssertion(raw string) (*JSONPathAssertion, error) {
+	match := jsonPathAssertionPattern.FindStringSubmatch(raw)
+	if match == nil {
+		return nil, fmt.Errorf(`"%s" is not of the form "$.path <operator> <number>"`, raw)
+	}
+	threshold, err := strconv.ParseFloat(match[3], 64)
+	if err != nil {
+		return nil, fmt.Errorf(`threshold "%s" is not a number`, match[3])
+	}
+	assertion := JSONPathAssertion{raw: raw, operator: match[2], threshold: threshold}
+	rest := strings.TrimPrefix(match[1], "$")
+	for rest != "" {
+		segment := jsonPathSegmentPattern.FindStringSubmatch(rest)
+		if segment == nil {
+			return nil, fmt.Errorf(`path "%s" is malformed at "%s"`, match[1], rest)
+		}
+		switch {
+		case segment[1] != "":
+			assertion.path = append(assertion.path, jsonPathSegment{name: segment[1], isKey: true})
+		case segment[3] != "":
+			index, err := strconv.Atoi(segment[3])
+			if err != nil {
+				return nil, fmt.Errorf(`index "%s" of path "%s": %v`, segment[3], match[1], err)
+			}
+			assertion.path = append(assertion.path, jsonPathSegment{index: index})
+		default:
+			assertion.path = append(assertion.path, jsonPathSegment{name: segment[2], isKey: true})
+		}
+		rest = rest[len(segment[0]):]
+	}
+	return &assertion, nil
+}
+
+// Check evaluates the assertion against the JSON document body, and returns an
+// error, if the selected value is missing, not a number, or does not satisfy
+// the comparison.
+func (a *JSONPathAssertion) Check(body []byte) error {
+	decoder := json.NewDecoder(bytes.NewReader(body))
+	decoder.UseNumber()
+	var value any
+	if err := decoder.Decode(&value); err != nil {
+		return fmt.Errorf("body is not JSON: %v", err)
+	}
+	for _, segment := range a.path {
+		switch current := value.(type) {
+		case map[string]any:
+			member, ok := current[segment.name]
+			if !segment.isKey || !ok {
+				return fmt.Errorf("%s: no such value", a.raw)
+			}
+			value = member
+		case []any:
+			if segment.isKey || segment.index >= len(current) {
+				return fmt.Errorf("%s: no such value", a.raw)
+			}
+			value = current[segment.index]
+		default:
+			return fmt.Errorf("%s: no such value", a.raw)
+		}
+	}
+	number, ok := value.(json.Number)
+	if !ok {
+		return fmt.Errorf("%s: value %v is not a number", a.raw, value)
+	}
+	actual, err := number.Float64()
+	if err != nil {
+		return fmt.Errorf("%s: value %s: %v", a.raw, number, err)
+	}
+	var satisfied bool
+	switch a.operator {
+	case "<":
+		satisfied = actual < a.threshold
+	case "<=":
+		satisfied = actual <= a.threshold
+	case ">":
+		satisfied = actual > a.threshold
+	case ">=":
+		satisfied = actual >= a.threshold
+	case "==":
+		satisfied = actual == a.threshold
+	}
+	if !satisfied {
+		return fmt.Errorf("%s: value is %s", a.raw, number)
+	}
+	return nil
+}
+
+// String returns the assertion as it was parsed.
+func (a *JSONPathAssertion) String() string {
+	return a.raw
+}
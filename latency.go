@@ -0,0 +1,120 @@
+package meow
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/valkey-io/valkey-go"
+)
+
+// LatencyKey returns the key of the time series holding the latencies of the
+// endpoint identified by identifier in milliseconds, which is only written if
+// Valkey provides time series (see TimeSeriesAvailable).
+func LatencyKey(identifier string) string {
+	return "latency:" + identifier
+}
+
+// TimeSeriesAvailable indicates whether or not Valkey provides the time series
+// commands TS.ADD and TS.RANGE (e.g. by the RedisTimeSeries module).
+func TimeSeriesAvailable(ctx context.Context, client valkey.Client) (bool, error) {
+	infos, err := client.Do(ctx, client.B().CommandInfo().CommandName("TS.ADD", "TS.RANGE").Build()).ToArray()
+	if err != nil {
+		return false, fmt.Errorf("command info TS.ADD TS.RANGE: %v", err)
+	}
+	for _, info := range infos {
+		if info.IsNil() {
+			return false, nil
+		}
+	}
+	return len(infos) > 0, nil
+}
+
+// LatencySource is the storage the latencies of an endpoint are queried from.
+type LatencySource string
+
+const (
+	LatencyFromTimeSeries LatencySource = "timeseries"
+	LatencyFromHistory    LatencySource = "history"
+)
+
+// LatencyPoint is the latency of the probes of an endpoint within a bucket
+// starting at Timestamp.
+type LatencyPoint struct {
+	Timestamp time.Time `json:"timestamp"`
+	AverageMS float64   `json:"avg_ms"`
+	MaxMS     float64   `json:"max_ms"`
+	Probes    int       `json:"probes"`
+}
+
+// LatencyRange are the latencies of an endpoint within a time window, which
+// are aggregated into buckets of the given size, the oldest first. Buckets
+// without probes are left out.
+type LatencyRange struct {
+	Window time.Duration
+	Bucket time.Duration
+	Source LatencySource
+	Points []LatencyPoint
+}
+
+// LatencyRangePayload contains the same fields as LatencyRange, but as
+// serializable primitives with JSON tags.
+type LatencyRangePayload struct {
+	Window string         `json:"window"`
+	Bucket string         `json:"bucket"`
+	Source LatencySource  `json:"source"`
+	Points []LatencyPoint `json:"points"`
+}
+
+// JSON returns the LatencyRange as JSON data, or an error, if it cannot be
+// serialized.
+func (l LatencyRange) JSON() ([]byte, error) {
+	points := l.Points
+	if points == nil {
+		points = []LatencyPoint{}
+	}
+	payload := LatencyRangePayload{
+		Window: l.Window.String(),
+		Bucket: l.Bucket.String(),
+		Source: l.Source,
+		Points: points,
+	}
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return nil, fmt.Errorf("marshal latency range %v as JSON: %v", l, err)
+	}
+	return data, nil
+}
+
+// AggregateLatency aggregates the latencies of the history entries within the
+// window ending at end into buckets of the given size. The buckets are aligned
+// to the Unix epoch, like the ones of TS.RANGE.
+func AggregateLatency(entries []HistoryEntry, window, bucket time.Duration, end time.Time) LatencyRange {
+	start := end.Add(-window)
+	buckets := make(map[int64]*LatencyPoint)
+	for _, entry := range entries {
+		if entry.Timestamp.Before(start) || entry.Timestamp.After(end) {
+			continue
+		}
+		bucketStart := entry.Timestamp.UnixMilli() / bucket.Milliseconds() * bucket.Milliseconds()
+		point, ok := buckets[bucketStart]
+		if !ok {
+			point = &LatencyPoint{Timestamp: time.UnixMilli(bucketStart).UTC()}
+			buckets[bucketStart] = point
+		}
+		latency := float64(entry.LatencyMS)
+		point.AverageMS += (latency - point.AverageMS) / float64(point.Probes+1)
+		point.MaxMS = max(point.MaxMS, latency)
+		point.Probes++
+	}
+	points := make([]LatencyPoint, 0, len(buckets))
+	for _, point := range buckets {
+		points = append(points, *point)
+	}
+	slices.SortFunc(points, func(a, b LatencyPoint) int {
+		return a.Timestamp.Compare(b.Timestamp)
+	})
+	return LatencyRange{Window: window, Bucket: bucket, Source: LatencyFromHistory, Points: points}
+}
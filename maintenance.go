@@ -0,0 +1,134 @@
+package meow
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// MaxMaintenanceDuration is the longest duration a maintenance window can
+// last.
+const MaxMaintenanceDuration = 7 * 24 * time.Hour
+
+// MaintenanceWindow is a recurring period of time, during which an endpoint is
+// under maintenance. Its start is defined by a cron-like expression with the
+// five fields minute, hour, day of month, month, and day of week (0 being
+// Sunday), followed by the duration of the window, e.g. "0 2 * * 0 2h" for
+// every Sunday from 02:00 to 04:00 (local time of the probe). Each field
+// supports "*", single values, lists ("1,3,5"), ranges ("1-5") and steps
+// ("*/15", "0-30/10").
+type MaintenanceWindow struct {
+	spec     string
+	minutes  map[int]bool
+	hours    map[int]bool
+	days     map[int]bool
+	months   map[int]bool
+	weekdays map[int]bool
+	Duration time.Duration
+}
+
+// ParseMaintenanceWindow parses the given spec, or returns an error, if it is
+// malformed.
+func ParseMaintenanceWindow(spec string) (*MaintenanceWindow, error) {
+	fields := strings.Fields(spec)
+	if len(fields) != 6 {
+		return nil, fmt.Errorf(`maintenance window "%s" needs 6 fields`, spec)
+	}
+	bounds := [5][2]int{{0, 59}, {0, 23}, {1, 31}, {1, 12}, {0, 6}}
+	sets := make([]map[int]bool, 5)
+	for i := range sets {
+		set, err := parseCronField(fields[i], bounds[i][0], bounds[i][1])
+		if err != nil {
+			return nil, fmt.Errorf(`maintenance window "%s": %v`, spec, err)
+		}
+		sets[i] = set
+	}
+	duration, err := time.ParseDuration(fields[5])
+	if err != nil || duration < time.Minute || duration > MaxMaintenanceDuration {
+		return nil, fmt.Errorf(`maintenance window "%s": "%s" is not a valid duration `+
+			`(must be between 1m and %v)`, spec, fields[5], MaxMaintenanceDuration)
+	}
+	return &MaintenanceWindow{
+		spec:     strings.Join(fields, " "),
+		minutes:  sets[0],
+		hours:    sets[1],
+		days:     sets[2],
+		months:   sets[3],
+		weekdays: sets[4],
+		Duration: duration,
+	}, nil
+}
+
+func parseCronField(field string, lower, upper int) (map[int]bool, error) {
+	set := make(map[int]bool)
+	for _, part := range strings.Split(field, ",") {
+		step := 1
+		if before, after, found := strings.Cut(part, "/"); found {
+			n, err := strconv.Atoi(after)
+			if err != nil || n < 1 {
+				return nil, fmt.Errorf(`"%s" is not a valid step`, after)
+			}
+			part, step = before, n
+		}
+		from, to := lower, upper
+		if part != "*" {
+			before, after, isRange := strings.Cut(part, "-")
+			var err error
+			if from, err = strconv.Atoi(before); err != nil {
+				return nil, fmt.Errorf(`"%s" is not a number`, before)
+			}
+			to = from
+			if isRange {
+				if to, err = strconv.Atoi(after); err != nil {
+					return nil, fmt.Errorf(`"%s" is not a number`, after)
+				}
+			}
+		}
+		if from < lower || to > upper || from > to {
+			return nil, fmt.Errorf(`"%s" is out of range %d-%d`, part, lower, upper)
+		}
+		for i := from; i <= to; i += step {
+			set[i] = true
+		}
+	}
+	return set, nil
+}
+
+// String returns the spec the window was parsed from.
+func (w MaintenanceWindow) String() string {
+	return w.spec
+}
+
+// starts indicates whether or not the window starts at the minute of t.
+func (w MaintenanceWindow) starts(t time.Time) bool {
+	return w.minutes[t.Minute()] && w.hours[t.Hour()] && w.days[t.Day()] &&
+		w.months[int(t.Month())] && w.weekdays[int(t.Weekday())]
+}
+
+// Active indicates whether or not t lies within the window.
+func (w MaintenanceWindow) Active(t time.Time) bool {
+	t = t.Truncate(time.Minute)
+	for start := t; t.Sub(start) < w.Duration; start = start.Add(-time.Minute) {
+		if w.starts(start) {
+			return true
+		}
+	}
+	return false
+}
+
+// InMaintenance indicates whether or not t lies within one of the endpoint's
+// maintenance windows.
+func (e Endpoint) InMaintenance(t time.Time) bool {
+	for _, w := range e.MaintenanceWindows {
+		if w.Active(t) {
+			return true
+		}
+	}
+	return false
+}
+
+// MarshalText returns the spec the window was parsed from.
+func (w MaintenanceWindow) MarshalText() ([]byte, error) {
+	return []byte(w.spec), nil
+}
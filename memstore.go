@@ -0,0 +1,183 @@
+package meow
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"maps"
+	"slices"
+	"strconv"
+	"sync"
+)
+
+// NewMemConfigStore creates a ConfigStore keeping the endpoints in memory,
+// e.g. for testing the handlers of the config server without Valkey. The
+// endpoints are kept as the fields of their hashes (see EndpointFields), and
+// parsed when read like the ones stored in Valkey. The store is seeded with
+// the hashes given, each of which is identified by its identifier field, so
+// that e.g. endpoints stored malformed can be set up.
+func NewMemConfigStore(seed ...map[string]string) ConfigStore {
+	s := &memStore{endpoints: make(map[string]map[string]string, len(seed))}
+	for _, kvs := range seed {
+		s.endpoints[kvs["identifier"]] = maps.Clone(kvs)
+	}
+	return s
+}
+
+// memStore keeps the hashes of the endpoints by their identifiers. Pages are
+// listed in the order of the identifiers, and continued after the identifier
+// listed last.
+type memStore struct {
+	mu        sync.Mutex
+	endpoints map[string]map[string]string
+}
+
+func (s *memStore) Get(ctx context.Context, identifier string) (*Endpoint, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	kvs, ok := s.endpoints[identifier]
+	if !ok {
+		return nil, fmt.Errorf(`endpoint "%s": %w`, identifier, ErrNotFound)
+	}
+	endpoint, err := EndpointFromMap(kvs)
+	if err != nil {
+		return nil, fmt.Errorf("parse endpoint %s: %v", identifier, err)
+	}
+	return endpoint, nil
+}
+
+func (s *memStore) Stat(ctx context.Context, identifier string) (*EndpointInfo, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	kvs, ok := s.endpoints[identifier]
+	if !ok {
+		return nil, fmt.Errorf(`endpoint "%s": %w`, identifier, ErrNotFound)
+	}
+	version, _ := strconv.ParseUint(kvs["version"], 10, 64)
+	return &EndpointInfo{Owner: kvs["owner"], Version: version, UpdatedBy: kvs["updated_by"]}, nil
+}
+
+func (s *memStore) Put(ctx context.Context, e *Endpoint) (bool, error) {
+	return s.PutIf(ctx, e, Precondition{})
+}
+
+func (s *memStore) PutIf(ctx context.Context, e *Endpoint, cond Precondition) (bool, error) {
+	created, err := s.PutAll(ctx, []*Endpoint{e}, []Precondition{cond})
+	if err != nil {
+		return false, err
+	}
+	return created[0], nil
+}
+
+func (s *memStore) PutAll(ctx context.Context, endpoints []*Endpoint, conds []Precondition) ([]bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	created := make([]bool, len(endpoints))
+	hashes := make([]map[string]string, len(endpoints))
+	var n int
+	for i, e := range endpoints {
+		kvs, exists := s.endpoints[e.Identifier]
+		version, _ := strconv.ParseUint(kvs["version"], 10, 64)
+		if i < len(conds) {
+			if exists && conds[i].CreateOnly {
+				return nil, fmt.Errorf("create endpoint %s: %w", e.Identifier, ErrConflict)
+			}
+			if len(conds[i].Versions) > 0 && (!exists || !slices.Contains(conds[i].Versions, version)) {
+				return nil, fmt.Errorf("write endpoint %s: version does not match: %w", e.Identifier, ErrPreconditionFailed)
+			}
+		}
+		fields, err := EndpointFields(e, e.UpdatedBy)
+		if err != nil {
+			return nil, err
+		}
+		hashes[i] = map[string]string{"version": strconv.FormatUint(version+1, 10)}
+		for j := 0; j+1 < len(fields); j += 2 {
+			hashes[i][fields[j]] = fields[j+1]
+		}
+		if !exists {
+			created[i] = true
+			n++
+		}
+	}
+	if max := CurrentSettings().MaxEndpoints; max > 0 && len(s.endpoints)+n > max {
+		return nil, fmt.Errorf("maximum of %d endpoints: %w", max, ErrLimitReached)
+	}
+	for i, e := range endpoints {
+		s.endpoints[e.Identifier] = hashes[i]
+		e.Version, _ = strconv.ParseUint(hashes[i]["version"], 10, 64)
+	}
+	return created, nil
+}
+
+func (s *memStore) Delete(ctx context.Context, identifier string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.endpoints[identifier]; !ok {
+		return fmt.Errorf(`endpoint "%s": %w`, identifier, ErrNotFound)
+	}
+	delete(s.endpoints, identifier)
+	return nil
+}
+
+func (s *memStore) List(ctx context.Context) ([]*Endpoint, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var endpoints []*Endpoint
+	for _, identifier := range slices.Sorted(maps.Keys(s.endpoints)) {
+		if endpoint, err := EndpointFromMap(s.endpoints[identifier]); err == nil {
+			endpoints = append(endpoints, endpoint)
+		}
+	}
+	return endpoints, nil
+}
+
+func (s *memStore) ListPage(ctx context.Context, query ListQuery, fn func(string, []*Endpoint) error) (string, error) {
+	if query.Cursor != "" && !ValidIdentifier(query.Cursor) {
+		return "", fmt.Errorf(`cursor "%s" is not an identifier: %w`, query.Cursor, ErrInvalidCursor)
+	}
+	s.mu.Lock()
+	var selected []map[string]string
+	for _, identifier := range slices.Sorted(maps.Keys(s.endpoints)) {
+		kvs := s.endpoints[identifier]
+		if identifier > query.Cursor && (query.Owner == "" || kvs["owner"] == query.Owner) && query.matches(kvs) {
+			selected = append(selected, maps.Clone(kvs))
+		}
+	}
+	s.mu.Unlock()
+	var next string
+	if query.Limit > 0 && len(selected) > query.Limit {
+		selected = selected[:query.Limit]
+		next = selected[len(selected)-1]["identifier"]
+	}
+	for batch := range slices.Chunk(selected, listBatchSize) {
+		endpoints := make([]*Endpoint, len(batch))
+		for i, kvs := range batch {
+			endpoint, err := EndpointFromMap(kvs)
+			if err != nil {
+				return "", fmt.Errorf("parse endpoint %s: %v", kvs["identifier"], err)
+			}
+			endpoints[i] = endpoint
+		}
+		if err := fn(next, endpoints); err != nil {
+			return "", err
+		}
+	}
+	return next, nil
+}
+
+func (s *memStore) Tagged(ctx context.Context, tag string) ([]string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var identifiers []string
+	for identifier, kvs := range s.endpoints {
+		var tags []string
+		if raw := kvs["tags"]; raw != "" {
+			json.Unmarshal([]byte(raw), &tags)
+		}
+		if slices.Contains(tags, tag) {
+			identifiers = append(identifiers, identifier)
+		}
+	}
+	slices.Sort(identifiers)
+	return identifiers, nil
+}
@@ -8,3 +8,37 @@ const (
 	CatAlert          = '\U0001f640'
 	CrossMark         = '\u274C'
 )
+
+// State describes the state of an endpoint as observed by the probe.
+type State string
+
+// States an endpoint can be in.
+const (
+	StateUnknown  State = "unknown"
+	StateOnline   State = "online"
+	StateDegraded State = "degraded"
+	StateOffline  State = "offline"
+
+	// StateRefused indicates that the endpoint is offline, because its
+	// connections are refused (e.g. its port is closed).
+	StateRefused     State = "refused"
+	StateMaintenance State = "maintenance"
+
+	// StatePaused indicates that the endpoint is not probed, because it is
+	// outside of its active hours.
+	StatePaused State = "paused"
+
+	// StateMisconfigured indicates that the endpoint's host cannot be
+	// resolved at all, which is likely a configuration error.
+	StateMisconfigured State = "misconfigured"
+
+	// StateRedirect indicates that the endpoint responded with a redirect it
+	// was not expected to, which counts as the state configured by the
+	// endpoint's RedirectCountsAs.
+	StateRedirect State = "redirect"
+)
+
+// MaxBodySize is the maximum number of bytes of a response body read by the
+// probe. Bodies are read up to that size regardless of their transfer encoding
+// (e.g. chunked), so that matching is performed on the same data.
+const MaxBodySize = 64 << 10
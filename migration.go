@@ -0,0 +1,174 @@
+package meow
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+)
+
+// EndpointSchemaVersion is the version of the schema of stored endpoints, which
+// is kept in their schema_version field. Stored endpoints lacking the field
+// have version 0.
+const EndpointSchemaVersion = 27
+
+// endpointMigrations[i] returns the fields (with their default values) added
+// when upgrading a stored endpoint from schema version i to i+1. A new field
+// requires bumping EndpointSchemaVersion and adding a migration.
+var endpointMigrations = []func() map[string]string{
+	// 0 → 1: optional fields added after the initial schema
+	func() map[string]string {
+		settings := CurrentSettings()
+		return map[string]string{
+			"frequency":                   settings.DefaultFrequency.String(),
+			"fail_after":                  strconv.Itoa(int(settings.DefaultFailAfter)),
+			"maintenance_windows":         "[]",
+			"keep_connections_on_failure": "false",
+			"max_ttfb":                    time.Duration(0).String(),
+			"response_schema":             "",
+			"expect_trailer":              "",
+			"expect_trailer_value":        "",
+			"extract_regex":               "",
+			"extract_header":              "",
+			"host_header":                 "",
+		}
+	},
+	// 1 → 2: active hours
+	func() map[string]string {
+		return map[string]string{"active_hours": ""}
+	},
+	// 2 → 3: expected body hash
+	func() map[string]string {
+		return map[string]string{"expect_body_hash": ""}
+	},
+	// 3 → 4: headers to capture
+	func() map[string]string {
+		return map[string]string{"capture_headers": "[]"}
+	},
+	// 4 → 5: fast fail on refused connections
+	func() map[string]string {
+		return map[string]string{"fast_fail_on_refused": "false"}
+	},
+	// 5 → 6: probe timeout and check paths
+	func() map[string]string {
+		return map[string]string{"timeout": "0s", "check_paths": "[]"}
+	},
+	// 6 → 7: owner
+	func() map[string]string {
+		return map[string]string{"owner": ""}
+	},
+	// 7 → 8: stability
+	func() map[string]string {
+		return map[string]string{"stability_window": "0", "stability_threshold": "0"}
+	},
+	// 8 → 9: transport settings
+	func() map[string]string {
+		return map[string]string{"insecure_skip_verify": "false", "proxy": ""}
+	},
+	// 9 → 10: build verification
+	func() map[string]string {
+		return map[string]string{"expect_build_header": ""}
+	},
+	// 10 → 11: cookie assertion
+	func() map[string]string {
+		return map[string]string{"expect_set_cookie": ""}
+	},
+	// 11 → 12: tags
+	func() map[string]string {
+		return map[string]string{"tags": "[]"}
+	},
+	// 12 → 13: request body
+	func() map[string]string {
+		return map[string]string{"body_source": ""}
+	},
+	// 13 → 14: JSONPath assertion
+	func() map[string]string {
+		return map[string]string{"expect_json_path": ""}
+	},
+	// 14 → 15: write metadata
+	func() map[string]string {
+		return map[string]string{"version": "0", "updated_by": ""}
+	},
+	// 15 → 16: headers
+	func() map[string]string {
+		return map[string]string{"headers": "{}"}
+	},
+	// 16 → 17: compression validation
+	func() map[string]string {
+		return map[string]string{"expect_valid_compression": "false"}
+	},
+	// 17 → 18: protocol
+	func() map[string]string {
+		return map[string]string{"protocol": ""}
+	},
+	// 18 → 19: concurrent probes
+	func() map[string]string {
+		return map[string]string{"concurrent_probes": "0"}
+	},
+	// 19 → 20: expected status text
+	func() map[string]string {
+		return map[string]string{"expect_status_text": ""}
+	},
+	// 20 → 21: pinned certificate
+	func() map[string]string {
+		return map[string]string{"pinned_cert_sha256": ""}
+	},
+	// 21 → 22: expected body substring
+	func() map[string]string {
+		return map[string]string{"body_contains": ""}
+	},
+	// 22 → 23: forbidden body substring
+	func() map[string]string {
+		return map[string]string{"body_not_contains": ""}
+	},
+	// 23 → 24: redirect handling
+	func() map[string]string {
+		return map[string]string{"redirect_counts_as": ""}
+	},
+	// 24 → 25: custom resolver
+	func() map[string]string {
+		return map[string]string{"resolver": ""}
+	},
+	// 25 → 26: tunnel target
+	func() map[string]string {
+		return map[string]string{"connect_target": ""}
+	},
+	// 26 → 27: dial timeout
+	func() map[string]string {
+		return map[string]string{"dial_timeout": "0s"}
+	},
+}
+
+// MigrateEndpoint upgrades the stored endpoint m to EndpointSchemaVersion. It
+// returns the fields to be set: the ones missing in m with their defaults, and
+// the new schema_version. Existing fields are never changed, and no fields are
+// returned for an up-to-date endpoint, so that the migration is idempotent.
+// The schema version m had is returned, too.
+func MigrateEndpoint(m map[string]string) (map[string]string, int, error) {
+	version := 0
+	if raw, ok := m["schema_version"]; ok {
+		var err error
+		if version, err = strconv.Atoi(raw); err != nil {
+			return nil, 0, fmt.Errorf(`schema_version "%s" is not a number`, raw)
+		}
+	}
+	if version > EndpointSchemaVersion {
+		return nil, version, fmt.Errorf("schema version %d is newer than supported version %d",
+			version, EndpointSchemaVersion)
+	}
+	changes := make(map[string]string)
+	for v := version; v < EndpointSchemaVersion; v++ {
+		for field, value := range endpointMigrations[v]() {
+			if _, ok := m[field]; ok {
+				continue
+			}
+			if _, ok := changes[field]; ok {
+				continue
+			}
+			changes[field] = value
+		}
+	}
+	if version < EndpointSchemaVersion {
+		changes["schema_version"] = strconv.Itoa(EndpointSchemaVersion)
+	}
+	return changes, version, nil
+}
@@ -0,0 +1,158 @@
+package meow
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"text/template"
+	"time"
+)
+
+// Notification informs about an endpoint going offline (or being
+// misconfigured), or being online again after an alert. From is the state of
+// the endpoint as of its previous probe.
+type Notification struct {
+	Identifier  string      `json:"identifier"`
+	State       State       `json:"state"`
+	From        State       `json:"from,omitempty"`
+	FailureKind FailureKind `json:"failure_kind,omitempty"`
+	Error       string      `json:"error,omitempty"`
+	Failures    int         `json:"consecutive_failures"`
+	Time        time.Time   `json:"time"`
+}
+
+// To returns the state the endpoint changed to, so that templates can refer to
+// the change as {{.From}} and {{.To}}.
+func (n Notification) To() State {
+	return n.State
+}
+
+// At returns the time of the probe raising the notification.
+func (n Notification) At() time.Time {
+	return n.Time
+}
+
+// ParseNotifyTemplate parses a text/template rendering the body of a
+// notification, e.g. "{{.Identifier}} is {{.To}} since {{.At}}: {{.Error}}".
+// The template is executed with a sample notification, so that references to
+// unknown fields are reported right away rather than on delivery.
+func ParseNotifyTemplate(raw string) (*template.Template, error) {
+	tmpl, err := template.New("notification").Parse(raw)
+	if err != nil {
+		return nil, fmt.Errorf("parse notification template: %v", err)
+	}
+	sample := Notification{Identifier: "sample", State: StateOffline, From: StateOnline,
+		FailureKind: FailureTimeout, Error: "timed out", Failures: 1, Time: time.Now()}
+	if err := tmpl.Execute(io.Discard, sample); err != nil {
+		return nil, fmt.Errorf("execute notification template: %v", err)
+	}
+	return tmpl, nil
+}
+
+// notifyBufferSize is the number of notifications buffered for delivery,
+// beyond which further notifications are dropped rather than blocking the
+// probe.
+const notifyBufferSize = 100
+
+// Notifier delivers notifications to a webhook, as JSON or rendered by a
+// template. Delivery happens in the background, so that a slow webhook never
+// blocks the probe.
+type Notifier struct {
+	webhook       string
+	timeout       time.Duration
+	template      *template.Template
+	client        *http.Client
+	notifications chan Notification
+	errors        func(error)
+}
+
+// NewNotifier creates a notifier posting to the webhook URL, whose deliveries
+// are cancelled after timeout. The notifications are rendered by tmpl (see
+// ParseNotifyTemplate), or posted as JSON if it is nil. Failed deliveries are
+// reported to errors, and their notifications are dropped.
+func NewNotifier(webhook string, timeout time.Duration, tmpl *template.Template, errors func(error)) *Notifier {
+	return &Notifier{
+		webhook:       webhook,
+		timeout:       timeout,
+		template:      tmpl,
+		client:        &http.Client{},
+		notifications: make(chan Notification, notifyBufferSize),
+		errors:        errors,
+	}
+}
+
+// Notify queues the notification n for delivery without blocking. It does
+// nothing if the notifier is nil, i.e. disabled.
+func (n *Notifier) Notify(notification Notification) {
+	if n == nil {
+		return
+	}
+	select {
+	case n.notifications <- notification:
+	default:
+		n.errors(fmt.Errorf("notification buffer full: dropped notification of %s",
+			notification.Identifier))
+	}
+}
+
+// Run delivers the queued notifications one by one until the process
+// terminates.
+func (n *Notifier) Run() {
+	for notification := range n.notifications {
+		if err := n.deliver(notification); err != nil {
+			n.errors(err)
+		}
+	}
+}
+
+// render returns the body of the notification and its content type, which is
+// JSON unless a template renders something else.
+func (n *Notifier) render(notification Notification) ([]byte, string, error) {
+	if n.template == nil {
+		data, err := json.Marshal(notification)
+		if err != nil {
+			return nil, "", fmt.Errorf("marshal notification of %s: %v", notification.Identifier, err)
+		}
+		return data, "application/json", nil
+	}
+	var buf bytes.Buffer
+	if err := n.template.Execute(&buf, notification); err != nil {
+		return nil, "", fmt.Errorf("render notification of %s: %v", notification.Identifier, err)
+	}
+	if json.Valid(buf.Bytes()) {
+		// e.g. the payload of a chat's incoming webhook
+		return buf.Bytes(), "application/json", nil
+	}
+	return buf.Bytes(), "text/plain; charset=utf-8", nil
+}
+
+func (n *Notifier) deliver(notification Notification) error {
+	data, contentType, err := n.render(notification)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), n.timeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, n.webhook, bytes.NewReader(data))
+	if err != nil {
+		return fmt.Errorf("prepare notification of %s: %v", notification.Identifier, err)
+	}
+	req.Header.Set("Content-Type", contentType)
+	res, err := n.client.Do(req)
+	if errors.Is(err, context.DeadlineExceeded) {
+		return fmt.Errorf("deliver notification of %s: timed out after %v", notification.Identifier, n.timeout)
+	}
+	if err != nil {
+		return fmt.Errorf("deliver notification of %s: %v", notification.Identifier, err)
+	}
+	defer res.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(res.Body, MaxBodySize))
+	if res.StatusCode/100 != 2 {
+		return fmt.Errorf("deliver notification of %s: status %d", notification.Identifier, res.StatusCode)
+	}
+	return nil
+}
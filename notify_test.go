@@ -0,0 +1,69 @@
+package meow
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestNotifierSlowWebhook(t *testing.T) {
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-release:
+		case <-r.Context().Done():
+		}
+	}))
+	defer server.Close()
+	defer close(release)
+	failures := make(chan error, notifyBufferSize)
+	notifier := NewNotifier(server.URL, 50*time.Millisecond, nil, func(err error) { failures <- err })
+	go notifier.Run()
+	start := time.Now()
+	for range 3 {
+		notifier.Notify(Notification{Identifier: "libvirt", State: StateOffline, Time: start})
+	}
+	if elapsed := time.Since(start); elapsed > 10*time.Millisecond {
+		t.Errorf("expected notifying not to wait for the webhook, took %v", elapsed)
+	}
+	for i := range 3 {
+		select {
+		case err := <-failures:
+			if !strings.Contains(err.Error(), "timed out after 50ms") {
+				t.Errorf("expected delivery %d to time out, got %v", i, err)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("expected delivery %d to be cancelled after its timeout", i)
+		}
+	}
+}
+
+func TestNotifierDelivered(t *testing.T) {
+	bodies := make(chan string, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			t.Errorf("read notification: %v", err)
+		}
+		bodies <- r.Header.Get("Content-Type") + " " + string(body)
+	}))
+	defer server.Close()
+	tmpl, err := ParseNotifyTemplate("{{.Identifier}} is {{.To}}")
+	if err != nil {
+		t.Fatalf("parse template: %v", err)
+	}
+	notifier := NewNotifier(server.URL, time.Second, tmpl, func(err error) { t.Errorf("deliver: %v", err) })
+	go notifier.Run()
+	notifier.Notify(Notification{Identifier: "libvirt", State: StateOffline})
+	select {
+	case body := <-bodies:
+		if body != "text/plain; charset=utf-8 libvirt is offline" {
+			t.Errorf(`expected rendered notification, got "%s"`, body)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("expected notification to be delivered")
+	}
+}
@@ -0,0 +1,334 @@
+package meow
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ProbeMetrics are the metrics of a single probe exported via OpenTelemetry.
+type ProbeMetrics struct {
+	Identifier          string
+	Time                time.Time
+	Up                  bool
+	Latency             time.Duration
+	ConsecutiveFailures int
+	FailureKind         FailureKind
+}
+
+// ProbeSpan is the span of a single probe exported via OpenTelemetry.
+type ProbeSpan struct {
+	TraceID    [16]byte
+	SpanID     [8]byte
+	Identifier string
+	URL        string
+	Method     string
+	Status     int
+	Start      time.Time
+	End        time.Time
+
+	// Error describes why the probe failed, or is empty, if it succeeded.
+	Error string
+}
+
+// NewProbeSpan creates a span for a probe of the endpoint e starting at start
+// with random trace and span ids.
+func NewProbeSpan(e Endpoint, start time.Time) ProbeSpan {
+	span := ProbeSpan{Identifier: e.Identifier, Method: e.Method, Start: start,
+		URL: SanitizeURL(e.URL, CurrentSettings().LogSafeParams)}
+	rand.Read(span.TraceID[:])
+	rand.Read(span.SpanID[:])
+	return span
+}
+
+// Traceparent returns the W3C Trace Context header value propagating the span
+// to the probed endpoint.
+func (s ProbeSpan) Traceparent() string {
+	return fmt.Sprintf("00-%x-%x-01", s.TraceID, s.SpanID)
+}
+
+// OTLPExporter pushes probe metrics and, if enabled, probe spans in batches to
+// an OpenTelemetry collector using OTLP over HTTP with JSON encoding.
+type OTLPExporter struct {
+	endpoint string
+	client   *http.Client
+	metrics  *otlpQueue[ProbeMetrics]
+	spans    *otlpQueue[ProbeSpan]
+	errors   func(error)
+}
+
+// otlpBufferSize is the number of items (metrics or spans) buffered for export,
+// beyond which further items are dropped rather than blocking the probe.
+const otlpBufferSize = 1000
+
+// otlpQueue collects items to be exported in batches.
+type otlpQueue[T any] struct {
+	items    chan T
+	batch    int
+	interval time.Duration
+}
+
+func newOTLPQueue[T any](batch int, interval time.Duration) *otlpQueue[T] {
+	return &otlpQueue[T]{make(chan T, otlpBufferSize), batch, interval}
+}
+
+// add queues the item without blocking, and indicates whether or not it was
+// queued.
+func (q *otlpQueue[T]) add(item T) bool {
+	select {
+	case q.items <- item:
+		return true
+	default:
+		return false
+	}
+}
+
+// run exports the queued items once batch items are queued, or after interval.
+func (q *otlpQueue[T]) run(export func([]T) error, errors func(error)) {
+	ticker := time.NewTicker(q.interval)
+	batch := make([]T, 0, q.batch)
+	for {
+		select {
+		case item := <-q.items:
+			batch = append(batch, item)
+			if len(batch) < q.batch {
+				continue
+			}
+		case <-ticker.C:
+			if len(batch) == 0 {
+				continue
+			}
+		}
+		if err := export(batch); err != nil {
+			errors(err)
+		}
+		batch = batch[:0]
+	}
+}
+
+// NewOTLPExporter creates an exporter pushing to the collector at endpoint (e.g.
+// http://localhost:4318), which sends a batch once it holds batch items, or
+// after interval. Spans are only exported if tracing is set. Failed exports are
+// reported to errors, and their items are dropped.
+func NewOTLPExporter(endpoint string, tracing bool, batch int, interval time.Duration, errors func(error)) *OTLPExporter {
+	x := &OTLPExporter{
+		endpoint: strings.TrimSuffix(endpoint, "/"),
+		client:   &http.Client{Timeout: 10 * time.Second},
+		metrics:  newOTLPQueue[ProbeMetrics](batch, interval),
+		errors:   errors,
+	}
+	if tracing {
+		x.spans = newOTLPQueue[ProbeSpan](batch, interval)
+	}
+	return x
+}
+
+// Record queues the metrics m for export without blocking. It does nothing if
+// the exporter is nil, i.e. disabled.
+func (x *OTLPExporter) Record(m ProbeMetrics) {
+	if x == nil {
+		return
+	}
+	if !x.metrics.add(m) {
+		x.errors(fmt.Errorf("export buffer full: dropped metrics of %s", m.Identifier))
+	}
+}
+
+// Tracing indicates whether or not spans are exported.
+func (x *OTLPExporter) Tracing() bool {
+	return x != nil && x.spans != nil
+}
+
+// RecordSpan queues the span for export without blocking. It does nothing
+// unless tracing is enabled.
+func (x *OTLPExporter) RecordSpan(span ProbeSpan) {
+	if !x.Tracing() {
+		return
+	}
+	if !x.spans.add(span) {
+		x.errors(fmt.Errorf("export buffer full: dropped span of %s", span.Identifier))
+	}
+}
+
+// Run exports the recorded metrics and spans until the process terminates.
+func (x *OTLPExporter) Run() {
+	if x.spans != nil {
+		go x.spans.run(func(batch []ProbeSpan) error {
+			return x.export("/v1/traces", otlpTracesRequest(batch), len(batch))
+		}, x.errors)
+	}
+	x.metrics.run(func(batch []ProbeMetrics) error {
+		return x.export("/v1/metrics", otlpMetricsRequest(batch), len(batch))
+	}, x.errors)
+}
+
+func (x *OTLPExporter) export(path string, request any, n int) error {
+	data, err := json.Marshal(request)
+	if err != nil {
+		return fmt.Errorf("marshal OTLP request: %v", err)
+	}
+	url := x.endpoint + path
+	res, err := x.client.Post(url, "application/json", bytes.NewReader(data))
+	if err != nil {
+		return fmt.Errorf("export %d items to %s: %v", n, url, err)
+	}
+	defer res.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(res.Body, MaxBodySize))
+	if res.StatusCode/100 != 2 {
+		return fmt.Errorf("export %d items to %s: status %d", n, url, res.StatusCode)
+	}
+	return nil
+}
+
+// The following types model the JSON encoding of the OTLP
+// ExportMetricsServiceRequest (as far as needed for gauges) and
+// ExportTraceServiceRequest.
+type (
+	otlpMetricsPayload struct {
+		ResourceMetrics []otlpResourceMetrics `json:"resourceMetrics"`
+	}
+	otlpResourceMetrics struct {
+		Resource     otlpResource       `json:"resource"`
+		ScopeMetrics []otlpScopeMetrics `json:"scopeMetrics"`
+	}
+	otlpResource struct {
+		Attributes []otlpAttribute `json:"attributes"`
+	}
+	otlpScopeMetrics struct {
+		Scope   otlpScope    `json:"scope"`
+		Metrics []otlpMetric `json:"metrics"`
+	}
+	otlpScope struct {
+		Name string `json:"name"`
+	}
+	otlpMetric struct {
+		Name  string    `json:"name"`
+		Unit  string    `json:"unit"`
+		Gauge otlpGauge `json:"gauge"`
+	}
+	otlpGauge struct {
+		DataPoints []otlpDataPoint `json:"dataPoints"`
+	}
+	otlpDataPoint struct {
+		Attributes   []otlpAttribute `json:"attributes"`
+		TimeUnixNano string          `json:"timeUnixNano"`
+		AsDouble     float64         `json:"asDouble"`
+	}
+	otlpAttribute struct {
+		Key   string    `json:"key"`
+		Value otlpValue `json:"value"`
+	}
+	otlpValue struct {
+		StringValue string `json:"stringValue,omitempty"`
+		IntValue    string `json:"intValue,omitempty"`
+	}
+	otlpTracesPayload struct {
+		ResourceSpans []otlpResourceSpans `json:"resourceSpans"`
+	}
+	otlpResourceSpans struct {
+		Resource   otlpResource     `json:"resource"`
+		ScopeSpans []otlpScopeSpans `json:"scopeSpans"`
+	}
+	otlpScopeSpans struct {
+		Scope otlpScope  `json:"scope"`
+		Spans []otlpSpan `json:"spans"`
+	}
+	otlpSpan struct {
+		TraceID           string          `json:"traceId"`
+		SpanID            string          `json:"spanId"`
+		Name              string          `json:"name"`
+		Kind              int             `json:"kind"`
+		StartTimeUnixNano string          `json:"startTimeUnixNano"`
+		EndTimeUnixNano   string          `json:"endTimeUnixNano"`
+		Attributes        []otlpAttribute `json:"attributes"`
+		Status            otlpSpanStatus  `json:"status"`
+	}
+	otlpSpanStatus struct {
+		Code    int    `json:"code"`
+		Message string `json:"message,omitempty"`
+	}
+)
+
+// otlpResourceAttributes describe the probe as the source of metrics and spans.
+var otlpResourceAttributes = []otlpAttribute{{"service.name", otlpValue{StringValue: "meow-probe"}}}
+
+// otlpScopeName is the name of the instrumentation scope.
+const otlpScopeName = "github.com/patrickbucher/meow"
+
+func otlpMetricsRequest(batch []ProbeMetrics) otlpMetricsPayload {
+	up := otlpMetric{Name: "meow.probe.up", Unit: "1"}
+	latency := otlpMetric{Name: "meow.probe.latency", Unit: "s"}
+	failures := otlpMetric{Name: "meow.probe.consecutive_failures", Unit: "1"}
+	for _, m := range batch {
+		attributes := []otlpAttribute{{"endpoint", otlpValue{StringValue: m.Identifier}}}
+		if m.FailureKind != "" {
+			attributes = append(attributes,
+				otlpAttribute{"failure_kind", otlpValue{StringValue: string(m.FailureKind)}})
+		}
+		timestamp := strconv.FormatInt(m.Time.UnixNano(), 10)
+		point := func(value float64) otlpDataPoint {
+			return otlpDataPoint{attributes, timestamp, value}
+		}
+		var upValue float64
+		if m.Up {
+			upValue = 1
+		}
+		up.Gauge.DataPoints = append(up.Gauge.DataPoints, point(upValue))
+		latency.Gauge.DataPoints = append(latency.Gauge.DataPoints, point(m.Latency.Seconds()))
+		failures.Gauge.DataPoints = append(failures.Gauge.DataPoints, point(float64(m.ConsecutiveFailures)))
+	}
+	return otlpMetricsPayload{[]otlpResourceMetrics{{
+		Resource: otlpResource{otlpResourceAttributes},
+		ScopeMetrics: []otlpScopeMetrics{{
+			Scope:   otlpScope{otlpScopeName},
+			Metrics: []otlpMetric{up, latency, failures},
+		}},
+	}}}
+}
+
+// OTLP span kind and status codes.
+const (
+	otlpSpanKindClient  = 3
+	otlpStatusCodeOK    = 1
+	otlpStatusCodeError = 2
+)
+
+func otlpTracesRequest(batch []ProbeSpan) otlpTracesPayload {
+	spans := make([]otlpSpan, 0, len(batch))
+	for _, s := range batch {
+		span := otlpSpan{
+			TraceID:           hex.EncodeToString(s.TraceID[:]),
+			SpanID:            hex.EncodeToString(s.SpanID[:]),
+			Name:              "probe " + s.Identifier,
+			Kind:              otlpSpanKindClient,
+			StartTimeUnixNano: strconv.FormatInt(s.Start.UnixNano(), 10),
+			EndTimeUnixNano:   strconv.FormatInt(s.End.UnixNano(), 10),
+			Attributes: []otlpAttribute{
+				{"meow.endpoint", otlpValue{StringValue: s.Identifier}},
+				{"url.full", otlpValue{StringValue: s.URL}},
+				{"http.request.method", otlpValue{StringValue: s.Method}},
+				{"http.response.status_code", otlpValue{IntValue: strconv.Itoa(s.Status)}},
+				{"meow.latency_ms", otlpValue{IntValue: strconv.FormatInt(s.End.Sub(s.Start).Milliseconds(), 10)}},
+			},
+			Status: otlpSpanStatus{Code: otlpStatusCodeOK},
+		}
+		if s.Error != "" {
+			span.Status = otlpSpanStatus{Code: otlpStatusCodeError, Message: s.Error}
+		}
+		spans = append(spans, span)
+	}
+	return otlpTracesPayload{[]otlpResourceSpans{{
+		Resource: otlpResource{otlpResourceAttributes},
+		ScopeSpans: []otlpScopeSpans{{
+			Scope: otlpScope{otlpScopeName},
+			Spans: spans,
+		}},
+	}}}
+}
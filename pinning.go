@@ -0,0 +1,51 @@
+package meow
+
+import (
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// CertificatePinError is the error of a TLS handshake, in which the peer
+// certificate does not have the pinned fingerprint.
+type CertificatePinError struct {
+	Expected string
+	Observed string
+}
+
+// Error returns both the observed and the expected fingerprint.
+func (c *CertificatePinError) Error() string {
+	return fmt.Sprintf("certificate fingerprint is %s, expected %s", c.Observed, c.Expected)
+}
+
+// CertificateFingerprint returns the hex-encoded SHA-256 hash of the
+// DER-encoded certificate der.
+func CertificateFingerprint(der []byte) string {
+	sum := sha256.Sum256(der)
+	return hex.EncodeToString(sum[:])
+}
+
+// normalizeFingerprint converts the fingerprint raw to lowercase and removes
+// its colons, as printed by "openssl x509 -fingerprint -sha256".
+func normalizeFingerprint(raw string) string {
+	return strings.ToLower(strings.ReplaceAll(raw, ":", ""))
+}
+
+// VerifyPinnedCertificate returns a function to be used as the
+// VerifyPeerCertificate callback of a tls.Config, which fails the handshake
+// with a *CertificatePinError unless the peer's leaf certificate has the
+// fingerprint pinned.
+func VerifyPinnedCertificate(pinned string) func([][]byte, [][]*x509.Certificate) error {
+	return func(rawCerts [][]byte, _ [][]*x509.Certificate) error {
+		if len(rawCerts) == 0 {
+			return errors.New("peer presented no certificate")
+		}
+		if observed := CertificateFingerprint(rawCerts[0]); observed != pinned {
+			return &CertificatePinError{Expected: pinned, Observed: observed}
+		}
+		return nil
+	}
+}
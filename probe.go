@@ -0,0 +1,711 @@
+package meow
+
+import (
+	"bytes"
+	"container/list"
+	"context"
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptrace"
+	"net/url"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ProbeResult is the outcome of a single probe of an endpoint performed on
+// demand, which is neither persisted nor alerted on.
+type ProbeResult struct {
+	Identifier string `json:"identifier"`
+
+	// State is online, degraded, offline, or redirect (see ProbeEndpoint),
+	// and OK indicates whether or not the probe succeeded.
+	State State `json:"state"`
+	OK    bool  `json:"ok"`
+
+	// StatusCode is 0 if no response was received.
+	StatusCode int    `json:"status_code,omitempty"`
+	Latency    string `json:"latency"`
+	TTFB       string `json:"ttfb,omitempty"`
+
+	FailureKind      FailureKind `json:"failure_kind,omitempty"`
+	Error            string      `json:"error,omitempty"`
+	RedirectLocation string      `json:"redirect_location,omitempty"`
+}
+
+// NewProbeResult reports the result of probing the endpoint identified by
+// identifier on demand.
+func NewProbeResult(identifier string, result CheckResult) ProbeResult {
+	probeResult := ProbeResult{
+		Identifier:       identifier,
+		State:            result.State,
+		OK:               result.FailureKind == "",
+		StatusCode:       result.StatusCode,
+		Latency:          result.Latency.String(),
+		FailureKind:      result.FailureKind,
+		Error:            result.Error,
+		RedirectLocation: result.RedirectLocation,
+	}
+	if result.StatusCode != 0 {
+		probeResult.TTFB = result.TTFB.String()
+	}
+	return probeResult
+}
+
+// ProbeInput holds the inputs of a probe beyond the endpoint's configuration,
+// which are attached to the context of ProbeEndpoint using WithProbeInput.
+type ProbeInput struct {
+	// Clients provides the HTTP client the endpoint is probed with. If nil,
+	// a cache shared by the whole process is used.
+	Clients *ClientCache
+
+	// Extracted is the value extracted from the previous response (see
+	// CheckResult.Extracted), and Traceparent the trace context propagated,
+	// unless they are empty.
+	Extracted   string
+	Traceparent string
+
+	// ExpectedBuild is the build the endpoint must report in its
+	// ExpectBuildHeader, unless it is empty.
+	ExpectedBuild string
+
+	// FreshConnections demands that the endpoint is probed over connections
+	// of its own, which are closed after use, e.g. because the previous probe
+	// failed, so that a possibly broken pooled connection is not reused. The
+	// connections pooled by Clients for other endpoints are left alone.
+	FreshConnections bool
+}
+
+type probeInputKey struct{}
+
+// WithProbeInput returns a copy of ctx, which makes ProbeEndpoint probe with
+// the given input.
+func WithProbeInput(ctx context.Context, input ProbeInput) context.Context {
+	return context.WithValue(ctx, probeInputKey{}, input)
+}
+
+// defaultClients are the clients used by ProbeEndpoint unless its input
+// provides others.
+var defaultClients = NewClientCache(8)
+
+// ProbeEndpoint probes the endpoint e once, bound to ctx and the endpoint's
+// ProbeTimeout: it requests the endpoint (along with its concurrent probes, if
+// any) and its check paths, and evaluates the responses. The result's state is
+// redirect (see Redirected), offline if the probe failed, degraded if the time
+// to the first byte exceeds MaxTTFB or some concurrent probes failed, and
+// online otherwise; its consecutive failures are left to the caller. An error
+// is only returned if the probe could not be performed, e.g. because ctx is
+// done already.
+func ProbeEndpoint(ctx context.Context, e *Endpoint) (CheckResult, error) {
+	if err := ctx.Err(); err != nil {
+		return CheckResult{}, fmt.Errorf("probe %s: %v", e.Identifier, err)
+	}
+	input, _ := ctx.Value(probeInputKey{}).(ProbeInput)
+	clients := input.Clients
+	if clients == nil {
+		clients = defaultClients
+	}
+	client := clients.Get(*e)
+	if input.FreshConnections {
+		if fresh := freshClient(client); fresh != nil {
+			client = fresh
+		}
+	}
+	ctx, cancel := context.WithTimeout(ctx, e.ProbeTimeout())
+	defer cancel()
+	start := time.Now()
+	var checkFailure *ProbeError
+	var concurrentSuccesses int
+	var checks sync.WaitGroup
+	checks.Add(1)
+	go func() {
+		defer checks.Done()
+		checkFailure = RequestCheckPaths(ctx, client, *e, input.Traceparent)
+	}()
+	if e.ConcurrentProbes > 1 {
+		checks.Add(1)
+		go func() {
+			defer checks.Done()
+			concurrentSuccesses = RequestConcurrently(ctx, client, *e, int(e.ConcurrentProbes)-1, input.Traceparent)
+		}()
+	}
+	res, err := RequestEndpoint(ctx, client, *e, input.Extracted, input.Traceparent)
+	checks.Wait()
+	result := CheckResult{State: StateOnline, LastChecked: start, Latency: time.Since(start)}
+	var failure *ProbeError
+	if err != nil {
+		failure = ClassifyError(err)
+		var pinErr *CertificatePinError
+		if errors.As(err, &pinErr) {
+			result.CertSHA256 = pinErr.Observed
+		}
+	} else {
+		result.StatusCode, result.TTFB = res.Status, res.TTFB
+		if e.ExtractRegex != nil {
+			if match := e.ExtractRegex.FindSubmatch(res.Body); match != nil {
+				result.Extracted = string(match[1])
+			}
+		}
+		if e.ExpectBodyHash != "" && !res.Truncated {
+			result.BodyHash = BodyHash(res.Body)
+		}
+		if e.ExpectSetCookie != nil {
+			result.SetCookie, _ = e.ExpectSetCookie.Check(res.Header)
+		}
+		if e.ExpectBuildHeader != "" {
+			result.Build = res.Header.Get(e.ExpectBuildHeader)
+		}
+		if e.ExpectStatusText != "" {
+			result.StatusText = res.StatusText
+		}
+		result.ContentEncoding = res.Encoding
+		if len(e.CaptureHeaderNames) > 0 {
+			result.Headers = e.CaptureHeaders(res.Header, CurrentSettings().RedactHeaders)
+		}
+		failure = EvaluateResponse(*e, res)
+		if failure == nil && input.ExpectedBuild != "" && result.Build != input.ExpectedBuild {
+			failure = &ProbeError{Kind: FailureAssertion,
+				Err: fmt.Errorf("build is %q, expected %q", result.Build, input.ExpectedBuild)}
+		} else if failure == nil {
+			failure = checkFailure
+		}
+		if Redirected(*e, res) {
+			result.State = StateRedirect
+			result.RedirectLocation = res.Header.Get("Location")
+		}
+	}
+	if e.ConcurrentProbes > 1 {
+		successes := concurrentSuccesses
+		if failure == nil {
+			successes++
+		}
+		concurrency := float64(successes) / float64(e.ConcurrentProbes)
+		result.Concurrency = &concurrency
+	}
+	switch {
+	case result.State == StateRedirect:
+	case failure != nil:
+		result.State = StateOffline
+	case e.MaxTTFB > 0 && result.TTFB > e.MaxTTFB:
+		result.State = StateDegraded
+	case result.Concurrency != nil && *result.Concurrency < 1:
+		result.State = StateDegraded
+	}
+	if failure != nil {
+		result.FailureKind, result.Error = failure.Kind, failure.Err.Error()
+	}
+	return result, nil
+}
+
+// transportKey consists of the endpoint fields affecting the transport of its
+// HTTP client, and whether or not the client follows redirects.
+type transportKey struct {
+	insecureSkipVerify bool
+	proxy              string
+	pinnedCertSHA256   string
+	noRedirects        bool
+	resolver           string
+	dialTimeout        time.Duration
+}
+
+// ClientCache holds HTTP clients shared between endpoints with identical
+// transport settings, so that their connections are reused.
+type ClientCache struct {
+	mu      sync.Mutex
+	clients map[transportKey]*list.Element
+	max     int
+
+	// recent orders the clients from the most to the least recently used.
+	recent *list.List
+}
+
+// cachedClient is the client held by a ClientCache for the transport settings
+// of its key.
+type cachedClient struct {
+	key    transportKey
+	client *http.Client
+}
+
+// NewClientCache creates a ClientCache holding up to size clients (at least
+// one).
+func NewClientCache(size int) *ClientCache {
+	return &ClientCache{clients: make(map[transportKey]*list.Element), max: max(size, 1), recent: list.New()}
+}
+
+// Get returns the client for the transport settings of the endpoint e. Once
+// the cache is full, the least recently used client is evicted, and its idle
+// connections are closed; requests still performed with it remain unaffected.
+func (c *ClientCache) Get(e Endpoint) *http.Client {
+	key := transportKey{insecureSkipVerify: e.InsecureSkipVerify, pinnedCertSHA256: e.PinnedCertSHA256,
+		noRedirects: e.RedirectCountsAs != "", resolver: e.Resolver,
+		dialTimeout: e.DialTimeout}
+	if e.Proxy != nil {
+		key.proxy = e.Proxy.String()
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if element, ok := c.clients[key]; ok {
+		c.recent.MoveToFront(element)
+		return element.Value.(*cachedClient).client
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	if key.insecureSkipVerify || key.pinnedCertSHA256 != "" {
+		transport.TLSClientConfig = &tls.Config{InsecureSkipVerify: key.insecureSkipVerify}
+		if key.pinnedCertSHA256 != "" {
+			transport.TLSClientConfig.VerifyPeerCertificate = VerifyPinnedCertificate(key.pinnedCertSHA256)
+		}
+	}
+	if e.Proxy != nil {
+		transport.Proxy = http.ProxyURL(e.Proxy)
+	}
+	if key.resolver != "" || key.dialTimeout > 0 {
+		transport.DialContext = newDialer(key.resolver, key.dialTimeout).DialContext
+	}
+	client := &http.Client{Transport: transport}
+	if key.noRedirects {
+		// the redirect is reported rather than followed
+		client.CheckRedirect = func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		}
+	}
+	if c.recent.Len() >= c.max {
+		evicted := c.recent.Remove(c.recent.Back()).(*cachedClient)
+		delete(c.clients, evicted.key)
+		evicted.client.CloseIdleConnections()
+	}
+	c.clients[key] = c.recent.PushFront(&cachedClient{key, client})
+	return client
+}
+
+// newDialer returns a dialer like the one of http.DefaultTransport, but
+// resolving hosts with the DNS server at resolver rather than the system's,
+// unless it is empty, and giving up after timeout, unless it is 0. The timeout
+// spans both resolving the host and connecting to it.
+func newDialer(resolver string, timeout time.Duration) *net.Dialer {
+	dialer := &net.Dialer{
+		Timeout:   30 * time.Second,
+		KeepAlive: 30 * time.Second,
+	}
+	if timeout > 0 {
+		dialer.Timeout = timeout
+	}
+	if resolver != "" {
+		dialer.Resolver = &net.Resolver{
+			PreferGo: true,
+			Dial: func(ctx context.Context, network, _ string) (net.Conn, error) {
+				var d net.Dialer
+				return d.DialContext(ctx, network, resolver)
+			},
+		}
+	}
+	return dialer
+}
+
+// Response is the outcome of a request to an endpoint.
+type Response struct {
+	// Status is the HTTP status code, and StatusText the reason phrase of the
+	// status line.
+	Status     int
+	StatusText string
+
+	// Body holds up to MaxBodySize bytes of the response body, decoded
+	// from the transfer encoding (e.g. chunked) by the HTTP client.
+	Body []byte
+
+	// Truncated indicates that the body was larger than MaxBodySize.
+	Truncated bool
+
+	// TTFB is the time from sending the request until the first byte of the
+	// response was received.
+	TTFB time.Duration
+
+	// Trailer holds the trailers received after the body, which are only
+	// available if the body was read completely.
+	Trailer http.Header
+
+	// Complete indicates that the body was read until EOF.
+	Complete bool
+
+	// Header holds the response headers.
+	Header http.Header
+
+	// Encoding is the content encoding of the body, and CompressionErr the
+	// error decompressing it, which are only set for endpoints expecting valid
+	// compression. The body is decoded then.
+	Encoding       string
+	CompressionErr error
+}
+
+// reasonPhrase returns the reason phrase of the status line status, e.g. "Not
+// Found" of "404 Not Found".
+func reasonPhrase(status string) string {
+	_, phrase, _ := strings.Cut(status, " ")
+	return phrase
+}
+
+// RequestEndpoint performs a request to the endpoint e using the client, whose
+// body is resolved from e.BodySource, if set. The value extracted from the
+// previous response is sent in the header e.ExtractHeader, unless it is empty.
+// The Host header is overridden by e.HostHeader, if set. For endpoints with a
+// ConnectTarget, a CONNECT request for the target is sent to the endpoint. The
+// request is bound to ctx, and propagates the trace context traceparent,
+// unless it is empty.
+func RequestEndpoint(ctx context.Context, client *http.Client, e Endpoint, extracted, traceparent string) (*Response, error) {
+	var requestBody io.Reader
+	var data []byte
+	if e.BodySource != nil {
+		var err error
+		if data, err = e.BodySource.Render(os.Getenv("MEOW_BODY_DIR")); err != nil {
+			return nil, fmt.Errorf("prepare request body %v: %v", e, err)
+		}
+		requestBody = bytes.NewReader(data)
+	}
+	if e.Protocol == ProtocolGRPCWeb {
+		// the body source (if any) provides the serialized message
+		requestBody = bytes.NewReader(GRPCWebFrame(data))
+	}
+	req, err := http.NewRequestWithContext(ctx, e.Method, e.URL.String(), requestBody)
+	if err != nil {
+		return nil, fmt.Errorf("prepare request %v: %v", e, err)
+	}
+	e.SetRequestHeaders(req.Header)
+	if e.Protocol == ProtocolGRPCWeb {
+		req.Header.Set("Content-Type", GRPCWebContentType)
+		req.Header.Set("Accept", GRPCWebContentType)
+		req.Header.Set("X-Grpc-Web", "1")
+	}
+	if e.ExpectValidCompression {
+		// the compressed body is not decoded transparently then
+		req.Header.Set("Accept-Encoding", AcceptedEncodings)
+	}
+	if e.HostHeader != "" {
+		req.Host = e.HostHeader
+	}
+	if e.ConnectTarget != "" {
+		// the endpoint is dialed, but the request line names the target
+		req.URL.Path, req.URL.RawPath, req.URL.RawQuery = "", "", ""
+		req.Host = e.ConnectTarget
+	}
+	if e.ExtractHeader != "" && extracted != "" {
+		req.Header.Set(e.ExtractHeader, extracted)
+	}
+	if traceparent != "" {
+		req.Header.Set("Traceparent", traceparent)
+	}
+	var ttfb time.Duration
+	start := time.Now()
+	trace := &httptrace.ClientTrace{
+		GotFirstResponseByte: func() { ttfb = time.Since(start) },
+	}
+	req = req.WithContext(httptrace.WithClientTrace(req.Context(), trace))
+	res, err := doRetrying(client, req)
+	if err != nil {
+		return nil, fmt.Errorf("perform request %v: %w", e, err)
+	}
+	defer res.Body.Close()
+	if e.ConnectTarget != "" {
+		// the body of a successful CONNECT is the tunnel, which is not used
+		return &Response{Status: res.StatusCode, StatusText: reasonPhrase(res.Status), TTFB: ttfb,
+			Complete: true, Header: res.Header}, nil
+	}
+	body, truncated, err := readBody(res.Body)
+	if err != nil {
+		return nil, fmt.Errorf("read body %v: %w", e, err)
+	}
+	complete := !truncated
+	if truncated && e.ExpectTrailer != "" {
+		// trailers are only available after reading the body until EOF
+		n, err := io.Copy(io.Discard, io.LimitReader(res.Body, maxDrainSize+1))
+		if err != nil {
+			return nil, fmt.Errorf("drain body %v: %w", e, err)
+		}
+		complete = n <= maxDrainSize
+	}
+	result := &Response{res.StatusCode, reasonPhrase(res.Status), body, truncated, ttfb, res.Trailer, complete, res.Header, "", nil}
+	if e.ExpectValidCompression {
+		result.Encoding = res.Header.Get("Content-Encoding")
+		if result.Encoding == "" {
+			result.Encoding = "identity"
+		}
+		result.Body, result.Truncated, result.CompressionErr = DecodeBody(result.Encoding, body, truncated)
+	}
+	return result, nil
+}
+
+// doRetrying performs the request req using the client. If it fails with a
+// transport error before the response headers were received (e.g. an HTTP/2
+// GOAWAY or a reset of a pooled connection), it is retried once on a fresh
+// connection, unless disabled by the settings. The error of the retry is
+// returned if it fails as well.
+func doRetrying(client *http.Client, req *http.Request) (*http.Response, error) {
+	res, err := client.Do(req)
+	if err == nil || !CurrentSettings().RetryTransportErrors || req.Context().Err() != nil {
+		return res, err
+	}
+	switch ClassifyError(err).Kind {
+	case FailureGoAway, FailureReset:
+	default:
+		return nil, err
+	}
+	retryClient := freshClient(client)
+	if retryClient == nil {
+		return nil, err
+	}
+	retry := req.Clone(req.Context())
+	if req.GetBody != nil {
+		if retry.Body, err = req.GetBody(); err != nil {
+			return nil, err
+		}
+	}
+	return retryClient.Do(retry)
+}
+
+// freshClient returns a copy of the client with a transport of its own, whose
+// connections are closed after use, or nil, if the client's transport is not
+// an *http.Transport.
+func freshClient(client *http.Client) *http.Client {
+	transport, ok := client.Transport.(*http.Transport)
+	if !ok {
+		return nil
+	}
+	fresh := transport.Clone()
+	fresh.DisableKeepAlives = true
+	return &http.Client{Transport: fresh, CheckRedirect: client.CheckRedirect, Jar: client.Jar}
+}
+
+// RequestConcurrently issues n requests to the endpoint e at once using the
+// client, bound to ctx, and returns the number of them that succeeded, i.e.
+// responded with the expected status (and gRPC status). They are issued along
+// with the probe's own request, which uses a connection of its own unless the
+// requests are multiplexed (e.g. over HTTP/2).
+func RequestConcurrently(ctx context.Context, client *http.Client, e Endpoint, n int, traceparent string) int {
+	var successes atomic.Int64
+	var wg sync.WaitGroup
+	for range n {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			res, err := RequestEndpoint(ctx, client, e, "", traceparent)
+			if err == nil && res.Status == int(e.StatusOnline) && grpcWebStatus(e, res) == nil {
+				successes.Add(1)
+			}
+		}()
+	}
+	wg.Wait()
+	return int(successes.Load())
+}
+
+// maxParallelChecks is the maximum number of check paths of an endpoint that
+// are requested concurrently.
+const maxParallelChecks = 4
+
+// RequestCheckPaths requests the check paths of the endpoint e concurrently
+// (at most maxParallelChecks at a time) using the client, bound to ctx. The
+// failure of the first check path (in their configured order) that failed is
+// returned, or nil, if all of them responded with the expected status.
+func RequestCheckPaths(ctx context.Context, client *http.Client, e Endpoint, traceparent string) *ProbeError {
+	failures := make([]*ProbeError, len(e.CheckPaths))
+	slots := make(chan struct{}, maxParallelChecks)
+	var wg sync.WaitGroup
+	for i, path := range e.CheckPaths {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			select {
+			case slots <- struct{}{}:
+				defer func() { <-slots }()
+			case <-ctx.Done():
+				failures[i] = ClassifyError(fmt.Errorf("check path %s: %w", path, ctx.Err()))
+				return
+			}
+			failures[i] = requestCheckPath(ctx, client, e, path, traceparent)
+		}()
+	}
+	wg.Wait()
+	for _, failure := range failures {
+		if failure != nil {
+			return failure
+		}
+	}
+	return nil
+}
+
+// requestCheckPath requests the path relative to the URL of the endpoint e, and
+// returns a failure, unless it responded with the expected status.
+func requestCheckPath(ctx context.Context, client *http.Client, e Endpoint, path, traceparent string) *ProbeError {
+	ref, err := url.Parse(path)
+	if err != nil {
+		return &ProbeError{Kind: FailureOther, Err: fmt.Errorf("parse check path %s: %v", path, err)}
+	}
+	target := e.URL.ResolveReference(ref)
+	req, err := http.NewRequestWithContext(ctx, e.Method, target.String(), nil)
+	if err != nil {
+		return &ProbeError{Kind: FailureOther, Err: fmt.Errorf("prepare request: %s %s %s: %v",
+			e.Identifier, e.Method, SanitizeURL(target, CurrentSettings().LogSafeParams), err)}
+	}
+	e.SetRequestHeaders(req.Header)
+	if e.HostHeader != "" {
+		req.Host = e.HostHeader
+	}
+	if traceparent != "" {
+		req.Header.Set("Traceparent", traceparent)
+	}
+	res, err := doRetrying(client, req)
+	if err != nil {
+		return ClassifyError(fmt.Errorf("check path %s: %w", path, err))
+	}
+	defer res.Body.Close()
+	// allow the connection to be reused
+	io.Copy(io.Discard, io.LimitReader(res.Body, MaxBodySize))
+	if res.StatusCode != int(e.StatusOnline) {
+		return &ProbeError{Kind: FailureStatus,
+			Err: fmt.Errorf("check path %s: expected status %d, got %d", path, e.StatusOnline, res.StatusCode)}
+	}
+	return nil
+}
+
+// grpcWebStatus returns the error indicated by the gRPC status of the response
+// res, if the endpoint e is probed using gRPC-web, and nil otherwise.
+func grpcWebStatus(e Endpoint, res *Response) error {
+	if e.Protocol != ProtocolGRPCWeb {
+		return nil
+	}
+	return GRPCWebStatus(res.Header, res.Trailer, res.Body, res.Truncated)
+}
+
+// isRedirect indicates whether or not status is a redirect, i.e. a 3xx status
+// other than 304 Not Modified, which has no target.
+func isRedirect(status int) bool {
+	return status >= 300 && status < 400 && status != http.StatusNotModified
+}
+
+// Redirected indicates whether or not the response res is a redirect to be
+// reported, because the endpoint e does not follow redirects, and did not
+// expect the status either.
+func Redirected(e Endpoint, res *Response) bool {
+	return e.RedirectCountsAs != "" && res.Status != int(e.StatusOnline) && isRedirect(res.Status)
+}
+
+// EvaluateResponse checks the response res of the endpoint e against its
+// expected status (including the status text and gRPC status) and its further
+// assertions, and returns the failure of the first check that failed, or nil.
+// A redirect reported (see Redirected) only fails if it counts as offline.
+func EvaluateResponse(e Endpoint, res *Response) *ProbeError {
+	if Redirected(e, res) {
+		if e.RedirectCountsAs == StateOffline {
+			return &ProbeError{Kind: FailureStatus,
+				Err: fmt.Errorf("redirected with status %d to %q", res.Status, res.Header.Get("Location"))}
+		}
+		return nil
+	}
+	if res.Status != int(e.StatusOnline) {
+		return &ProbeError{Kind: FailureStatus,
+			Err: fmt.Errorf("expected status %d, got %d", e.StatusOnline, res.Status)}
+	}
+	if e.ExpectStatusText != "" && !strings.Contains(res.StatusText, e.ExpectStatusText) {
+		return &ProbeError{Kind: FailureStatus,
+			Err: fmt.Errorf("expected status text containing %q, got %q", e.ExpectStatusText, res.StatusText)}
+	}
+	if err := grpcWebStatus(e, res); err != nil {
+		return &ProbeError{Kind: FailureStatus, Err: err}
+	}
+	if err := CheckResponse(e, res); err != nil {
+		return &ProbeError{Kind: FailureAssertion, Err: err}
+	}
+	if e.ExpectSetCookie != nil {
+		if _, err := e.ExpectSetCookie.Check(res.Header); err != nil {
+			return &ProbeError{Kind: FailureAssertion, Err: err}
+		}
+	}
+	return nil
+}
+
+// CheckResponse checks the response res of the endpoint e, which returned the
+// expected status, against the endpoint's further assertions, and returns an
+// error describing the first assertion that failed.
+func CheckResponse(e Endpoint, res *Response) error {
+	if res.CompressionErr != nil {
+		return res.CompressionErr
+	}
+	if e.ResponseSchema != nil {
+		if res.Truncated {
+			return fmt.Errorf("body exceeds %d bytes, cannot validate against schema", MaxBodySize)
+		}
+		if err := e.ResponseSchema.ValidateJSON(res.Body); err != nil {
+			return fmt.Errorf("body violates schema: %v", err)
+		}
+	}
+	if e.ExpectBodyHash != "" {
+		if res.Truncated {
+			return fmt.Errorf("body exceeds %d bytes, cannot compare its hash", MaxBodySize)
+		}
+		if hash := BodyHash(res.Body); hash != e.ExpectBodyHash {
+			return fmt.Errorf("body hash is %s, expected %s", hash, e.ExpectBodyHash)
+		}
+	}
+	if e.BodyContains != "" && !bytes.Contains(res.Body, []byte(e.BodyContains)) {
+		if res.Truncated {
+			return fmt.Errorf("body lacks %q within its first %d bytes", e.BodyContains, MaxBodySize)
+		}
+		return fmt.Errorf("body lacks %q", e.BodyContains)
+	}
+	if e.BodyNotContains != "" && bytes.Contains(res.Body, []byte(e.BodyNotContains)) {
+		return fmt.Errorf("body contains %q", e.BodyNotContains)
+	}
+	if e.ExpectJSONPath != nil {
+		if res.Truncated {
+			return fmt.Errorf("body exceeds %d bytes, cannot evaluate %s", MaxBodySize, e.ExpectJSONPath)
+		}
+		if err := e.ExpectJSONPath.Check(res.Body); err != nil {
+			return err
+		}
+	}
+	if e.ExpectTrailer != "" {
+		if !res.Complete {
+			return fmt.Errorf("body too large to inspect trailer %s", e.ExpectTrailer)
+		}
+		values, ok := res.Trailer[http.CanonicalHeaderKey(e.ExpectTrailer)]
+		if !ok {
+			return fmt.Errorf("trailer %s is absent", e.ExpectTrailer)
+		}
+		if e.ExpectTrailerValue != "" && !slices.Contains(values, e.ExpectTrailerValue) {
+			return fmt.Errorf("trailer %s is %q, expected %q",
+				e.ExpectTrailer, strings.Join(values, ", "), e.ExpectTrailerValue)
+		}
+	}
+	return nil
+}
+
+// BodyHash returns the hex-encoded SHA-256 hash of body.
+func BodyHash(body []byte) string {
+	sum := sha256.Sum256(body)
+	return hex.EncodeToString(sum[:])
+}
+
+// maxDrainSize is the maximum number of bytes discarded after MaxBodySize
+// in order to read the trailers of a response.
+const maxDrainSize = 16 << 20
+
+// readBody reads up to MaxBodySize bytes from body until EOF. The size is
+// never derived from the Content-Length header, which is not set for chunked
+// responses. Whether or not the body exceeded the limit is indicated as
+// truncated.
+func readBody(body io.Reader) ([]byte, bool, error) {
+	data, err := io.ReadAll(io.LimitReader(body, MaxBodySize+1))
+	if err != nil {
+		return nil, false, err
+	}
+	if len(data) > MaxBodySize {
+		return data[:MaxBodySize], true, nil
+	}
+	return data, false, nil
+}
@@ -0,0 +1,472 @@
+package meow
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// probedEndpoint creates an endpoint of the server's URL, which is modified by
+// change before it is validated.
+func probedEndpoint(t *testing.T, server *httptest.Server, change func(*EndpointPayload)) *Endpoint {
+	t.Helper()
+	payload := validPayload()
+	payload.URL = server.URL
+	if change != nil {
+		change(&payload)
+	}
+	return mustEndpoint(t, payload)
+}
+
+func TestProbeEndpoint(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/slow":
+			time.Sleep(50 * time.Millisecond)
+		case "/missing":
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte("token=abc123"))
+			return
+		case "/moved":
+			http.Redirect(w, r, "/", http.StatusFound)
+			return
+		}
+		w.Header().Set("X-Build", "2026.10.1")
+		w.Write([]byte("token=abc123"))
+	}))
+	defer server.Close()
+	tests := []struct {
+		name   string
+		change func(*EndpointPayload)
+		input  ProbeInput
+		state  State
+		kind   FailureKind
+	}{
+		{"online", nil, ProbeInput{}, StateOnline, ""},
+		{"unexpected status", func(p *EndpointPayload) { p.URL += "/missing" }, ProbeInput{}, StateOffline, FailureStatus},
+		{"substring", func(p *EndpointPayload) { p.BodyContains = "token" }, ProbeInput{}, StateOnline, ""},
+		{"substring with unexpected status", func(p *EndpointPayload) { p.URL += "/missing"; p.BodyContains = "token" },
+			ProbeInput{}, StateOffline, FailureStatus},
+		{"expected status without substring", func(p *EndpointPayload) { p.BodyContains = "healthy" },
+			ProbeInput{}, StateOffline, FailureAssertion},
+		{"failing check path", func(p *EndpointPayload) { p.CheckPaths = []string{"/missing"} }, ProbeInput{}, StateOffline, FailureStatus},
+		{"slow first byte", func(p *EndpointPayload) { p.URL += "/slow"; p.MaxTTFB = "10ms" }, ProbeInput{}, StateDegraded, ""},
+		{"timeout", func(p *EndpointPayload) { p.URL += "/slow"; p.Timeout = "10ms" }, ProbeInput{}, StateOffline, FailureTimeout},
+		{"redirect", func(p *EndpointPayload) { p.URL += "/moved"; p.RedirectCountsAs = "online" }, ProbeInput{}, StateRedirect, ""},
+		{"redirect counting as offline", func(p *EndpointPayload) { p.URL += "/moved"; p.RedirectCountsAs = "offline" },
+			ProbeInput{}, StateRedirect, FailureStatus},
+		{"expected build", func(p *EndpointPayload) { p.ExpectBuildHeader = "X-Build" },
+			ProbeInput{ExpectedBuild: "2026.10.1"}, StateOnline, ""},
+		{"other build", func(p *EndpointPayload) { p.ExpectBuildHeader = "X-Build" },
+			ProbeInput{ExpectedBuild: "2026.09.2"}, StateOffline, FailureAssertion},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			e := probedEndpoint(t, server, test.change)
+			result, err := ProbeEndpoint(WithProbeInput(context.Background(), test.input), e)
+			if err != nil {
+				t.Fatalf("probe endpoint: %v", err)
+			}
+			if result.State != test.state || result.FailureKind != test.kind {
+				t.Errorf("expected state %s with failure %q, got %s with %q (%s)",
+					test.state, test.kind, result.State, result.FailureKind, result.Error)
+			}
+			if result.LastChecked.IsZero() || result.Latency <= 0 {
+				t.Errorf("expected time and latency of the probe, got %v and %v", result.LastChecked, result.Latency)
+			}
+		})
+	}
+}
+
+func TestProbeEndpointExtracted(t *testing.T) {
+	var sent atomic.Value
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		sent.Store(r.Header.Get("X-Token"))
+		w.Write([]byte("token=abc123"))
+	}))
+	defer server.Close()
+	e := probedEndpoint(t, server, func(p *EndpointPayload) {
+		p.ExtractRegex, p.ExtractHeader = "token=([a-z0-9]+)", "X-Token"
+	})
+	result, err := ProbeEndpoint(context.Background(), e)
+	if err != nil || result.Extracted != "abc123" {
+		t.Fatalf(`expected "abc123" to be extracted, got "%s" (%v)`, result.Extracted, err)
+	}
+	if _, err := ProbeEndpoint(WithProbeInput(context.Background(), ProbeInput{Extracted: result.Extracted}), e); err != nil {
+		t.Fatalf("probe endpoint: %v", err)
+	}
+	if token := sent.Load(); token != "abc123" {
+		t.Errorf(`expected extracted value to be sent, got "%v"`, token)
+	}
+	data, err := result.JSON()
+	if err != nil {
+		t.Fatalf("convert to JSON: %v", err)
+	}
+	if strings.Contains(string(data), "abc123") {
+		t.Errorf("expected extracted value not to be reported, got %s", data)
+	}
+}
+
+func TestProbeEndpointConcurrent(t *testing.T) {
+	var requests atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// every other request fails
+		if requests.Add(1)%2 == 0 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	defer server.Close()
+	e := probedEndpoint(t, server, func(p *EndpointPayload) { p.ConcurrentProbes = 4 })
+	result, err := ProbeEndpoint(context.Background(), e)
+	if err != nil {
+		t.Fatalf("probe endpoint: %v", err)
+	}
+	if requests.Load() != 4 {
+		t.Errorf("expected 4 requests, got %d", requests.Load())
+	}
+	if result.Concurrency == nil || *result.Concurrency != 0.5 {
+		t.Fatalf("expected half of the requests to succeed, got %v", result.Concurrency)
+	}
+	// the probe's own request may be one of those failing
+	if failed := result.FailureKind != ""; failed && result.State != StateOffline {
+		t.Errorf("expected offline state, got %s", result.State)
+	} else if !failed && result.State != StateDegraded {
+		t.Errorf("expected degraded state, got %s", result.State)
+	}
+}
+
+func TestProbeEndpointDone(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	e := mustEndpoint(t, validPayload())
+	if _, err := ProbeEndpoint(ctx, e); err == nil {
+		t.Error("expected probe to fail once its context is done")
+	}
+}
+
+func TestNewProbeResult(t *testing.T) {
+	result := NewProbeResult("libvirt", CheckResult{State: StateOffline, FailureKind: FailureTimeout,
+		Error: "deadline exceeded", Latency: 10 * time.Second})
+	expected := ProbeResult{Identifier: "libvirt", State: StateOffline, Latency: "10s",
+		FailureKind: FailureTimeout, Error: "deadline exceeded"}
+	if result != expected {
+		t.Errorf("expected %+v, got %+v", expected, result)
+	}
+	result = NewProbeResult("libvirt", CheckResult{State: StateOnline, StatusCode: 200, TTFB: time.Millisecond})
+	if !result.OK || result.TTFB != "1ms" {
+		t.Errorf("expected successful probe, got %+v", result)
+	}
+}
+
+func TestClientCacheShared(t *testing.T) {
+	clients := NewClientCache(8)
+	base := validPayload()
+	client := clients.Get(*mustEndpoint(t, base))
+	tests := []struct {
+		name   string
+		change func(*EndpointPayload)
+		shared bool
+	}{
+		{"same settings", func(p *EndpointPayload) { p.Identifier, p.URL = "go-dev", "https://go.dev" }, true},
+		{"other method and frequency", func(p *EndpointPayload) { p.Method, p.Frequency = "HEAD", "5m" }, true},
+		{"skip verification", func(p *EndpointPayload) { p.InsecureSkipVerify = true }, false},
+		{"proxy", func(p *EndpointPayload) { p.Proxy = "http://proxy.example.com:3128" }, false},
+		{"resolver", func(p *EndpointPayload) { p.Resolver = "10.0.0.53:53" }, false},
+		{"dial timeout", func(p *EndpointPayload) { p.DialTimeout = "2s" }, false},
+		{"redirects not followed", func(p *EndpointPayload) { p.RedirectCountsAs = "online" }, false},
+	}
+	for _, test := range tests {
+		payload := base
+		test.change(&payload)
+		other := clients.Get(*mustEndpoint(t, payload))
+		if shared := other == client; shared != test.shared {
+			t.Errorf("%s: expected client to be shared %t, got %t", test.name, test.shared, shared)
+		}
+		if again := clients.Get(*mustEndpoint(t, payload)); again != other {
+			t.Errorf("%s: expected client to be cached", test.name)
+		}
+	}
+}
+
+func TestClientCacheLimit(t *testing.T) {
+	closed := make(chan struct{}, 8)
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	server.Config.ConnState = func(_ net.Conn, state http.ConnState) {
+		if state == http.StateClosed {
+			closed <- struct{}{}
+		}
+	}
+	server.Start()
+	defer server.Close()
+	clients := NewClientCache(2)
+	endpoint := func(resolver string) Endpoint {
+		return *probedEndpoint(t, server, func(p *EndpointPayload) { p.Resolver = resolver })
+	}
+	first := clients.Get(endpoint(""))
+	res, err := first.Get(server.URL)
+	if err != nil {
+		t.Fatalf("request %s: %v", server.URL, err)
+	}
+	res.Body.Close()
+	second := clients.Get(endpoint("10.0.0.53:53"))
+	if clients.Get(endpoint("")) != first {
+		t.Fatal("expected first client to be cached")
+	}
+	// evicts the second client, which was used least recently
+	third := clients.Get(endpoint("10.0.0.54:53"))
+	if clients.Get(endpoint("")) != first || clients.Get(endpoint("10.0.0.54:53")) != third {
+		t.Fatal("expected first and third client to be cached")
+	}
+	for element := clients.recent.Front(); element != nil; element = element.Next() {
+		if element.Value.(*cachedClient).client == second {
+			t.Fatal("expected second client to be evicted")
+		}
+	}
+	select {
+	case <-closed:
+		t.Fatal("expected idle connection of first client to be kept")
+	default:
+	}
+	// evicts the first client, closing its idle connection
+	clients.Get(endpoint("10.0.0.55:53"))
+	select {
+	case <-closed:
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected idle connection of evicted client to be closed")
+	}
+	if len(clients.clients) != 2 || clients.recent.Len() != 2 {
+		t.Errorf("expected 2 clients to be cached, got %d", len(clients.clients))
+	}
+}
+
+func TestProbeEndpointFreshConnections(t *testing.T) {
+	// requests on the first connection fail, as if it was broken, until the
+	// connection is given up
+	var first atomic.Value
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		first.CompareAndSwap(nil, r.RemoteAddr)
+		if first.Load() == r.RemoteAddr {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	defer server.Close()
+	clients := NewClientCache(8)
+	e := probedEndpoint(t, server, nil)
+	probe := func(fresh bool) CheckResult {
+		t.Helper()
+		input := ProbeInput{Clients: clients, FreshConnections: fresh}
+		result, err := ProbeEndpoint(WithProbeInput(context.Background(), input), e)
+		if err != nil {
+			t.Fatalf("probe endpoint: %v", err)
+		}
+		return result
+	}
+	if result := probe(false); result.State != StateOffline {
+		t.Fatalf("expected probe over the broken connection to fail, got %s", result.State)
+	}
+	if result := probe(true); result.State != StateOnline {
+		t.Fatalf("expected probe over a fresh connection to succeed, got %s (%s)", result.State, result.Error)
+	}
+	// the pooled connection, which other endpoints share, is left alone
+	if result := probe(false); result.State != StateOffline {
+		t.Errorf("expected pooled connection to be reused, got %s", result.State)
+	}
+}
+
+func TestProbeEndpointHeaders(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-Api-Key") != "key" {
+			w.WriteHeader(http.StatusUnauthorized)
+		}
+	}))
+	defer server.Close()
+	e := probedEndpoint(t, server, func(p *EndpointPayload) {
+		p.Headers = map[string]string{"X-Api-Key": "key"}
+	})
+	result, err := ProbeEndpoint(context.Background(), e)
+	if err != nil {
+		t.Fatalf("probe endpoint: %v", err)
+	}
+	if result.State != StateOnline {
+		t.Errorf("expected headers to be sent, got %s (status %d)", result.State, result.StatusCode)
+	}
+}
+
+func TestProbeEndpointChunked(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		chunks := []string{"status=", "heal", "thy token=ab", "c123"}
+		if r.URL.Path == "/large" {
+			chunks = []string{strings.Repeat("x", MaxBodySize-4), "status=healthy"}
+		}
+		for _, chunk := range chunks {
+			w.Write([]byte(chunk))
+			w.(http.Flusher).Flush()
+		}
+	}))
+	defer server.Close()
+	tests := []struct {
+		name   string
+		change func(*EndpointPayload)
+		state  State
+		error  string
+	}{
+		{"substring across chunks", func(p *EndpointPayload) { p.BodyContains = "healthy" }, StateOnline, ""},
+		{"regex across chunks", func(p *EndpointPayload) { p.ExtractRegex, p.ExtractHeader = "token=([a-z0-9]+)", "X-Token" },
+			StateOnline, ""},
+		{"substring beyond the limit", func(p *EndpointPayload) { p.URL += "/large"; p.BodyContains = "healthy" },
+			StateOffline, "within its first"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			e := probedEndpoint(t, server, test.change)
+			result, err := ProbeEndpoint(context.Background(), e)
+			if err != nil {
+				t.Fatalf("probe endpoint: %v", err)
+			}
+			if result.State != test.state || !strings.Contains(result.Error, test.error) {
+				t.Errorf(`expected state %s with error "%s", got %s with "%s"`, test.state, test.error, result.State, result.Error)
+			}
+			if e.ExtractRegex != nil && result.Extracted != "abc123" {
+				t.Errorf(`expected "abc123" to be extracted, got "%s"`, result.Extracted)
+			}
+		})
+	}
+}
+
+func TestProbeEndpointSlowCheckPath(t *testing.T) {
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/stuck":
+			select {
+			case <-release:
+			case <-r.Context().Done():
+			}
+		case "/slow":
+			time.Sleep(50 * time.Millisecond)
+		}
+	}))
+	defer server.Close()
+	defer close(release)
+	tests := []struct {
+		name  string
+		paths []string
+		state State
+		kind  FailureKind
+	}{
+		{"stuck", []string{"/healthz", "/stuck", "/ready"}, StateOffline, FailureTimeout},
+		// requested one after another, they would exceed the timeout
+		{"slow in parallel", []string{"/slow", "/slow", "/slow", "/slow"}, StateOnline, ""},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			e := probedEndpoint(t, server, func(p *EndpointPayload) {
+				p.CheckPaths = test.paths
+				p.Timeout = "150ms"
+			})
+			start := time.Now()
+			result, err := ProbeEndpoint(context.Background(), e)
+			if err != nil {
+				t.Fatalf("probe endpoint: %v", err)
+			}
+			if elapsed := time.Since(start); elapsed > e.Timeout+100*time.Millisecond {
+				t.Errorf("expected probe to be bound by its timeout of %v, took %v", e.Timeout, elapsed)
+			}
+			if result.State != test.state || result.FailureKind != test.kind {
+				t.Errorf("expected state %s with failure %q, got %s with %q (%s)",
+					test.state, test.kind, result.State, result.FailureKind, result.Error)
+			}
+		})
+	}
+}
+
+// goAwayConn is a connection on which the server seemingly sent an HTTP/2
+// GOAWAY frame before responding.
+type goAwayConn struct {
+	net.Conn
+}
+
+// Write discards p, so that the server never handles the request.
+func (c goAwayConn) Write(p []byte) (int, error) {
+	return len(p), nil
+}
+
+func (c goAwayConn) Read([]byte) (int, error) {
+	return 0, errors.New("http2: server sent GOAWAY and closed the connection; LastStreamID=1, ErrCode=NO_ERROR")
+}
+
+func TestDoRetrying(t *testing.T) {
+	defer ApplySettings(CurrentSettings())
+	var resets, goAways atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if resets.Add(-1) >= 0 {
+			conn, _, err := w.(http.Hijacker).Hijack()
+			if err != nil {
+				t.Errorf("hijack connection: %v", err)
+				return
+			}
+			conn.Close()
+		}
+	}))
+	defer server.Close()
+	var dialer net.Dialer
+	// net/http retries requests failing on reused connections by itself
+	transport := &http.Transport{DisableKeepAlives: true,
+		DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+			conn, err := dialer.DialContext(ctx, network, addr)
+			if err == nil && goAways.Add(-1) >= 0 {
+				return goAwayConn{conn}, nil
+			}
+			return conn, err
+		},
+	}
+	client := &http.Client{Transport: transport}
+	tests := []struct {
+		name    string
+		resets  int32
+		goAways int32
+		retry   bool
+		kind    FailureKind
+	}{
+		{"GOAWAY then success", 0, 1, true, ""},
+		{"reset then success", 1, 0, true, ""},
+		{"reset twice", 2, 0, true, FailureReset},
+		{"GOAWAY twice", 0, 2, true, FailureGoAway},
+		{"without retries", 1, 0, false, FailureReset},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			settings := DefaultSettings()
+			settings.RetryTransportErrors = test.retry
+			ApplySettings(settings)
+			resets.Store(test.resets)
+			goAways.Store(test.goAways)
+			req, err := http.NewRequest(http.MethodGet, server.URL, nil)
+			if err != nil {
+				t.Fatalf("prepare request: %v", err)
+			}
+			res, err := doRetrying(client, req)
+			if err == nil {
+				res.Body.Close()
+			}
+			if test.kind == "" {
+				if err != nil || res.StatusCode != http.StatusOK {
+					t.Errorf("expected retry to succeed, got %v", err)
+				}
+				return
+			}
+			if err == nil {
+				t.Fatalf("expected failure %s, got status %d", test.kind, res.StatusCode)
+			}
+			if kind := ClassifyError(err).Kind; kind != test.kind {
+				t.Errorf("expected failure %s, got %s (%v)", test.kind, kind, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,92 @@
+package meow
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"syscall"
+)
+
+// FailureKind classifies how a probe failed.
+type FailureKind string
+
+// Kinds of probe failures.
+const (
+	FailureDNS        FailureKind = "dns"
+	FailureNXDomain   FailureKind = "dns_nxdomain"
+	FailureDNSTimeout FailureKind = "dns_timeout"
+	FailureTimeout    FailureKind = "timeout"
+	FailureRefused    FailureKind = "connection_refused"
+	FailureReset      FailureKind = "connection_reset"
+	FailureGoAway     FailureKind = "http2_goaway"
+	FailureTLS        FailureKind = "tls"
+	FailureStatus     FailureKind = "status"
+	FailureAssertion  FailureKind = "assertion"
+	FailureOther      FailureKind = "other"
+)
+
+// ProbeError is the error of a failed probe, classified by its kind.
+type ProbeError struct {
+	Kind FailureKind
+	Err  error
+}
+
+// Error returns the kind and the message of the underlying error.
+func (p *ProbeError) Error() string {
+	return fmt.Sprintf("%s: %v", p.Kind, p.Err)
+}
+
+// Unwrap returns the underlying error.
+func (p *ProbeError) Unwrap() error {
+	return p.Err
+}
+
+// ClassifyError wraps the error err of a failed request as a ProbeError of the
+// according kind. If err already is a ProbeError, it is returned as is.
+func ClassifyError(err error) *ProbeError {
+	var probeErr *ProbeError
+	if errors.As(err, &probeErr) {
+		return probeErr
+	}
+	return &ProbeError{classify(err), err}
+}
+
+func classify(err error) FailureKind {
+	var dnsErr *net.DNSError
+	var certErr *tls.CertificateVerificationError
+	var recordErr tls.RecordHeaderError
+	var authorityErr x509.UnknownAuthorityError
+	var hostnameErr x509.HostnameError
+	var invalidErr x509.CertificateInvalidError
+	var pinErr *CertificatePinError
+	var netErr net.Error
+	switch {
+	case errors.As(err, &dnsErr) && dnsErr.IsNotFound:
+		return FailureNXDomain
+	case errors.As(err, &dnsErr) && dnsErr.IsTimeout:
+		return FailureDNSTimeout
+	case errors.As(err, &dnsErr):
+		return FailureDNS
+	case errors.Is(err, context.DeadlineExceeded),
+		errors.As(err, &netErr) && netErr.Timeout():
+		return FailureTimeout
+	case errors.Is(err, syscall.ECONNREFUSED):
+		return FailureRefused
+	case strings.Contains(err.Error(), "GOAWAY"):
+		// the HTTP/2 errors of net/http are not exported
+		return FailureGoAway
+	case errors.Is(err, syscall.ECONNRESET), errors.Is(err, syscall.EPIPE),
+		errors.Is(err, io.ErrUnexpectedEOF), errors.Is(err, io.EOF):
+		return FailureReset
+	case errors.As(err, &certErr), errors.As(err, &recordErr),
+		errors.As(err, &authorityErr), errors.As(err, &hostnameErr),
+		errors.As(err, &invalidErr), errors.As(err, &pinErr):
+		return FailureTLS
+	}
+	return FailureOther
+}
@@ -0,0 +1,109 @@
+package meow
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math"
+	"strings"
+	"time"
+)
+
+// PrometheusUpMetric is the name of the meow.probe.up gauge as exported to
+// Prometheus, e.g. through an OpenTelemetry collector.
+const PrometheusUpMetric = "meow_probe_up"
+
+// PrometheusRules returns a Prometheus alerting rules file with a rule for each
+// of the endpoints, which fires once the endpoint has been down for as long as
+// it takes meow to consider it offline: fail_after probes, or, if stability is
+// required, as many failed probes as the stability window tolerates plus one.
+func PrometheusRules(endpoints []Endpoint) []byte {
+	var buf bytes.Buffer
+	buf.WriteString("groups:\n  - name: meow\n")
+	if len(endpoints) == 0 {
+		buf.WriteString("    rules: []\n")
+		return buf.Bytes()
+	}
+	buf.WriteString("    rules:\n")
+	for _, e := range endpoints {
+		failures := max(int(e.FailAfter), 1)
+		if e.StabilityWindow > 0 {
+			failures = int(e.StabilityWindow-e.StabilityThreshold) + 1
+		}
+		summary := fmt.Sprintf("%s (%s) is offline", e.Identifier, SanitizeURL(e.URL, CurrentSettings().LogSafeParams))
+		fmt.Fprintf(&buf, "      - alert: MeowEndpointOffline\n")
+		expr := fmt.Sprintf("%s{endpoint=%s} == 0", PrometheusUpMetric, yamlString(e.Identifier))
+		fmt.Fprintf(&buf, "        expr: %s\n", yamlString(expr))
+		fmt.Fprintf(&buf, "        for: %s\n", prometheusDuration(time.Duration(failures)*e.Frequency))
+		fmt.Fprintf(&buf, "        labels:\n          endpoint: %s\n", yamlString(e.Identifier))
+		if e.Owner != "" {
+			fmt.Fprintf(&buf, "          owner: %s\n", yamlString(e.Owner))
+		}
+		fmt.Fprintf(&buf, "        annotations:\n          summary: %s\n", yamlString(summary))
+	}
+	return buf.Bytes()
+}
+
+// PrometheusMetricsContentType is the content type of the Prometheus text
+// exposition format.
+const PrometheusMetricsContentType = "text/plain; version=0.0.4; charset=utf-8"
+
+// PrometheusMetrics returns the metrics of the endpoints in the Prometheus text
+// exposition format, which are derived from their statuses by identifier: the
+// gauge meow_endpoint_up (not reported for endpoints not probed yet), and the
+// counters meow_endpoint_checks_total and meow_endpoint_failures_total. The
+// metrics are only labeled by the identifier in order to bound cardinality.
+func PrometheusMetrics(endpoints []Endpoint, statuses map[string]CheckResult) []byte {
+	var buf bytes.Buffer
+	buf.WriteString("# HELP meow_endpoint_up Whether the endpoint is up (1) or down (0) as of its latest probe.\n")
+	buf.WriteString("# TYPE meow_endpoint_up gauge\n")
+	for _, e := range endpoints {
+		status, ok := statuses[e.Identifier]
+		if !ok {
+			continue
+		}
+		status.DeriveUp(e.FailAfter)
+		if status.Up == nil {
+			continue
+		}
+		up := 0
+		if *status.Up {
+			up = 1
+		}
+		fmt.Fprintf(&buf, "meow_endpoint_up{identifier=%s} %d\n", prometheusLabel(e.Identifier), up)
+	}
+	buf.WriteString("# HELP meow_endpoint_checks_total The number of probes of the endpoint.\n")
+	buf.WriteString("# TYPE meow_endpoint_checks_total counter\n")
+	for _, e := range endpoints {
+		fmt.Fprintf(&buf, "meow_endpoint_checks_total{identifier=%s} %d\n",
+			prometheusLabel(e.Identifier), statuses[e.Identifier].Checks)
+	}
+	buf.WriteString("# HELP meow_endpoint_failures_total The number of failed probes of the endpoint.\n")
+	buf.WriteString("# TYPE meow_endpoint_failures_total counter\n")
+	for _, e := range endpoints {
+		fmt.Fprintf(&buf, "meow_endpoint_failures_total{identifier=%s} %d\n",
+			prometheusLabel(e.Identifier), statuses[e.Identifier].Failures)
+	}
+	return buf.Bytes()
+}
+
+// prometheusLabelEscaper escapes label values of the text exposition format.
+var prometheusLabelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// prometheusLabel quotes s as a label value of the text exposition format.
+func prometheusLabel(s string) string {
+	return `"` + prometheusLabelEscaper.Replace(s) + `"`
+}
+
+// yamlString quotes s as a double-quoted YAML string, of which JSON strings are
+// a subset.
+func yamlString(s string) string {
+	quoted, _ := json.Marshal(s)
+	return string(quoted)
+}
+
+// prometheusDuration formats d in whole seconds (rounded up), which is a valid
+// Prometheus duration.
+func prometheusDuration(d time.Duration) string {
+	return fmt.Sprintf("%ds", int64(math.Ceil(d.Seconds())))
+}
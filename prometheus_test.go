@@ -0,0 +1,51 @@
+package meow
+
+import (
+	"regexp"
+	"strings"
+	"testing"
+)
+
+// prometheusLine matches the lines of the text exposition format: comments
+// describing a metric, or samples labeled by an identifier.
+var prometheusLine = regexp.MustCompile(`^(# (HELP|TYPE) meow_endpoint_[a-z_]+ .+|meow_endpoint_[a-z_]+\{identifier="[^"]*"\} [0-9]+)$`)
+
+func TestPrometheusMetrics(t *testing.T) {
+	libvirt := newEndpoint(t, "libvirt", "")
+	goDev := newEndpoint(t, "go-dev", "")
+	unprobed := newEndpoint(t, "unprobed", "")
+	statuses := map[string]CheckResult{
+		"libvirt": {State: StateOnline, Checks: 10, Failures: 1},
+		"go-dev":  {State: StateOffline, ConsecutiveFailures: 3, Checks: 4, Failures: 3},
+	}
+	metrics := string(PrometheusMetrics([]Endpoint{*libvirt, *goDev, *unprobed}, statuses))
+	expected := `# HELP meow_endpoint_up Whether the endpoint is up (1) or down (0) as of its latest probe.
+# TYPE meow_endpoint_up gauge
+meow_endpoint_up{identifier="libvirt"} 1
+meow_endpoint_up{identifier="go-dev"} 0
+# HELP meow_endpoint_checks_total The number of probes of the endpoint.
+# TYPE meow_endpoint_checks_total counter
+meow_endpoint_checks_total{identifier="libvirt"} 10
+meow_endpoint_checks_total{identifier="go-dev"} 4
+meow_endpoint_checks_total{identifier="unprobed"} 0
+# HELP meow_endpoint_failures_total The number of failed probes of the endpoint.
+# TYPE meow_endpoint_failures_total counter
+meow_endpoint_failures_total{identifier="libvirt"} 1
+meow_endpoint_failures_total{identifier="go-dev"} 3
+meow_endpoint_failures_total{identifier="unprobed"} 0
+`
+	if metrics != expected {
+		t.Errorf("expected metrics\n%s\ngot\n%s", expected, metrics)
+	}
+	for _, line := range strings.Split(strings.TrimSuffix(metrics, "\n"), "\n") {
+		if !prometheusLine.MatchString(line) {
+			t.Errorf("expected well-formed line, got %q", line)
+		}
+	}
+}
+
+func TestPrometheusLabel(t *testing.T) {
+	if label := prometheusLabel("a\"b\\c\nd"); label != `"a\"b\\c\nd"` {
+		t.Errorf("expected label to be escaped, got %s", label)
+	}
+}
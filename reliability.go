@@ -0,0 +1,104 @@
+package meow
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ParseWindow parses a time window such as "30d" or "12h". Besides the units
+// supported by time.ParseDuration, whole days are supported using the suffix
+// "d". An error is returned unless the window is positive.
+func ParseWindow(raw string) (time.Duration, error) {
+	var window time.Duration
+	if days, ok := strings.CutSuffix(raw, "d"); ok {
+		n, err := strconv.Atoi(days)
+		if err != nil {
+			return 0, fmt.Errorf(`"%s" is not a valid window`, raw)
+		}
+		window = time.Duration(n) * 24 * time.Hour
+	} else {
+		var err error
+		if window, err = time.ParseDuration(raw); err != nil {
+			return 0, fmt.Errorf(`"%s" is not a valid window`, raw)
+		}
+	}
+	if window <= 0 {
+		return 0, fmt.Errorf(`window "%s" must be positive`, raw)
+	}
+	return window, nil
+}
+
+// Reliability describes how reliable an endpoint was within a time window.
+type Reliability struct {
+	// Window is the time window considered.
+	Window time.Duration
+
+	// Incidents is the number of incidents that overlap the window.
+	Incidents int
+
+	// MTTR is the mean time to recovery, i.e. the mean duration of the
+	// incidents.
+	MTTR time.Duration
+
+	// MTBF is the mean time between failures, i.e. the time within the window
+	// the endpoint was online divided by the number of incidents.
+	MTBF time.Duration
+}
+
+// ReliabilityPayload contains the same fields as Reliability, but as
+// serializable primitives with JSON tags. MTTR and MTBF are null if there were
+// no incidents within the window.
+type ReliabilityPayload struct {
+	Window    string  `json:"window"`
+	Incidents int     `json:"incidents"`
+	MTTR      *string `json:"mttr"`
+	MTBF      *string `json:"mtbf"`
+}
+
+// ComputeReliability computes the reliability within the window ending at end
+// from the given incidents. Incidents not overlapping the window are ignored,
+// and the downtime of those partially overlapping it is clipped to the window.
+func ComputeReliability(incidents []Incident, window time.Duration, end time.Time) Reliability {
+	start := end.Add(-window)
+	reliability := Reliability{Window: window}
+	var recovery, downtime time.Duration
+	for _, incident := range incidents {
+		if !incident.End.After(start) || incident.Start.After(end) {
+			continue
+		}
+		reliability.Incidents++
+		recovery += incident.Duration()
+		from, to := incident.Start, incident.End
+		if from.Before(start) {
+			from = start
+		}
+		if to.After(end) {
+			to = end
+		}
+		downtime += to.Sub(from)
+	}
+	if reliability.Incidents > 0 {
+		n := time.Duration(reliability.Incidents)
+		reliability.MTTR = recovery / n
+		reliability.MTBF = (window - downtime) / n
+	}
+	return reliability
+}
+
+// JSON returns the Reliability's fields as JSON data, or an error, if it cannot
+// be serialized.
+func (r Reliability) JSON() ([]byte, error) {
+	payload := ReliabilityPayload{Window: r.Window.String(), Incidents: r.Incidents}
+	if r.Incidents > 0 {
+		mttr, mtbf := r.MTTR.String(), r.MTBF.String()
+		payload.MTTR, payload.MTBF = &mttr, &mtbf
+	}
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return nil, fmt.Errorf("marshal reliability %v as JSON: %v", r, err)
+	}
+	return data, nil
+}
@@ -0,0 +1,30 @@
+package meow
+
+import (
+	"net/url"
+	"slices"
+	"strings"
+)
+
+// SanitizeURL returns u as a string suitable for logging: Its password is
+// masked, and the values of its query parameters are replaced by Redacted,
+// except for the parameters listed in safe, which are kept as they are.
+func SanitizeURL(u *url.URL, safe []string) string {
+	if u == nil {
+		return ""
+	}
+	if u.RawQuery == "" {
+		return u.Redacted()
+	}
+	params := strings.Split(u.RawQuery, "&")
+	for i, param := range params {
+		rawName, _, _ := strings.Cut(param, "=")
+		name, err := url.QueryUnescape(rawName)
+		if err != nil || !slices.Contains(safe, name) {
+			params[i] = rawName + "=" + Redacted
+		}
+	}
+	sanitized := *u
+	sanitized.RawQuery = ""
+	return sanitized.Redacted() + "?" + strings.Join(params, "&")
+}
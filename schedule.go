@@ -0,0 +1,71 @@
+package meow
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// Schedule describes when an endpoint was probed the last time, and when it is
+// due for the next probe.
+type Schedule struct {
+	// LastProbed is the time the endpoint was probed the last time. It is the
+	// zero value, if the endpoint has not been probed yet.
+	LastProbed time.Time
+
+	// NextDue is the time the next probe of the endpoint is due. It is the
+	// zero value, if the endpoint has not been scheduled yet.
+	NextDue time.Time
+
+	// EffectiveInterval is the interval actually applied between two probes.
+	EffectiveInterval time.Duration
+}
+
+// SchedulePayload contains the same fields as Schedule, but as serializable
+// primitives with JSON tags. Times not known yet are null.
+type SchedulePayload struct {
+	LastProbed        *time.Time `json:"last_probed"`
+	NextDue           *time.Time `json:"next_due"`
+	EffectiveInterval string     `json:"effective_interval"`
+}
+
+// JSON returns the Schedule's fields as JSON data, or an error, if it cannot be
+// serialized.
+func (s Schedule) JSON() ([]byte, error) {
+	payload := SchedulePayload{EffectiveInterval: s.EffectiveInterval.String()}
+	if !s.LastProbed.IsZero() {
+		payload.LastProbed = &s.LastProbed
+	}
+	if !s.NextDue.IsZero() {
+		payload.NextDue = &s.NextDue
+	}
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return nil, fmt.Errorf("marshal schedule %v as JSON: %v", s, err)
+	}
+	return data, nil
+}
+
+// ScheduleFromMap creates a new Schedule from the given map, which provides
+// the fields last_probed, next_due (both RFC 3339), and effective_interval.
+// Missing fields are left at their zero value.
+func ScheduleFromMap(m map[string]string) (*Schedule, error) {
+	var schedule Schedule
+	var err error
+	if raw, ok := m["last_probed"]; ok {
+		if schedule.LastProbed, err = time.Parse(time.RFC3339Nano, raw); err != nil {
+			return nil, fmt.Errorf("parse last_probed: %v", err)
+		}
+	}
+	if raw, ok := m["next_due"]; ok {
+		if schedule.NextDue, err = time.Parse(time.RFC3339Nano, raw); err != nil {
+			return nil, fmt.Errorf("parse next_due: %v", err)
+		}
+	}
+	if raw, ok := m["effective_interval"]; ok {
+		if schedule.EffectiveInterval, err = time.ParseDuration(raw); err != nil {
+			return nil, fmt.Errorf("parse effective_interval: %v", err)
+		}
+	}
+	return &schedule, nil
+}
@@ -0,0 +1,49 @@
+package meow
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// SchedulerState describes whether or not the probe issues probes at all.
+type SchedulerState string
+
+// States the scheduler can be in. A paused scheduler issues no probes, but
+// keeps the status of the endpoints as it is.
+const (
+	SchedulerRunning SchedulerState = "running"
+	SchedulerPaused  SchedulerState = "paused"
+)
+
+// SchedulerKey is the key holding the state of the scheduler. The scheduler is
+// running if the key does not exist.
+const SchedulerKey = "scheduler"
+
+// ParseSchedulerState parses raw as a SchedulerState, or returns an error, if
+// it is neither running nor paused.
+func ParseSchedulerState(raw string) (SchedulerState, error) {
+	switch state := SchedulerState(raw); state {
+	case SchedulerRunning, SchedulerPaused:
+		return state, nil
+	default:
+		return "", fmt.Errorf(`scheduler state "%s" must be %s or %s`,
+			raw, SchedulerRunning, SchedulerPaused)
+	}
+}
+
+// JSON returns the scheduler state as a JSON object, or an error, if it cannot
+// be serialized.
+func (s SchedulerState) JSON() ([]byte, error) {
+	data, err := json.Marshal(struct {
+		State SchedulerState `json:"state"`
+	}{s})
+	if err != nil {
+		return nil, fmt.Errorf("marshal scheduler state %s as JSON: %v", s, err)
+	}
+	return data, nil
+}
+
+// ReadOnlyKey is the key holding whether or not the configuration is frozen,
+// i.e. whether the config server rejects all changes. The configuration is
+// writable if the key does not exist.
+const ReadOnlyKey = "readonly"
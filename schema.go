@@ -0,0 +1,407 @@
+package meow
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+	"unicode/utf8"
+)
+
+// Schema is a compiled JSON Schema used to validate response bodies. The
+// following keywords are supported: type, enum, const, properties, required,
+// additionalProperties, items, minItems, maxItems, minLength, maxLength,
+// pattern, minimum, maximum, exclusiveMinimum, exclusiveMaximum, allOf, anyOf,
+// oneOf, and not. Other keywords (e.g. $ref) are ignored.
+type Schema struct {
+	raw json.RawMessage
+
+	// always is set for the boolean schemas true and false.
+	always *bool
+
+	types                []string
+	enum                 []any
+	constant             any
+	hasConst             bool
+	properties           map[string]*Schema
+	required             []string
+	additionalProperties *Schema
+	items                *Schema
+	minItems, maxItems   *int
+	minLength, maxLength *int
+	pattern              *regexp.Regexp
+	minimum, maximum     *float64
+	exclusiveMinimum     *float64
+	exclusiveMaximum     *float64
+	allOf, anyOf, oneOf  []*Schema
+	not                  *Schema
+}
+
+var schemaTypes = map[string]bool{
+	"null": true, "boolean": true, "object": true, "array": true,
+	"number": true, "string": true, "integer": true,
+}
+
+// CompileSchema compiles the given JSON Schema, or returns an error, if it is
+// malformed.
+func CompileSchema(raw json.RawMessage) (*Schema, error) {
+	var doc any
+	decoder := json.NewDecoder(bytes.NewReader(raw))
+	decoder.UseNumber()
+	if err := decoder.Decode(&doc); err != nil {
+		return nil, fmt.Errorf("parse schema: %v", err)
+	}
+	schema, err := compileSchema(doc, "#")
+	if err != nil {
+		return nil, err
+	}
+	var compact bytes.Buffer
+	if err := json.Compact(&compact, raw); err != nil {
+		return nil, fmt.Errorf("compact schema: %v", err)
+	}
+	schema.raw = compact.Bytes()
+	return schema, nil
+}
+
+func compileSchema(doc any, path string) (*Schema, error) {
+	if b, ok := doc.(bool); ok {
+		return &Schema{always: &b}, nil
+	}
+	m, ok := doc.(map[string]any)
+	if !ok {
+		return nil, fmt.Errorf("%s: schema must be an object or a boolean", path)
+	}
+	var s Schema
+	var err error
+	switch t := m["type"].(type) {
+	case nil:
+	case string:
+		s.types = []string{t}
+	case []any:
+		for _, v := range t {
+			name, ok := v.(string)
+			if !ok {
+				return nil, fmt.Errorf("%s/type: must contain strings", path)
+			}
+			s.types = append(s.types, name)
+		}
+	default:
+		return nil, fmt.Errorf("%s/type: must be a string or an array", path)
+	}
+	for _, t := range s.types {
+		if !schemaTypes[t] {
+			return nil, fmt.Errorf(`%s/type: "%s" is not a valid type`, path, t)
+		}
+	}
+	if v, ok := m["enum"]; ok {
+		if s.enum, ok = v.([]any); !ok {
+			return nil, fmt.Errorf("%s/enum: must be an array", path)
+		}
+	}
+	s.constant, s.hasConst = m["const"]
+	if v, ok := m["properties"]; ok {
+		props, ok := v.(map[string]any)
+		if !ok {
+			return nil, fmt.Errorf("%s/properties: must be an object", path)
+		}
+		s.properties = make(map[string]*Schema, len(props))
+		for name, sub := range props {
+			if s.properties[name], err = compileSchema(sub, path+"/properties/"+name); err != nil {
+				return nil, err
+			}
+		}
+	}
+	if v, ok := m["required"]; ok {
+		names, ok := v.([]any)
+		if !ok {
+			return nil, fmt.Errorf("%s/required: must be an array", path)
+		}
+		for _, name := range names {
+			str, ok := name.(string)
+			if !ok {
+				return nil, fmt.Errorf("%s/required: must contain strings", path)
+			}
+			s.required = append(s.required, str)
+		}
+	}
+	if v, ok := m["additionalProperties"]; ok {
+		if s.additionalProperties, err = compileSchema(v, path+"/additionalProperties"); err != nil {
+			return nil, err
+		}
+	}
+	if v, ok := m["items"]; ok {
+		if s.items, err = compileSchema(v, path+"/items"); err != nil {
+			return nil, err
+		}
+	}
+	for keyword, target := range map[string]**int{
+		"minItems": &s.minItems, "maxItems": &s.maxItems,
+		"minLength": &s.minLength, "maxLength": &s.maxLength,
+	} {
+		if *target, err = schemaInt(m, keyword, path); err != nil {
+			return nil, err
+		}
+	}
+	for keyword, target := range map[string]**float64{
+		"minimum": &s.minimum, "maximum": &s.maximum,
+		"exclusiveMinimum": &s.exclusiveMinimum, "exclusiveMaximum": &s.exclusiveMaximum,
+	} {
+		if *target, err = schemaNumber(m, keyword, path); err != nil {
+			return nil, err
+		}
+	}
+	if v, ok := m["pattern"]; ok {
+		str, ok := v.(string)
+		if !ok {
+			return nil, fmt.Errorf("%s/pattern: must be a string", path)
+		}
+		if s.pattern, err = regexp.Compile(str); err != nil {
+			return nil, fmt.Errorf("%s/pattern: %v", path, err)
+		}
+	}
+	for keyword, target := range map[string]*[]*Schema{
+		"allOf": &s.allOf, "anyOf": &s.anyOf, "oneOf": &s.oneOf,
+	} {
+		v, ok := m[keyword]
+		if !ok {
+			continue
+		}
+		subs, ok := v.([]any)
+		if !ok || len(subs) == 0 {
+			return nil, fmt.Errorf("%s/%s: must be a non-empty array", path, keyword)
+		}
+		for i, sub := range subs {
+			compiled, err := compileSchema(sub, fmt.Sprintf("%s/%s/%d", path, keyword, i))
+			if err != nil {
+				return nil, err
+			}
+			*target = append(*target, compiled)
+		}
+	}
+	if v, ok := m["not"]; ok {
+		if s.not, err = compileSchema(v, path+"/not"); err != nil {
+			return nil, err
+		}
+	}
+	return &s, nil
+}
+
+func schemaInt(m map[string]any, keyword, path string) (*int, error) {
+	f, err := schemaNumber(m, keyword, path)
+	if err != nil || f == nil {
+		return nil, err
+	}
+	if *f < 0 || *f != math.Trunc(*f) {
+		return nil, fmt.Errorf("%s/%s: must be a non-negative integer", path, keyword)
+	}
+	n := int(*f)
+	return &n, nil
+}
+
+func schemaNumber(m map[string]any, keyword, path string) (*float64, error) {
+	v, ok := m[keyword]
+	if !ok {
+		return nil, nil
+	}
+	n, ok := v.(json.Number)
+	if !ok {
+		return nil, fmt.Errorf("%s/%s: must be a number", path, keyword)
+	}
+	f, err := n.Float64()
+	if err != nil {
+		return nil, fmt.Errorf("%s/%s: %v", path, keyword, err)
+	}
+	return &f, nil
+}
+
+// String returns the (compacted) JSON representation of the schema.
+func (s *Schema) String() string {
+	return string(s.raw)
+}
+
+// MarshalJSON returns the JSON representation of the schema.
+func (s *Schema) MarshalJSON() ([]byte, error) {
+	return s.raw, nil
+}
+
+// ValidateJSON parses the given JSON document and validates it against the
+// schema. The first violation found is returned as an error.
+func (s *Schema) ValidateJSON(data []byte) error {
+	var doc any
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	if err := decoder.Decode(&doc); err != nil {
+		return fmt.Errorf("parse JSON: %v", err)
+	}
+	return s.validate(doc, "$")
+}
+
+func (s *Schema) validate(v any, path string) error {
+	if s.always != nil {
+		if !*s.always {
+			return fmt.Errorf("%s: not allowed", path)
+		}
+		return nil
+	}
+	if len(s.types) > 0 && !matchesAnyType(v, s.types) {
+		return fmt.Errorf("%s: expected %s, got %s", path,
+			strings.Join(s.types, " or "), jsonType(v))
+	}
+	if s.enum != nil {
+		found := false
+		for _, candidate := range s.enum {
+			if jsonEqual(v, candidate) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return fmt.Errorf("%s: value not in enum", path)
+		}
+	}
+	if s.hasConst && !jsonEqual(v, s.constant) {
+		return fmt.Errorf("%s: value does not match const", path)
+	}
+	switch value := v.(type) {
+	case map[string]any:
+		for _, name := range s.required {
+			if _, ok := value[name]; !ok {
+				return fmt.Errorf(`%s: missing required property "%s"`, path, name)
+			}
+		}
+		names := make([]string, 0, len(value))
+		for name := range value {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			sub, ok := s.properties[name]
+			if !ok {
+				sub = s.additionalProperties
+			}
+			if sub == nil {
+				continue
+			}
+			if err := sub.validate(value[name], path+"."+name); err != nil {
+				return err
+			}
+		}
+	case []any:
+		if s.minItems != nil && len(value) < *s.minItems {
+			return fmt.Errorf("%s: expected at least %d items, got %d", path, *s.minItems, len(value))
+		}
+		if s.maxItems != nil && len(value) > *s.maxItems {
+			return fmt.Errorf("%s: expected at most %d items, got %d", path, *s.maxItems, len(value))
+		}
+		if s.items != nil {
+			for i, item := range value {
+				if err := s.items.validate(item, fmt.Sprintf("%s[%d]", path, i)); err != nil {
+					return err
+				}
+			}
+		}
+	case string:
+		length := utf8.RuneCountInString(value)
+		if s.minLength != nil && length < *s.minLength {
+			return fmt.Errorf("%s: expected at least %d characters", path, *s.minLength)
+		}
+		if s.maxLength != nil && length > *s.maxLength {
+			return fmt.Errorf("%s: expected at most %d characters", path, *s.maxLength)
+		}
+		if s.pattern != nil && !s.pattern.MatchString(value) {
+			return fmt.Errorf(`%s: does not match pattern "%s"`, path, s.pattern)
+		}
+	case json.Number:
+		f, _ := value.Float64()
+		if s.minimum != nil && f < *s.minimum {
+			return fmt.Errorf("%s: %v is less than %v", path, f, *s.minimum)
+		}
+		if s.maximum != nil && f > *s.maximum {
+			return fmt.Errorf("%s: %v is greater than %v", path, f, *s.maximum)
+		}
+		if s.exclusiveMinimum != nil && f <= *s.exclusiveMinimum {
+			return fmt.Errorf("%s: %v is not greater than %v", path, f, *s.exclusiveMinimum)
+		}
+		if s.exclusiveMaximum != nil && f >= *s.exclusiveMaximum {
+			return fmt.Errorf("%s: %v is not less than %v", path, f, *s.exclusiveMaximum)
+		}
+	}
+	for _, sub := range s.allOf {
+		if err := sub.validate(v, path); err != nil {
+			return err
+		}
+	}
+	if s.anyOf != nil {
+		matched := false
+		for _, sub := range s.anyOf {
+			if sub.validate(v, path) == nil {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			return fmt.Errorf("%s: does not match any schema of anyOf", path)
+		}
+	}
+	if s.oneOf != nil {
+		matches := 0
+		for _, sub := range s.oneOf {
+			if sub.validate(v, path) == nil {
+				matches++
+			}
+		}
+		if matches != 1 {
+			return fmt.Errorf("%s: matches %d schemas of oneOf instead of one", path, matches)
+		}
+	}
+	if s.not != nil && s.not.validate(v, path) == nil {
+		return fmt.Errorf("%s: must not match schema of not", path)
+	}
+	return nil
+}
+
+func matchesAnyType(v any, types []string) bool {
+	actual := jsonType(v)
+	for _, t := range types {
+		if t == actual || (t == "number" && actual == "integer") {
+			return true
+		}
+	}
+	return false
+}
+
+func jsonType(v any) string {
+	switch value := v.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "boolean"
+	case map[string]any:
+		return "object"
+	case []any:
+		return "array"
+	case string:
+		return "string"
+	case json.Number:
+		if f, err := value.Float64(); err == nil && f == math.Trunc(f) {
+			return "integer"
+		}
+		return "number"
+	}
+	return "unknown"
+}
+
+func jsonEqual(a, b any) bool {
+	na, aIsNumber := a.(json.Number)
+	nb, bIsNumber := b.(json.Number)
+	if aIsNumber && bIsNumber {
+		fa, errA := na.Float64()
+		fb, errB := nb.Float64()
+		return errA == nil && errB == nil && fa == fb
+	}
+	return reflect.DeepEqual(a, b)
+}
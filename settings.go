@@ -0,0 +1,517 @@
+package meow
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// Settings are runtime-tunable settings. They are loaded by the config server
+// and shared with the probe through Valkey, so that they can be reloaded
+// without a restart.
+type Settings struct {
+	// DefaultFrequency is applied to endpoints created without a frequency.
+	DefaultFrequency time.Duration
+
+	// MinFrequency is the lowest frequency endpoints may be probed with, so
+	// that a tiny frequency (e.g. 10ms) cannot hammer the target.
+	MinFrequency time.Duration
+
+	// DefaultFailAfter is applied to endpoints created without a fail_after
+	// value.
+	DefaultFailAfter uint8
+
+	// IncidentRetention is how long incidents are retained after their start.
+	IncidentRetention time.Duration
+
+	// StatusWriteOnChange indicates that the probe only writes the full status
+	// of an endpoint if it changed, and only its schedule otherwise.
+	StatusWriteOnChange bool
+
+	// HistorySize is the number of history entries retained per endpoint.
+	HistorySize int
+
+	// NXDomainAsConfigError indicates that endpoints whose host does not
+	// exist (NXDOMAIN) are reported as misconfigured rather than offline.
+	NXDomainAsConfigError bool
+
+	// MaxEndpoints is the maximum number of endpoints that can be created, or
+	// 0 for no limit.
+	MaxEndpoints int
+
+	// RedactHeaders are the names of sensitive response headers, whose values
+	// are redacted when captured.
+	RedactHeaders []string
+
+	// RetryTransportErrors indicates that probes failing due to a transport
+	// error (e.g. an HTTP/2 GOAWAY or a connection reset) before the
+	// response headers were received are retried once on a fresh connection.
+	RetryTransportErrors bool
+
+	// LogSafeParams are the names of the query parameters whose values are
+	// logged as they are. The values of other query parameters of logged URLs
+	// are redacted, because they may contain secrets (e.g. tokens).
+	LogSafeParams []string
+
+	// BreakerThreshold is the number of consecutive failed probes across the
+	// endpoints of a host, after which the host's circuit breaker opens, or 0
+	// to disable circuit breakers.
+	BreakerThreshold int
+
+	// BreakerCooldown is how long an open circuit breaker suspends probing its
+	// host, before a single probe tests whether the host recovered.
+	BreakerCooldown time.Duration
+
+	// MaxInFlight is the number of requests the config server handles at once,
+	// beyond which further requests are rejected, or 0 for no limit.
+	MaxInFlight int
+
+	// RejectStaleUpdates indicates that updates based on an older version of
+	// an endpoint than the one stored are rejected, rather than only logged.
+	RejectStaleUpdates bool
+
+	// DefaultHeaders are set on the requests of all probes, unless the
+	// endpoint's headers set a header of the same name.
+	DefaultHeaders map[string]string
+
+	// NotifyLimit is the number of notifications sent per endpoint within
+	// NotifyWindow (across all channels), beyond which further notifications
+	// are suppressed, or 0 for no limit.
+	NotifyLimit  int
+	NotifyWindow time.Duration
+
+	// StatsInterval is the interval in which the probe persists its own runtime
+	// statistics, or 0 to not persist them.
+	StatsInterval time.Duration
+
+	// LatencyRetention is how long the probe retains the latencies of each
+	// endpoint in a time series, if Valkey provides time series, or 0 to not
+	// store them.
+	LatencyRetention time.Duration
+}
+
+// SettingsPayload contains the same fields as Settings, but as serializable
+// primitives with JSON tags.
+type SettingsPayload struct {
+	DefaultFrequency    string `json:"default_frequency"`
+	MinFrequency        string `json:"min_frequency"`
+	DefaultFailAfter    uint8  `json:"default_fail_after"`
+	IncidentRetention   string `json:"incident_retention"`
+	StatusWriteOnChange bool   `json:"status_write_on_change"`
+	HistorySize         int    `json:"history_size"`
+
+	NXDomainAsConfigError bool     `json:"nxdomain_as_config_error"`
+	MaxEndpoints          int      `json:"max_endpoints"`
+	RedactHeaders         []string `json:"redact_headers"`
+	RetryTransportErrors  bool     `json:"retry_transport_errors"`
+	LogSafeParams         []string `json:"log_safe_params"`
+	BreakerThreshold      int      `json:"breaker_threshold"`
+	BreakerCooldown       string   `json:"breaker_cooldown"`
+	MaxInFlight           int      `json:"max_in_flight"`
+	RejectStaleUpdates    bool     `json:"reject_stale_updates"`
+
+	DefaultHeaders map[string]string `json:"default_headers"`
+	NotifyLimit    int               `json:"notify_limit"`
+	NotifyWindow   string            `json:"notify_window"`
+	StatsInterval  string            `json:"stats_interval"`
+
+	LatencyRetention string `json:"latency_retention"`
+}
+
+// SettingsKey is the key of the hash holding the effective settings.
+const SettingsKey = "settings"
+
+// DefaultSettings returns the settings applied if not configured otherwise.
+func DefaultSettings() Settings {
+	return Settings{
+		DefaultFrequency:  5 * time.Minute,
+		MinFrequency:      5 * time.Second,
+		DefaultFailAfter:  3,
+		IncidentRetention: 90 * 24 * time.Hour,
+		HistorySize:       500,
+		RedactHeaders:     []string{"Authorization", "Cookie", "Proxy-Authorization", "Set-Cookie"},
+
+		RetryTransportErrors: true,
+		LogSafeParams:        []string{"method", "include", "fields", "window", "aggregation", "state", "limit", "cursor", "identifier_prefix", "bucket"},
+		BreakerCooldown:      time.Minute,
+		MaxInFlight:          256,
+		NotifyWindow:         time.Hour,
+		StatsInterval:        time.Minute,
+	}
+}
+
+var currentSettings atomic.Pointer[Settings]
+
+func init() {
+	settings := DefaultSettings()
+	currentSettings.Store(&settings)
+}
+
+// CurrentSettings returns the settings currently in effect.
+func CurrentSettings() Settings {
+	return *currentSettings.Load()
+}
+
+// ApplySettings puts the given settings into effect.
+func ApplySettings(settings Settings) {
+	currentSettings.Store(&settings)
+}
+
+// LookupFunc looks up the value of the setting with the given name (e.g.
+// MEOW_DEFAULT_FREQUENCY), and indicates whether or not it was found.
+type LookupFunc func(name string) (string, bool)
+
+// SettingsFileLookup reads the settings file at path, which consists of lines
+// of the form NAME=VALUE (blank lines and lines starting with # are ignored),
+// and returns a LookupFunc that looks up settings in that file first, and in
+// the environment second. If path is empty, only the environment is used.
+func SettingsFileLookup(path string) (LookupFunc, error) {
+	if path == "" {
+		return os.LookupEnv, nil
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("open settings file %s: %v", path, err)
+	}
+	defer file.Close()
+	values := make(map[string]string)
+	scanner := bufio.NewScanner(file)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		name, value, ok := strings.Cut(line, "=")
+		if !ok {
+			return nil, fmt.Errorf(`%s:%d: "%s" is not of the form NAME=VALUE`, path, n, line)
+		}
+		values[strings.TrimSpace(name)] = strings.TrimSpace(value)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("read settings file %s: %v", path, err)
+	}
+	return func(name string) (string, bool) {
+		if value, ok := values[name]; ok {
+			return value, true
+		}
+		return os.LookupEnv(name)
+	}, nil
+}
+
+// LoadSettings creates Settings from the values found using lookup for the
+// names MEOW_DEFAULT_FREQUENCY, MEOW_MIN_FREQUENCY, MEOW_DEFAULT_FAIL_AFTER,
+// MEOW_INCIDENT_RETENTION, MEOW_STATUS_WRITE_ON_CHANGE, MEOW_HISTORY_SIZE,
+// MEOW_NXDOMAIN_AS_CONFIG_ERROR, MEOW_MAX_ENDPOINTS, MEOW_REDACT_HEADERS
+// (separated by commas), MEOW_RETRY_TRANSPORT_ERRORS, MEOW_LOG_SAFE_PARAMS
+// (separated by commas), MEOW_BREAKER_THRESHOLD, MEOW_BREAKER_COOLDOWN,
+// MEOW_MAX_IN_FLIGHT, MEOW_REJECT_STALE_UPDATES, MEOW_DEFAULT_HEADERS
+// (Name:value pairs separated by commas), MEOW_NOTIFY_LIMIT,
+// MEOW_NOTIFY_WINDOW, MEOW_STATS_INTERVAL, and MEOW_LATENCY_RETENTION. The
+// DefaultSettings are applied for the values not found. An error is returned if one of the values cannot be
+// parsed, or if the default frequency is below the minimum frequency.
+func LoadSettings(lookup LookupFunc) (*Settings, error) {
+	settings := DefaultSettings()
+	if raw, ok := lookup("MEOW_DEFAULT_FREQUENCY"); ok {
+		frequency, err := time.ParseDuration(raw)
+		if err != nil || frequency <= 0 {
+			return nil, fmt.Errorf(`MEOW_DEFAULT_FREQUENCY "%s" is not a valid duration`, raw)
+		}
+		settings.DefaultFrequency = frequency
+	}
+	if raw, ok := lookup("MEOW_MIN_FREQUENCY"); ok {
+		frequency, err := time.ParseDuration(raw)
+		if err != nil || frequency <= 0 {
+			return nil, fmt.Errorf(`MEOW_MIN_FREQUENCY "%s" is not a valid duration`, raw)
+		}
+		settings.MinFrequency = frequency
+	}
+	if settings.DefaultFrequency < settings.MinFrequency {
+		return nil, fmt.Errorf("default frequency %v is below the minimum frequency %v",
+			settings.DefaultFrequency, settings.MinFrequency)
+	}
+	if raw, ok := lookup("MEOW_DEFAULT_FAIL_AFTER"); ok {
+		failAfter, err := strconv.ParseUint(raw, 10, 8)
+		if err != nil {
+			return nil, fmt.Errorf(`MEOW_DEFAULT_FAIL_AFTER "%s" is not a number`, raw)
+		}
+		settings.DefaultFailAfter = uint8(failAfter)
+	}
+	if raw, ok := lookup("MEOW_INCIDENT_RETENTION"); ok {
+		retention, err := time.ParseDuration(raw)
+		if err != nil || retention <= 0 {
+			return nil, fmt.Errorf(`MEOW_INCIDENT_RETENTION "%s" is not a valid duration`, raw)
+		}
+		settings.IncidentRetention = retention
+	}
+	if raw, ok := lookup("MEOW_STATUS_WRITE_ON_CHANGE"); ok {
+		writeOnChange, err := strconv.ParseBool(raw)
+		if err != nil {
+			return nil, fmt.Errorf(`MEOW_STATUS_WRITE_ON_CHANGE "%s" is not a boolean`, raw)
+		}
+		settings.StatusWriteOnChange = writeOnChange
+	}
+	if raw, ok := lookup("MEOW_HISTORY_SIZE"); ok {
+		size, err := strconv.Atoi(raw)
+		if err != nil || size < 1 {
+			return nil, fmt.Errorf(`MEOW_HISTORY_SIZE "%s" is not a positive number`, raw)
+		}
+		settings.HistorySize = size
+	}
+	if raw, ok := lookup("MEOW_NXDOMAIN_AS_CONFIG_ERROR"); ok {
+		asConfigError, err := strconv.ParseBool(raw)
+		if err != nil {
+			return nil, fmt.Errorf(`MEOW_NXDOMAIN_AS_CONFIG_ERROR "%s" is not a boolean`, raw)
+		}
+		settings.NXDomainAsConfigError = asConfigError
+	}
+	if raw, ok := lookup("MEOW_MAX_ENDPOINTS"); ok {
+		max, err := strconv.Atoi(raw)
+		if err != nil || max < 0 {
+			return nil, fmt.Errorf(`MEOW_MAX_ENDPOINTS "%s" is not a non-negative number`, raw)
+		}
+		settings.MaxEndpoints = max
+	}
+	if raw, ok := lookup("MEOW_REDACT_HEADERS"); ok {
+		settings.RedactHeaders = splitList(raw)
+	}
+	if raw, ok := lookup("MEOW_RETRY_TRANSPORT_ERRORS"); ok {
+		retry, err := strconv.ParseBool(raw)
+		if err != nil {
+			return nil, fmt.Errorf(`MEOW_RETRY_TRANSPORT_ERRORS "%s" is not a boolean`, raw)
+		}
+		settings.RetryTransportErrors = retry
+	}
+	if raw, ok := lookup("MEOW_LOG_SAFE_PARAMS"); ok {
+		settings.LogSafeParams = splitList(raw)
+	}
+	if raw, ok := lookup("MEOW_BREAKER_THRESHOLD"); ok {
+		threshold, err := strconv.Atoi(raw)
+		if err != nil || threshold < 0 {
+			return nil, fmt.Errorf(`MEOW_BREAKER_THRESHOLD "%s" is not a non-negative number`, raw)
+		}
+		settings.BreakerThreshold = threshold
+	}
+	if raw, ok := lookup("MEOW_BREAKER_COOLDOWN"); ok {
+		cooldown, err := time.ParseDuration(raw)
+		if err != nil || cooldown <= 0 {
+			return nil, fmt.Errorf(`MEOW_BREAKER_COOLDOWN "%s" is not a valid duration`, raw)
+		}
+		settings.BreakerCooldown = cooldown
+	}
+	if raw, ok := lookup("MEOW_MAX_IN_FLIGHT"); ok {
+		max, err := strconv.Atoi(raw)
+		if err != nil || max < 0 {
+			return nil, fmt.Errorf(`MEOW_MAX_IN_FLIGHT "%s" is not a non-negative number`, raw)
+		}
+		settings.MaxInFlight = max
+	}
+	if raw, ok := lookup("MEOW_REJECT_STALE_UPDATES"); ok {
+		reject, err := strconv.ParseBool(raw)
+		if err != nil {
+			return nil, fmt.Errorf(`MEOW_REJECT_STALE_UPDATES "%s" is not a boolean`, raw)
+		}
+		settings.RejectStaleUpdates = reject
+	}
+	if raw, ok := lookup("MEOW_DEFAULT_HEADERS"); ok {
+		headers, err := ParseHeaderList(raw)
+		if err != nil {
+			return nil, fmt.Errorf("MEOW_DEFAULT_HEADERS: %v", err)
+		}
+		settings.DefaultHeaders = headers
+	}
+	if raw, ok := lookup("MEOW_NOTIFY_LIMIT"); ok {
+		limit, err := strconv.Atoi(raw)
+		if err != nil || limit < 0 {
+			return nil, fmt.Errorf(`MEOW_NOTIFY_LIMIT "%s" is not a non-negative number`, raw)
+		}
+		settings.NotifyLimit = limit
+	}
+	if raw, ok := lookup("MEOW_NOTIFY_WINDOW"); ok {
+		window, err := time.ParseDuration(raw)
+		if err != nil || window <= 0 {
+			return nil, fmt.Errorf(`MEOW_NOTIFY_WINDOW "%s" is not a valid duration`, raw)
+		}
+		settings.NotifyWindow = window
+	}
+	if raw, ok := lookup("MEOW_STATS_INTERVAL"); ok {
+		interval, err := time.ParseDuration(raw)
+		if err != nil || interval < 0 {
+			return nil, fmt.Errorf(`MEOW_STATS_INTERVAL "%s" is not a valid duration`, raw)
+		}
+		settings.StatsInterval = interval
+	}
+	if raw, ok := lookup("MEOW_LATENCY_RETENTION"); ok {
+		retention, err := time.ParseDuration(raw)
+		if err != nil || retention < 0 {
+			return nil, fmt.Errorf(`MEOW_LATENCY_RETENTION "%s" is not a valid duration`, raw)
+		}
+		settings.LatencyRetention = retention
+	}
+	return &settings, nil
+}
+
+// SettingsFromMap creates Settings from the given map, which provides the
+// fields default_frequency, min_frequency, default_fail_after,
+// incident_retention, status_write_on_change, history_size,
+// nxdomain_as_config_error, max_endpoints, redact_headers (separated by
+// commas), retry_transport_errors, log_safe_params (separated by commas),
+// breaker_threshold, breaker_cooldown, max_in_flight, reject_stale_updates,
+// default_headers (Name:value pairs separated by commas), notify_limit,
+// notify_window, stats_interval, and latency_retention. The DefaultSettings
+// are applied for missing fields.
+func SettingsFromMap(m map[string]string) (*Settings, error) {
+	settings := DefaultSettings()
+	var err error
+	if raw, ok := m["default_frequency"]; ok {
+		if settings.DefaultFrequency, err = time.ParseDuration(raw); err != nil {
+			return nil, fmt.Errorf("parse default_frequency: %v", err)
+		}
+	}
+	if raw, ok := m["min_frequency"]; ok {
+		if settings.MinFrequency, err = time.ParseDuration(raw); err != nil {
+			return nil, fmt.Errorf("parse min_frequency: %v", err)
+		}
+	}
+	if raw, ok := m["default_fail_after"]; ok {
+		failAfter, err := strconv.ParseUint(raw, 10, 8)
+		if err != nil {
+			return nil, fmt.Errorf("parse default_fail_after: %v", err)
+		}
+		settings.DefaultFailAfter = uint8(failAfter)
+	}
+	if raw, ok := m["incident_retention"]; ok {
+		if settings.IncidentRetention, err = time.ParseDuration(raw); err != nil {
+			return nil, fmt.Errorf("parse incident_retention: %v", err)
+		}
+	}
+	if raw, ok := m["status_write_on_change"]; ok {
+		if settings.StatusWriteOnChange, err = strconv.ParseBool(raw); err != nil {
+			return nil, fmt.Errorf("parse status_write_on_change: %v", err)
+		}
+	}
+	if raw, ok := m["history_size"]; ok {
+		if settings.HistorySize, err = strconv.Atoi(raw); err != nil {
+			return nil, fmt.Errorf("parse history_size: %v", err)
+		}
+	}
+	if raw, ok := m["nxdomain_as_config_error"]; ok {
+		if settings.NXDomainAsConfigError, err = strconv.ParseBool(raw); err != nil {
+			return nil, fmt.Errorf("parse nxdomain_as_config_error: %v", err)
+		}
+	}
+	if raw, ok := m["max_endpoints"]; ok {
+		if settings.MaxEndpoints, err = strconv.Atoi(raw); err != nil {
+			return nil, fmt.Errorf("parse max_endpoints: %v", err)
+		}
+	}
+	if raw, ok := m["redact_headers"]; ok {
+		settings.RedactHeaders = splitList(raw)
+	}
+	if raw, ok := m["retry_transport_errors"]; ok {
+		if settings.RetryTransportErrors, err = strconv.ParseBool(raw); err != nil {
+			return nil, fmt.Errorf("parse retry_transport_errors: %v", err)
+		}
+	}
+	if raw, ok := m["log_safe_params"]; ok {
+		settings.LogSafeParams = splitList(raw)
+	}
+	if raw, ok := m["breaker_threshold"]; ok {
+		if settings.BreakerThreshold, err = strconv.Atoi(raw); err != nil {
+			return nil, fmt.Errorf("parse breaker_threshold: %v", err)
+		}
+	}
+	if raw, ok := m["breaker_cooldown"]; ok {
+		if settings.BreakerCooldown, err = time.ParseDuration(raw); err != nil {
+			return nil, fmt.Errorf("parse breaker_cooldown: %v", err)
+		}
+	}
+	if raw, ok := m["max_in_flight"]; ok {
+		if settings.MaxInFlight, err = strconv.Atoi(raw); err != nil {
+			return nil, fmt.Errorf("parse max_in_flight: %v", err)
+		}
+	}
+	if raw, ok := m["reject_stale_updates"]; ok {
+		if settings.RejectStaleUpdates, err = strconv.ParseBool(raw); err != nil {
+			return nil, fmt.Errorf("parse reject_stale_updates: %v", err)
+		}
+	}
+	if raw, ok := m["default_headers"]; ok {
+		if settings.DefaultHeaders, err = ParseHeaderList(raw); err != nil {
+			return nil, fmt.Errorf("parse default_headers: %v", err)
+		}
+	}
+	if raw, ok := m["notify_limit"]; ok {
+		if settings.NotifyLimit, err = strconv.Atoi(raw); err != nil {
+			return nil, fmt.Errorf("parse notify_limit: %v", err)
+		}
+	}
+	if raw, ok := m["notify_window"]; ok {
+		if settings.NotifyWindow, err = time.ParseDuration(raw); err != nil {
+			return nil, fmt.Errorf("parse notify_window: %v", err)
+		}
+	}
+	if raw, ok := m["stats_interval"]; ok {
+		if settings.StatsInterval, err = time.ParseDuration(raw); err != nil {
+			return nil, fmt.Errorf("parse stats_interval: %v", err)
+		}
+	}
+	if raw, ok := m["latency_retention"]; ok {
+		if settings.LatencyRetention, err = time.ParseDuration(raw); err != nil {
+			return nil, fmt.Errorf("parse latency_retention: %v", err)
+		}
+	}
+	return &settings, nil
+}
+
+// JSON returns the Settings' fields as JSON data, or an error, if they cannot
+// be serialized.
+func (s Settings) JSON() ([]byte, error) {
+	payload := SettingsPayload{
+		DefaultFrequency:  s.DefaultFrequency.String(),
+		MinFrequency:      s.MinFrequency.String(),
+		DefaultFailAfter:  s.DefaultFailAfter,
+		IncidentRetention: s.IncidentRetention.String(),
+
+		StatusWriteOnChange: s.StatusWriteOnChange,
+		HistorySize:         s.HistorySize,
+
+		NXDomainAsConfigError: s.NXDomainAsConfigError,
+		MaxEndpoints:          s.MaxEndpoints,
+		RedactHeaders:         s.RedactHeaders,
+		RetryTransportErrors:  s.RetryTransportErrors,
+		LogSafeParams:         s.LogSafeParams,
+		BreakerThreshold:      s.BreakerThreshold,
+		BreakerCooldown:       s.BreakerCooldown.String(),
+		MaxInFlight:           s.MaxInFlight,
+		RejectStaleUpdates:    s.RejectStaleUpdates,
+
+		DefaultHeaders: s.DefaultHeaders,
+		NotifyLimit:    s.NotifyLimit,
+		NotifyWindow:   s.NotifyWindow.String(),
+		StatsInterval:  s.StatsInterval.String(),
+
+		LatencyRetention: s.LatencyRetention.String(),
+	}
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return nil, fmt.Errorf("marshal settings %v as JSON: %v", s, err)
+	}
+	return data, nil
+}
+
+// splitList splits the comma-separated list raw into its trimmed, non-empty
+// elements.
+func splitList(raw string) []string {
+	elements := make([]string, 0)
+	for _, element := range strings.Split(raw, ",") {
+		if element = strings.TrimSpace(element); element != "" {
+			elements = append(elements, element)
+		}
+	}
+	return elements
+}
@@ -0,0 +1,61 @@
+package meow
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// ProbeStatsKey is the key of the list holding the runtime statistics of the
+// probe, the most recent entry first.
+const ProbeStatsKey = "probe:stats"
+
+// ProbeStatsRetained is the number of runtime statistics retained, i.e. the
+// number of stats intervals looked back.
+const ProbeStatsRetained = 60
+
+// ProbeStats are the runtime statistics of the probe within a stats interval
+// ending at Timestamp.
+type ProbeStats struct {
+	Timestamp  time.Time `json:"timestamp"`
+	IntervalMS int64     `json:"interval_ms"`
+
+	// Probes is the number of probes issued within the interval, Failures the
+	// number of them that failed, and ErrorRate their ratio (0 if there were no
+	// probes at all).
+	Probes    int64   `json:"probes"`
+	Failures  int64   `json:"failures"`
+	ErrorRate float64 `json:"error_rate"`
+
+	// AverageDurationMS is the average duration of the probes issued.
+	AverageDurationMS float64 `json:"average_duration_ms"`
+
+	// Endpoints is the number of endpoints probed at the end of the interval.
+	Endpoints int `json:"endpoints"`
+
+	// Goroutines, HeapAllocBytes, SysBytes, and GCCycles describe the probe
+	// process at the end of the interval (see runtime.MemStats).
+	Goroutines     int    `json:"goroutines"`
+	HeapAllocBytes uint64 `json:"heap_alloc_bytes"`
+	SysBytes       uint64 `json:"sys_bytes"`
+	GCCycles       uint32 `json:"gc_cycles"`
+}
+
+// JSON returns the ProbeStats as JSON data, or an error, if they cannot be
+// serialized.
+func (p ProbeStats) JSON() ([]byte, error) {
+	data, err := json.Marshal(p)
+	if err != nil {
+		return nil, fmt.Errorf("marshal probe stats %v as JSON: %v", p, err)
+	}
+	return data, nil
+}
+
+// ProbeStatsFromJSON creates ProbeStats from the given JSON data.
+func ProbeStatsFromJSON(rawJSON string) (*ProbeStats, error) {
+	var stats ProbeStats
+	if err := json.Unmarshal([]byte(rawJSON), &stats); err != nil {
+		return nil, fmt.Errorf(`unmarshal probe stats "%s": %v`, rawJSON, err)
+	}
+	return &stats, nil
+}
@@ -0,0 +1,284 @@
+package meow
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"time"
+)
+
+// StatusKey returns the key of the hash holding the status of the endpoint
+// identified by identifier, as written by the probe.
+func StatusKey(identifier string) string {
+	return "status:" + identifier
+}
+
+// ExpectedBuildKey returns the key holding the build expected to be reported
+// by the endpoint identified by identifier.
+func ExpectedBuildKey(identifier string) string {
+	return "expected_build:" + identifier
+}
+
+// CheckResult is the outcome of the latest probe of an endpoint.
+type CheckResult struct {
+	State               State
+	StatusCode          int
+	ConsecutiveFailures int
+	FailureKind         FailureKind
+	Error               string
+	Latency             time.Duration
+	TTFB                time.Duration
+
+	// BodyHash is the hash of the response body, which is only computed for
+	// endpoints expecting a body hash.
+	BodyHash string
+
+	// Build is the build reported by the endpoint, which is only captured for
+	// endpoints expecting a build header.
+	Build string
+
+	// StatusText is the reason phrase of the response's status line, which is
+	// only captured for endpoints expecting a status text.
+	StatusText string
+
+	// CertSHA256 is the fingerprint of the TLS certificate observed, which is
+	// only captured if it does not match the one pinned by the endpoint.
+	CertSHA256 string
+
+	// RedirectLocation is the target of the redirect the endpoint responded
+	// with, which is only captured for endpoints not following redirects.
+	RedirectLocation string
+
+	// SetCookie is the cookie set by the endpoint (with its value redacted),
+	// which is only captured for endpoints expecting a cookie.
+	SetCookie string
+
+	// ContentEncoding is the content encoding of the response body (identity
+	// if uncompressed), which is only captured for endpoints expecting valid
+	// compression.
+	ContentEncoding string
+
+	// Headers are the response headers captured.
+	Headers map[string]string
+
+	// Stability is the ratio of successful probes among the recent probes
+	// considered for endpoints requiring stability, and nil otherwise.
+	Stability *float64
+
+	// Concurrency is the ratio of successful requests among the ones issued
+	// at once by the latest probe of endpoints with concurrent probes, and
+	// nil otherwise.
+	Concurrency *float64
+
+	// Breaker is the state of the circuit breaker of the endpoint's host:
+	// closed, open (not probed), or half_open.
+	Breaker string
+
+	// Notifications is the number of notifications sent about the endpoint
+	// within the notification window starting at NotificationsSince.
+	Notifications      int
+	NotificationsSince time.Time
+
+	// LastChecked is the time of the latest probe, which is zero for endpoints
+	// not probed yet.
+	LastChecked time.Time
+
+	// Checks and Failures are the number of probes of the endpoint, and the
+	// number of them that failed, respectively.
+	Checks   int64
+	Failures int64
+
+	// Up indicates whether or not the endpoint is considered up as of its
+	// latest probe (see DeriveUp), and is nil for endpoints not probed yet.
+	Up *bool
+
+	// Extracted is the value extracted by the endpoint's ExtractRegex from
+	// the response, which is neither stored nor reported, since it may be a
+	// secret (e.g. a token).
+	Extracted string
+}
+
+// DeriveUp sets Up according to the consecutive failures observed by the
+// probe: an endpoint is considered down once it failed failAfter consecutive
+// probes (or one, if failAfter is zero). Up remains nil if the endpoint has not
+// been probed yet.
+func (s *CheckResult) DeriveUp(failAfter uint8) {
+	if s.State == StateUnknown {
+		s.Up = nil
+		return
+	}
+	up := s.ConsecutiveFailures < max(int(failAfter), 1)
+	s.Up = &up
+}
+
+// CheckResultPayload contains the same fields as CheckResult, but as
+// serializable primitives with JSON tags.
+type CheckResultPayload struct {
+	State               string            `json:"state"`
+	StatusCode          int               `json:"status_code"`
+	ConsecutiveFailures int               `json:"consecutive_failures"`
+	FailureKind         string            `json:"failure_kind,omitempty"`
+	Error               string            `json:"error,omitempty"`
+	Latency             string            `json:"latency"`
+	TTFB                string            `json:"ttfb"`
+	BodyHash            string            `json:"body_hash,omitempty"`
+	Build               string            `json:"build,omitempty"`
+	StatusText          string            `json:"status_text,omitempty"`
+	CertSHA256          string            `json:"cert_sha256,omitempty"`
+	RedirectLocation    string            `json:"redirect_location,omitempty"`
+	SetCookie           string            `json:"set_cookie,omitempty"`
+	ContentEncoding     string            `json:"content_encoding,omitempty"`
+	Headers             map[string]string `json:"headers,omitempty"`
+	Stability           *float64          `json:"stability,omitempty"`
+	Concurrency         *float64          `json:"concurrency,omitempty"`
+	Breaker             string            `json:"breaker,omitempty"`
+	Notifications       int               `json:"notifications,omitempty"`
+	NotificationsSince  string            `json:"notifications_since,omitempty"`
+	LastChecked         string            `json:"last_checked,omitempty"`
+	Checks              int64             `json:"checks,omitempty"`
+	Failures            int64             `json:"failures,omitempty"`
+	Up                  *bool             `json:"up,omitempty"`
+}
+
+// Payload converts the status to its payload representation.
+func (s CheckResult) Payload() CheckResultPayload {
+	var notificationsSince string
+	if !s.NotificationsSince.IsZero() {
+		notificationsSince = s.NotificationsSince.Format(time.RFC3339Nano)
+	}
+	var lastChecked string
+	if !s.LastChecked.IsZero() {
+		lastChecked = s.LastChecked.Format(time.RFC3339Nano)
+	}
+	return CheckResultPayload{
+		State:               string(s.State),
+		StatusCode:          s.StatusCode,
+		ConsecutiveFailures: s.ConsecutiveFailures,
+		FailureKind:         string(s.FailureKind),
+		Error:               s.Error,
+		Latency:             s.Latency.String(),
+		TTFB:                s.TTFB.String(),
+		BodyHash:            s.BodyHash,
+		Build:               s.Build,
+		StatusText:          s.StatusText,
+		CertSHA256:          s.CertSHA256,
+		RedirectLocation:    s.RedirectLocation,
+		SetCookie:           s.SetCookie,
+		ContentEncoding:     s.ContentEncoding,
+		Headers:             s.Headers,
+		Stability:           s.Stability,
+		Concurrency:         s.Concurrency,
+		Breaker:             s.Breaker,
+		Notifications:       s.Notifications,
+		NotificationsSince:  notificationsSince,
+		LastChecked:         lastChecked,
+		Checks:              s.Checks,
+		Failures:            s.Failures,
+		Up:                  s.Up,
+	}
+}
+
+// JSON returns the CheckResult's fields as JSON data, or an error, if it
+// cannot be serialized.
+func (s CheckResult) JSON() ([]byte, error) {
+	data, err := json.Marshal(s.Payload())
+	if err != nil {
+		return nil, fmt.Errorf("marshal status %v as JSON: %v", s, err)
+	}
+	return data, nil
+}
+
+// CheckResultFromMap creates a new CheckResult from the given map, which
+// provides the fields state, status_code, consecutive_failures, failure_kind,
+// error, latency, ttfb (both durations), body_hash, build, status_text,
+// cert_sha256, redirect_location, set_cookie, content_encoding, headers (a
+// JSON object), stability, concurrency, breaker, notifications,
+// notifications_since, last_probed (both RFC 3339), checks, and failures. Up
+// is not derived from the map, but left nil. Missing fields are left at their
+// zero value, except for the state, which is StateUnknown for endpoints not
+// probed yet.
+func CheckResultFromMap(m map[string]string) (*CheckResult, error) {
+	status := CheckResult{
+		State:       StateUnknown,
+		FailureKind: FailureKind(m["failure_kind"]),
+		Error:       m["error"],
+		BodyHash:    m["body_hash"],
+		Build:       m["build"],
+		StatusText:  m["status_text"],
+		CertSHA256:  m["cert_sha256"],
+		SetCookie:   m["set_cookie"],
+		Breaker:     m["breaker"],
+
+		ContentEncoding:  m["content_encoding"],
+		RedirectLocation: m["redirect_location"],
+	}
+	var err error
+	if raw, ok := m["state"]; ok {
+		status.State = State(raw)
+	}
+	if raw, ok := m["status_code"]; ok {
+		if status.StatusCode, err = strconv.Atoi(raw); err != nil {
+			return nil, fmt.Errorf("parse status_code: %v", err)
+		}
+	}
+	if raw, ok := m["consecutive_failures"]; ok {
+		if status.ConsecutiveFailures, err = strconv.Atoi(raw); err != nil {
+			return nil, fmt.Errorf("parse consecutive_failures: %v", err)
+		}
+	}
+	if raw, ok := m["latency"]; ok {
+		if status.Latency, err = time.ParseDuration(raw); err != nil {
+			return nil, fmt.Errorf("parse latency: %v", err)
+		}
+	}
+	if raw, ok := m["ttfb"]; ok {
+		if status.TTFB, err = time.ParseDuration(raw); err != nil {
+			return nil, fmt.Errorf("parse ttfb: %v", err)
+		}
+	}
+	if raw := m["stability"]; raw != "" {
+		stability, err := strconv.ParseFloat(raw, 64)
+		if err != nil {
+			return nil, fmt.Errorf("parse stability: %v", err)
+		}
+		status.Stability = &stability
+	}
+	if raw := m["concurrency"]; raw != "" {
+		concurrency, err := strconv.ParseFloat(raw, 64)
+		if err != nil {
+			return nil, fmt.Errorf("parse concurrency: %v", err)
+		}
+		status.Concurrency = &concurrency
+	}
+	if raw := m["notifications"]; raw != "" {
+		if status.Notifications, err = strconv.Atoi(raw); err != nil {
+			return nil, fmt.Errorf("parse notifications: %v", err)
+		}
+	}
+	if raw := m["notifications_since"]; raw != "" {
+		if status.NotificationsSince, err = time.Parse(time.RFC3339Nano, raw); err != nil {
+			return nil, fmt.Errorf("parse notifications_since: %v", err)
+		}
+	}
+	if raw := m["last_probed"]; raw != "" {
+		if status.LastChecked, err = time.Parse(time.RFC3339Nano, raw); err != nil {
+			return nil, fmt.Errorf("parse last_probed: %v", err)
+		}
+	}
+	for field, value := range map[string]*int64{
+		"checks":   &status.Checks,
+		"failures": &status.Failures,
+	} {
+		if raw := m[field]; raw != "" {
+			if *value, err = strconv.ParseInt(raw, 10, 64); err != nil {
+				return nil, fmt.Errorf("parse %s: %v", field, err)
+			}
+		}
+	}
+	if raw := m["headers"]; raw != "" {
+		if err := json.Unmarshal([]byte(raw), &status.Headers); err != nil {
+			return nil, fmt.Errorf("parse headers: %v", err)
+		}
+	}
+	return &status, nil
+}
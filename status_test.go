@@ -0,0 +1,105 @@
+package meow
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+func TestCheckResultFromMapUnknown(t *testing.T) {
+	result, err := CheckResultFromMap(map[string]string{})
+	if err != nil {
+		t.Fatalf("parse empty status: %v", err)
+	}
+	result.DeriveUp(3)
+	if result.State != StateUnknown || result.Up != nil || !result.LastChecked.IsZero() {
+		t.Errorf("expected unknown state without up, got %+v", *result)
+	}
+	data, err := result.JSON()
+	if err != nil {
+		t.Fatalf("convert to JSON: %v", err)
+	}
+	var fields map[string]any
+	if err := json.Unmarshal(data, &fields); err != nil {
+		t.Fatalf("parse %s: %v", data, err)
+	}
+	if fields["state"] != "unknown" {
+		t.Errorf(`expected "state": "unknown", got %s`, data)
+	}
+	if _, ok := fields["up"]; ok {
+		t.Errorf("expected up to be omitted, got %s", data)
+	}
+}
+
+func TestCheckResultFromMap(t *testing.T) {
+	lastProbed := time.Date(2026, 10, 14, 8, 30, 0, 0, time.UTC)
+	result, err := CheckResultFromMap(map[string]string{
+		"state":                "offline",
+		"status_code":          "503",
+		"consecutive_failures": "2",
+		"failure_kind":         "status",
+		"latency":              "120ms",
+		"ttfb":                 "80ms",
+		"last_probed":          lastProbed.Format(time.RFC3339Nano),
+		"checks":               "10",
+		"failures":             "4",
+		"headers":              `{"Server":"nginx"}`,
+	})
+	if err != nil {
+		t.Fatalf("parse status: %v", err)
+	}
+	if result.State != StateOffline || result.StatusCode != 503 || result.ConsecutiveFailures != 2 ||
+		result.Latency != 120*time.Millisecond || !result.LastChecked.Equal(lastProbed) ||
+		result.Checks != 10 || result.Failures != 4 || result.Headers["Server"] != "nginx" {
+		t.Errorf("unexpected result %+v", *result)
+	}
+	var payload CheckResultPayload
+	data, err := result.JSON()
+	if err != nil {
+		t.Fatalf("convert to JSON: %v", err)
+	}
+	if err := json.Unmarshal(data, &payload); err != nil {
+		t.Fatalf("parse %s: %v", data, err)
+	}
+	if payload.State != "offline" || payload.Latency != "120ms" || payload.LastChecked != "2026-10-14T08:30:00Z" {
+		t.Errorf("unexpected payload %s", data)
+	}
+}
+
+func TestCheckResultFromMapInvalid(t *testing.T) {
+	for field, value := range map[string]string{
+		"status_code":          "ok",
+		"consecutive_failures": "-",
+		"latency":              "fast",
+		"last_probed":          "yesterday",
+		"checks":               "many",
+		"headers":              "Server: nginx",
+	} {
+		if _, err := CheckResultFromMap(map[string]string{field: value}); err == nil {
+			t.Errorf("expected %s %q to be rejected", field, value)
+		}
+	}
+}
+
+func TestDeriveUp(t *testing.T) {
+	tests := []struct {
+		failures  int
+		failAfter uint8
+		expected  bool
+	}{
+		{0, 3, true},
+		{2, 3, true},
+		{3, 3, false},
+		{0, 0, true},
+		{1, 0, false},
+		{1, 1, false},
+	}
+	for _, test := range tests {
+		result := CheckResult{State: StateOnline, ConsecutiveFailures: test.failures}
+		result.DeriveUp(test.failAfter)
+		if result.Up == nil || *result.Up != test.expected {
+			t.Errorf("expected up %t after %d failures with fail_after %d, got %v",
+				test.expected, test.failures, test.failAfter, result.Up)
+		}
+	}
+}
@@ -0,0 +1,883 @@
+package meow
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/valkey-io/valkey-go"
+)
+
+// ConfigStore stores the configured endpoints.
+type ConfigStore interface {
+	// Get loads the endpoint identified by identifier, or returns an error
+	// wrapping ErrNotFound, if there is no such endpoint.
+	Get(ctx context.Context, identifier string) (*Endpoint, error)
+
+	// Stat returns the metadata of the endpoint identified by identifier, or
+	// an error wrapping ErrNotFound, if there is no such endpoint. The
+	// endpoint is not parsed, so that endpoints stored malformed can be
+	// replaced or deleted, too.
+	Stat(ctx context.Context, identifier string) (*EndpointInfo, error)
+
+	// Put stores the endpoint e, which was written last by e.UpdatedBy, and
+	// increments its version, which is set on e. Whether or not the endpoint
+	// was created (rather than updated) is indicated as created. Creating an
+	// endpoint beyond the MaxEndpoints setting fails with an error wrapping
+	// ErrLimitReached.
+	Put(ctx context.Context, e *Endpoint) (created bool, err error)
+
+	// PutIf stores the endpoint e like Put, provided that cond holds, which
+	// is checked and written at once. Otherwise, nothing is stored, and an
+	// error wrapping ErrConflict (if the endpoint must not exist yet) or
+	// ErrPreconditionFailed (if its version does not match) is returned.
+	PutIf(ctx context.Context, e *Endpoint, cond Precondition) (created bool, err error)
+
+	// PutAll stores the endpoints like PutIf within a single transaction,
+	// provided that each of them meets the precondition of the same index in
+	// conds (if any), so that either all or none of them are stored. If an
+	// endpoint is changed concurrently, the transaction fails with an error
+	// wrapping ErrConflict.
+	PutAll(ctx context.Context, endpoints []*Endpoint, conds []Precondition) (created []bool, err error)
+
+	// Delete deletes the endpoint identified by identifier along with the data
+	// recorded for it, or returns an error wrapping ErrNotFound, if there is
+	// no such endpoint.
+	Delete(ctx context.Context, identifier string) error
+
+	// List returns the endpoints stored ordered by their identifier. Endpoints
+	// that cannot be parsed are left out.
+	List(ctx context.Context) ([]*Endpoint, error)
+
+	// ListPage calls fn with the endpoints selected by query in batches as
+	// they are read, and returns the cursor of the next page, which is empty
+	// for the last page. The cursor is passed to fn as well, since the page
+	// is selected before its first batch is read. Endpoints that cannot be
+	// parsed fail the listing, as does fn returning an error, and a cursor not
+	// issued by ListPage fails it with an error wrapping ErrInvalidCursor.
+	ListPage(ctx context.Context, query ListQuery, fn func(next string, endpoints []*Endpoint) error) (string, error)
+
+	// Tagged returns the identifiers of the endpoints tagged with tag in
+	// order.
+	Tagged(ctx context.Context, tag string) ([]string, error)
+}
+
+// EndpointInfo is the metadata of a stored endpoint.
+type EndpointInfo struct {
+	Owner     string
+	Version   uint64
+	UpdatedBy string
+}
+
+// Precondition restricts writing an endpoint. The zero Precondition permits
+// every write.
+type Precondition struct {
+	// CreateOnly demands that the endpoint does not exist yet.
+	CreateOnly bool
+
+	// Versions are the versions one of which the stored endpoint must have,
+	// unless there are none.
+	Versions []uint64
+}
+
+// ListQuery selects the endpoints listed by ListPage. Empty fields select all
+// endpoints.
+type ListQuery struct {
+	// Owner selects the endpoints owned by Owner.
+	Owner string
+
+	// Method and IdentifierPrefix select the endpoints probed with Method
+	// and identified with the prefix IdentifierPrefix.
+	Method           string
+	IdentifierPrefix string
+
+	// Cursor is the cursor returned for the previous page, or empty for the
+	// first page, which consists of up to Limit endpoints, or of all, if
+	// Limit is 0.
+	Cursor string
+	Limit  int
+}
+
+// matches indicates whether or not the endpoint stored with the fields kvs is
+// selected by the query, disregarding its owner.
+func (q ListQuery) matches(kvs map[string]string) bool {
+	if q.Method != "" && kvs["method"] != q.Method {
+		return false
+	}
+	return strings.HasPrefix(kvs["identifier"], q.IdentifierPrefix)
+}
+
+// NewConfigStore creates a ConfigStore keeping the endpoints in Valkey using
+// the client.
+func NewConfigStore(client valkey.Client) ConfigStore {
+	return &valkeyStore{client: client}
+}
+
+// EndpointKey returns the key of the hash storing the endpoint identified by
+// identifier.
+func EndpointKey(identifier string) string {
+	return "endpoint:" + identifier
+}
+
+// EndpointCountKey is the key of the counter of stored endpoints, which is
+// maintained upon creation and deletion, so that MaxEndpoints can be enforced
+// without scanning all keys.
+const EndpointCountKey = "endpoints:count"
+
+// valkeyStore keeps each endpoint in a hash (see EndpointKey), indexes them by
+// their owner and tags, and counts them (see EndpointCountKey).
+type valkeyStore struct {
+	client valkey.Client
+}
+
+func (s *valkeyStore) Get(ctx context.Context, identifier string) (*Endpoint, error) {
+	client := s.client
+	key := EndpointKey(identifier)
+	kvs, err := client.Do(ctx, client.B().Hgetall().Key(key).Build()).AsStrMap()
+	if err != nil {
+		return nil, fmt.Errorf("hgetall %s: %v", key, err)
+	}
+	if len(kvs) == 0 {
+		return nil, fmt.Errorf(`endpoint "%s": %w`, identifier, ErrNotFound)
+	}
+	endpoint, err := EndpointFromMap(kvs)
+	if err != nil {
+		return nil, fmt.Errorf("parse endpoint from %s: %v", key, err)
+	}
+	return endpoint, nil
+}
+
+func (s *valkeyStore) Stat(ctx context.Context, identifier string) (*EndpointInfo, error) {
+	client := s.client
+	key := EndpointKey(identifier)
+	stored, err := client.Do(ctx, client.B().Hmget().Key(key).
+		Field("identifier", "owner", "version", "updated_by").Build()).ToArray()
+	if err != nil {
+		return nil, fmt.Errorf("hmget %s identifier owner version updated_by: %v", key, err)
+	}
+	if _, err := stored[0].ToString(); err != nil {
+		return nil, fmt.Errorf(`endpoint "%s": %w`, identifier, ErrNotFound)
+	}
+	var info EndpointInfo
+	info.Owner, _ = stored[1].ToString()
+	raw, _ := stored[2].ToString()
+	info.Version, _ = strconv.ParseUint(raw, 10, 64)
+	info.UpdatedBy, _ = stored[3].ToString()
+	return &info, nil
+}
+
+// indexed returns whether or not the endpoint stored under key exists, and,
+// if so, the owner and the tags it is indexed by, as well as its version.
+func indexed(ctx context.Context, client valkey.CoreClient, key string) (exists bool, owner string,
+	tags []string, version uint64, err error) {
+	stored, err := client.Do(ctx, client.B().Hmget().Key(key).Field("identifier", "owner", "tags", "version").Build()).ToArray()
+	if err != nil {
+		return false, "", nil, 0, fmt.Errorf("hmget %s identifier owner tags version: %v", key, err)
+	}
+	_, err = stored[0].ToString()
+	exists = err == nil
+	owner, _ = stored[1].ToString()
+	if raw, _ := stored[2].ToString(); raw != "" {
+		json.Unmarshal([]byte(raw), &tags)
+	}
+	raw, _ := stored[3].ToString()
+	version, _ = strconv.ParseUint(raw, 10, 64)
+	return exists, owner, tags, version, nil
+}
+
+func (s *valkeyStore) Put(ctx context.Context, e *Endpoint) (bool, error) {
+	return s.PutIf(ctx, e, Precondition{})
+}
+
+func (s *valkeyStore) PutIf(ctx context.Context, e *Endpoint, cond Precondition) (bool, error) {
+	client := s.client
+	key := EndpointKey(e.Identifier)
+	exists, previousOwner, previousTags, _, err := indexed(ctx, client, key)
+	if err != nil {
+		return false, err
+	}
+	if exists && cond.CreateOnly {
+		return false, fmt.Errorf("create endpoint %s: %w", e.Identifier, ErrConflict)
+	}
+	fields, err := EndpointFields(e, e.UpdatedBy)
+	if err != nil {
+		return false, err
+	}
+	created := !exists
+	if created {
+		if err := s.reserve(ctx, 1); err != nil {
+			return false, fmt.Errorf("create endpoint %s: %w", e.Identifier, err)
+		}
+	}
+	args := []string{strconv.Itoa(len(cond.Versions))}
+	for _, version := range cond.Versions {
+		args = append(args, strconv.FormatUint(version, 10))
+	}
+	if cond.CreateOnly {
+		args = []string{"-1"}
+	}
+	version, err := writeEndpointScript.Exec(ctx, client, []string{key}, append(args, fields...)).AsInt64()
+	if err != nil {
+		err = fmt.Errorf("write %s: %v", key, err)
+	} else if version == -2 {
+		// created concurrently since the existence was checked
+		err = fmt.Errorf("create endpoint %s: %w", e.Identifier, ErrConflict)
+	} else if version < 0 {
+		err = fmt.Errorf("write endpoint %s: version does not match: %w", e.Identifier, ErrPreconditionFailed)
+	}
+	if err != nil {
+		if created {
+			err = errors.Join(err, s.release(ctx, 1))
+		}
+		return false, err
+	}
+	e.Version = uint64(version)
+	if err := IndexEndpoint(ctx, client, e.Identifier, previousOwner, e.Owner, previousTags, e.Tags); err != nil {
+		return false, fmt.Errorf("index %s: %v", key, err)
+	}
+	return created, nil
+}
+
+// writeEndpointScript stores the fields of an endpoint (KEYS[1]) and increments
+// its version, which is returned. The first argument is the number n of
+// versions following it, one of which the stored version must match, unless n
+// is 0; otherwise, nothing is stored, and -1 is returned. If n is -1, no
+// versions follow, and the endpoint must not exist yet; otherwise, nothing is
+// stored, and -2 is returned. The remaining arguments are the field/value
+// pairs. Checking and writing the version at once prevents concurrent writers
+// from both passing the check.
+var writeEndpointScript = valkey.NewLuaScript(`
+local n = tonumber(ARGV[1])
+if n < 0 then
+	if redis.call('EXISTS', KEYS[1]) == 1 then
+		return -2
+	end
+	n = 0
+elseif n > 0 then
+	local current = redis.call('HGET', KEYS[1], 'version')
+	local matched = false
+	for i = 2, n + 1 do
+		if ARGV[i] == current then
+			matched = true
+		end
+	end
+	if not matched then
+		return -1
+	end
+end
+redis.call('HSET', KEYS[1], unpack(ARGV, n + 2))
+return redis.call('HINCRBY', KEYS[1], 'version', 1)
+`)
+
+func (s *valkeyStore) PutAll(ctx context.Context, endpoints []*Endpoint, conds []Precondition) ([]bool, error) {
+	created := make([]bool, len(endpoints))
+	if len(endpoints) == 0 {
+		return created, nil
+	}
+	client := s.client
+	err := client.Dedicated(func(dc valkey.DedicatedClient) error {
+		keys := make([]string, len(endpoints))
+		for i, e := range endpoints {
+			keys[i] = EndpointKey(e.Identifier)
+		}
+		// the transaction is aborted if any of the endpoints changes meanwhile
+		if err := dc.Do(ctx, dc.B().Watch().Key(keys...).Build()).Error(); err != nil {
+			return fmt.Errorf("watch endpoints: %v", err)
+		}
+		// no-op after EXEC, which unwatches the keys
+		defer dc.Do(ctx, dc.B().Unwatch().Build())
+		cmds := valkey.Commands{dc.B().Multi().Build()}
+		// the positions of the replies to HINCRBY version among the replies
+		// to the commands executed
+		versionReplies := make([]int, len(endpoints))
+		var n int64
+		for i, e := range endpoints {
+			key := keys[i]
+			exists, previousOwner, previousTags, version, err := indexed(ctx, dc, key)
+			if err != nil {
+				return err
+			}
+			if i < len(conds) {
+				if exists && conds[i].CreateOnly {
+					return fmt.Errorf("create endpoint %s: %w", e.Identifier, ErrConflict)
+				}
+				if len(conds[i].Versions) > 0 && (!exists || !slices.Contains(conds[i].Versions, version)) {
+					return fmt.Errorf("write endpoint %s: version does not match: %w", e.Identifier, ErrPreconditionFailed)
+				}
+			}
+			fields, err := EndpointFields(e, e.UpdatedBy)
+			if err != nil {
+				return err
+			}
+			if !exists {
+				created[i] = true
+				n++
+			}
+			cmds = append(cmds, dc.B().Arbitrary("HSET", key).Args(fields...).Build(),
+				dc.B().Hincrby().Key(key).Field("version").Increment(1).Build())
+			// MULTI is not replied to within EXEC
+			versionReplies[i] = len(cmds) - 2
+			cmds = append(cmds, IndexCommands(client, e.Identifier, previousOwner, e.Owner,
+				previousTags, e.Tags)...)
+		}
+		if n > 0 {
+			if err := s.reserve(ctx, n); err != nil {
+				return fmt.Errorf("create %d endpoints: %w", n, err)
+			}
+		}
+		cmds = append(cmds, dc.B().Exec().Build())
+		replies := dc.DoMulti(ctx, cmds...)
+		for i, reply := range replies {
+			if err := reply.Error(); err != nil {
+				if valkey.IsValkeyNil(err) && i == len(replies)-1 {
+					// EXEC returns nil if a watched key was changed
+					err = fmt.Errorf("store endpoints: changed concurrently: %w", ErrConflict)
+				} else {
+					err = fmt.Errorf("store endpoints: %v", err)
+				}
+				if n > 0 {
+					err = errors.Join(err, s.release(ctx, n))
+				}
+				return err
+			}
+		}
+		executed, _ := replies[len(replies)-1].ToArray()
+		for _, reply := range executed {
+			if err := reply.Error(); err != nil {
+				// the endpoints stored already cannot be rolled back
+				return fmt.Errorf("store endpoints: %v", err)
+			}
+		}
+		for i, e := range endpoints {
+			if position := versionReplies[i]; position < len(executed) {
+				version, _ := executed[position].AsInt64()
+				e.Version = uint64(version)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return created, nil
+}
+
+// reserve counts n endpoints about to be created. An error wrapping
+// ErrLimitReached is returned if the MaxEndpoints setting would be exceeded, in
+// which case the endpoints must not be created.
+func (s *valkeyStore) reserve(ctx context.Context, n int64) error {
+	client := s.client
+	count, err := client.Do(ctx, client.B().Incrby().Key(EndpointCountKey).Increment(n).Build()).AsInt64()
+	if err != nil {
+		return fmt.Errorf("incrby %s: %v", EndpointCountKey, err)
+	}
+	max := CurrentSettings().MaxEndpoints
+	if max > 0 && count > int64(max) {
+		return errors.Join(fmt.Errorf("maximum of %d endpoints: %w", max, ErrLimitReached), s.release(ctx, n))
+	}
+	return nil
+}
+
+// release reverts reserve, e.g. if creating the endpoints failed, or counts n
+// deleted endpoints.
+func (s *valkeyStore) release(ctx context.Context, n int64) error {
+	client := s.client
+	if err := client.Do(ctx, client.B().Decrby().Key(EndpointCountKey).Decrement(n).Build()).Error(); err != nil {
+		return fmt.Errorf("decrby %s: %v", EndpointCountKey, err)
+	}
+	return nil
+}
+
+func (s *valkeyStore) Delete(ctx context.Context, identifier string) error {
+	client := s.client
+	key := EndpointKey(identifier)
+	// not parsed, so that endpoints stored malformed can be deleted, too
+	kvs, err := client.Do(ctx, client.B().Hgetall().Key(key).Build()).AsStrMap()
+	if err != nil {
+		return fmt.Errorf("hgetall %s: %v", key, err)
+	}
+	deleted, err := client.Do(ctx, client.B().Del().Key(key).Build()).AsInt64()
+	if err != nil {
+		return fmt.Errorf("del %s: %v", key, err)
+	}
+	if len(kvs) == 0 || deleted == 0 {
+		// possibly deleted concurrently
+		return fmt.Errorf(`endpoint "%s": %w`, identifier, ErrNotFound)
+	}
+	var tags []string
+	var errs []error
+	if err := s.release(ctx, 1); err != nil {
+		errs = append(errs, err)
+	}
+	if raw := kvs["tags"]; raw != "" {
+		if err := json.Unmarshal([]byte(raw), &tags); err != nil {
+			errs = append(errs, fmt.Errorf("parse tags of %s: %v", key, err))
+		}
+	}
+	// the endpoint is gone already: clean up as much as possible
+	if err := IndexEndpoint(ctx, client, identifier, kvs["owner"], "", tags, nil); err != nil {
+		errs = append(errs, fmt.Errorf("unindex %s: %v", key, err))
+	}
+	if err := deleteEndpointData(ctx, client, identifier); err != nil {
+		errs = append(errs, err)
+	}
+	return errors.Join(errs...)
+}
+
+func (s *valkeyStore) List(ctx context.Context) ([]*Endpoint, error) {
+	var endpoints []*Endpoint
+	err := ScanEndpointKeys(ctx, s.client, "", func(keys []string) error {
+		stored, err := s.fetch(ctx, keys)
+		if err != nil {
+			return err
+		}
+		for _, kvs := range stored {
+			if endpoint, err := EndpointFromMap(kvs); err == nil {
+				endpoints = append(endpoints, endpoint)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	slices.SortFunc(endpoints, func(a, b *Endpoint) int {
+		return strings.Compare(a.Identifier, b.Identifier)
+	})
+	return endpoints, nil
+}
+
+func (s *valkeyStore) ListPage(ctx context.Context, query ListQuery, fn func(string, []*Endpoint) error) (string, error) {
+	keys, next, err := s.pageKeys(ctx, query)
+	if err != nil {
+		return "", err
+	}
+	for batch := range slices.Chunk(keys, listBatchSize) {
+		if err := ctx.Err(); err != nil {
+			// e.g. the client disconnected
+			return "", err
+		}
+		stored, err := s.fetch(ctx, batch)
+		if err != nil {
+			return "", err
+		}
+		endpoints := make([]*Endpoint, 0, len(batch))
+		for i, kvs := range stored {
+			if kvs == nil {
+				// deleted since the page was selected
+				continue
+			}
+			endpoint, err := EndpointFromMap(kvs)
+			if err != nil {
+				return "", fmt.Errorf("parse endpoint from %s: %v", batch[i], err)
+			}
+			endpoints = append(endpoints, endpoint)
+		}
+		if len(endpoints) > 0 {
+			if err := fn(next, endpoints); err != nil {
+				return "", err
+			}
+		}
+	}
+	return next, nil
+}
+
+// pageKeys returns the keys of the endpoints on the page selected by query,
+// and the cursor of the next page. Only the fields the query matches are read,
+// so that the endpoints themselves are not held in memory.
+func (s *valkeyStore) pageKeys(ctx context.Context, query ListQuery) ([]string, string, error) {
+	var position pageCursor
+	if query.Cursor != "" {
+		var err error
+		if position, err = parsePageCursor(query.Cursor); err != nil {
+			return nil, "", err
+		}
+	}
+	// keys may be reported repeatedly by the scan
+	seen := make(map[string]bool)
+	var page []string
+	full := func() bool { return query.Limit > 0 && len(page) == query.Limit }
+	for {
+		if err := ctx.Err(); err != nil {
+			// e.g. the client disconnected
+			return nil, "", err
+		}
+		keys, next, err := scanEndpointBatch(ctx, s.client, query.Owner, position.scan)
+		if err != nil {
+			return nil, "", err
+		}
+		// the batch may have changed since the previous page
+		keys = keys[min(position.offset, len(keys)):]
+		stored, err := s.fetchMatched(ctx, keys)
+		if err != nil {
+			return nil, "", err
+		}
+		for i, kvs := range stored {
+			if kvs == nil || seen[keys[i]] || !query.matches(kvs) {
+				continue
+			}
+			if full() {
+				return page, pageCursor{position.scan, position.offset + i}.String(), nil
+			}
+			seen[keys[i]] = true
+			page = append(page, keys[i])
+		}
+		if next == 0 {
+			return page, "", nil
+		}
+		position = pageCursor{scan: next}
+		if full() {
+			return page, position.String(), nil
+		}
+	}
+}
+
+func (s *valkeyStore) Tagged(ctx context.Context, tag string) ([]string, error) {
+	client := s.client
+	key := TagIndexKey(tag)
+	identifiers, err := client.Do(ctx, client.B().Smembers().Key(key).Build()).AsStrSlice()
+	if err != nil {
+		return nil, fmt.Errorf("smembers %s: %v", key, err)
+	}
+	slices.Sort(identifiers)
+	return identifiers, nil
+}
+
+// fetch reads the endpoints stored under keys within a single round-trip, and
+// returns the fields stored for each of the keys, which are nil for endpoints
+// not existing (anymore).
+func (s *valkeyStore) fetch(ctx context.Context, keys []string) ([]map[string]string, error) {
+	if len(keys) == 0 {
+		return nil, nil
+	}
+	client := s.client
+	cmds := make(valkey.Commands, 0, len(keys))
+	for _, key := range keys {
+		cmds = append(cmds, client.B().Hgetall().Key(key).Build())
+	}
+	stored := make([]map[string]string, len(keys))
+	for i, result := range client.DoMulti(ctx, cmds...) {
+		kvs, err := result.AsStrMap()
+		if err != nil {
+			return nil, fmt.Errorf("hgetall %s: %v", keys[i], err)
+		}
+		if len(kvs) > 0 {
+			// otherwise deleted meanwhile, or a stale entry of the owner index
+			stored[i] = kvs
+		}
+	}
+	return stored, nil
+}
+
+// fetchMatched reads the fields of the endpoints stored under keys that a
+// ListQuery matches within a single round-trip, which are nil for endpoints not
+// existing (anymore).
+func (s *valkeyStore) fetchMatched(ctx context.Context, keys []string) ([]map[string]string, error) {
+	if len(keys) == 0 {
+		return nil, nil
+	}
+	client := s.client
+	cmds := make(valkey.Commands, 0, len(keys))
+	for _, key := range keys {
+		cmds = append(cmds, client.B().Hmget().Key(key).Field("identifier", "method").Build())
+	}
+	stored := make([]map[string]string, len(keys))
+	for i, result := range client.DoMulti(ctx, cmds...) {
+		values, err := result.ToArray()
+		if err != nil {
+			return nil, fmt.Errorf("hmget %s: %v", keys[i], err)
+		}
+		identifier, err := values[0].ToString()
+		if valkey.IsValkeyNil(err) {
+			// deleted meanwhile, or a stale entry of the owner index
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("hmget %s: %v", keys[i], err)
+		}
+		method, _ := values[1].ToString()
+		stored[i] = map[string]string{"identifier": identifier, "method": method}
+	}
+	return stored, nil
+}
+
+// pageCursor is the position at which the listing of endpoints continues: the
+// cursor of the scan returning the next batch of keys, and the number of keys
+// of that batch listed already.
+type pageCursor struct {
+	scan   uint64
+	offset int
+}
+
+// parsePageCursor parses raw as a pageCursor of the form "scan-offset".
+func parsePageCursor(raw string) (pageCursor, error) {
+	malformed := fmt.Errorf(`cursor "%s" is malformed: %w`, raw, ErrInvalidCursor)
+	rawScan, rawOffset, ok := strings.Cut(raw, "-")
+	if !ok {
+		return pageCursor{}, malformed
+	}
+	scan, err := strconv.ParseUint(rawScan, 10, 64)
+	if err != nil {
+		return pageCursor{}, malformed
+	}
+	offset, err := strconv.Atoi(rawOffset)
+	if err != nil || offset < 0 {
+		return pageCursor{}, malformed
+	}
+	return pageCursor{scan, offset}, nil
+}
+
+func (c pageCursor) String() string {
+	return fmt.Sprintf("%d-%d", c.scan, c.offset)
+}
+
+// listBatchSize is the number of keys scanned at once when listing endpoints.
+const listBatchSize = 100
+
+// ScanEndpointKeys calls fn with batches of keys of the endpoints owned by
+// owner, which are looked up using the owner index, or of all endpoints, if
+// owner is empty. Each key is passed once, even if reported repeatedly by the
+// scan, which is aborted once ctx is done. Unlike KEYS, scanning does not
+// block Valkey.
+func ScanEndpointKeys(ctx context.Context, client valkey.Client, owner string, fn func([]string) error) error {
+	seen := make(map[string]bool)
+	var cursor uint64
+	for {
+		if err := ctx.Err(); err != nil {
+			// e.g. the client disconnected
+			return err
+		}
+		batch, next, err := scanEndpointBatch(ctx, client, owner, cursor)
+		if err != nil {
+			return err
+		}
+		keys := make([]string, 0, len(batch))
+		for _, key := range batch {
+			if !seen[key] {
+				seen[key] = true
+				keys = append(keys, key)
+			}
+		}
+		if len(keys) > 0 {
+			if err := fn(keys); err != nil {
+				return err
+			}
+		}
+		if cursor = next; cursor == 0 {
+			return nil
+		}
+	}
+}
+
+// scanEndpointBatch returns the batch of keys of endpoints owned by owner (or
+// of all endpoints, if owner is empty) scanned at cursor, and the cursor of the
+// next batch, which is 0 once the scan is complete.
+func scanEndpointBatch(ctx context.Context, client valkey.Client, owner string, cursor uint64) ([]string, uint64, error) {
+	if owner == "" {
+		entry, err := client.Do(ctx, client.B().Scan().Cursor(cursor).
+			Match(EndpointKey("*")).Count(listBatchSize).Build()).AsScanEntry()
+		if err != nil {
+			return nil, 0, fmt.Errorf("scan %s: %v", EndpointKey("*"), err)
+		}
+		return entry.Elements, entry.Cursor, nil
+	}
+	key := OwnerIndexKey(owner)
+	entry, err := client.Do(ctx, client.B().Sscan().Key(key).Cursor(cursor).
+		Count(listBatchSize).Build()).AsScanEntry()
+	if err != nil {
+		return nil, 0, fmt.Errorf("sscan %s: %v", key, err)
+	}
+	for i, identifier := range entry.Elements {
+		entry.Elements[i] = EndpointKey(identifier)
+	}
+	return entry.Elements, entry.Cursor, nil
+}
+
+// deleteEndpointData deletes the data the probe recorded for the endpoint
+// identified by identifier: its status, history, latencies, and incidents, as
+// well as the build it is expected to report.
+func deleteEndpointData(ctx context.Context, client valkey.Client, identifier string) error {
+	indexKey := IncidentIndexKey(identifier)
+	keys, err := client.Do(ctx, client.B().Zrange().Key(indexKey).Min("0").Max("-1").Build()).AsStrSlice()
+	if err != nil {
+		return fmt.Errorf("zrange %s: %v", indexKey, err)
+	}
+	keys = append(keys, indexKey, StatusKey(identifier), HistoryKey(identifier),
+		LatencyKey(identifier), ExpectedBuildKey(identifier))
+	if err := client.Do(ctx, client.B().Del().Key(keys...).Build()).Error(); err != nil {
+		return fmt.Errorf("del %s: %v", strings.Join(keys, " "), err)
+	}
+	return nil
+}
+
+// OwnerIndexKey returns the key of the set holding the identifiers of the
+// endpoints owned by owner.
+func OwnerIndexKey(owner string) string {
+	return "owner:" + owner
+}
+
+// ownerIndexCommands returns the commands moving the endpoint identified by
+// identifier from the owner index of previousOwner to the one of owner. Empty
+// owners are not indexed.
+func ownerIndexCommands(client valkey.Client, identifier, previousOwner, owner string) valkey.Commands {
+	var cmds valkey.Commands
+	if previousOwner != "" && previousOwner != owner {
+		cmds = append(cmds, client.B().Srem().Key(OwnerIndexKey(previousOwner)).Member(identifier).Build())
+	}
+	if owner != "" {
+		cmds = append(cmds, client.B().Sadd().Key(OwnerIndexKey(owner)).Member(identifier).Build())
+	}
+	return cmds
+}
+
+// TagIndexKey returns the key of the set of identifiers of the endpoints
+// tagged with tag.
+func TagIndexKey(tag string) string {
+	return "tag:" + tag
+}
+
+// tagIndexCommands returns the commands moving the endpoint identified by
+// identifier from the tag indices of previousTags to the ones of tags.
+func tagIndexCommands(client valkey.Client, identifier string, previousTags, tags []string) valkey.Commands {
+	var cmds valkey.Commands
+	for _, tag := range previousTags {
+		if !slices.Contains(tags, tag) {
+			cmds = append(cmds, client.B().Srem().Key(TagIndexKey(tag)).Member(identifier).Build())
+		}
+	}
+	for _, tag := range tags {
+		cmds = append(cmds, client.B().Sadd().Key(TagIndexKey(tag)).Member(identifier).Build())
+	}
+	return cmds
+}
+
+// IndexCommands returns the commands moving the endpoint identified by
+// identifier from the indices of its previous owner and tags to the ones of
+// owner and tags.
+func IndexCommands(client valkey.Client, identifier, previousOwner, owner string, previousTags, tags []string) valkey.Commands {
+	cmds := ownerIndexCommands(client, identifier, previousOwner, owner)
+	return append(cmds, tagIndexCommands(client, identifier, previousTags, tags)...)
+}
+
+// IndexEndpoint performs the IndexCommands one by one, and returns the error of
+// the first failing one.
+func IndexEndpoint(ctx context.Context, client valkey.Client, identifier, previousOwner, owner string,
+	previousTags, tags []string) error {
+	cmds := IndexCommands(client, identifier, previousOwner, owner, previousTags, tags)
+	for _, cmd := range cmds {
+		args := strings.Join(cmd.Commands(), " ")
+		if err := client.Do(ctx, cmd).Error(); err != nil {
+			return fmt.Errorf("%s: %v", strings.ToLower(args), err)
+		}
+	}
+	return nil
+}
+
+// EndpointFields returns the fields (and their values) of the hash storing the
+// endpoint, which was written last by updatedBy. The version is not included,
+// but incremented separately.
+func EndpointFields(endpoint *Endpoint, updatedBy string) ([]string, error) {
+	windows, err := json.Marshal(endpoint.MaintenanceWindows)
+	if err != nil {
+		return nil, fmt.Errorf("serialize maintenance windows of %s: %v", endpoint.Identifier, err)
+	}
+	var schema string
+	if endpoint.ResponseSchema != nil {
+		schema = endpoint.ResponseSchema.String()
+	}
+	var extractRegex string
+	if endpoint.ExtractRegex != nil {
+		extractRegex = endpoint.ExtractRegex.String()
+	}
+	var activeHours []byte
+	if endpoint.ActiveHours != nil {
+		if activeHours, err = json.Marshal(endpoint.ActiveHours.Payload()); err != nil {
+			return nil, fmt.Errorf("serialize active hours of %s: %v", endpoint.Identifier, err)
+		}
+	}
+	captureHeaders, err := json.Marshal(endpoint.CaptureHeaderNames)
+	if err != nil {
+		return nil, fmt.Errorf("serialize headers to capture of %s: %v", endpoint.Identifier, err)
+	}
+	checkPaths, err := json.Marshal(endpoint.CheckPaths)
+	if err != nil {
+		return nil, fmt.Errorf("serialize check paths of %s: %v", endpoint.Identifier, err)
+	}
+	var proxy string
+	if endpoint.Proxy != nil {
+		proxy = endpoint.Proxy.String()
+	}
+	var expectSetCookie []byte
+	if endpoint.ExpectSetCookie != nil {
+		if expectSetCookie, err = json.Marshal(endpoint.ExpectSetCookie); err != nil {
+			return nil, fmt.Errorf("serialize expected cookie of %s: %v", endpoint.Identifier, err)
+		}
+	}
+	tags, err := json.Marshal(endpoint.Tags)
+	if err != nil {
+		return nil, fmt.Errorf("serialize tags of %s: %v", endpoint.Identifier, err)
+	}
+	var bodySource string
+	if endpoint.BodySource != nil {
+		bodySource = endpoint.BodySource.String()
+	}
+	var expectJSONPath string
+	if endpoint.ExpectJSONPath != nil {
+		expectJSONPath = endpoint.ExpectJSONPath.String()
+	}
+	headers, err := json.Marshal(endpoint.Headers)
+	if err != nil {
+		return nil, fmt.Errorf("serialize headers of %s: %v", endpoint.Identifier, err)
+	}
+	return []string{
+		"identifier", endpoint.Identifier,
+		"url", endpoint.URL.String(),
+		"method", endpoint.Method,
+		"status_online", strconv.Itoa(int(endpoint.StatusOnline)),
+		"frequency", endpoint.Frequency.String(),
+		"fail_after", strconv.Itoa(int(endpoint.FailAfter)),
+		"maintenance_windows", string(windows),
+		"keep_connections_on_failure", strconv.FormatBool(endpoint.KeepConnectionsOnFailure),
+		"max_ttfb", endpoint.MaxTTFB.String(),
+		"response_schema", schema,
+		"expect_trailer", endpoint.ExpectTrailer,
+		"expect_trailer_value", endpoint.ExpectTrailerValue,
+		"extract_regex", extractRegex,
+		"extract_header", endpoint.ExtractHeader,
+		"host_header", endpoint.HostHeader,
+		"schema_version", strconv.Itoa(EndpointSchemaVersion),
+		"active_hours", string(activeHours),
+		"expect_body_hash", endpoint.ExpectBodyHash,
+		"capture_headers", string(captureHeaders),
+		"fast_fail_on_refused", strconv.FormatBool(endpoint.FastFailOnRefused),
+		"timeout", endpoint.Timeout.String(),
+		"check_paths", string(checkPaths),
+		"owner", endpoint.Owner,
+		"stability_window", strconv.Itoa(int(endpoint.StabilityWindow)),
+		"stability_threshold", strconv.Itoa(int(endpoint.StabilityThreshold)),
+		"insecure_skip_verify", strconv.FormatBool(endpoint.InsecureSkipVerify),
+		"proxy", proxy,
+		"expect_build_header", endpoint.ExpectBuildHeader,
+		"expect_set_cookie", string(expectSetCookie),
+		"tags", string(tags),
+		"body_source", bodySource,
+		"expect_json_path", expectJSONPath,
+		"updated_by", updatedBy,
+		"headers", string(headers),
+		"expect_valid_compression", strconv.FormatBool(endpoint.ExpectValidCompression),
+		"protocol", endpoint.Protocol,
+		"concurrent_probes", strconv.Itoa(int(endpoint.ConcurrentProbes)),
+		"expect_status_text", endpoint.ExpectStatusText,
+		"pinned_cert_sha256", endpoint.PinnedCertSHA256,
+		"body_contains", endpoint.BodyContains,
+		"body_not_contains", endpoint.BodyNotContains,
+		"redirect_counts_as", string(endpoint.RedirectCountsAs),
+		"resolver", endpoint.Resolver,
+		"connect_target", endpoint.ConnectTarget,
+		"dial_timeout", endpoint.DialTimeout.String(),
+	}, nil
+}
@@ -0,0 +1,447 @@
+package meow
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/patrickbucher/meow/internal/valkeytest"
+	"github.com/valkey-io/valkey-go"
+)
+
+// newEndpoint creates a valid endpoint with the given identifier, owner and
+// tags, or fails t, if it is invalid.
+func newEndpoint(t *testing.T, identifier, owner string, tags ...string) *Endpoint {
+	t.Helper()
+	payload := validPayload()
+	payload.Identifier, payload.Owner, payload.Tags = identifier, owner, tags
+	return mustEndpoint(t, payload)
+}
+
+func TestConfigStorePutGet(t *testing.T) {
+	ctx := context.Background()
+	store := NewMemConfigStore()
+	endpoint := newEndpoint(t, "libvirt", "")
+	endpoint.UpdatedBy = "admin"
+	created, err := store.Put(ctx, endpoint)
+	if err != nil || !created {
+		t.Fatalf("expected endpoint to be created, got created %t (%v)", created, err)
+	}
+	if endpoint.Version != 1 {
+		t.Errorf("expected version 1 after creation, got %d", endpoint.Version)
+	}
+	stored, err := store.Get(ctx, "libvirt")
+	if err != nil {
+		t.Fatalf("get endpoint: %v", err)
+	}
+	if !stored.Equal(endpoint) || stored.Version != 1 || stored.UpdatedBy != "admin" {
+		t.Errorf("expected %+v to be stored, got %+v", *endpoint, *stored)
+	}
+
+	update := newEndpoint(t, "libvirt", "")
+	update.Method, update.UpdatedBy = "HEAD", "ops"
+	created, err = store.Put(ctx, update)
+	if err != nil || created {
+		t.Fatalf("expected endpoint to be updated, got created %t (%v)", created, err)
+	}
+	if update.Version != 2 {
+		t.Errorf("expected version 2 after update, got %d", update.Version)
+	}
+	stored, err = store.Get(ctx, "libvirt")
+	if err != nil {
+		t.Fatalf("get endpoint: %v", err)
+	}
+	if stored.Method != "HEAD" || stored.Version != 2 || stored.UpdatedBy != "ops" {
+		t.Errorf("expected update to be stored, got %+v", *stored)
+	}
+	info, err := store.Stat(ctx, "libvirt")
+	if err != nil {
+		t.Fatalf("stat endpoint: %v", err)
+	}
+	if *info != (EndpointInfo{Version: 2, UpdatedBy: "ops"}) {
+		t.Errorf("expected version 2 updated by ops, got %+v", *info)
+	}
+}
+
+func TestConfigStoreNotFound(t *testing.T) {
+	ctx := context.Background()
+	store := NewMemConfigStore()
+	if _, err := store.Get(ctx, "libvirt"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("expected Get to fail with ErrNotFound, got %v", err)
+	}
+	if _, err := store.Stat(ctx, "libvirt"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("expected Stat to fail with ErrNotFound, got %v", err)
+	}
+	if err := store.Delete(ctx, "libvirt"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("expected Delete to fail with ErrNotFound, got %v", err)
+	}
+}
+
+func TestConfigStoreDelete(t *testing.T) {
+	ctx := context.Background()
+	store := NewMemConfigStore()
+	if _, err := store.Put(ctx, newEndpoint(t, "libvirt", "", "infra")); err != nil {
+		t.Fatalf("put endpoint: %v", err)
+	}
+	if err := store.Delete(ctx, "libvirt"); err != nil {
+		t.Fatalf("delete endpoint: %v", err)
+	}
+	if _, err := store.Get(ctx, "libvirt"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("expected deleted endpoint to be gone, got %v", err)
+	}
+	if tagged, _ := store.Tagged(ctx, "infra"); len(tagged) > 0 {
+		t.Errorf("expected deleted endpoint to be untagged, got %v", tagged)
+	}
+	if err := store.Delete(ctx, "libvirt"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("expected second deletion to fail with ErrNotFound, got %v", err)
+	}
+	created, err := store.Put(ctx, newEndpoint(t, "libvirt", ""))
+	if err != nil || !created {
+		t.Errorf("expected deleted endpoint to be created again, got created %t (%v)", created, err)
+	}
+}
+
+func TestConfigStoreMalformed(t *testing.T) {
+	ctx := context.Background()
+	store := NewMemConfigStore(map[string]string{
+		"identifier": "broken", "url": "https://example.com", "method": "GET",
+		"status_online": "two hundred", "frequency": "1m0s", "version": "3", "owner": "ops",
+	})
+	if _, err := store.Put(ctx, newEndpoint(t, "libvirt", "")); err != nil {
+		t.Fatalf("put endpoint: %v", err)
+	}
+	if _, err := store.Get(ctx, "broken"); err == nil || errors.Is(err, ErrNotFound) {
+		t.Errorf("expected parse error, got %v", err)
+	}
+	// the metadata is available regardless
+	info, err := store.Stat(ctx, "broken")
+	if err != nil || *info != (EndpointInfo{Owner: "ops", Version: 3}) {
+		t.Errorf("expected metadata of malformed endpoint, got %v (%v)", info, err)
+	}
+	endpoints, err := store.List(ctx)
+	if err != nil {
+		t.Fatalf("list endpoints: %v", err)
+	}
+	if len(endpoints) != 1 || endpoints[0].Identifier != "libvirt" {
+		t.Errorf("expected List to skip malformed endpoint, got %v", endpoints)
+	}
+	_, err = store.ListPage(ctx, ListQuery{}, func(string, []*Endpoint) error { return nil })
+	if err == nil {
+		t.Error("expected ListPage to fail on malformed endpoint")
+	}
+	if err := store.Delete(ctx, "broken"); err != nil {
+		t.Errorf("expected malformed endpoint to be deleted, got %v", err)
+	}
+}
+
+func TestConfigStorePutIf(t *testing.T) {
+	ctx := context.Background()
+	store := NewMemConfigStore()
+	endpoint := newEndpoint(t, "libvirt", "")
+	if _, err := store.PutIf(ctx, endpoint, Precondition{Versions: []uint64{1}}); !errors.Is(err, ErrPreconditionFailed) {
+		t.Errorf("expected versioned write of missing endpoint to fail, got %v", err)
+	}
+	created, err := store.PutIf(ctx, endpoint, Precondition{CreateOnly: true})
+	if err != nil || !created {
+		t.Fatalf("expected endpoint to be created, got created %t (%v)", created, err)
+	}
+	if _, err := store.PutIf(ctx, endpoint, Precondition{CreateOnly: true}); !errors.Is(err, ErrConflict) {
+		t.Errorf("expected second creation to fail with ErrConflict, got %v", err)
+	}
+	if _, err := store.PutIf(ctx, endpoint, Precondition{Versions: []uint64{0, 2}}); !errors.Is(err, ErrPreconditionFailed) {
+		t.Errorf("expected write of other version to fail with ErrPreconditionFailed, got %v", err)
+	}
+	created, err = store.PutIf(ctx, endpoint, Precondition{Versions: []uint64{2, 1}})
+	if err != nil || created {
+		t.Fatalf("expected endpoint of matching version to be updated, got created %t (%v)", created, err)
+	}
+	if endpoint.Version != 2 {
+		t.Errorf("expected version 2, got %d", endpoint.Version)
+	}
+}
+
+func TestConfigStorePutAll(t *testing.T) {
+	ctx := context.Background()
+	store := NewMemConfigStore()
+	if _, err := store.Put(ctx, newEndpoint(t, "libvirt", "")); err != nil {
+		t.Fatalf("put endpoint: %v", err)
+	}
+	batch := []*Endpoint{newEndpoint(t, "go-dev", ""), newEndpoint(t, "libvirt", "")}
+	_, err := store.PutAll(ctx, batch, []Precondition{{CreateOnly: true}, {Versions: []uint64{7}}})
+	if !errors.Is(err, ErrPreconditionFailed) {
+		t.Fatalf("expected batch with failing precondition to fail, got %v", err)
+	}
+	if _, err := store.Get(ctx, "go-dev"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("expected no endpoint of failed batch to be stored, got %v", err)
+	}
+	created, err := store.PutAll(ctx, batch, []Precondition{{CreateOnly: true}, {Versions: []uint64{1}}})
+	if err != nil {
+		t.Fatalf("put endpoints: %v", err)
+	}
+	if !slices.Equal(created, []bool{true, false}) {
+		t.Errorf("expected go-dev to be created and libvirt updated, got %v", created)
+	}
+	if batch[0].Version != 1 || batch[1].Version != 2 {
+		t.Errorf("expected versions 1 and 2, got %d and %d", batch[0].Version, batch[1].Version)
+	}
+}
+
+func TestConfigStoreLimit(t *testing.T) {
+	defer ApplySettings(CurrentSettings())
+	settings := DefaultSettings()
+	settings.MaxEndpoints = 2
+	ApplySettings(settings)
+	ctx := context.Background()
+	store := NewMemConfigStore()
+	for _, identifier := range []string{"go-dev", "libvirt"} {
+		if _, err := store.Put(ctx, newEndpoint(t, identifier, "")); err != nil {
+			t.Fatalf("put endpoint %s: %v", identifier, err)
+		}
+	}
+	if _, err := store.Put(ctx, newEndpoint(t, "hackernews", "")); !errors.Is(err, ErrLimitReached) {
+		t.Errorf("expected creation beyond the maximum to fail with ErrLimitReached, got %v", err)
+	}
+	if _, err := store.Put(ctx, newEndpoint(t, "libvirt", "")); err != nil {
+		t.Errorf("expected update at the maximum to succeed, got %v", err)
+	}
+	batch := []*Endpoint{newEndpoint(t, "libvirt", ""), newEndpoint(t, "hackernews", "")}
+	if _, err := store.PutAll(ctx, batch, nil); !errors.Is(err, ErrLimitReached) {
+		t.Errorf("expected batch beyond the maximum to fail with ErrLimitReached, got %v", err)
+	}
+}
+
+func TestConfigStoreList(t *testing.T) {
+	ctx := context.Background()
+	store := NewMemConfigStore()
+	for _, identifier := range []string{"libvirt", "go-dev", "hackernews"} {
+		if _, err := store.Put(ctx, newEndpoint(t, identifier, "")); err != nil {
+			t.Fatalf("put endpoint %s: %v", identifier, err)
+		}
+	}
+	endpoints, err := store.List(ctx)
+	if err != nil {
+		t.Fatalf("list endpoints: %v", err)
+	}
+	var identifiers []string
+	for _, endpoint := range endpoints {
+		identifiers = append(identifiers, endpoint.Identifier)
+	}
+	if expected := []string{"go-dev", "hackernews", "libvirt"}; !slices.Equal(identifiers, expected) {
+		t.Errorf("expected endpoints %v, got %v", expected, identifiers)
+	}
+}
+
+// storeHashes stores the endpoints in Valkey with the fields and owner index
+// written by a ConfigStore, but without its transactions, which are not
+// supported by valkeytest.
+func storeHashes(t *testing.T, client valkey.Client, endpoints ...*Endpoint) {
+	t.Helper()
+	ctx := context.Background()
+	for _, endpoint := range endpoints {
+		fields, err := EndpointFields(endpoint, "")
+		if err != nil {
+			t.Fatalf("serialize endpoint: %v", err)
+		}
+		cmds := valkey.Commands{client.B().Arbitrary("HSET", EndpointKey(endpoint.Identifier)).Args(fields...).Build()}
+		if endpoint.Owner != "" {
+			cmds = append(cmds, client.B().Sadd().Key(OwnerIndexKey(endpoint.Owner)).Member(endpoint.Identifier).Build())
+		}
+		for _, resp := range client.DoMulti(ctx, cmds...) {
+			if err := resp.Error(); err != nil {
+				t.Fatalf("store %s: %v", endpoint.Identifier, err)
+			}
+		}
+	}
+}
+
+func TestConfigStoreListPage(t *testing.T) {
+	ctx := context.Background()
+	const n = 250
+	endpoints := make([]*Endpoint, n)
+	for i := range n {
+		owner := "dev"
+		if i%5 == 0 {
+			owner = "ops"
+		}
+		endpoints[i] = newEndpoint(t, fmt.Sprintf("endpoint-%03d", i), owner)
+	}
+	memory := NewMemConfigStore()
+	for _, endpoint := range endpoints {
+		if _, err := memory.Put(ctx, endpoint); err != nil {
+			t.Fatalf("put endpoint: %v", err)
+		}
+	}
+	client := valkeytest.NewClient(t)
+	storeHashes(t, client, endpoints...)
+	stores := map[string]ConfigStore{"memory": memory, "valkey": NewConfigStore(client)}
+	tests := []struct {
+		name     string
+		query    ListQuery
+		expected int
+	}{
+		{"all", ListQuery{Limit: 30}, n},
+		{"unlimited", ListQuery{}, n},
+		{"owner", ListQuery{Owner: "ops", Limit: 7}, n / 5},
+		{"prefix", ListQuery{IdentifierPrefix: "endpoint-1", Limit: 40}, 100},
+		{"method", ListQuery{Method: "HEAD", Limit: 10}, 0},
+	}
+	for name, store := range stores {
+		for _, test := range tests {
+			t.Run(name+"/"+test.name, func(t *testing.T) {
+				listed := make(map[string]int)
+				query := test.query
+				for pages := 0; ; pages++ {
+					if pages > n {
+						t.Fatal("listing does not end")
+					}
+					var page int
+					var passed []string
+					next, err := store.ListPage(ctx, query, func(next string, endpoints []*Endpoint) error {
+						passed = append(passed, next)
+						for _, endpoint := range endpoints {
+							listed[endpoint.Identifier]++
+							if query.Owner != "" && endpoint.Owner != query.Owner {
+								t.Errorf("expected endpoints of %s only, got %s", query.Owner, endpoint.Owner)
+							}
+						}
+						page += len(endpoints)
+						return nil
+					})
+					if err != nil {
+						t.Fatalf("list page: %v", err)
+					}
+					if query.Limit > 0 && page > query.Limit {
+						t.Errorf("expected at most %d endpoints per page, got %d", query.Limit, page)
+					}
+					for _, cursor := range passed {
+						if cursor != next {
+							t.Errorf("expected cursor %q to be passed with every batch, got %q", next, cursor)
+						}
+					}
+					if next == "" {
+						break
+					}
+					query.Cursor = next
+				}
+				if len(listed) != test.expected {
+					t.Errorf("expected %d endpoints, got %d", test.expected, len(listed))
+				}
+				for identifier, count := range listed {
+					if count != 1 {
+						t.Errorf("expected %s to be listed once, got %d times", identifier, count)
+					}
+				}
+			})
+		}
+		_, err := store.ListPage(ctx, ListQuery{Cursor: "no such cursor"}, func(string, []*Endpoint) error { return nil })
+		if !errors.Is(err, ErrInvalidCursor) {
+			t.Errorf("expected malformed cursor to fail %s listing with ErrInvalidCursor, got %v", name, err)
+		}
+	}
+}
+
+func TestConfigStoreTagged(t *testing.T) {
+	ctx := context.Background()
+	store := NewMemConfigStore()
+	for identifier, tags := range map[string][]string{
+		"libvirt": {"infra", "docs"}, "go-dev": {"docs"}, "hackernews": nil,
+	} {
+		if _, err := store.Put(ctx, newEndpoint(t, identifier, "", tags...)); err != nil {
+			t.Fatalf("put endpoint %s: %v", identifier, err)
+		}
+	}
+	tagged, err := store.Tagged(ctx, "docs")
+	if err != nil {
+		t.Fatalf("look up tag: %v", err)
+	}
+	if expected := []string{"go-dev", "libvirt"}; !slices.Equal(tagged, expected) {
+		t.Errorf("expected %v tagged with docs, got %v", expected, tagged)
+	}
+	if _, err := store.Put(ctx, newEndpoint(t, "libvirt", "", "infra")); err != nil {
+		t.Fatalf("put endpoint: %v", err)
+	}
+	if tagged, _ := store.Tagged(ctx, "docs"); !slices.Equal(tagged, []string{"go-dev"}) {
+		t.Errorf("expected untagged endpoint to be left out, got %v", tagged)
+	}
+}
+
+func TestScanEndpointKeys(t *testing.T) {
+	ctx := context.Background()
+	client := valkeytest.NewClient(t)
+	expected := make(map[string]bool)
+	for i := range 300 {
+		identifier := fmt.Sprintf("endpoint-%03d", i)
+		owner := "ops"
+		if i%3 == 0 {
+			owner = "dev"
+		}
+		cmds := valkey.Commands{
+			client.B().Hset().Key(EndpointKey(identifier)).FieldValue().FieldValue("identifier", identifier).Build(),
+			client.B().Hset().Key(StatusKey(identifier)).FieldValue().FieldValue("state", "online").Build(),
+			client.B().Sadd().Key(OwnerIndexKey(owner)).Member(identifier).Build(),
+		}
+		for _, resp := range client.DoMulti(ctx, cmds...) {
+			if err := resp.Error(); err != nil {
+				t.Fatalf("seed %s: %v", identifier, err)
+			}
+		}
+		if owner == "dev" {
+			expected[EndpointKey(identifier)] = true
+		}
+	}
+	tests := []struct {
+		owner    string
+		expected int
+	}{
+		{"", 300},
+		{"dev", 100},
+		{"nobody", 0},
+	}
+	for _, test := range tests {
+		seen := make(map[string]int)
+		batches := 0
+		err := ScanEndpointKeys(ctx, client, test.owner, func(keys []string) error {
+			batches++
+			for _, key := range keys {
+				seen[key]++
+			}
+			return nil
+		})
+		if err != nil {
+			t.Fatalf("scan keys of owner %q: %v", test.owner, err)
+		}
+		if len(seen) != test.expected || (test.expected > listBatchSize && batches < 2) {
+			t.Errorf("expected %d keys of owner %q in batches, got %d in %d", test.expected, test.owner, len(seen), batches)
+		}
+		for key, n := range seen {
+			if n != 1 || !strings.HasPrefix(key, EndpointKey("")) || (test.owner == "dev" && !expected[key]) {
+				t.Errorf("expected %s of owner %q to be returned once, got %d times", key, test.owner, n)
+			}
+		}
+	}
+}
+
+func TestScanEndpointKeysCancelled(t *testing.T) {
+	client := valkeytest.NewClient(t)
+	for i := range 300 {
+		key := EndpointKey(fmt.Sprintf("endpoint-%03d", i))
+		if err := client.Do(context.Background(), client.B().Hset().Key(key).FieldValue().
+			FieldValue("identifier", key).Build()).Error(); err != nil {
+			t.Fatalf("seed %s: %v", key, err)
+		}
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	batches := 0
+	err := ScanEndpointKeys(ctx, client, "", func(keys []string) error {
+		batches++
+		// e.g. the client disconnected
+		cancel()
+		return nil
+	})
+	if !errors.Is(err, context.Canceled) || batches != 1 {
+		t.Errorf("expected scan to stop after the first batch, got %d batches (%v)", batches, err)
+	}
+}